@@ -0,0 +1,203 @@
+// redis-check-aof checks an append only file, a single file or the files
+// listed in a manifest, and can truncate it after the last valid command
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	aofmanifest "github.com/codecrafters-io/redis-starter-go/app/protocol/aof_manifest"
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
+)
+
+// analysis is the result of checking a file, the commands are valid up to
+// okUpTo and err is why the rest is not
+type analysis struct {
+	size   int64
+	okUpTo int64
+	// lines of the valid commands
+	lines int64
+	err   error
+	// the rdb preamble can not be fixed by truncating the file
+	fatal bool
+}
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the last file after its last valid command")
+	yes := flag.Bool("y", false, "fix without asking for confirmation")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-fix] [-y] <file.manifest|file.aof>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	// the parser logs what it reads
+	log.SetOutput(io.Discard)
+	os.Exit(run(flag.Arg(0), *fix, *yes, os.Stdin, os.Stdout))
+}
+
+// run checks the file at path, a manifest is recognized by its suffix. It
+// returns the exit code
+func run(path string, fix, yes bool, in io.Reader, out io.Writer) int {
+	if !strings.HasSuffix(path, aofmanifest.MANIFEST_SUFFIX) {
+		fmt.Fprintln(out, "Start checking Old-Style AOF")
+		return checkLast(path, fix, yes, in, out)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(out, "Cannot open file: %s\n", path)
+		return 1
+	}
+	m, err := aofmanifest.Parse(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(out, "Invalid AOF manifest %s: %s\n", path, err)
+		return 1
+	}
+	files := m.Files()
+	if len(files) == 0 {
+		fmt.Fprintf(out, "The AOF manifest %s lists no file\n", path)
+		return 1
+	}
+
+	fmt.Fprintln(out, "Start checking Multi Part AOF")
+	dir := filepath.Dir(path)
+	for i, file := range files {
+		path := filepath.Join(dir, file.Name)
+		if i == len(files)-1 {
+			return checkLast(path, fix, yes, in, out)
+		}
+		a := checkFile(path, out)
+		if a.err != nil {
+			fmt.Fprintf(out, "AOF %s is not valid, it can't be fixed as it is not the last file: %s\n", path, a.err)
+			return 1
+		}
+		fmt.Fprintf(out, "AOF %s is valid\n", path)
+	}
+	return 0
+}
+
+// checkLast checks the last file, the one that can be truncated
+func checkLast(path string, fix, yes bool, in io.Reader, out io.Writer) int {
+	a := checkFile(path, out)
+	if a.err == nil {
+		fmt.Fprintf(out, "AOF %s is valid\n", path)
+		return 0
+	}
+	fmt.Fprintf(out, "AOF analyzed: filename=%s, size=%d, ok_up_to=%d, ok_up_to_line=%d, diff=%d\n",
+		path, a.size, a.okUpTo, a.lines, a.size-a.okUpTo)
+	fmt.Fprintf(out, "0x%016x: %s\n", a.okUpTo, a.err)
+	if a.fatal {
+		fmt.Fprintf(out, "RDB preamble of AOF file %s is not sane, aborting.\n", path)
+		return 1
+	}
+	if !fix {
+		fmt.Fprintf(out, "AOF %s is not valid. Use the -fix option to try fixing it.\n", path)
+		return 1
+	}
+
+	fmt.Fprintf(out, "This will shrink the AOF %s from %d bytes, with %d bytes, to %d bytes\n", path, a.size, a.size-a.okUpTo, a.okUpTo)
+	if !yes {
+		fmt.Fprint(out, "Continue? [y/N]: ")
+		answer, _ := bufio.NewReader(in).ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			fmt.Fprintln(out, "Aborting...")
+			return 1
+		}
+	}
+	if err := os.Truncate(path, a.okUpTo); err != nil {
+		fmt.Fprintf(out, "Failed to truncate AOF %s: %s\n", path, err)
+		return 1
+	}
+	fmt.Fprintf(out, "Successfully truncated AOF %s\n", path)
+	return 0
+}
+
+// checkFile parses the commands of a file after its rdb preamble if it has
+// one. A MULTI without its EXEC is not valid, the commands are valid up to
+// the MULTI
+func checkFile(path string, out io.Writer) analysis {
+	file, err := os.Open(path)
+	if err != nil {
+		return analysis{err: err, fatal: true}
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return analysis{err: err, fatal: true}
+	}
+
+	a := analysis{size: stat.Size()}
+	reader := bufio.NewReader(file)
+	if magic, _ := reader.Peek(len(redisdb.MAGIC_NUMBER)); string(magic) == redisdb.MAGIC_NUMBER {
+		fmt.Fprintf(out, "Start to check BASE AOF %s (RDB format).\n", path)
+		rr, err := redisdb.NewRDBReader(reader)
+		for err == nil {
+			_, err = rr.Next()
+		}
+		if err != io.EOF {
+			a.err, a.fatal = err, true
+			return a
+		}
+		a.okUpTo = rr.Offset()
+		fmt.Fprintln(out, "RDB preamble is OK, proceeding with AOF tail...")
+	}
+
+	p := parser.NewParser(reader)
+	offset, lines := a.okUpTo, int64(0)
+	multi := false
+	for {
+		// unlike clients the aof has no inline commands
+		if first, err := reader.Peek(1); err == nil && first[0] != '*' {
+			a.err = fmt.Errorf("Expected a command array, got '%c'", first[0])
+			return a
+		}
+		cmd, err := p.ReadCommand()
+		if err == io.EOF {
+			if multi {
+				a.err = fmt.Errorf("Reached EOF before reading EXEC for MULTI")
+			}
+			return a
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			a.err = fmt.Errorf("Unexpected end of file: %w", err)
+			return a
+		}
+		if err != nil {
+			a.err = err
+			return a
+		}
+
+		offset += int64(cmd.Size)
+		lines += int64(1 + 2*(len(cmd.Args)+1))
+		switch cmd.CmdName {
+		case "multi":
+			if multi {
+				a.err = fmt.Errorf("Unexpected MULTI")
+				return a
+			}
+			multi = true
+		case "exec":
+			if !multi {
+				a.err = fmt.Errorf("Unexpected EXEC")
+				return a
+			}
+			multi = false
+		}
+		if !multi {
+			a.okUpTo, a.lines = offset, lines
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	setA      = "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	multiCmd  = "*1\r\n$5\r\nMULTI\r\n"
+	execCmd   = "*1\r\n$4\r\nEXEC\r\n"
+	selectCmd = "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n"
+)
+
+func rdbPreamble(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := redisdb.NewRDBWriter(&buf)
+	assert.NoError(t, w.WriteDB(0, []redisdb.RDBEntry{{Key: "k", Value: redisdb.RDBString("v")}}))
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func Test_CheckFile(t *testing.T) {
+	preamble := string(rdbPreamble(t))
+
+	tests := []struct {
+		name   string
+		file   string
+		okUpTo int
+		err    string
+	}{
+		{name: "valid", file: selectCmd + setA, okUpTo: len(selectCmd + setA)},
+		{name: "empty", file: ""},
+		{name: "truncated", file: setA + setA[:10], okUpTo: len(setA), err: "Unexpected end of file"},
+		{name: "truncated before the last crlf", file: setA + setA[:len(setA)-2], okUpTo: len(setA), err: "Unexpected end of file"},
+		{name: "not an array", file: setA + "+OK\r\n", okUpTo: len(setA), err: "Expected a command array"},
+		{name: "bad length", file: setA + "*x\r\n", okUpTo: len(setA), err: "x"},
+		{name: "trailing garbage", file: setA + "garbage here\r\n", okUpTo: len(setA), err: "Expected a command array"},
+		{name: "resp3 type", file: setA + "%1\r\n", okUpTo: len(setA), err: "Expected a command array"},
+		{name: "null array", file: setA + "*-1\r\n" + setA, okUpTo: len(setA), err: "invalid multibulk length"},
+		{name: "empty array", file: setA + "*0\r\n", okUpTo: len(setA), err: "invalid multibulk length"},
+		{name: "negative length", file: setA + "*-5\r\n", okUpTo: len(setA), err: "invalid length"},
+		{name: "multi without exec", file: setA + multiCmd + setA, okUpTo: len(setA), err: "EXEC for MULTI"},
+		{name: "multi", file: multiCmd + setA + execCmd + setA, okUpTo: len(multiCmd + setA + execCmd + setA)},
+		{name: "exec without multi", file: setA + execCmd, okUpTo: len(setA), err: "Unexpected EXEC"},
+		{name: "rdb preamble", file: preamble + setA, okUpTo: len(preamble + setA)},
+		{name: "rdb preamble truncated tail", file: preamble + setA[:5], okUpTo: len(preamble), err: "Unexpected end of file"},
+	}
+
+	for _, tc := range tests {
+		path := filepath.Join(t.TempDir(), "appendonly.aof")
+		assert.NoError(t, os.WriteFile(path, []byte(tc.file), 0644))
+		a := checkFile(path, &bytes.Buffer{})
+		assert.Equal(t, int64(len(tc.file)), a.size, tc.name)
+		assert.Equal(t, int64(tc.okUpTo), a.okUpTo, tc.name)
+		if tc.err == "" {
+			assert.NoError(t, a.err, tc.name)
+			continue
+		}
+		assert.ErrorContains(t, a.err, tc.err, tc.name)
+		assert.False(t, a.fatal, tc.name)
+	}
+
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	assert.NoError(t, os.WriteFile(path, []byte(preamble[:len(preamble)-4]), 0644))
+	assert.True(t, checkFile(path, &bytes.Buffer{}).fatal)
+}
+
+func Test_RunFix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	assert.NoError(t, os.WriteFile(path, []byte(setA+setA[:10]), 0644))
+
+	var out bytes.Buffer
+	assert.Equal(t, 1, run(path, false, false, nil, &out))
+	assert.Contains(t, out.String(), "ok_up_to="+strconv.Itoa(len(setA)))
+	assert.Contains(t, out.String(), "Use the -fix option")
+
+	out.Reset()
+	assert.Equal(t, 1, run(path, true, false, strings.NewReader("n\n"), &out))
+	assert.Contains(t, out.String(), "Aborting...")
+
+	out.Reset()
+	assert.Equal(t, 0, run(path, true, false, strings.NewReader("y\n"), &out))
+	assert.Contains(t, out.String(), "Successfully truncated")
+	file, _ := os.ReadFile(path)
+	assert.Equal(t, setA, string(file))
+	assert.Equal(t, 0, run(path, false, false, nil, &out))
+}
+
+func Test_RunManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "appendonly.aof.manifest")
+	assert.NoError(t, os.WriteFile(manifest, []byte("file appendonly.aof.1.base.rdb seq 1 type b\n"+
+		"file appendonly.aof.1.incr.aof seq 1 type i\n"+
+		"file appendonly.aof.2.incr.aof seq 2 type i\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "appendonly.aof.1.base.rdb"), rdbPreamble(t), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "appendonly.aof.1.incr.aof"), []byte(setA+setA[:3]), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "appendonly.aof.2.incr.aof"), []byte(setA+setA[:3]), 0644))
+
+	// only the last file can be fixed
+	var out bytes.Buffer
+	assert.Equal(t, 1, run(manifest, true, true, nil, &out))
+	assert.Contains(t, out.String(), "appendonly.aof.1.incr.aof is not valid, it can't be fixed")
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "appendonly.aof.1.incr.aof"), []byte(setA), 0644))
+	out.Reset()
+	assert.Equal(t, 0, run(manifest, true, true, nil, &out))
+	assert.Contains(t, out.String(), "RDB preamble is OK")
+	file, _ := os.ReadFile(filepath.Join(dir, "appendonly.aof.2.incr.aof"))
+	assert.Equal(t, setA, string(file))
+
+	assert.NoError(t, os.WriteFile(manifest, []byte("file a seq 1 type x\n"), 0644))
+	out.Reset()
+	assert.Equal(t, 1, run(manifest, false, false, nil, &out))
+	assert.Contains(t, out.String(), "Unknown AOF file type")
+}
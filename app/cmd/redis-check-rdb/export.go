@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
+	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
+)
+
+// elements of a collection per command of the resp export, like the aof
+// rewrite of redis
+const ITEMS_PER_CMD = 64
+
+// exporter writes the keys as they are read, so the file is never held in
+// memory
+type exporter interface {
+	write(object redisdb.RDBObject) error
+	close() error
+}
+
+type nopExporter struct{}
+
+func (nopExporter) write(redisdb.RDBObject) error { return nil }
+
+func (nopExporter) close() error { return nil }
+
+// jsonExporter writes an array with an object per key
+type jsonExporter struct {
+	w    io.Writer
+	keys int
+}
+
+type jsonKey struct {
+	DB   int    `json:"db"`
+	Key  string `json:"key"`
+	Type string `json:"type"`
+	// unix milliseconds of the expiration
+	Expires int64 `json:"expires,omitempty"`
+	Value   any   `json:"value"`
+}
+
+type jsonScoredMember struct {
+	Member string `json:"member"`
+	// a string as json has no infinities
+	Score string `json:"score"`
+}
+
+type jsonStream struct {
+	Entries      []jsonStreamEntry `json:"entries"`
+	LastID       string            `json:"last_id"`
+	MaxDeletedID string            `json:"max_deleted_id"`
+	EntriesAdded uint64            `json:"entries_added"`
+	Groups       []jsonStreamGroup `json:"groups"`
+}
+
+type jsonStreamEntry struct {
+	ID     string            `json:"id"`
+	Fields map[string]string `json:"fields"`
+}
+
+type jsonStreamGroup struct {
+	Name        string   `json:"name"`
+	LastID      string   `json:"last_id"`
+	EntriesRead int64    `json:"entries_read"`
+	Pending     int      `json:"pending"`
+	Consumers   []string `json:"consumers"`
+}
+
+func newJSONExporter(w io.Writer) *jsonExporter {
+	return &jsonExporter{w: w}
+}
+
+func (e *jsonExporter) write(object redisdb.RDBObject) error {
+	key, err := json.Marshal(jsonKey{
+		DB:      object.DB,
+		Key:     object.Key,
+		Type:    typeName(object.Value),
+		Expires: object.Expires,
+		Value:   jsonValue(object.Value),
+	})
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if e.keys == 0 {
+		separator = "[\n"
+	}
+	e.keys++
+	if _, err := io.WriteString(e.w, separator); err != nil {
+		return err
+	}
+	_, err = e.w.Write(key)
+	return err
+}
+
+func (e *jsonExporter) close() error {
+	end := "\n]\n"
+	if e.keys == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+func jsonValue(value redisdb.RDBValue) any {
+	switch v := value.(type) {
+	case redisdb.RDBString:
+		return string(v)
+	case redisdb.RDBList:
+		return []string(v)
+	case redisdb.RDBSet:
+		return []string(v)
+	case redisdb.RDBHash:
+		hash := make(map[string]string, len(v))
+		for _, field := range v {
+			hash[field.Field] = field.Value
+		}
+		return hash
+	case redisdb.RDBSortedSet:
+		zset := make([]jsonScoredMember, 0, len(v))
+		for _, member := range v {
+			zset = append(zset, jsonScoredMember{Member: member.Member, Score: formatScore(member.Score)})
+		}
+		return zset
+	case *redisdb.RDBStream:
+		stream := jsonStream{
+			Entries:      make([]jsonStreamEntry, 0, len(v.Entries)),
+			LastID:       formatStreamID(v.LastID),
+			MaxDeletedID: formatStreamID(v.MaxDeletedID),
+			EntriesAdded: v.EntriesAdded,
+			Groups:       make([]jsonStreamGroup, 0, len(v.Groups)),
+		}
+		for _, entry := range v.Entries {
+			fields := make(map[string]string, len(entry.Fields)/2)
+			for i := 0; i+1 < len(entry.Fields); i += 2 {
+				fields[entry.Fields[i]] = entry.Fields[i+1]
+			}
+			stream.Entries = append(stream.Entries, jsonStreamEntry{ID: formatStreamID(entry.ID), Fields: fields})
+		}
+		for _, group := range v.Groups {
+			consumers := make([]string, 0, len(group.Consumers))
+			for _, consumer := range group.Consumers {
+				consumers = append(consumers, consumer.Name)
+			}
+			stream.Groups = append(stream.Groups, jsonStreamGroup{
+				Name:        group.Name,
+				LastID:      formatStreamID(group.LastID),
+				EntriesRead: group.EntriesRead,
+				Pending:     len(group.Pending),
+				Consumers:   consumers,
+			})
+		}
+		return stream
+	}
+	return nil
+}
+
+// respExporter writes the commands that create the keys, a SELECT before
+// the keys of each database
+type respExporter struct {
+	w  io.Writer
+	db int
+}
+
+func newRESPExporter(w io.Writer) *respExporter {
+	return &respExporter{w: w, db: -1}
+}
+
+func (e *respExporter) write(object redisdb.RDBObject) error {
+	commands := keyCommands(object.RDBEntry)
+	if object.DB != e.db {
+		commands = append([][]string{{"SELECT", strconv.Itoa(object.DB)}}, commands...)
+		e.db = object.DB
+	}
+	for _, args := range commands {
+		elements := make([][]byte, len(args))
+		for i, arg := range args {
+			elements[i] = []byte(arg)
+		}
+		if _, err := e.w.Write(respencoding.EncodeArray(elements)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *respExporter) close() error {
+	return nil
+}
+
+// keyCommands are the redis commands that create the key with its
+// expiration, the pending entries of the stream groups are not recreated
+func keyCommands(entry redisdb.RDBEntry) [][]string {
+	key := entry.Key
+	var commands [][]string
+	// batches adds the commands for items, width items per element
+	batches := func(name string, items []string, width int) {
+		for start := 0; start < len(items); start += width * ITEMS_PER_CMD {
+			end := min(start+width*ITEMS_PER_CMD, len(items))
+			commands = append(commands, append([]string{name, key}, items[start:end]...))
+		}
+	}
+
+	switch v := entry.Value.(type) {
+	case redisdb.RDBString:
+		args := []string{"SET", key, string(v)}
+		if entry.Expires != 0 {
+			args = append(args, "PXAT", strconv.FormatInt(entry.Expires, 10))
+		}
+		return [][]string{args}
+	case redisdb.RDBList:
+		batches("RPUSH", v, 1)
+	case redisdb.RDBSet:
+		batches("SADD", v, 1)
+	case redisdb.RDBHash:
+		items := make([]string, 0, 2*len(v))
+		for _, field := range v {
+			items = append(items, field.Field, field.Value)
+		}
+		batches("HSET", items, 2)
+	case redisdb.RDBSortedSet:
+		items := make([]string, 0, 2*len(v))
+		for _, member := range v {
+			items = append(items, formatScore(member.Score), member.Member)
+		}
+		batches("ZADD", items, 2)
+	case *redisdb.RDBStream:
+		commands = streamCommands(key, v)
+	}
+	if entry.Expires != 0 {
+		commands = append(commands, []string{"PEXPIREAT", key, strconv.FormatInt(entry.Expires, 10)})
+	}
+	return commands
+}
+
+// streamCommands adds the entries and sets the ids of the stream, an empty
+// stream is created with an entry trimmed right away like redis does
+func streamCommands(key string, stream *redisdb.RDBStream) [][]string {
+	var commands [][]string
+	for _, entry := range stream.Entries {
+		commands = append(commands, append([]string{"XADD", key, formatStreamID(entry.ID)}, entry.Fields...))
+	}
+	if len(stream.Entries) == 0 {
+		id := stream.LastID
+		if id == (redisdb.RDBStreamID{}) {
+			id.Seq = 1
+		}
+		commands = append(commands, []string{"XADD", key, "MAXLEN", "0", formatStreamID(id), "x", "y"})
+	}
+	commands = append(commands, []string{"XSETID", key, formatStreamID(stream.LastID),
+		"ENTRIESADDED", strconv.FormatUint(stream.EntriesAdded, 10),
+		"MAXDELETEDID", formatStreamID(stream.MaxDeletedID)})
+	for _, group := range stream.Groups {
+		args := []string{"XGROUP", "CREATE", key, group.Name, formatStreamID(group.LastID)}
+		if group.EntriesRead >= 0 {
+			args = append(args, "ENTRIESREAD", strconv.FormatInt(group.EntriesRead, 10))
+		}
+		commands = append(commands, args)
+		for _, consumer := range group.Consumers {
+			commands = append(commands, []string{"XGROUP", "CREATECONSUMER", key, group.Name, consumer.Name})
+		}
+	}
+	return commands
+}
+
+func formatStreamID(id redisdb.RDBStreamID) string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+// formatScore renders a score like redis, with the shortest representation
+// that parses back to the same value
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
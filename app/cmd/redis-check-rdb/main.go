@@ -0,0 +1,170 @@
+// redis-check-rdb checks an rdb file without starting a server, it reports
+// the aux fields of the file and the keys of each database by type, and
+// can export the keys as json or as the commands that create them
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
+)
+
+const (
+	EXPORT_JSON = "json"
+	EXPORT_RESP = "resp"
+)
+
+// dbStats are the keys of a database
+type dbStats struct {
+	keys    int
+	expires int
+	// keys by type name
+	types map[string]int
+}
+
+func main() {
+	export := flag.String("export", "", "write the keys to stdout as "+EXPORT_JSON+" or as "+EXPORT_RESP+" commands, the report goes to stderr")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-export json|resp] <rdb-file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || (*export != "" && *export != EXPORT_JSON && *export != EXPORT_RESP) {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	path := flag.Arg(0)
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open file: %s\n", path)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	report := os.Stdout
+	out := bufio.NewWriter(os.Stdout)
+	var e exporter = nopExporter{}
+	switch *export {
+	case EXPORT_JSON:
+		report, e = os.Stderr, newJSONExporter(out)
+	case EXPORT_RESP:
+		report, e = os.Stderr, newRESPExporter(out)
+	}
+	err = check(file, path, e, report)
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// check reads every key of the file, the keys are given to e as they are
+// read and the report is written to report
+func check(r io.Reader, path string, e exporter, report io.Writer) error {
+	fmt.Fprintf(report, "[offset 0] Checking RDB file %s\n", path)
+	rr, err := redisdb.NewRDBReader(r)
+	if err != nil {
+		return reportError(report, 0, err)
+	}
+	fmt.Fprintf(report, "[offset %d] RDB version %d\n", rr.Offset(), rr.Version())
+
+	stats := make(map[int]*dbStats)
+	keys, expires, expired := 0, 0, 0
+	now := time.Now().UnixMilli()
+	aux := 0
+	for {
+		object, err := rr.Next()
+		// the aux fields are read before the first key
+		for _, field := range rr.Aux()[aux:] {
+			fmt.Fprintf(report, "[info] AUX FIELD %s = '%s'\n", field.Field, field.Value)
+		}
+		aux = len(rr.Aux())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return reportError(report, rr.Offset(), err)
+		}
+
+		db, ok := stats[object.DB]
+		if !ok {
+			db = &dbStats{types: make(map[string]int)}
+			stats[object.DB] = db
+			fmt.Fprintf(report, "[info] Selecting DB ID %d\n", object.DB)
+		}
+		keys++
+		db.keys++
+		db.types[typeName(object.Value)]++
+		if object.Expires != 0 {
+			expires++
+			db.expires++
+			if object.Expires < now {
+				expired++
+			}
+		}
+		if err := e.write(object); err != nil {
+			return fmt.Errorf("error writing the export: %w", err)
+		}
+	}
+	if err := e.close(); err != nil {
+		return fmt.Errorf("error writing the export: %w", err)
+	}
+
+	if rr.Version() >= redisdb.RDB_VERSION_CHECKSUM {
+		fmt.Fprintf(report, "[offset %d] Checksum OK\n", rr.Offset())
+	}
+	fmt.Fprintf(report, "[offset %d] \\o/ RDB looks OK! \\o/\n", rr.Offset())
+	fmt.Fprintf(report, "[info] %d keys read\n", keys)
+	fmt.Fprintf(report, "[info] %d expires\n", expires)
+	fmt.Fprintf(report, "[info] %d already expired\n", expired)
+
+	dbs := make([]int, 0, len(stats))
+	for db := range stats {
+		dbs = append(dbs, db)
+	}
+	slices.Sort(dbs)
+	for _, db := range dbs {
+		fmt.Fprintf(report, "[info] db %d: %d keys, %d expires\n", db, stats[db].keys, stats[db].expires)
+		types := make([]string, 0, len(stats[db].types))
+		for name, count := range stats[db].types {
+			types = append(types, fmt.Sprintf("%s %d", name, count))
+		}
+		slices.Sort(types)
+		fmt.Fprintf(report, "[info] db %d types: %s\n", db, strings.Join(types, ", "))
+	}
+	return nil
+}
+
+func reportError(report io.Writer, offset int64, err error) error {
+	fmt.Fprintln(report, "--- RDB ERROR DETECTED ---")
+	fmt.Fprintf(report, "[offset %d] %s\n", offset, err)
+	return err
+}
+
+// typeName is the name TYPE gives to the keys of the value
+func typeName(value redisdb.RDBValue) string {
+	switch value.(type) {
+	case redisdb.RDBString:
+		return "string"
+	case redisdb.RDBList:
+		return "list"
+	case redisdb.RDBSet:
+		return "set"
+	case redisdb.RDBSortedSet:
+		return "zset"
+	case redisdb.RDBHash:
+		return "hash"
+	case *redisdb.RDBStream:
+		return "stream"
+	}
+	return "none"
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRDB(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := redisdb.NewRDBWriter(&buf)
+	assert.NoError(t, w.WriteAux(redisdb.REDIS_VERSION, "7.2.0"))
+	assert.NoError(t, w.WriteDB(0, []redisdb.RDBEntry{
+		{Key: "s", Value: redisdb.RDBString("v"), Expires: 1},
+		{Key: "l", Value: redisdb.RDBList{"a", "b"}, Expires: 4102444800000},
+		{Key: "z", Value: redisdb.RDBSortedSet{{Member: "m", Score: math.Inf(-1)}}},
+	}))
+	assert.NoError(t, w.WriteDB(2, []redisdb.RDBEntry{
+		{Key: "h", Value: redisdb.RDBHash{{Field: "f", Value: "v"}}},
+		{Key: "x", Value: &redisdb.RDBStream{
+			Entries:      []redisdb.RDBStreamEntry{{ID: redisdb.RDBStreamID{Ms: 1, Seq: 1}, Fields: []string{"f", "v"}}},
+			LastID:       redisdb.RDBStreamID{Ms: 1, Seq: 1},
+			EntriesAdded: 1,
+			Groups:       []redisdb.RDBStreamGroup{{Name: "g", EntriesRead: -1, Consumers: []redisdb.RDBStreamConsumer{{Name: "c"}}}},
+		}},
+	}))
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func Test_Check(t *testing.T) {
+	file := newTestRDB(t)
+	var report bytes.Buffer
+	assert.NoError(t, check(bytes.NewReader(file), "dump.rdb", nopExporter{}, &report))
+	for _, line := range []string{
+		"[offset 0] Checking RDB file dump.rdb",
+		"[offset 9] RDB version 11",
+		"[info] AUX FIELD redis-ver = '7.2.0'",
+		"[offset " + strconv.Itoa(len(file)) + "] Checksum OK",
+		"[info] 5 keys read",
+		"[info] 2 expires",
+		"[info] 1 already expired",
+		"[info] db 0: 3 keys, 2 expires",
+		"[info] db 0 types: list 1, string 1, zset 1",
+		"[info] db 2 types: hash 1, stream 1",
+	} {
+		assert.Contains(t, report.String(), line+"\n")
+	}
+
+	report.Reset()
+	assert.Error(t, check(bytes.NewReader(file[:len(file)-20]), "dump.rdb", nopExporter{}, &report))
+	assert.Contains(t, report.String(), "--- RDB ERROR DETECTED ---\n[offset ")
+}
+
+func Test_ExportJSON(t *testing.T) {
+	var out, report bytes.Buffer
+	e := newJSONExporter(&out)
+	assert.NoError(t, check(bytes.NewReader(newTestRDB(t)), "dump.rdb", e, &report))
+
+	var keys []map[string]any
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &keys))
+	assert.Len(t, keys, 5)
+	assert.Equal(t, map[string]any{"db": 0.0, "key": "l", "type": "list", "expires": 4102444800000.0, "value": []any{"a", "b"}}, keys[1])
+	assert.Equal(t, []any{map[string]any{"member": "m", "score": "-inf"}}, keys[2]["value"])
+	assert.Equal(t, map[string]any{"f": "v"}, keys[3]["value"])
+	stream := keys[4]["value"].(map[string]any)
+	assert.Equal(t, "1-1", stream["last_id"])
+	assert.Equal(t, []any{map[string]any{"id": "1-1", "fields": map[string]any{"f": "v"}}}, stream["entries"])
+
+	out.Reset()
+	assert.NoError(t, newJSONExporter(&out).close())
+	assert.Equal(t, "[]\n", out.String())
+}
+
+func Test_ExportRESP(t *testing.T) {
+	var out, report bytes.Buffer
+	assert.NoError(t, check(bytes.NewReader(newTestRDB(t)), "dump.rdb", newRESPExporter(&out), &report))
+
+	commands := []string{}
+	for _, command := range strings.Split(out.String(), "*")[1:] {
+		lines := strings.Split(strings.TrimSuffix(command, "\r\n"), "\r\n")
+		args := []string{}
+		for i := 2; i < len(lines); i += 2 {
+			args = append(args, lines[i])
+		}
+		commands = append(commands, strings.Join(args, " "))
+	}
+	assert.Equal(t, []string{
+		"SELECT 0",
+		"SET s v PXAT 1",
+		"RPUSH l a b",
+		"PEXPIREAT l 4102444800000",
+		"ZADD z -inf m",
+		"SELECT 2",
+		"HSET h f v",
+		"XADD x 1-1 f v",
+		"XSETID x 1-1 ENTRIESADDED 1 MAXDELETEDID 0-0",
+		"XGROUP CREATE x g 0-0",
+		"XGROUP CREATECONSUMER x g c",
+	}, commands)
+}
+
+func Test_KeyCommandsBatches(t *testing.T) {
+	set := make(redisdb.RDBSet, ITEMS_PER_CMD+1)
+	for i := range set {
+		set[i] = strconv.Itoa(i)
+	}
+	commands := keyCommands(redisdb.RDBEntry{Key: "s", Value: set})
+	assert.Len(t, commands, 2)
+	assert.Len(t, commands[0], ITEMS_PER_CMD+2)
+	assert.Equal(t, []string{"SADD", "s", strconv.Itoa(ITEMS_PER_CMD)}, commands[1])
+
+	empty := keyCommands(redisdb.RDBEntry{Key: "x", Value: &redisdb.RDBStream{LastID: redisdb.RDBStreamID{Ms: 5}}})
+	assert.Equal(t, []string{"XADD", "x", "MAXLEN", "0", "5-0", "x", "y"}, empty[0])
+}
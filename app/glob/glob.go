@@ -0,0 +1,135 @@
+// Package glob implements the glob style patterns of redis, it follows
+// stringmatchlen so KEYS, SCAN, PSUBSCRIBE and CONFIG GET behave the same.
+//
+// Supported patterns:
+//
+//	h?llo      matches hello, hallo and hxllo
+//	h*llo      matches hllo and heeeello
+//	h[ae]llo   matches hello and hallo, but not hillo
+//	h[^e]llo   matches hallo, hbllo, ... but not hello
+//	h[a-b]llo  matches hallo and hbllo
+//
+// Use \ to escape special characters to match them literally.
+package glob
+
+// MAX_NESTING bounds the recursion on '*' so abusive patterns fail fast
+const MAX_NESTING = 1000
+
+// Match reports whether s matches pattern
+func Match(pattern, s string) bool {
+	skipLongerMatches := false
+	return match(pattern, s, false, &skipLongerMatches, 0)
+}
+
+// MatchNoCase is Match ignoring the case of ASCII letters
+func MatchNoCase(pattern, s string) bool {
+	skipLongerMatches := false
+	return match(pattern, s, true, &skipLongerMatches, 0)
+}
+
+func match(pattern, s string, nocase bool, skipLongerMatches *bool, nesting int) bool {
+	if nesting > MAX_NESTING {
+		return false
+	}
+
+	for len(pattern) > 0 && len(s) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for len(s) > 0 {
+				if match(pattern[1:], s, nocase, skipLongerMatches, nesting+1) {
+					return true
+				}
+				if *skipLongerMatches {
+					return false
+				}
+				s = s[1:]
+			}
+			// the rest of the pattern matches nowhere in s, so a longer
+			// match for any earlier '*' can not help either
+			*skipLongerMatches = true
+			return false
+		case '?':
+			s = s[1:]
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			matched := false
+			for {
+				if len(pattern) >= 2 && pattern[0] == '\\' {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						matched = true
+					}
+				} else if len(pattern) == 0 || pattern[0] == ']' {
+					// an unterminated class ends with the pattern
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end, c := pattern[0], pattern[2], s[0]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = lower(start), lower(end), lower(c)
+					}
+					pattern = pattern[2:]
+					if c >= start && c <= end {
+						matched = true
+					}
+				} else if equal(pattern[0], s[0], nocase) {
+					matched = true
+				}
+				pattern = pattern[1:]
+			}
+			if not {
+				matched = !matched
+			}
+			if !matched {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if !equal(pattern[0], s[0], nocase) {
+				return false
+			}
+			s = s[1:]
+		}
+		if len(pattern) > 0 {
+			pattern = pattern[1:]
+		}
+		if len(s) == 0 {
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+		}
+	}
+
+	return len(pattern) == 0 && len(s) == 0
+}
+
+func equal(a, b byte, nocase bool) bool {
+	if nocase {
+		return lower(a) == lower(b)
+	}
+	return a == b
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
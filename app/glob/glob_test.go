@@ -0,0 +1,65 @@
+package glob
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Match(t *testing.T) {
+
+	tests := []struct {
+		pattern  string
+		s        string
+		nocase   bool
+		expected bool
+	}{
+		{pattern: "*", s: "anything", expected: true},
+		{pattern: "user:*", s: "user:1000", expected: true},
+		{pattern: "user:*", s: "users", expected: false},
+		{pattern: "a/*", s: "a/b/c", expected: true},
+		{pattern: "h?llo", s: "hello", expected: true},
+		{pattern: "h?llo", s: "hllo", expected: false},
+		{pattern: "h*llo", s: "hllo", expected: true},
+		{pattern: "h*llo*", s: "heeeello", expected: true},
+		{pattern: "h[ae]llo", s: "hallo", expected: true},
+		{pattern: "h[ae]llo", s: "hillo", expected: false},
+		{pattern: "h[^e]llo", s: "hallo", expected: true},
+		{pattern: "h[^e]llo", s: "hello", expected: false},
+		{pattern: "h[a-b]llo", s: "hbllo", expected: true},
+		{pattern: "h[b-a]llo", s: "hallo", expected: true},
+		{pattern: "h[a-b]llo", s: "hcllo", expected: false},
+		{pattern: "[\\]]", s: "]", expected: true},
+		{pattern: "[abc", s: "a", expected: true},
+		{pattern: "h\\*llo", s: "h*llo", expected: true},
+		{pattern: "h\\*llo", s: "hello", expected: false},
+		{pattern: "\\", s: "\\", expected: true},
+		{pattern: "*a", s: "", expected: false},
+		{pattern: "a**", s: "a", expected: true},
+		{pattern: "HELLO", s: "hello", expected: false},
+		{pattern: "HELLO", s: "hello", nocase: true, expected: true},
+		{pattern: "[A-Z]ello", s: "hello", nocase: true, expected: true},
+		{pattern: "MAX*", s: "maxmemory", nocase: true, expected: true},
+	}
+
+	for _, tc := range tests {
+		match := Match
+		if tc.nocase {
+			match = MatchNoCase
+		}
+		assert.Equal(t, tc.expected, match(tc.pattern, tc.s), "%q %q", tc.pattern, tc.s)
+	}
+}
+
+func Test_MatchAbusivePattern(t *testing.T) {
+
+	pattern := strings.Repeat("a*", 50) + "b"
+	s := strings.Repeat("a", 100)
+
+	start := time.Now()
+	assert.False(t, Match(pattern, s))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.False(t, Match(strings.Repeat("*x", MAX_NESTING+1), strings.Repeat("x", MAX_NESTING+1)))
+}
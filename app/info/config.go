@@ -0,0 +1,168 @@
+package info
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Config params
+	CONFIG_NOTIFY_KEYSPACE_EVENTS = "notify-keyspace-events"
+	CONFIG_REQUIREPASS            = "requirepass"
+	CONFIG_OUTPUT_BUFFER_LIMIT    = "client-output-buffer-limit"
+	CONFIG_DATABASES              = "databases"
+	CONFIG_MAXMEMORY              = "maxmemory"
+	CONFIG_MAXMEMORY_POLICY       = "maxmemory-policy"
+	CONFIG_MAXMEMORY_SAMPLES      = "maxmemory-samples"
+	CONFIG_LFU_LOG_FACTOR         = "lfu-log-factor"
+	CONFIG_LFU_DECAY_TIME         = "lfu-decay-time"
+	CONFIG_DIR                    = "dir"
+	CONFIG_DBFILENAME             = "dbfilename"
+	CONFIG_SAVE                   = "save"
+	CONFIG_APPENDONLY             = "appendonly"
+	CONFIG_APPENDFILENAME         = "appendfilename"
+	CONFIG_APPENDFSYNC            = "appendfsync"
+	CONFIG_AOF_LOAD_TRUNCATED     = "aof-load-truncated"
+	CONFIG_APPENDDIRNAME          = "appenddirname"
+	CONFIG_AOF_USE_RDB_PREAMBLE   = "aof-use-rdb-preamble"
+	CONFIG_AUTO_AOF_REWRITE_PERC  = "auto-aof-rewrite-percentage"
+	CONFIG_AUTO_AOF_REWRITE_MIN   = "auto-aof-rewrite-min-size"
+
+	CONFIG_HASH_MAX_LISTPACK_ENTRIES = "hash-max-listpack-entries"
+	CONFIG_HASH_MAX_LISTPACK_VALUE   = "hash-max-listpack-value"
+	CONFIG_SET_MAX_INTSET_ENTRIES    = "set-max-intset-entries"
+	CONFIG_SET_MAX_LISTPACK_ENTRIES  = "set-max-listpack-entries"
+	CONFIG_SET_MAX_LISTPACK_VALUE    = "set-max-listpack-value"
+	CONFIG_ZSET_MAX_LISTPACK_ENTRIES = "zset-max-listpack-entries"
+	CONFIG_ZSET_MAX_LISTPACK_VALUE   = "zset-max-listpack-value"
+)
+
+// memory units accepted in config values, like redis k is 1000 and kb 1024
+var memoryUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"k":  1000,
+	"kb": 1024,
+	"m":  1000 * 1000,
+	"mb": 1024 * 1024,
+	"g":  1000 * 1000 * 1000,
+	"gb": 1024 * 1024 * 1024,
+}
+
+// Config holds the server parameters that can be read and changed at
+// runtime with CONFIG GET / CONFIG SET.
+type Config struct {
+	mx     sync.RWMutex
+	params map[string]*configParam
+}
+
+type configParam struct {
+	value string
+	// apply validates a new value and returns the canonical form to store
+	apply func(string) (string, error)
+	// can only be set on startup
+	immutable bool
+}
+
+func NewConfig() *Config {
+	return &Config{params: make(map[string]*configParam)}
+}
+
+// Define registers a parameter with its initial value, apply can be nil
+// for parameters that do not need validation.
+func (c *Config) Define(name, value string, apply func(string) (string, error)) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.params[strings.ToLower(name)] = &configParam{value: value, apply: apply}
+}
+
+// DefineImmutable registers a parameter that CONFIG SET can not change,
+// it is only set from the command line.
+func (c *Config) DefineImmutable(name, value string, apply func(string) (string, error)) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.params[strings.ToLower(name)] = &configParam{value: value, apply: apply, immutable: true}
+}
+
+func (c *Config) IsImmutable(name string) bool {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	param, ok := c.params[strings.ToLower(name)]
+	return ok && param.immutable
+}
+
+func (c *Config) Get(name string) (string, bool) {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	param, ok := c.params[strings.ToLower(name)]
+	if !ok {
+		return "", false
+	}
+	return param.value, true
+}
+
+func (c *Config) Set(name, value string) error {
+	name = strings.ToLower(name)
+	c.mx.RLock()
+	param, ok := c.params[name]
+	c.mx.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown option '%s'", name)
+	}
+
+	if param.apply != nil {
+		applied, err := param.apply(value)
+		if err != nil {
+			return err
+		}
+		value = applied
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	param.value = value
+	return nil
+}
+
+func (c *Config) Names() []string {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	names := make([]string, 0, len(c.params))
+	for name := range c.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseMemory parses a memory amount like 100, 64kb or 1gb into bytes
+func ParseMemory(s string) (int64, error) {
+	s = strings.ToLower(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i == -1 {
+		i = len(s)
+	}
+	unit, ok := memoryUnits[s[i:]]
+	if !ok || i == 0 {
+		return 0, fmt.Errorf("invalid memory value '%s'", s)
+	}
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory value '%s'", s)
+	}
+	return n * unit, nil
+}
+
+// ParseBool parses the yes and no of the boolean configs
+func ParseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("argument must be 'yes' or 'no'")
+}
@@ -13,11 +13,12 @@ import (
 const (
 	// Ctx value
 	CTX_SERVER_INFO              = "server-info"
-	CTX_SESSION_ID               = "session-id"
 	CTX_METRICS                  = "metrics"
-	CTX_REPLICATION_EVENTS       = "replication-events"
 	CTX_REPLACATION_REGISTRATION = "replication-registration"
 	CTX_ACK_EVENT                = "ack-event"
+	CTX_CONFIG                   = "config"
+	CTX_CLIENT                   = "client"
+	CTX_TRANSACTION              = "transaction"
 
 	// Server inf
 	SERVER_ROLE               = "role"
@@ -26,14 +27,15 @@ const (
 	SERVER_MASTER_PORT        = "master_port"
 	SERVER_MASTER_REPLID      = "master_replid"
 	SERVER_MASTER_REPL_OFFSET = "master_repl_offset"
-	SERVER_RDB_DIR            = "dir"
-	SERVER_RDB_FILE_NAME      = "dbfilename"
 
 	// role types
 	ROLE_MASTER = "master"
 	ROLE_SLAVE  = "slave"
 
 	INFO_REPL = "replication"
+
+	// version reported to clients
+	REDIS_VERSION = "7.2.0"
 )
 
 type ServerInfo map[string]string
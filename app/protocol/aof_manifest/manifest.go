@@ -0,0 +1,236 @@
+package aofmanifest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// types of the files listed, the history ones are no longer loaded
+	// and wait to be deleted
+	FILE_TYPE_BASE = "b"
+	FILE_TYPE_HIST = "h"
+	FILE_TYPE_INCR = "i"
+
+	MANIFEST_SUFFIX   = ".manifest"
+	BASE_SUFFIX       = ".base"
+	INCR_SUFFIX       = ".incr"
+	RDB_FORMAT_SUFFIX = ".rdb"
+	AOF_FORMAT_SUFFIX = ".aof"
+
+	// longest line accepted, like redis
+	MANIFEST_MAX_LINE = 1024
+)
+
+// File is a file of the append only file listed in the manifest
+type File struct {
+	Name string
+	Seq  int64
+	Type string
+}
+
+// Manifest tracks the files that make the append only file, the base one
+// with the dataset of the last rewrite and the incr ones with the commands
+// run after it, in the order they are loaded
+type Manifest struct {
+	Base    *File
+	History []File
+	Incrs   []File
+	// last sequence numbers given, the next files take the following ones
+	BaseSeq int64
+	IncrSeq int64
+}
+
+// ManifestName is the name of the manifest of the files named after
+// filename, the appendfilename config
+func ManifestName(filename string) string {
+	return filename + MANIFEST_SUFFIX
+}
+
+// Parse reads a manifest in the format of redis 7, one file per line with
+// its name, sequence number and type. Lines starting with # are comments
+// and unknown keys are ignored
+func Parse(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	reader := bufio.NewReaderSize(r, MANIFEST_MAX_LINE+1)
+	lines := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			if line != "" {
+				return nil, fmt.Errorf("The AOF manifest file is invalid format")
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(line) > MANIFEST_MAX_LINE {
+			return nil, fmt.Errorf("The AOF manifest file contains too long line")
+		}
+		lines++
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		args, err := splitArgs(line)
+		if err != nil || len(args) < 6 || len(args)%2 != 0 {
+			return nil, fmt.Errorf("Invalid AOF manifest file format")
+		}
+		var file File
+		for i := 0; i < len(args); i += 2 {
+			switch strings.ToLower(args[i]) {
+			case "file":
+				file.Name = args[i+1]
+			case "seq":
+				file.Seq, err = strconv.ParseInt(args[i+1], 10, 64)
+			case "type":
+				file.Type = args[i+1]
+			}
+		}
+		if err != nil || file.Name == "" || file.Seq < 1 || file.Type == "" {
+			return nil, fmt.Errorf("Invalid AOF manifest file format")
+		}
+		if filepath.Base(file.Name) != file.Name {
+			return nil, fmt.Errorf("File can't be a path, just a filename")
+		}
+
+		switch file.Type {
+		case FILE_TYPE_BASE:
+			if m.Base != nil {
+				return nil, fmt.Errorf("Found duplicate base file information")
+			}
+			m.Base = &file
+			m.BaseSeq = file.Seq
+		case FILE_TYPE_HIST:
+			m.History = append(m.History, file)
+		case FILE_TYPE_INCR:
+			if file.Seq <= m.IncrSeq {
+				return nil, fmt.Errorf("Found a non-monotonic sequence number")
+			}
+			m.Incrs = append(m.Incrs, file)
+			m.IncrSeq = file.Seq
+		default:
+			return nil, fmt.Errorf("Unknown AOF file type")
+		}
+	}
+	if lines == 0 {
+		return nil, fmt.Errorf("Found an empty AOF manifest")
+	}
+	return m, nil
+}
+
+// Bytes is the manifest as it is written, the base file first
+func (m *Manifest) Bytes() []byte {
+	var b strings.Builder
+	files := m.History
+	if m.Base != nil {
+		files = append([]File{*m.Base}, files...)
+	}
+	for _, file := range append(files, m.Incrs...) {
+		fmt.Fprintf(&b, "file %s seq %d type %s\n", quoteName(file.Name), file.Seq, file.Type)
+	}
+	return []byte(b.String())
+}
+
+// Files are the files loaded in order, the base and the incr ones
+func (m *Manifest) Files() []File {
+	files := make([]File, 0, len(m.Incrs)+1)
+	if m.Base != nil {
+		files = append(files, *m.Base)
+	}
+	return append(files, m.Incrs...)
+}
+
+// NextBase adds a new base file, the one it replaces becomes history
+func (m *Manifest) NextBase(filename string, rdb bool) File {
+	if m.Base != nil {
+		m.History = append(m.History, File{Name: m.Base.Name, Seq: m.Base.Seq, Type: FILE_TYPE_HIST})
+	}
+	m.BaseSeq++
+	suffix := AOF_FORMAT_SUFFIX
+	if rdb {
+		suffix = RDB_FORMAT_SUFFIX
+	}
+	m.Base = &File{Name: fmt.Sprintf("%s.%d%s%s", filename, m.BaseSeq, BASE_SUFFIX, suffix), Seq: m.BaseSeq, Type: FILE_TYPE_BASE}
+	return *m.Base
+}
+
+// NextIncr adds a new incr file, the commands are appended to the last one
+func (m *Manifest) NextIncr(filename string) File {
+	m.IncrSeq++
+	file := File{Name: fmt.Sprintf("%s.%d%s%s", filename, m.IncrSeq, INCR_SUFFIX, AOF_FORMAT_SUFFIX), Seq: m.IncrSeq, Type: FILE_TYPE_INCR}
+	m.Incrs = append(m.Incrs, file)
+	return file
+}
+
+// DropIncrs makes the incr files before the sequence number seq history,
+// their commands are in a new base file
+func (m *Manifest) DropIncrs(seq int64) {
+	kept := m.Incrs[:0]
+	for _, file := range m.Incrs {
+		if file.Seq >= seq {
+			kept = append(kept, file)
+			continue
+		}
+		m.History = append(m.History, File{Name: file.Name, Seq: file.Seq, Type: FILE_TYPE_HIST})
+	}
+	m.Incrs = kept
+}
+
+// Clone copies the manifest so it can be changed without changing m
+func (m *Manifest) Clone() *Manifest {
+	c := &Manifest{
+		History: append([]File{}, m.History...),
+		Incrs:   append([]File{}, m.Incrs...),
+		BaseSeq: m.BaseSeq,
+		IncrSeq: m.IncrSeq,
+	}
+	if m.Base != nil {
+		base := *m.Base
+		c.Base = &base
+	}
+	return c
+}
+
+// quoteName quotes the names with spaces or characters that are not
+// printable, so they are read back as one argument
+func quoteName(name string) string {
+	if strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r == '"' || r == '\'' || r > '~' }) == -1 {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+// splitArgs splits a line on spaces, the arguments in double quotes can
+// have them
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for line = strings.TrimLeft(line, " \t"); line != ""; line = strings.TrimLeft(line, " \t") {
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end == -1 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+			continue
+		}
+		quoted, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return nil, err
+		}
+		arg, _ := strconv.Unquote(quoted)
+		args = append(args, arg)
+		line = line[len(quoted):]
+		if line != "" && line[0] != ' ' && line[0] != '\t' {
+			return nil, fmt.Errorf("closing quote must be followed by a space")
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,74 @@
+package aofmanifest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Parse(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		expect *Manifest
+		err    string
+	}{
+		{
+			name: "base and incrs",
+			input: "file appendonly.aof.2.base.rdb seq 2 type b\n" +
+				"# a comment\n" +
+				"file appendonly.aof.1.incr.aof seq 1 type h\n" +
+				"file appendonly.aof.2.incr.aof seq 2 type i\n" +
+				"file \"append only.aof.3.incr.aof\" seq 3 type i startoffset 10\n",
+			expect: &Manifest{
+				Base:    &File{Name: "appendonly.aof.2.base.rdb", Seq: 2, Type: FILE_TYPE_BASE},
+				History: []File{{Name: "appendonly.aof.1.incr.aof", Seq: 1, Type: FILE_TYPE_HIST}},
+				Incrs: []File{
+					{Name: "appendonly.aof.2.incr.aof", Seq: 2, Type: FILE_TYPE_INCR},
+					{Name: "append only.aof.3.incr.aof", Seq: 3, Type: FILE_TYPE_INCR},
+				},
+				BaseSeq: 2,
+				IncrSeq: 3,
+			},
+		},
+		{name: "empty", input: "", err: "Found an empty AOF manifest"},
+		{name: "no new line", input: "file a seq 1 type b", err: "invalid format"},
+		{name: "missing type", input: "file a seq 1\n", err: "Invalid AOF manifest file format"},
+		{name: "bad seq", input: "file a seq x type b\n", err: "Invalid AOF manifest file format"},
+		{name: "path", input: "file ../a seq 1 type b\n", err: "just a filename"},
+		{name: "two bases", input: "file a seq 1 type b\nfile b seq 2 type b\n", err: "duplicate base"},
+		{name: "incr seq", input: "file a seq 2 type i\nfile b seq 2 type i\n", err: "non-monotonic"},
+		{name: "unknown type", input: "file a seq 1 type x\n", err: "Unknown AOF file type"},
+		{name: "long line", input: "file " + strings.Repeat("a", MANIFEST_MAX_LINE) + " seq 1 type b\n", err: "too long line"},
+	}
+
+	for _, tc := range tests {
+		m, err := Parse(strings.NewReader(tc.input))
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expect, m, tc.name)
+	}
+}
+
+func Test_ManifestRewrite(t *testing.T) {
+	m := &Manifest{}
+	m.NextIncr("appendonly.aof")
+	assert.Equal(t, File{Name: "appendonly.aof.1.base.rdb", Seq: 1, Type: FILE_TYPE_BASE}, m.NextBase("appendonly.aof", true))
+	m.DropIncrs(m.NextIncr("appendonly.aof").Seq)
+	assert.Equal(t, "file appendonly.aof.1.base.rdb seq 1 type b\n"+
+		"file appendonly.aof.1.incr.aof seq 1 type h\n"+
+		"file appendonly.aof.2.incr.aof seq 2 type i\n", string(m.Bytes()))
+
+	c := m.Clone()
+	c.NextBase("appendonly.aof", false)
+	assert.Equal(t, "appendonly.aof.1.base.rdb", m.Base.Name)
+	assert.Equal(t, []File{{Name: "appendonly.aof.2.base.aof", Seq: 2, Type: FILE_TYPE_BASE}, {Name: "appendonly.aof.2.incr.aof", Seq: 2, Type: FILE_TYPE_INCR}}, c.Files())
+
+	parsed, err := Parse(strings.NewReader(string(c.Bytes())))
+	assert.NoError(t, err)
+	assert.Equal(t, c, parsed)
+}
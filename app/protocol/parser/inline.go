@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// same as redis PROTO_INLINE_MAX_SIZE
+	MAX_INLINE_SIZE = 64 * 1024
+)
+
+// readInline reads a command sent with the inline protocol, i.e. a line
+// of space separated arguments as typed in nc or telnet
+func (p *Parser) readInline() (CmdInfo, error) {
+	for {
+		start := p.consumed
+		line, err := p.readInlineLine()
+		if err != nil {
+			return CmdInfo{}, err
+		}
+
+		args, err := splitArgs(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"))
+		if err != nil {
+			return CmdInfo{}, err
+		}
+		// empty lines are ignored, like redis does
+		if len(args) == 0 {
+			continue
+		}
+
+		return CmdInfo{CmdName: strings.ToLower(args[0]), Args: args[1:], Size: p.consumed - start}, nil
+	}
+}
+
+// readInlineLine reads up to LF a chunk at a time, so a line that never
+// ends fails once it is over MAX_INLINE_SIZE instead of growing the buffer
+func (p *Parser) readInlineLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := p.input.ReadSlice(LN)
+		p.consumed += len(chunk)
+		if len(line)+len(chunk) > MAX_INLINE_SIZE {
+			return "", fmt.Errorf("Protocol error: too big inline request")
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(line), nil
+	}
+}
+
+// splitArgs splits a line the way redis sdssplitargs does: arguments are
+// separated by spaces, "double quoted" arguments support \n \r \t \b \a
+// \\ \" and \xHH escapes, 'single quoted' ones only \'
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var current strings.Builder
+		inDoubleQuotes, inSingleQuotes := false, false
+		for done := false; !done; {
+			if i == len(line) {
+				if inDoubleQuotes || inSingleQuotes {
+					return nil, fmt.Errorf("Protocol error: unbalanced quotes in request")
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDoubleQuotes:
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current.WriteByte(byte(b))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					current.WriteByte(unescape(line[i]))
+				} else if c == '"' {
+					// the closing quote must be followed by a space or nothing
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, fmt.Errorf("Protocol error: unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					current.WriteByte(c)
+				}
+			case inSingleQuotes:
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					current.WriteByte('\'')
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, fmt.Errorf("Protocol error: unbalanced quotes in request")
+					}
+					done = true
+				} else {
+					current.WriteByte(c)
+				}
+			default:
+				switch {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDoubleQuotes = true
+				case c == '\'':
+					inSingleQuotes = true
+				default:
+					current.WriteByte(c)
+				}
+			}
+			i++
+		}
+		args = append(args, current.String())
+	}
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return c
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
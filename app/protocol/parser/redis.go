@@ -2,10 +2,10 @@ package parser
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -19,6 +19,27 @@ const (
 	RESP_ARRAY         DataType = '*'
 	RESP_BULK_STRING   DataType = '$'
 	RESP_SIMPLE_STRING DataType = '+'
+	RESP_SIMPLE_ERROR  DataType = '-'
+	RESP_INTEGER       DataType = ':'
+
+	//RESP3
+	RESP_NULL            DataType = '_'
+	RESP_DOUBLE          DataType = ','
+	RESP_BOOLEAN         DataType = '#'
+	RESP_BIG_NUMBER      DataType = '('
+	RESP_BULK_ERROR      DataType = '!'
+	RESP_VERBATIM_STRING DataType = '='
+	RESP_MAP             DataType = '%'
+	RESP_SET             DataType = '~'
+	RESP_ATTRIBUTE       DataType = '|'
+	RESP_PUSH            DataType = '>'
+
+	// limits, the bulk one is the redis proto-max-bulk-len default
+	MAX_BULK_LEN       = 512 * 1024 * 1024
+	MAX_AGGREGATE_LEN  = math.MaxInt32
+	MAX_NESTING_DEPTH  = 128
+	MAX_PREALLOCATED   = 1024
+	VERBATIM_FORMAT_SZ = 3
 
 	// CMD info
 )
@@ -28,6 +49,8 @@ type DataType byte
 
 type Parser struct {
 	input *bufio.Reader
+	// bytes consumed from input, used to report the size of each frame
+	consumed int
 }
 
 // marker interface design pattern
@@ -45,13 +68,6 @@ type CmdInfo struct {
 
 func (cmdInfo CmdInfo) respResponseType() {}
 
-type UnknownData struct {
-	Dt   DataType
-	Data []byte
-}
-
-func (uk UnknownData) respResponseType() {}
-
 type RDBFile struct {
 	Data []byte
 }
@@ -70,6 +86,29 @@ type BulkString struct {
 
 func (bs BulkString) respResponseType() {}
 
+// Value is a decoded RESP frame, aggregates keep their children in Elems
+// (maps and attributes as key, value, key, value...)
+type Value struct {
+	Type DataType
+	Null bool
+	// simple strings, errors, bulk strings, verbatim strings and big numbers
+	Str    []byte
+	Int    int64
+	Double float64
+	Bool   bool
+	// verbatim string format, e.g. txt
+	Format string
+	Elems  []Value
+	// attributes sent right before the value
+	Attrs []Value
+}
+
+func (v Value) respResponseType() {}
+
+func (v Value) String() string {
+	return string(v.Str)
+}
+
 func NewParser(reader *bufio.Reader) *Parser {
 	return &Parser{input: reader}
 }
@@ -110,7 +149,65 @@ func (p *Parser) GetSimpleStringResponse() (*SimpleString, error) {
 	}
 }
 
+// ParseIncomingData reads the next frame, arrays of bulk strings and
+// inline lines are commands, a top level bulk string not followed by
+// CRLF is an RDB transfer and every other RESP type is returned as a Value.
+// Null and empty arrays are skipped like redis does, their bytes are
+// counted in the size of the next command
 func (p *Parser) ParseIncomingData() (RespResponse, error) {
+	start := p.consumed
+	for {
+		incoming, err := p.parseFrame(start)
+		if err != errEmptyArray {
+			return incoming, err
+		}
+	}
+}
+
+// ReadRequest reads the next command sent by a client, like redis every
+// request not starting with '*' is an inline command
+func (p *Parser) ReadRequest() (CmdInfo, error) {
+	start := p.consumed
+	for {
+		first, err := p.input.Peek(1)
+		if err != nil {
+			return CmdInfo{}, err
+		}
+		if DataType(first[0]) != RESP_ARRAY {
+			return p.readInline()
+		}
+		p.input.ReadByte()
+		p.consumed++
+		cmd, err := p.readCommand(start)
+		if err != errEmptyArray {
+			return cmd, err
+		}
+	}
+}
+
+// ReadCommand reads a multibulk command where nothing else is expected,
+// like in the aof: other types and null or empty arrays are errors
+func (p *Parser) ReadCommand() (CmdInfo, error) {
+	start := p.consumed
+	dataType, err := p.input.ReadByte()
+	if err != nil {
+		return CmdInfo{}, err
+	}
+	p.consumed++
+	if DataType(dataType) != RESP_ARRAY {
+		return CmdInfo{}, fmt.Errorf("Protocol error: expected '*', got '%c'", dataType)
+	}
+	cmd, err := p.readCommand(start)
+	if err == errEmptyArray {
+		return CmdInfo{}, fmt.Errorf("Protocol error: invalid multibulk length")
+	}
+	return cmd, err
+}
+
+// errEmptyArray tells a null or empty array was read
+var errEmptyArray = fmt.Errorf("empty array")
+
+func (p *Parser) parseFrame(start int) (RespResponse, error) {
 	dataType, err := p.input.ReadByte()
 
 	if err != nil {
@@ -121,127 +218,328 @@ func (p *Parser) ParseIncomingData() (RespResponse, error) {
 		}
 		return CmdInfo{}, fmt.Errorf("Error reading data type %s", err)
 	}
+	p.consumed++
 
 	switch DataType(dataType) {
 
 	case RESP_ARRAY:
-		size := 1
-		arraySizeByte, err := p.input.ReadString(LN)
-		size += len(arraySizeByte)
-		arraySizeByte = strings.TrimRight(arraySizeByte, CRNL)
+		return p.readCommand(start)
+	case RESP_SIMPLE_STRING:
+		data, err := p.readLine()
 
 		if err != nil {
+			return SimpleString{}, fmt.Errorf("error parsing simple string: %s", err)
+		}
 
-			return CmdInfo{}, fmt.Errorf("Could not read array size: %s", err)
+		return SimpleString{data}, nil
+	case RESP_BULK_STRING:
+		size, err := p.readLength()
+		if err != nil {
+			return SimpleString{}, fmt.Errorf("error parsing rbd string: %s", err)
+		}
+		if size < 0 {
+			return Value{Type: RESP_BULK_STRING, Null: true}, nil
 		}
-		arraySize, err := strconv.Atoi(arraySizeByte)
 
+		data, err := p.readExactly(size)
 		if err != nil {
-			return CmdInfo{}, fmt.Errorf("Could not read array size: %s", err)
+			return SimpleString{}, fmt.Errorf("error parsing rbd string: %s", err)
+		}
 
+		// the RDB transfer has no trailing CRLF, only look at what is
+		// already buffered so we never wait for the next frame
+		if p.input.Buffered() >= 2 {
+			if term, _ := p.input.Peek(2); string(term) == CRNL {
+				p.input.Discard(2)
+				p.consumed += 2
+				return BulkString{Data: string(data)}, nil
+			}
 		}
+		return RDBFile{Data: data}, nil
 
-		lines, err, bulkTotal := p.bulkStringToStringSlice(arraySize)
-		size += bulkTotal
+	default:
+		if !isRespType(DataType(dataType)) {
+			p.input.UnreadByte()
+			p.consumed--
+			return p.readInline()
+		}
+		return p.readTyped(DataType(dataType), 0)
+	}
+}
 
-		if err != nil {
-			return CmdInfo{}, fmt.Errorf("Could not get cmd lines: %s", err)
+// readCommand reads a multibulk command once its '*' is consumed
+func (p *Parser) readCommand(start int) (CmdInfo, error) {
+	arraySize, err := p.readLength()
+	if err != nil {
+		return CmdInfo{}, fmt.Errorf("Could not read array size: %w", err)
+	}
+	if arraySize <= 0 {
+		return CmdInfo{}, errEmptyArray
+	}
 
-		}
+	lines, err, _ := p.bulkStringToStringSlice(arraySize)
+	if err != nil {
+		return CmdInfo{}, fmt.Errorf("Could not get cmd lines: %w", err)
+	}
 
-		cmdName, err := getCmdName(lines)
+	cmdName, err := getCmdName(lines)
 
+	if err != nil {
+		return CmdInfo{}, fmt.Errorf("cmd name error: %s", err)
+	}
+	return CmdInfo{CmdName: cmdName, Args: lines[1:], Size: p.consumed - start}, nil
+}
+
+// ReadValue reads the next frame whatever its type
+func (p *Parser) ReadValue() (Value, error) {
+	return p.readValue(0)
+}
+
+func (p *Parser) readValue(depth int) (Value, error) {
+	dataType, err := p.input.ReadByte()
+	if err != nil {
+		return Value{}, err
+	}
+	p.consumed++
+	return p.readTyped(DataType(dataType), depth)
+}
+
+func (p *Parser) readTyped(dt DataType, depth int) (Value, error) {
+	if depth > MAX_NESTING_DEPTH {
+		return Value{}, fmt.Errorf("Protocol error: too many nested aggregates")
+	}
+
+	v := Value{Type: dt}
+	switch dt {
+	case RESP_SIMPLE_STRING, RESP_SIMPLE_ERROR:
+		line, err := p.readLine()
 		if err != nil {
-			return CmdInfo{}, fmt.Errorf("cmd name error: %s", err)
+			return Value{}, err
 		}
-		return CmdInfo{CmdName: cmdName, Args: lines[1:], Size: size}, nil
-	case RESP_SIMPLE_STRING:
-		data, err := p.input.ReadString(LN)
-
+		v.Str = []byte(line)
+	case RESP_INTEGER:
+		line, err := p.readLine()
 		if err != nil {
-			return SimpleString{}, fmt.Errorf("error parsing simple string: %s", err)
+			return Value{}, err
 		}
-
-		return SimpleString{data}, nil
-	case RESP_BULK_STRING:
-		sizeString, err := p.input.ReadString(LN)
-
+		v.Int, err = strconv.ParseInt(line, 10, 64)
 		if err != nil {
-			return SimpleString{}, fmt.Errorf("error parsing rbd string: %s", err)
+			return Value{}, fmt.Errorf("Protocol error: invalid integer '%s'", line)
 		}
-		size, err := strconv.Atoi(strings.Trim(sizeString, CRNL))
-		log.Println("size", size)
+	case RESP_BULK_STRING, RESP_BULK_ERROR, RESP_VERBATIM_STRING:
+		size, err := p.readLength()
 		if err != nil {
-			return SimpleString{}, fmt.Errorf("error parsing rbd string: %s", err)
+			return Value{}, err
 		}
-
-		data := make([]byte, size)
-		p.input.Read(data)
-		if string(data[len(data)-2:]) == CRNL {
-
-			return BulkString{Data: string(bytes.Trim(data, CRNL))}, nil
-		} else {
-			return RDBFile{Data: data}, nil
+		if size < 0 {
+			v.Null = true
+			return v, nil
+		}
+		if v.Str, err = p.readBulkPayload(size); err != nil {
+			return Value{}, err
+		}
+		if dt == RESP_VERBATIM_STRING {
+			if len(v.Str) < VERBATIM_FORMAT_SZ+1 || v.Str[VERBATIM_FORMAT_SZ] != ':' {
+				return Value{}, fmt.Errorf("Protocol error: invalid verbatim string")
+			}
+			v.Format = string(v.Str[:VERBATIM_FORMAT_SZ])
+			v.Str = v.Str[VERBATIM_FORMAT_SZ+1:]
+		}
+	case RESP_NULL:
+		line, err := p.readLine()
+		if err != nil {
+			return Value{}, err
+		}
+		if line != "" {
+			return Value{}, fmt.Errorf("Protocol error: invalid null '%s'", line)
+		}
+		v.Null = true
+	case RESP_DOUBLE:
+		line, err := p.readLine()
+		if err != nil {
+			return Value{}, err
+		}
+		if v.Double, err = parseDouble(line); err != nil {
+			return Value{}, err
+		}
+	case RESP_BOOLEAN:
+		line, err := p.readLine()
+		if err != nil {
+			return Value{}, err
+		}
+		switch line {
+		case "t":
+			v.Bool = true
+		case "f":
+			v.Bool = false
+		default:
+			return Value{}, fmt.Errorf("Protocol error: invalid boolean '%s'", line)
+		}
+	case RESP_BIG_NUMBER:
+		line, err := p.readLine()
+		if err != nil {
+			return Value{}, err
+		}
+		if !isBigNumber(line) {
+			return Value{}, fmt.Errorf("Protocol error: invalid big number '%s'", line)
+		}
+		v.Str = []byte(line)
+	case RESP_ARRAY, RESP_SET, RESP_PUSH, RESP_MAP, RESP_ATTRIBUTE:
+		size, err := p.readLength()
+		if err != nil {
+			return Value{}, err
+		}
+		if size < 0 {
+			v.Null = true
+			return v, nil
+		}
+		if dt == RESP_MAP || dt == RESP_ATTRIBUTE {
+			if size > MAX_AGGREGATE_LEN/2 {
+				return Value{}, fmt.Errorf("Protocol error: invalid aggregate length %d", size)
+			}
+			size *= 2
+		}
+		v.Elems = make([]Value, 0, min(size, MAX_PREALLOCATED))
+		for range size {
+			elem, err := p.readValue(depth + 1)
+			if err != nil {
+				return Value{}, err
+			}
+			v.Elems = append(v.Elems, elem)
+		}
+		if dt == RESP_ATTRIBUTE {
+			// attributes decorate the next value
+			next, err := p.readValue(depth)
+			if err != nil {
+				return Value{}, err
+			}
+			next.Attrs = v.Elems
+			return next, nil
 		}
-
 	default:
-		unknowData := make([]byte, p.input.Buffered())
-		p.input.Read(unknowData)
-
-		return UnknownData{Dt: DataType(dataType), Data: unknowData}, nil
+		return Value{}, fmt.Errorf("Protocol error: unknown type '%c'", dt)
 	}
+	return v, nil
 }
 
 func (p *Parser) bulkStringToStringSlice(size int) ([]string, error, int) {
-	res := make([]string, 0, size)
-	total := 0
+	start := p.consumed
+	res := make([]string, 0, min(max(size, 0), MAX_PREALLOCATED))
 	for range size {
-		peekOne, err := p.input.Peek(1)
-
+		v, err := p.readValue(1)
 		if err != nil {
-			return nil, fmt.Errorf("Could not peek: %s", err), 0
+			return nil, fmt.Errorf("Could not read bulk string: %w", err), 0
 		}
+		if v.Type != RESP_BULK_STRING || v.Null {
+			return nil, fmt.Errorf("Protocol error: expected '$', got '%c'", v.Type), 0
+		}
+		res = append(res, string(v.Str))
+	}
+	return res, nil, p.consumed - start
+}
 
-		if isBulkStringDataType(peekOne[0]) {
-
-			lineByte, err := p.input.ReadString(LN)
-			total += len(lineByte)
-
-			if err != nil {
-				return nil, fmt.Errorf("Could read bulk string first line: %s", err), 0
-			}
-
-			lineByte = strings.TrimRight(lineByte, CRNL)
-			lineByte = strings.TrimLeft(lineByte, string(RESP_BULK_STRING))
-			dataSize, err := strconv.Atoi(lineByte)
+// readLine reads up to CRLF and returns the line without it
+func (p *Parser) readLine() (string, error) {
+	line, err := p.input.ReadString(LN)
+	p.consumed += len(line)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, CRNL) {
+		return "", fmt.Errorf("Protocol error: line not terminated by CRLF")
+	}
+	return line[:len(line)-len(CRNL)], nil
+}
 
-			if err != nil {
-				return nil, fmt.Errorf("data size atoi err: %s", err), 0
-			}
+// readLength reads the length header of bulk and aggregate types,
+// -1 means null
+func (p *Parser) readLength() (int, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return 0, err
+	}
+	size, err := strconv.Atoi(line)
+	if err != nil || size < -1 || size > MAX_AGGREGATE_LEN {
+		return 0, fmt.Errorf("Protocol error: invalid length '%s'", line)
+	}
+	return size, nil
+}
 
-			dataString, err := p.input.ReadString(LN)
-			total += len(dataString)
-			dataString = strings.TrimRight(dataString, CRNL)
+func (p *Parser) readBulkPayload(size int) ([]byte, error) {
+	data, err := p.readExactly(size)
+	if err != nil {
+		return nil, err
+	}
 
-			if !(len(dataString) == dataSize) {
-				return nil, fmt.Errorf("data size does not match data, size:%d data:%s", dataSize, dataString), 0
-			}
+	term := make([]byte, len(CRNL))
+	n, err := io.ReadFull(p.input, term)
+	p.consumed += n
+	if err == io.EOF {
+		// the payload is only complete with its CRLF
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if string(term) != CRNL {
+		return nil, fmt.Errorf("Protocol error: bulk payload not terminated by CRLF")
+	}
+	return data, nil
+}
 
-			res = append(res, dataString)
+func (p *Parser) readExactly(size int) ([]byte, error) {
+	if size > MAX_BULK_LEN {
+		return nil, fmt.Errorf("Protocol error: invalid bulk length %d", size)
+	}
+	data := make([]byte, size)
+	n, err := io.ReadFull(p.input, data)
+	p.consumed += n
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
 		}
+		return nil, err
 	}
-	return res, nil, total
+	return data, nil
 }
 
-func asciiByteToInt(b byte) (int, error) {
-	if b < '0' || b > '9' {
-		return 0, fmt.Errorf("invalid ASCII digit: %c", b)
+func parseDouble(s string) (float64, error) {
+	switch s {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
 	}
-	return int(b - '0'), nil
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Protocol error: invalid double '%s'", s)
+	}
+	return f, nil
+}
+
+func isBigNumber(s string) bool {
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	if digits == "" {
+		return false
+	}
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			return false
+		}
+	}
+	return true
 }
 
-func isBulkStringDataType(b byte) bool {
-	return DataType(b) == RESP_BULK_STRING
+func isRespType(dt DataType) bool {
+	switch dt {
+	case RESP_ARRAY, RESP_BULK_STRING, RESP_SIMPLE_STRING, RESP_SIMPLE_ERROR, RESP_INTEGER,
+		RESP_NULL, RESP_DOUBLE, RESP_BOOLEAN, RESP_BIG_NUMBER, RESP_BULK_ERROR,
+		RESP_VERBATIM_STRING, RESP_MAP, RESP_SET, RESP_ATTRIBUTE, RESP_PUSH:
+		return true
+	}
+	return false
 }
 
 func getCmdName(cmdLines []string) (string, error) {
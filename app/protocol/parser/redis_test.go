@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bufio"
+	"io"
 	"strings"
 	"testing"
 
@@ -83,11 +84,17 @@ func Test_getCmdInfo(t *testing.T) {
 			},
 		},
 		{
-			input: "*2\r\n$3\r\nget\r\n$4\r\ntest",
+			// the last argument misses its CRLF
+			input:       "*2\r\n$3\r\nget\r\n$4\r\ntest",
+			expectError: true,
+		},
+		{
+			// null and empty arrays are skipped
+			input: "*-1\r\n*0\r\n*1\r\n$4\r\nping\r\n",
 			expected: CmdInfo{
-				CmdName: "get",
-				Args:    []string{"test"},
-				Size:    21,
+				CmdName: "ping",
+				Args:    []string{},
+				Size:    23,
 			},
 		},
 	}
@@ -102,11 +109,240 @@ func Test_getCmdInfo(t *testing.T) {
 		got, err := parser.GetCmdInfo()
 
 		if tc.expectError {
-			assert.NotNil(t, err)
-		} else {
-			assert.Nil(t, err)
+			assert.NotNil(t, err, tc.input)
+			continue
 		}
-
+		assert.Nil(t, err)
 		assert.Equal(t, &tc.expected, got)
 	}
+
+	for _, input := range []string{"*-2\r\n", "*-1\r\n", "*0\r\n"} {
+		parser := NewParser(bufio.NewReader(strings.NewReader(input)))
+		_, err := parser.GetCmdInfo()
+		assert.NotNil(t, err, input)
+	}
+}
+
+func Test_ReadValue(t *testing.T) {
+
+	tests := []struct {
+		input       string
+		expected    Value
+		expectError bool
+	}{
+		{
+			input:    "$6\r\nfo\r\nbo\r\n",
+			expected: Value{Type: RESP_BULK_STRING, Str: []byte("fo\r\nbo")},
+		},
+		{
+			input:    "$-1\r\n",
+			expected: Value{Type: RESP_BULK_STRING, Null: true},
+		},
+		{
+			input:    "*-1\r\n",
+			expected: Value{Type: RESP_ARRAY, Null: true},
+		},
+		{
+			input:    "*0\r\n",
+			expected: Value{Type: RESP_ARRAY, Elems: []Value{}},
+		},
+		{
+			input:    "%-1\r\n",
+			expected: Value{Type: RESP_MAP, Null: true},
+		},
+		{
+			input:    ":-42\r\n",
+			expected: Value{Type: RESP_INTEGER, Int: -42},
+		},
+		{
+			input:    "-ERR boom\r\n",
+			expected: Value{Type: RESP_SIMPLE_ERROR, Str: []byte("ERR boom")},
+		},
+		{
+			input: "*2\r\n*1\r\n+a\r\n:1\r\n",
+			expected: Value{Type: RESP_ARRAY, Elems: []Value{
+				{Type: RESP_ARRAY, Elems: []Value{{Type: RESP_SIMPLE_STRING, Str: []byte("a")}}},
+				{Type: RESP_INTEGER, Int: 1},
+			}},
+		},
+		{
+			input: "%1\r\n+k\r\n#t\r\n",
+			expected: Value{Type: RESP_MAP, Elems: []Value{
+				{Type: RESP_SIMPLE_STRING, Str: []byte("k")},
+				{Type: RESP_BOOLEAN, Bool: true},
+			}},
+		},
+		{
+			input:    ",1.5\r\n",
+			expected: Value{Type: RESP_DOUBLE, Double: 1.5},
+		},
+		{
+			input:    "_\r\n",
+			expected: Value{Type: RESP_NULL, Null: true},
+		},
+		{
+			input:    "(-123456789012345678901234567890\r\n",
+			expected: Value{Type: RESP_BIG_NUMBER, Str: []byte("-123456789012345678901234567890")},
+		},
+		{
+			input:    "=7\r\ntxt:hey\r\n",
+			expected: Value{Type: RESP_VERBATIM_STRING, Format: "txt", Str: []byte("hey")},
+		},
+		{
+			input: "|1\r\n+ttl\r\n:3\r\n>1\r\n+msg\r\n",
+			expected: Value{
+				Type:  RESP_PUSH,
+				Elems: []Value{{Type: RESP_SIMPLE_STRING, Str: []byte("msg")}},
+				Attrs: []Value{{Type: RESP_SIMPLE_STRING, Str: []byte("ttl")}, {Type: RESP_INTEGER, Int: 3}},
+			},
+		},
+		{input: "$5\r\nab\r\n", expectError: true},
+		{input: "$3\r\nabcde\r\n", expectError: true},
+		{input: "#x\r\n", expectError: true},
+		{input: ":12\n", expectError: true},
+		{input: "*1\r\n", expectError: true},
+		{input: "*-2\r\n", expectError: true},
+		{input: "%-2\r\n", expectError: true},
+	}
+
+	for _, tc := range tests {
+		parser := NewParser(bufio.NewReader(strings.NewReader(tc.input)))
+
+		got, err := parser.ReadValue()
+
+		if tc.expectError {
+			assert.NotNil(t, err, tc.input)
+			continue
+		}
+		assert.Nil(t, err, tc.input)
+		assert.Equal(t, tc.expected, got)
+		assert.Equal(t, len(tc.input), parser.consumed)
+	}
+}
+
+func Test_ReadTruncatedBulk(t *testing.T) {
+
+	// a payload is only complete with its CRLF
+	for _, input := range []string{"$3\r\nfoo", "$3\r\nfoo\r", "$3\r\nfo"} {
+		_, err := NewParser(bufio.NewReader(strings.NewReader(input))).ReadValue()
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF, input)
+	}
+	_, err := NewParser(bufio.NewReader(strings.NewReader("*1\r\n$3\r\nfoo"))).ReadCommand()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func Test_readInline(t *testing.T) {
+
+	tests := []struct {
+		input       string
+		expected    CmdInfo
+		expectError bool
+	}{
+		{
+			input:    "PING\r\n",
+			expected: CmdInfo{CmdName: "ping", Args: []string{}, Size: 6},
+		},
+		{
+			input:    "set  foo bar\n",
+			expected: CmdInfo{CmdName: "set", Args: []string{"foo", "bar"}, Size: 13},
+		},
+		{
+			input:    "\r\n\r\nget foo\r\n",
+			expected: CmdInfo{CmdName: "get", Args: []string{"foo"}, Size: 9},
+		},
+		{
+			input:    `set "hello world" "a\"b\x41\n"` + "\r\n",
+			expected: CmdInfo{CmdName: "set", Args: []string{"hello world", "a\"bA\n"}, Size: 32},
+		},
+		{
+			input:    `echo 'it\'s' ''` + "\n",
+			expected: CmdInfo{CmdName: "echo", Args: []string{"it's", ""}, Size: 16},
+		},
+		{input: "set \"foo bar\r\n", expectError: true},
+		{input: "set \"foo\"bar\r\n", expectError: true},
+		{input: "set 'foo\r\n", expectError: true},
+	}
+
+	for _, tc := range tests {
+		parser := NewParser(bufio.NewReader(strings.NewReader(tc.input)))
+
+		got, err := parser.ParseIncomingData()
+
+		if tc.expectError {
+			assert.NotNil(t, err, tc.input)
+			continue
+		}
+		assert.Nil(t, err, tc.input)
+		assert.Equal(t, tc.expected, got)
+	}
+}
+
+// endlessReader sends the same byte forever counting how many were read
+type endlessReader struct {
+	read int
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	r.read += len(p)
+	return len(p), nil
+}
+
+func Test_readInlineTooBig(t *testing.T) {
+	// a line without LF fails once it is over the limit
+	input := &endlessReader{}
+	_, err := NewParser(bufio.NewReader(input)).ReadRequest()
+	assert.ErrorContains(t, err, "Protocol error: too big inline request")
+	assert.LessOrEqual(t, input.read, MAX_INLINE_SIZE+4096)
+
+	line := strings.Repeat("a", MAX_INLINE_SIZE-2) + "\r\n"
+	got, err := NewParser(bufio.NewReader(strings.NewReader(line))).ReadRequest()
+	assert.NoError(t, err)
+	assert.Equal(t, MAX_INLINE_SIZE, got.Size)
+	_, err = NewParser(bufio.NewReader(strings.NewReader("a" + line))).ReadRequest()
+	assert.ErrorContains(t, err, "too big inline request")
+}
+
+func Test_ReadRequest(t *testing.T) {
+
+	tests := []struct {
+		input       string
+		expected    CmdInfo
+		expectError bool
+	}{
+		{
+			input:    "*1\r\n$4\r\nping\r\n",
+			expected: CmdInfo{CmdName: "ping", Args: []string{}, Size: 14},
+		},
+		{
+			input:    "*0\r\n*1\r\n$4\r\nping\r\n",
+			expected: CmdInfo{CmdName: "ping", Args: []string{}, Size: 18},
+		},
+		{
+			// RESP3 type bytes are part of an inline command
+			input:    "%1 x\r\n",
+			expected: CmdInfo{CmdName: "%1", Args: []string{"x"}, Size: 6},
+		},
+		{
+			input:    "+PING\r\n",
+			expected: CmdInfo{CmdName: "+ping", Args: []string{}, Size: 7},
+		},
+		{input: "*-2\r\n", expectError: true},
+		{input: "*1\r\n:1\r\n", expectError: true},
+	}
+
+	for _, tc := range tests {
+		parser := NewParser(bufio.NewReader(strings.NewReader(tc.input)))
+
+		got, err := parser.ReadRequest()
+
+		if tc.expectError {
+			assert.NotNil(t, err, tc.input)
+			continue
+		}
+		assert.Nil(t, err, tc.input)
+		assert.Equal(t, tc.expected, got)
+	}
 }
@@ -0,0 +1,11 @@
+package redisdb
+
+import "hash/crc64"
+
+// crc64 of redis uses the Jones polynomial, reflected, without the
+// initial and final inversions of the standard library
+var crc64Table = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+func updateCRC64(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crc64Table, p)
+}
@@ -0,0 +1,558 @@
+package redisdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	// containers of the quicklist nodes
+	QUICKLIST_NODE_PLAIN  = 1
+	QUICKLIST_NODE_PACKED = 2
+
+	// opcodes of the values serialized by modules
+	RDB_MODULE_OPCODE_EOF    = 0
+	RDB_MODULE_OPCODE_SINT   = 1
+	RDB_MODULE_OPCODE_UINT   = 2
+	RDB_MODULE_OPCODE_FLOAT  = 3
+	RDB_MODULE_OPCODE_DOUBLE = 4
+	RDB_MODULE_OPCODE_STRING = 5
+
+	// lengths of the scores of the first zset type that are not numbers
+	RDB_DOUBLE_NAN     = 253
+	RDB_DOUBLE_POS_INF = 254
+	RDB_DOUBLE_NEG_INF = 255
+
+	// the counts read from the file only preallocate up to this
+	MAX_PREALLOC = 1024
+
+	MODULE_NAME_CHARSET = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+// readObject reads the key and the value of an object of type objType
+func (d *rdbDecoder) readObject(objType byte) (RDBEntry, error) {
+	key, err := d.readString()
+	if err != nil {
+		return RDBEntry{}, err
+	}
+	value, err := d.readValue(objType)
+	return RDBEntry{Key: key, Value: value}, err
+}
+
+// readValue decodes every encoding of the five types into its plain form
+func (d *rdbDecoder) readValue(objType byte) (RDBValue, error) {
+	switch objType {
+	case RDB_TYPE_STRING:
+		s, err := d.readString()
+		return RDBString(s), err
+	case RDB_TYPE_LIST:
+		elements, err := d.readStrings()
+		return RDBList(elements), err
+	case RDB_TYPE_SET:
+		members, err := d.readStrings()
+		return RDBSet(members), err
+	case RDB_TYPE_ZSET, RDB_TYPE_ZSET_2:
+		return d.readSortedSet(objType)
+	case RDB_TYPE_HASH:
+		return d.readHash()
+	case RDB_TYPE_LIST_QUICKLIST, RDB_TYPE_LIST_QUICKLIST_2:
+		return d.readQuicklist(objType)
+	case RDB_TYPE_HASH_ZIPMAP, RDB_TYPE_LIST_ZIPLIST, RDB_TYPE_SET_INTSET, RDB_TYPE_ZSET_ZIPLIST,
+		RDB_TYPE_HASH_ZIPLIST, RDB_TYPE_HASH_LISTPACK, RDB_TYPE_ZSET_LISTPACK, RDB_TYPE_SET_LISTPACK:
+		return d.readCompactValue(objType)
+	case RDB_TYPE_STREAM_LISTPACKS, RDB_TYPE_STREAM_LISTPACKS_2, RDB_TYPE_STREAM_LISTPACKS_3:
+		return d.readStream(objType)
+	case RDB_TYPE_MODULE_2:
+		id, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		return nil, d.errorf("the RDB file contains data for the module type '%s' that is not supported", moduleName(id))
+	}
+	return nil, d.errorf("unknown RDB object type %d", objType)
+}
+
+// readStrings reads a length followed by that many strings
+func (d *rdbDecoder) readStrings() ([]string, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]string, 0, min(n, MAX_PREALLOC))
+	for range n {
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, s)
+	}
+	return elements, nil
+}
+
+// readHash reads the number of fields followed by the field value pairs
+func (d *rdbDecoder) readHash() (RDBValue, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	hash := make(RDBHash, 0, min(n, MAX_PREALLOC))
+	for range n {
+		field, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		hash = append(hash, RDBField{Field: field, Value: value})
+	}
+	return hash, nil
+}
+
+func (d *rdbDecoder) readSortedSet(objType byte) (RDBValue, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	zset := make(RDBSortedSet, 0, min(n, MAX_PREALLOC))
+	for range n {
+		member, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if objType == RDB_TYPE_ZSET_2 {
+			var p []byte
+			if p, err = d.readBytes(8); err == nil {
+				score = math.Float64frombits(binary.LittleEndian.Uint64(p))
+			}
+		} else {
+			score, err = d.readDouble()
+		}
+		if err != nil {
+			return nil, err
+		}
+		zset = append(zset, RDBScoredMember{Member: member, Score: score})
+	}
+	return zset, nil
+}
+
+// readDouble reads a score of the first zset type, stored as a string with
+// a one byte length
+func (d *rdbDecoder) readDouble() (float64, error) {
+	length, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case RDB_DOUBLE_NAN:
+		return math.NaN(), nil
+	case RDB_DOUBLE_POS_INF:
+		return math.Inf(1), nil
+	case RDB_DOUBLE_NEG_INF:
+		return math.Inf(-1), nil
+	}
+	p, err := d.readBytes(int(length))
+	if err != nil {
+		return 0, err
+	}
+	score, err := strconv.ParseFloat(string(p), 64)
+	if err != nil {
+		return 0, d.errorf("invalid score %q", p)
+	}
+	return score, nil
+}
+
+// readQuicklist reads the nodes of a list, ziplists in the first version
+// and listpacks or plain elements in the second
+func (d *rdbDecoder) readQuicklist(objType byte) (RDBValue, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	list := RDBList{}
+	for range n {
+		container := uint64(QUICKLIST_NODE_PACKED)
+		if objType == RDB_TYPE_LIST_QUICKLIST_2 {
+			if container, err = d.readLength(); err != nil {
+				return nil, err
+			}
+		}
+		node, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case container == QUICKLIST_NODE_PLAIN:
+			list = append(list, node)
+			continue
+		case container != QUICKLIST_NODE_PACKED:
+			return nil, d.errorf("unknown quicklist node container %d", container)
+		}
+
+		var elements []string
+		if objType == RDB_TYPE_LIST_QUICKLIST_2 {
+			elements, err = listpackElements([]byte(node))
+		} else {
+			elements, err = ziplistElements([]byte(node))
+		}
+		if err != nil {
+			return nil, d.errorf("%s", err)
+		}
+		if len(elements) == 0 {
+			return nil, d.errorf("empty quicklist node")
+		}
+		list = append(list, elements...)
+	}
+	return list, nil
+}
+
+// readCompactValue reads the collections stored in a single string
+func (d *rdbDecoder) readCompactValue(objType byte) (RDBValue, error) {
+	blob, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []string
+	switch objType {
+	case RDB_TYPE_HASH_ZIPMAP:
+		elements, err = zipmapElements([]byte(blob))
+	case RDB_TYPE_SET_INTSET:
+		elements, err = intsetElements([]byte(blob))
+	case RDB_TYPE_LIST_ZIPLIST, RDB_TYPE_ZSET_ZIPLIST, RDB_TYPE_HASH_ZIPLIST:
+		elements, err = ziplistElements([]byte(blob))
+	default:
+		elements, err = listpackElements([]byte(blob))
+	}
+	if err != nil {
+		return nil, d.errorf("%s", err)
+	}
+
+	switch objType {
+	case RDB_TYPE_LIST_ZIPLIST:
+		return RDBList(elements), nil
+	case RDB_TYPE_SET_INTSET, RDB_TYPE_SET_LISTPACK:
+		return RDBSet(elements), nil
+	case RDB_TYPE_ZSET_ZIPLIST, RDB_TYPE_ZSET_LISTPACK:
+		if len(elements)%2 != 0 {
+			return nil, d.errorf("sorted set member without score")
+		}
+		zset := make(RDBSortedSet, 0, len(elements)/2)
+		for i := 0; i < len(elements); i += 2 {
+			score, err := strconv.ParseFloat(elements[i+1], 64)
+			if err != nil {
+				return nil, d.errorf("invalid score %q", elements[i+1])
+			}
+			zset = append(zset, RDBScoredMember{Member: elements[i], Score: score})
+		}
+		return zset, nil
+	}
+	hash, err := toHash(elements)
+	if err != nil {
+		return nil, d.errorf("%s", err)
+	}
+	return hash, nil
+}
+
+func toHash(pairs []string) (RDBHash, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("hash field without value")
+	}
+	hash := make(RDBHash, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		hash = append(hash, RDBField{Field: pairs[i], Value: pairs[i+1]})
+	}
+	return hash, nil
+}
+
+// readStream reads the listpack nodes of the entries, the metadata of the
+// stream and its consumer groups, the versions before the third one have
+// less metadata
+func (d *rdbDecoder) readStream(objType byte) (RDBValue, error) {
+	nodes, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	stream := &RDBStream{}
+	for range nodes {
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, d.errorf("stream node key of %d bytes", len(key))
+		}
+		master := RDBStreamID{Ms: binary.BigEndian.Uint64([]byte(key)), Seq: binary.BigEndian.Uint64([]byte(key[8:]))}
+		node, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		elements, err := listpackElements([]byte(node))
+		if err != nil {
+			return nil, d.errorf("%s", err)
+		}
+		entries, err := streamNodeEntries(master, elements)
+		if err != nil {
+			return nil, d.errorf("%s", err)
+		}
+		stream.Entries = append(stream.Entries, entries...)
+	}
+
+	// the number of entries is implied by the nodes
+	if _, err = d.readLength(); err != nil {
+		return nil, err
+	}
+	if stream.LastID, err = d.readStreamID(); err != nil {
+		return nil, err
+	}
+	if objType >= RDB_TYPE_STREAM_LISTPACKS_2 {
+		// the first id is the one of the first entry
+		if _, err = d.readStreamID(); err != nil {
+			return nil, err
+		}
+		if stream.MaxDeletedID, err = d.readStreamID(); err != nil {
+			return nil, err
+		}
+		if stream.EntriesAdded, err = d.readLength(); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for range groups {
+		group, err := d.readStreamGroup(objType)
+		if err != nil {
+			return nil, err
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return stream, nil
+}
+
+func (d *rdbDecoder) readStreamGroup(objType byte) (RDBStreamGroup, error) {
+	group := RDBStreamGroup{EntriesRead: -1}
+	var err error
+	if group.Name, err = d.readString(); err != nil {
+		return group, err
+	}
+	if group.LastID, err = d.readStreamID(); err != nil {
+		return group, err
+	}
+	if objType >= RDB_TYPE_STREAM_LISTPACKS_2 {
+		entriesRead, err := d.readLength()
+		if err != nil {
+			return group, err
+		}
+		group.EntriesRead = int64(entriesRead)
+	}
+
+	pending, err := d.readLength()
+	if err != nil {
+		return group, err
+	}
+	for range pending {
+		var nack RDBStreamPending
+		if nack.ID, err = d.readRawStreamID(); err != nil {
+			return group, err
+		}
+		if nack.DeliveryTime, err = d.readMillisecondTime(); err != nil {
+			return group, err
+		}
+		if nack.DeliveryCount, err = d.readLength(); err != nil {
+			return group, err
+		}
+		group.Pending = append(group.Pending, nack)
+	}
+
+	consumers, err := d.readLength()
+	if err != nil {
+		return group, err
+	}
+	for range consumers {
+		var consumer RDBStreamConsumer
+		if consumer.Name, err = d.readString(); err != nil {
+			return group, err
+		}
+		if consumer.SeenTime, err = d.readMillisecondTime(); err != nil {
+			return group, err
+		}
+		consumer.ActiveTime = -1
+		if objType >= RDB_TYPE_STREAM_LISTPACKS_3 {
+			if consumer.ActiveTime, err = d.readMillisecondTime(); err != nil {
+				return group, err
+			}
+		}
+		n, err := d.readLength()
+		if err != nil {
+			return group, err
+		}
+		for range n {
+			id, err := d.readRawStreamID()
+			if err != nil {
+				return group, err
+			}
+			consumer.Pending = append(consumer.Pending, id)
+		}
+		group.Consumers = append(group.Consumers, consumer)
+	}
+	return group, nil
+}
+
+func (d *rdbDecoder) readStreamID() (RDBStreamID, error) {
+	ms, err := d.readLength()
+	if err != nil {
+		return RDBStreamID{}, err
+	}
+	seq, err := d.readLength()
+	return RDBStreamID{Ms: ms, Seq: seq}, err
+}
+
+func (d *rdbDecoder) readRawStreamID() (RDBStreamID, error) {
+	p, err := d.readBytes(16)
+	if err != nil {
+		return RDBStreamID{}, err
+	}
+	return RDBStreamID{Ms: binary.BigEndian.Uint64(p), Seq: binary.BigEndian.Uint64(p[8:])}, nil
+}
+
+func (d *rdbDecoder) readMillisecondTime() (int64, error) {
+	p, err := d.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(p)), nil
+}
+
+// streamNodeEntries decodes the entries of a listpack node, its master
+// entry holds the fields the entries flagged with the same fields share
+// and the ids are relative to master
+func streamNodeEntries(master RDBStreamID, elements []string) ([]RDBStreamEntry, error) {
+	lp := &streamNode{elements: elements}
+	count, deleted := lp.int(), lp.int()
+	masterFields := make([]string, lp.int())
+	for i := range masterFields {
+		masterFields[i] = lp.string()
+	}
+	// the master entry ends with a 0
+	lp.int()
+
+	entries := make([]RDBStreamEntry, 0, min(count, MAX_PREALLOC))
+	for range count + deleted {
+		flags := lp.int()
+		id := RDBStreamID{Ms: master.Ms + uint64(lp.int()), Seq: master.Seq + uint64(lp.int())}
+		var fields []string
+		if flags&STREAM_ITEM_FLAG_SAMEFIELDS != 0 {
+			fields = make([]string, 0, 2*len(masterFields))
+			for _, field := range masterFields {
+				fields = append(fields, field, lp.string())
+			}
+		} else {
+			fields = make([]string, 2*max(lp.int(), 0))
+			for i := range fields {
+				fields[i] = lp.string()
+			}
+		}
+		// the number of elements of the entry, to walk the node backwards
+		lp.int()
+		if lp.err != nil {
+			return nil, lp.err
+		}
+		if flags&STREAM_ITEM_FLAG_DELETED == 0 {
+			entries = append(entries, RDBStreamEntry{ID: id, Fields: fields})
+		}
+	}
+	if lp.err == nil && lp.pos != len(elements) {
+		return nil, fmt.Errorf("stream node with %d elements left", len(elements)-lp.pos)
+	}
+	return entries, lp.err
+}
+
+// streamNode walks the elements of a stream node keeping the first error
+type streamNode struct {
+	elements []string
+	pos      int
+	err      error
+}
+
+func (lp *streamNode) string() string {
+	if lp.err != nil {
+		return ""
+	}
+	if lp.pos >= len(lp.elements) {
+		lp.err = fmt.Errorf("truncated stream node")
+		return ""
+	}
+	lp.pos++
+	return lp.elements[lp.pos-1]
+}
+
+func (lp *streamNode) int() int64 {
+	s := lp.string()
+	if lp.err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		lp.err = fmt.Errorf("invalid stream node integer %q", s)
+	}
+	return n
+}
+
+// skipModuleAux skips the data a module stores out of its keys, it is
+// written with the self describing opcodes of the second module format
+func (d *rdbDecoder) skipModuleAux() error {
+	id, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	when, err := d.readLength()
+	if err == nil && when != RDB_MODULE_OPCODE_UINT {
+		err = d.errorf("invalid module aux of '%s'", moduleName(id))
+	}
+	if err == nil {
+		_, err = d.readLength()
+	}
+	if err != nil {
+		return err
+	}
+	for {
+		opcode, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case RDB_MODULE_OPCODE_EOF:
+			return nil
+		case RDB_MODULE_OPCODE_SINT, RDB_MODULE_OPCODE_UINT:
+			_, err = d.readLength()
+		case RDB_MODULE_OPCODE_FLOAT:
+			_, err = d.readBytes(4)
+		case RDB_MODULE_OPCODE_DOUBLE:
+			_, err = d.readBytes(8)
+		case RDB_MODULE_OPCODE_STRING:
+			_, err = d.readString()
+		default:
+			err = d.errorf("unknown module opcode %d in the aux of '%s'", opcode, moduleName(id))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// moduleName decodes the 9 characters of the name of a module from the
+// 54 high bits of its id, the low 10 bits are its encoding version
+func moduleName(id uint64) string {
+	name := make([]byte, 9)
+	for i := range name {
+		name[i] = MODULE_NAME_CHARSET[(id>>(10+6*(8-i)))&63]
+	}
+	return string(name)
+}
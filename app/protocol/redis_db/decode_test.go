@@ -0,0 +1,144 @@
+package redisdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newListpack(elements ...string) []byte {
+	lp := &listpack{}
+	for _, element := range elements {
+		lp.appendString(element)
+	}
+	return lp.bytes()
+}
+
+func Test_LoadRDBFileObjects(t *testing.T) {
+	hash := newListpack("f", "v", "n", "1")
+	zset := newListpack("a", "1.5", "b", "2")
+	set := newListpack("x", "10")
+	node := newListpack("a", "b")
+	ziplist := []byte{
+		17, 0, 0, 0, 14, 0, 0, 0, 2, 0,
+		0, 0x02, 'a', 'b',
+		4, 0xF6,
+		ZIPLIST_END,
+	}
+	intset := []byte{2, 0, 0, 0, 3, 0, 0, 0, 1, 0, 2, 0, 0xFD, 0xFF}
+	zipmap := []byte{1, 1, 'f', 1, 0, 'v', ZIPMAP_END}
+
+	tests := []struct {
+		name   string
+		body   []byte
+		expect RDBValue
+	}{
+		{name: "list", body: []byte{RDB_TYPE_LIST, 1, 'k', 2, 1, 'a', 1, 'b'}, expect: RDBList{"a", "b"}},
+		{name: "hash", body: []byte{RDB_TYPE_HASH, 1, 'k', 2, 1, 'f', 1, 'v', 1, 'g', 1, 'w'}, expect: RDBHash{{Field: "f", Value: "v"}, {Field: "g", Value: "w"}}},
+		{
+			// scores as strings and the special lengths of the infinities
+			name:   "sorted set",
+			body:   []byte{RDB_TYPE_ZSET, 1, 'k', 2, 1, 'a', 3, '1', '.', '5', 1, 'b', RDB_DOUBLE_NEG_INF},
+			expect: RDBSortedSet{{Member: "a", Score: 1.5}, {Member: "b", Score: math.Inf(-1)}},
+		},
+		{name: "hash listpack", body: concat([]byte{RDB_TYPE_HASH_LISTPACK, 1, 'k', byte(len(hash))}, hash), expect: RDBHash{{Field: "f", Value: "v"}, {Field: "n", Value: "1"}}},
+		{name: "sorted set listpack", body: concat([]byte{RDB_TYPE_ZSET_LISTPACK, 1, 'k', byte(len(zset))}, zset), expect: RDBSortedSet{{Member: "a", Score: 1.5}, {Member: "b", Score: 2}}},
+		{name: "set listpack", body: concat([]byte{RDB_TYPE_SET_LISTPACK, 1, 'k', byte(len(set))}, set), expect: RDBSet{"x", "10"}},
+		{name: "intset", body: concat([]byte{RDB_TYPE_SET_INTSET, 1, 'k', byte(len(intset))}, intset), expect: RDBSet{"1", "2", "-3"}},
+		{name: "list ziplist", body: concat([]byte{RDB_TYPE_LIST_ZIPLIST, 1, 'k', byte(len(ziplist))}, ziplist), expect: RDBList{"ab", "5"}},
+		{name: "hash zipmap", body: concat([]byte{RDB_TYPE_HASH_ZIPMAP, 1, 'k', byte(len(zipmap))}, zipmap), expect: RDBHash{{Field: "f", Value: "v"}}},
+		{name: "quicklist", body: concat([]byte{RDB_TYPE_LIST_QUICKLIST, 1, 'k', 1, byte(len(ziplist))}, ziplist), expect: RDBList{"ab", "5"}},
+		{
+			// a plain node holds a single large element
+			name: "quicklist 2",
+			body: concat([]byte{
+				RDB_TYPE_LIST_QUICKLIST_2, 1, 'k', 2,
+				QUICKLIST_NODE_PLAIN, 3, 'b', 'i', 'g',
+				QUICKLIST_NODE_PACKED, byte(len(node))}, node),
+			expect: RDBList{"big", "a", "b"},
+		},
+	}
+
+	for _, tc := range tests {
+		file, err := LoadRDBFile(rdbFixture(concat([]byte{DB_SELECTOR, 1}, tc.body)))
+		if !assert.NoError(t, err, tc.name) {
+			continue
+		}
+		assert.Empty(t, file.Kv, tc.name)
+		assert.Equal(t, []RDBObject{{RDBEntry: RDBEntry{Key: "k", Value: tc.expect}, DB: 1}}, file.Objects, tc.name)
+	}
+}
+
+func Test_LoadRDBFileSkipsModuleAux(t *testing.T) {
+	aux := []byte{
+		RDB_OPCODE_MODULE_AUX, 5, RDB_MODULE_OPCODE_UINT, 2,
+		RDB_MODULE_OPCODE_UINT, 7,
+		RDB_MODULE_OPCODE_STRING, 1, 'x',
+		RDB_MODULE_OPCODE_DOUBLE, 0, 0, 0, 0, 0, 0, 0xF8, 0x3F,
+		RDB_MODULE_OPCODE_EOF,
+	}
+	file, err := LoadRDBFile(rdbFixture(concat(aux, []byte{DB_SELECTOR, 0, RDB_TYPE_STRING, 1, 'k', 1, 'v'})))
+	assert.NoError(t, err)
+	assert.Equal(t, []RDBSimplePair{{Key: "k", Value: "v"}}, file.Kv)
+}
+
+func Test_StreamNodeEntries(t *testing.T) {
+	entries, err := streamNodeEntries(RDBStreamID{Ms: 1}, []string{
+		// one entry and one deleted, the master fields
+		"1", "1", "1", "f", "0",
+		"3", "0", "0", "a", "4",
+		"2", "1", "2", "b", "4",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []RDBStreamEntry{{ID: RDBStreamID{Ms: 2, Seq: 2}, Fields: []string{"f", "b"}}}, entries)
+
+	_, err = streamNodeEntries(RDBStreamID{}, []string{"1", "0", "1", "f", "0", "2", "0"})
+	assert.Error(t, err)
+}
+
+func Test_RDBStreamRoundTrip(t *testing.T) {
+	id := func(ms, seq uint64) RDBStreamID { return RDBStreamID{Ms: ms, Seq: seq} }
+	stream := &RDBStream{
+		Entries: []RDBStreamEntry{
+			{ID: id(1, 0), Fields: []string{"f", "a"}},
+			{ID: id(2, 5), Fields: []string{"g", "b", "h", "c"}},
+		},
+		LastID:       id(3, 0),
+		MaxDeletedID: id(3, 0),
+		EntriesAdded: 3,
+		Groups: []RDBStreamGroup{
+			{
+				Name:        "g",
+				LastID:      id(2, 5),
+				EntriesRead: 2,
+				Pending:     []RDBStreamPending{{ID: id(1, 0), DeliveryTime: 1700000000000, DeliveryCount: 2}},
+				Consumers: []RDBStreamConsumer{
+					{Name: "c", SeenTime: 1700000000001, ActiveTime: 1700000000000, Pending: []RDBStreamID{id(1, 0)}},
+				},
+			},
+			{Name: "unread", EntriesRead: -1},
+		},
+	}
+
+	var buf bytes.Buffer
+	w := NewRDBWriter(&buf)
+	assert.NoError(t, w.WriteDB(0, []RDBEntry{{Key: "s", Value: stream}}))
+	assert.NoError(t, w.Close())
+
+	file, err := LoadRDBFile(buf.Bytes())
+	if assert.NoError(t, err) && assert.Len(t, file.Objects, 1) {
+		assert.Equal(t, stream, file.Objects[0].Value)
+	}
+
+	// the ids of the nodes are 16 bytes
+	body := []byte{RDB_TYPE_STREAM_LISTPACKS, 1, 's', 1, 1, 'x'}
+	_, err = LoadRDBFile(rdbFixture(concat([]byte{DB_SELECTOR, 0}, body)))
+	assert.ErrorContains(t, err, "stream node key of 1 bytes")
+}
+
+func Test_ModuleName(t *testing.T) {
+	assert.Equal(t, "ReJSON-RL", moduleName(binary.BigEndian.Uint64([]byte{0x45, 0xE2, 0x52, 0x38, 0xDF, 0x91, 0x2C, 0x03})))
+}
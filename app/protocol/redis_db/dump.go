@@ -0,0 +1,50 @@
+package redisdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// the payload of DUMP ends with the rdb version in 2 bytes and the crc64
+// of everything before it
+const DUMP_FOOTER_SIZE = 10
+
+// DumpValue serializes value like DUMP, the object type and the value in
+// the rdb format followed by the footer
+func DumpValue(value RDBValue) []byte {
+	var buf bytes.Buffer
+	rw := &RDBWriter{w: bufio.NewWriter(&buf), buf: make([]byte, 0, 16)}
+	rw.write([]byte{value.Type()})
+	rw.writeValue(value)
+	rw.write(binary.LittleEndian.AppendUint16(nil, RDB_VERSION))
+	rw.w.Write(binary.LittleEndian.AppendUint64(nil, rw.crc))
+	rw.w.Flush()
+	return buf.Bytes()
+}
+
+// RestoreValue decodes a payload of DUMP, payloads of newer versions and
+// the ones with a wrong checksum are rejected
+func RestoreValue(payload []byte) (RDBValue, error) {
+	if len(payload) < DUMP_FOOTER_SIZE {
+		return nil, fmt.Errorf("DUMP payload version or checksum are wrong")
+	}
+	body, footer := payload[:len(payload)-DUMP_FOOTER_SIZE], payload[len(payload)-DUMP_FOOTER_SIZE:]
+	version := binary.LittleEndian.Uint16(footer)
+	crc := binary.LittleEndian.Uint64(footer[2:])
+	if version > RDB_VERSION || crc != updateCRC64(0, payload[:len(payload)-8]) {
+		return nil, fmt.Errorf("DUMP payload version or checksum are wrong")
+	}
+
+	d := &rdbDecoder{r: bufio.NewReader(bytes.NewReader(body))}
+	objType, err := d.readByte()
+	if err != nil {
+		return nil, fmt.Errorf("Bad data format")
+	}
+	value, err := d.readValue(objType)
+	if err != nil || d.pos != int64(len(body)) {
+		return nil, fmt.Errorf("Bad data format")
+	}
+	return value, nil
+}
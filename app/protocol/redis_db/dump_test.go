@@ -0,0 +1,72 @@
+package redisdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DumpValue(t *testing.T) {
+	values := []RDBValue{
+		RDBString("hello"),
+		RDBString("10"),
+		RDBList{"a", "b"},
+		RDBHash{{Field: "f", Value: "v"}},
+		RDBSet{"m"},
+		RDBSortedSet{{Member: "m", Score: 1.5}},
+		&RDBStream{
+			Entries:      []RDBStreamEntry{{ID: RDBStreamID{Ms: 1, Seq: 1}, Fields: []string{"f", "v"}}},
+			LastID:       RDBStreamID{Ms: 1, Seq: 1},
+			EntriesAdded: 1,
+		},
+	}
+
+	for _, value := range values {
+		payload := DumpValue(value)
+		assert.Equal(t, value.Type(), payload[0])
+		assert.Equal(t, []byte{RDB_VERSION, 0}, payload[len(payload)-DUMP_FOOTER_SIZE:len(payload)-8])
+		restored, err := RestoreValue(payload)
+		assert.NoError(t, err)
+		assert.Equal(t, value, restored)
+	}
+}
+
+func Test_RestoreValue(t *testing.T) {
+	// DUMP of the integer 10 by redis 5, with an rdb version 9 footer
+	value, err := RestoreValue([]byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, RDBString("10"), value)
+
+	payload := DumpValue(RDBString("hello"))
+	tests := []struct {
+		name    string
+		payload []byte
+		err     string
+	}{
+		{name: "too short", payload: payload[:5], err: "DUMP payload version or checksum are wrong"},
+		{name: "wrong checksum", payload: append(append([]byte{}, payload[:len(payload)-1]...), 0), err: "DUMP payload version or checksum are wrong"},
+		{name: "newer version", payload: withFooter(payload[:len(payload)-DUMP_FOOTER_SIZE], RDB_VERSION+1), err: "DUMP payload version or checksum are wrong"},
+		{name: "trailing bytes", payload: withFooter(append(append([]byte{}, payload[:len(payload)-DUMP_FOOTER_SIZE]...), 'x'), RDB_VERSION), err: "Bad data format"},
+		{name: "unknown type", payload: withFooter([]byte{99, 1, 'x'}, RDB_VERSION), err: "Bad data format"},
+		{
+			name:    "lzf length too big",
+			payload: withFooter([]byte{RDB_TYPE_STRING, RDB_ENC_LZF, 2, RDB_LEN_32BIT, 0x7F, 0xFF, 0xFF, 0xFF, 0x00, 'a'}, RDB_VERSION),
+			err:     "Bad data format",
+		},
+	}
+
+	for _, tc := range tests {
+		_, err := RestoreValue(tc.payload)
+		assert.EqualError(t, err, tc.err, tc.name)
+	}
+}
+
+// withFooter appends the footer of a payload of that version to body
+func withFooter(body []byte, version uint16) []byte {
+	payload := append(append([]byte{}, body...), byte(version), byte(version>>8))
+	crc := updateCRC64(0, payload)
+	for i := 0; i < 8; i++ {
+		payload = append(payload, byte(crc>>(8*i)))
+	}
+	return payload
+}
@@ -0,0 +1,201 @@
+package redisdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+const (
+	LISTPACK_HEADER_SIZE = 6
+	LISTPACK_END         = 0xFF
+	// element count of a listpack with more elements than the header fits
+	LISTPACK_UNKNOWN_COUNT = 65535
+)
+
+// listpack serializes elements in the format redis keeps small collections
+// and stream nodes in, strings that are integers take the integer encodings
+type listpack struct {
+	entries []byte
+	count   int
+}
+
+func (lp *listpack) appendString(s string) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+		lp.appendInt(n)
+		return
+	}
+	start := len(lp.entries)
+	switch size := len(s); {
+	case size < 64:
+		lp.entries = append(lp.entries, 0x80|byte(size))
+	case size < 4096:
+		lp.entries = append(lp.entries, 0xE0|byte(size>>8), byte(size))
+	default:
+		lp.entries = append(lp.entries, 0xF0)
+		lp.entries = binary.LittleEndian.AppendUint32(lp.entries, uint32(size))
+	}
+	lp.entries = append(lp.entries, s...)
+	lp.appendBacklen(len(lp.entries) - start)
+}
+
+func (lp *listpack) appendInt(n int64) {
+	start := len(lp.entries)
+	switch {
+	case n >= 0 && n <= 127:
+		lp.entries = append(lp.entries, byte(n))
+	case n >= -4096 && n <= 4095:
+		v := uint64(n) & 0x1FFF
+		lp.entries = append(lp.entries, 0xC0|byte(v>>8), byte(v))
+	case n >= -32768 && n <= 32767:
+		lp.entries = append(lp.entries, 0xF1)
+		lp.entries = binary.LittleEndian.AppendUint16(lp.entries, uint16(n))
+	case n >= -8388608 && n <= 8388607:
+		v := uint32(n)
+		lp.entries = append(lp.entries, 0xF2, byte(v), byte(v>>8), byte(v>>16))
+	case n >= -2147483648 && n <= 2147483647:
+		lp.entries = append(lp.entries, 0xF3)
+		lp.entries = binary.LittleEndian.AppendUint32(lp.entries, uint32(n))
+	default:
+		lp.entries = append(lp.entries, 0xF4)
+		lp.entries = binary.LittleEndian.AppendUint64(lp.entries, uint64(n))
+	}
+	lp.appendBacklen(len(lp.entries) - start)
+}
+
+// appendBacklen stores the size of the element after it, seven bits per
+// byte, so the listpack can be walked backwards
+func (lp *listpack) appendBacklen(size int) {
+	switch backlenSize(size) {
+	case 1:
+		lp.entries = append(lp.entries, byte(size))
+	case 2:
+		lp.entries = append(lp.entries, byte(size>>7), byte(size&127)|128)
+	case 3:
+		lp.entries = append(lp.entries, byte(size>>14), byte((size>>7)&127)|128, byte(size&127)|128)
+	case 4:
+		lp.entries = append(lp.entries, byte(size>>21), byte((size>>14)&127)|128, byte((size>>7)&127)|128, byte(size&127)|128)
+	default:
+		lp.entries = append(lp.entries, byte(size>>28), byte((size>>21)&127)|128, byte((size>>14)&127)|128,
+			byte((size>>7)&127)|128, byte(size&127)|128)
+	}
+	lp.count++
+}
+
+func (lp *listpack) bytes() []byte {
+	res := make([]byte, 0, LISTPACK_HEADER_SIZE+len(lp.entries)+1)
+	res = binary.LittleEndian.AppendUint32(res, uint32(LISTPACK_HEADER_SIZE+len(lp.entries)+1))
+	res = binary.LittleEndian.AppendUint16(res, uint16(min(lp.count, LISTPACK_UNKNOWN_COUNT)))
+	res = append(res, lp.entries...)
+	return append(res, LISTPACK_END)
+}
+
+// listpackElements decodes the elements of a listpack, the integers in
+// their decimal form
+func listpackElements(lp []byte) ([]string, error) {
+	if len(lp) < LISTPACK_HEADER_SIZE+1 || int(binary.LittleEndian.Uint32(lp)) != len(lp) {
+		return nil, fmt.Errorf("invalid listpack header")
+	}
+	count := int(binary.LittleEndian.Uint16(lp[4:]))
+	elements := make([]string, 0, min(count, len(lp)))
+	for i := LISTPACK_HEADER_SIZE; ; {
+		if i >= len(lp) {
+			return nil, fmt.Errorf("listpack without end")
+		}
+		if lp[i] == LISTPACK_END {
+			break
+		}
+		element, size, err := listpackElement(lp[i:])
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		i += size + backlenSize(size)
+	}
+	if count != LISTPACK_UNKNOWN_COUNT && count != len(elements) {
+		return nil, fmt.Errorf("listpack of %d elements, header says %d", len(elements), count)
+	}
+	return elements, nil
+}
+
+// listpackElement decodes the element at the start of p returning it with
+// its size without the backlen
+func listpackElement(p []byte) (string, int, error) {
+	b := p[0]
+	var n int64
+	size := 0
+	switch {
+	case b < 0x80:
+		return strconv.Itoa(int(b)), 1, nil
+	case b < 0xC0:
+		return listpackString(p, 1, int(b&0x3F))
+	case b < 0xE0:
+		if len(p) < 2 {
+			return "", 0, fmt.Errorf("truncated listpack element")
+		}
+		// 13 bits two's complement
+		n = int64(b&0x1F)<<8 | int64(p[1])
+		if n >= 1<<12 {
+			n -= 1 << 13
+		}
+		return strconv.FormatInt(n, 10), 2, nil
+	case b < 0xF0:
+		if len(p) < 2 {
+			return "", 0, fmt.Errorf("truncated listpack element")
+		}
+		return listpackString(p, 2, int(b&0x0F)<<8|int(p[1]))
+	case b == 0xF0:
+		if len(p) < 5 {
+			return "", 0, fmt.Errorf("truncated listpack element")
+		}
+		return listpackString(p, 5, int(binary.LittleEndian.Uint32(p[1:])))
+	case b == 0xF1:
+		size = 3
+	case b == 0xF2:
+		size = 4
+	case b == 0xF3:
+		size = 5
+	case b == 0xF4:
+		size = 9
+	default:
+		return "", 0, fmt.Errorf("invalid listpack encoding 0x%x", b)
+	}
+	if len(p) < size {
+		return "", 0, fmt.Errorf("truncated listpack element")
+	}
+	switch size {
+	case 3:
+		n = int64(int16(binary.LittleEndian.Uint16(p[1:])))
+	case 4:
+		n = int64(int32(uint32(p[1])|uint32(p[2])<<8|uint32(p[3])<<16)<<8) >> 8
+	case 5:
+		n = int64(int32(binary.LittleEndian.Uint32(p[1:])))
+	case 9:
+		n = int64(binary.LittleEndian.Uint64(p[1:]))
+	}
+	return strconv.FormatInt(n, 10), size, nil
+}
+
+func listpackString(p []byte, header, length int) (string, int, error) {
+	if length < 0 || header+length > len(p) {
+		return "", 0, fmt.Errorf("truncated listpack element")
+	}
+	return string(p[header : header+length]), header + length, nil
+}
+
+// backlenSize is the number of bytes appendBacklen takes for an element
+// of size bytes, with the bounds of lpEncodeBacklen that keep one value
+// short of each power of 128
+func backlenSize(size int) int {
+	switch {
+	case size < 128:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+	return 5
+}
@@ -0,0 +1,60 @@
+package redisdb
+
+import "fmt"
+
+// the longest back reference takes 3 bytes and expands to 264, no lzf
+// string can be longer than 88 times its compressed size
+const LZF_MAX_EXPANSION = 264 / 3
+
+// lzfDecompress expands the lzf compressed strings of the rdb format,
+// length is the size of the uncompressed string stored along with it. A
+// length the input can not expand to is rejected before allocating it
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	if length > len(in)*LZF_MAX_EXPANSION {
+		return nil, fmt.Errorf("lzf string of %d bytes can't expand to %d", len(in), length)
+	}
+	out := make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		// literal run of ctrl+1 bytes
+		if ctrl < 1<<5 {
+			ctrl++
+			if i+ctrl > len(in) || len(out)+ctrl > length {
+				return nil, fmt.Errorf("invalid lzf literal run")
+			}
+			out = append(out, in[i:i+ctrl]...)
+			i += ctrl
+			continue
+		}
+
+		// back reference of size+2 bytes, 7 means the size goes on in the
+		// next byte
+		size := ctrl >> 5
+		if size == 7 {
+			if i >= len(in) {
+				return nil, fmt.Errorf("invalid lzf back reference")
+			}
+			size += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, fmt.Errorf("invalid lzf back reference")
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		size += 2
+		if ref < 0 || len(out)+size > length {
+			return nil, fmt.Errorf("invalid lzf back reference")
+		}
+		// the reference can overlap the bytes it produces
+		for j := range size {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != length {
+		return nil, fmt.Errorf("lzf string of %d bytes, expected %d", len(out), length)
+	}
+	return out, nil
+}
@@ -0,0 +1,133 @@
+package redisdb
+
+const (
+	// object types of the rdb format
+	RDB_TYPE_STRING             = 0
+	RDB_TYPE_LIST               = 1
+	RDB_TYPE_SET                = 2
+	RDB_TYPE_ZSET               = 3
+	RDB_TYPE_HASH               = 4
+	RDB_TYPE_ZSET_2             = 5
+	RDB_TYPE_MODULE_2           = 7
+	RDB_TYPE_HASH_ZIPMAP        = 9
+	RDB_TYPE_LIST_ZIPLIST       = 10
+	RDB_TYPE_SET_INTSET         = 11
+	RDB_TYPE_ZSET_ZIPLIST       = 12
+	RDB_TYPE_HASH_ZIPLIST       = 13
+	RDB_TYPE_LIST_QUICKLIST     = 14
+	RDB_TYPE_STREAM_LISTPACKS   = 15
+	RDB_TYPE_HASH_LISTPACK      = 16
+	RDB_TYPE_ZSET_LISTPACK      = 17
+	RDB_TYPE_LIST_QUICKLIST_2   = 18
+	RDB_TYPE_STREAM_LISTPACKS_2 = 19
+	RDB_TYPE_SET_LISTPACK       = 20
+	RDB_TYPE_STREAM_LISTPACKS_3 = 21
+)
+
+// RDBEntry is a key of a database with its value
+type RDBEntry struct {
+	Key string
+	// unix milliseconds of the expiration, 0 when the key never expires
+	Expires int64
+	Value   RDBValue
+}
+
+// RDBValue is one of RDBString, RDBList, RDBHash, RDBSet, RDBSortedSet or
+// RDBStream
+type RDBValue interface {
+	// Type is the object type the value is written with
+	Type() byte
+}
+
+type RDBString []byte
+
+func (s RDBString) Type() byte {
+	return RDB_TYPE_STRING
+}
+
+type RDBList []string
+
+func (l RDBList) Type() byte {
+	return RDB_TYPE_LIST
+}
+
+type RDBField struct {
+	Field string
+	Value string
+}
+
+type RDBHash []RDBField
+
+func (h RDBHash) Type() byte {
+	return RDB_TYPE_HASH
+}
+
+type RDBSet []string
+
+func (s RDBSet) Type() byte {
+	return RDB_TYPE_SET
+}
+
+type RDBScoredMember struct {
+	Member string
+	Score  float64
+}
+
+type RDBSortedSet []RDBScoredMember
+
+func (z RDBSortedSet) Type() byte {
+	return RDB_TYPE_ZSET_2
+}
+
+type RDBStreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+type RDBStreamEntry struct {
+	ID RDBStreamID
+	// field value pairs
+	Fields []string
+}
+
+// RDBStreamPending is an entry delivered to a consumer of a group and not
+// acknowledged yet
+type RDBStreamPending struct {
+	ID RDBStreamID
+	// unix milliseconds of the last delivery
+	DeliveryTime  int64
+	DeliveryCount uint64
+}
+
+type RDBStreamConsumer struct {
+	Name string
+	// unix milliseconds of the last interaction and of the last successful
+	// one
+	SeenTime   int64
+	ActiveTime int64
+	// ids of its entries in the pending list of the group
+	Pending []RDBStreamID
+}
+
+type RDBStreamGroup struct {
+	Name   string
+	LastID RDBStreamID
+	// entries read by the group, -1 when it is unknown
+	EntriesRead int64
+	Pending     []RDBStreamPending
+	Consumers   []RDBStreamConsumer
+}
+
+type RDBStream struct {
+	Entries []RDBStreamEntry
+	LastID  RDBStreamID
+	// the greatest id deleted and the number of entries ever added, the
+	// entries are taken as all the added ones when it is smaller
+	MaxDeletedID RDBStreamID
+	EntriesAdded uint64
+	Groups       []RDBStreamGroup
+}
+
+func (s *RDBStream) Type() byte {
+	return RDB_TYPE_STREAM_LISTPACKS_3
+}
@@ -1,11 +1,15 @@
 package redisdb
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"slices"
 	"strconv"
 )
 
@@ -23,6 +27,27 @@ const (
 	REDIS_BITS           = "redis-bits"
 	CREATE_TIME          = "ctime"
 	USED_MEM             = "used-mem"
+	AOF_BASE             = "aof-base"
+
+	// opcodes of the key metadata, of the functions and of the data of
+	// modules that is not in a key
+	RDB_OPCODE_FUNCTION_PRE_GA = 0xF6
+	RDB_OPCODE_FUNCTION2       = 0xF5
+	RDB_OPCODE_MODULE_AUX      = 0xF7
+	RDB_OPCODE_IDLE            = 0xF8
+	RDB_OPCODE_FREQ            = 0xF9
+
+	// version of the format written, older ones are loaded too, the ones
+	// from RDB_VERSION_CHECKSUM on end with a checksum
+	RDB_VERSION          = 11
+	RDB_VERSION_CHECKSUM = 5
+
+	// lengths that do not fit 14 bits
+	RDB_LEN_32BIT = 0x80
+	RDB_LEN_64BIT = 0x81
+
+	// largest buffer allocated ahead of the bytes it is read into
+	RDB_READ_CHUNK = 64 * 1024
 )
 
 const (
@@ -33,11 +58,21 @@ const (
 type RDBFile struct {
 	Kv    []RDBSimplePair
 	ExpKv []RDBExpirationPair
+	// keys of the other types
+	Objects []RDBObject
+}
+
+// RDBObject is a key that is not a string with the database it belongs to
+type RDBObject struct {
+	RDBEntry
+	DB int
 }
 
 type RDBSimplePair struct {
 	Key   string
 	Value string
+	// index of the database the key belongs to
+	DB int
 }
 
 type RDBExpirationPair struct {
@@ -45,141 +80,332 @@ type RDBExpirationPair struct {
 	RDBSimplePair
 }
 
+// LoadRDBFile decodes the keys of every database of an rdb file held in
+// memory, the checksum of the files that have one is verified
 func LoadRDBFile(file []byte) (*RDBFile, error) {
 	res := &RDBFile{Kv: make([]RDBSimplePair, 0, 1), ExpKv: make([]RDBExpirationPair, 0, 1)}
-
-	currentMagicNumber := string(file[:len(MAGIC_NUMBER):len(MAGIC_NUMBER)])
-	bytesRead := len(MAGIC_NUMBER)
-	if !(currentMagicNumber == MAGIC_NUMBER) {
-		return nil, fmt.Errorf("Error, magic number does not match, current magic number:%s, expected:%s", currentMagicNumber, MAGIC_NUMBER)
+	rr, err := NewRDBReader(bytes.NewReader(file))
+	if err != nil {
+		return nil, err
 	}
-	currentVersion := string(file[bytesRead : bytesRead+len(VERSION) : bytesRead+len(VERSION)])
-	bytesRead += len(VERSION)
-
-	if !(currentVersion == VERSION) {
-		return nil, fmt.Errorf("Error, version does not match, current version:%s, expected:%s", currentVersion, VERSION)
+	for {
+		object, err := rr.Next()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value, isString := object.Value.(RDBString)
+		if !isString {
+			res.Objects = append(res.Objects, object)
+			continue
+		}
+		pair := RDBSimplePair{Key: object.Key, Value: string(value), DB: object.DB}
+		if object.Expires != 0 {
+			res.ExpKv = append(res.ExpKv, RDBExpirationPair{Exp: uint64(object.Expires), RDBSimplePair: pair})
+		} else {
+			res.Kv = append(res.Kv, pair)
+		}
 	}
+}
 
-	nextSectionByte := file[bytesRead]
+// RDBReader decodes the keys of an rdb file one at a time, so a file can
+// be loaded holding a single key in memory besides the loaded ones
+type RDBReader struct {
+	d       *rdbDecoder
+	version int
+	db      int
+	aux     []RDBField
+	done    bool
+}
 
-	if nextSectionByte == AUXILIAR_FILE_HEADER {
-		_, auxBytesRead := readAuxiliarData(file[bytesRead:])
-		bytesRead += auxBytesRead
+// NewRDBReader checks the header of the file, the reader is buffered so
+// it can be a file as is. A bufio.Reader is used as it is, so what follows
+// the rdb file can be read from it after the end
+func NewRDBReader(r io.Reader) (*RDBReader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
 	}
+	rr := &RDBReader{d: &rdbDecoder{r: br}}
+	version, err := rr.d.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	rr.version = version
+	return rr, nil
+}
 
-	dbSelector := file[bytesRead]
+func (rr *RDBReader) Version() int {
+	return rr.version
+}
 
-	if dbSelector == DB_SELECTOR {
-		bytesRead++
-		// dbNum := int(file[bytesRead])
-		bytesRead++
-	}
+// Offset is the number of bytes of the file decoded so far
+func (rr *RDBReader) Offset() int64 {
+	return rr.d.pos
+}
 
+// Aux returns the auxiliary fields read so far, all of them once Next
+// returned io.EOF
+func (rr *RDBReader) Aux() []RDBField {
+	return rr.aux
+}
+
+// Next returns the next key with the database it belongs to, io.EOF once
+// the end of the file was read and its checksum verified
+func (rr *RDBReader) Next() (RDBObject, error) {
+	if rr.done {
+		return RDBObject{}, io.EOF
+	}
+	d := rr.d
+	expires := uint64(0)
 	for {
-		if file[bytesRead] == RDB_END {
-			break
+		opcode, err := d.readByte()
+		if err != nil {
+			return RDBObject{}, err
 		}
-		resizeDb := file[bytesRead]
-
-		if resizeDb == RESIZE_DB {
-			bytesRead++
-			numSize := getIntergerSize(file[bytesRead])
-			// hashSize := decodeInteger(file, bytesRead, numSize)
-			bytesRead += numSize
-			numSize = getIntergerSize(file[bytesRead])
-			// expHashSize := decodeInteger(file, bytesRead+1, getIntergerSize(file[bytesRead]))
-
-			bytesRead += numSize
-			if file[bytesRead] == 0 {
-				bytesRead++
+		switch opcode {
+		case AUXILIAR_FILE_HEADER:
+			var name, value string
+			if name, err = d.readString(); err == nil {
+				if value, err = d.readString(); err == nil {
+					rr.aux = append(rr.aux, RDBField{Field: name, Value: value})
+				}
 			}
-
-		}
-
-		switch file[bytesRead] {
-		case EXPIRE_PAIR_4_BYTE:
+		case RESIZE_DB:
+			if _, err = d.readLength(); err == nil {
+				_, err = d.readLength()
+			}
+		case DB_SELECTOR:
+			var n uint64
+			n, err = d.readLength()
+			if err == nil && n > math.MaxInt32 {
+				err = d.errorf("invalid database number %d", n)
+			}
+			rr.db = int(n)
 		case EXPIRE_PAIR_8_BYTE:
-			bytesRead++
-			exp := decodeLong(file, bytesRead)
-			bytesRead += 9
-			key, val, read := readPair(file, bytesRead)
-			bytesRead = read
-			res.ExpKv = append(
-				res.ExpKv,
-				RDBExpirationPair{
-					Exp:           exp,
-					RDBSimplePair: RDBSimplePair{Key: key, Value: val},
-				},
-			)
-
+			var p []byte
+			if p, err = d.readBytes(8); err == nil {
+				expires = binary.LittleEndian.Uint64(p)
+			}
+		case EXPIRE_PAIR_4_BYTE:
+			var p []byte
+			if p, err = d.readBytes(4); err == nil {
+				expires = uint64(binary.LittleEndian.Uint32(p)) * 1000
+			}
+		case RDB_OPCODE_FREQ:
+			_, err = d.readByte()
+		case RDB_OPCODE_IDLE:
+			_, err = d.readLength()
+		case RDB_END:
+			if err := d.verifyChecksum(rr.version); err != nil {
+				return RDBObject{}, err
+			}
+			rr.done = true
+			return RDBObject{}, io.EOF
+		case RDB_OPCODE_MODULE_AUX:
+			err = d.skipModuleAux()
+		case RDB_OPCODE_FUNCTION2:
+			// functions are not supported, their libraries are skipped
+			_, err = d.readString()
+		case RDB_OPCODE_FUNCTION_PRE_GA:
+			err = d.errorf("pre-release function format not supported")
 		default:
-			key := decodeString(file, bytesRead)
-			bytesRead += len(key) + 1
-			val := decodeString(file, bytesRead)
-			bytesRead += len(val) + 1
-			res.Kv = append(res.Kv, RDBSimplePair{Key: key, Value: val})
+			var entry RDBEntry
+			if entry, err = d.readObject(opcode); err == nil {
+				entry.Expires = int64(expires)
+				return RDBObject{RDBEntry: entry, DB: rr.db}, nil
+			}
 		}
-		if file[bytesRead] == 0 {
-			bytesRead++
+		if err != nil {
+			return RDBObject{}, err
 		}
 	}
+}
 
-	return res, nil
+// rdbDecoder reads the encodings of the rdb format keeping the offset and
+// the checksum of the bytes read, the reads past the end are errors
+type rdbDecoder struct {
+	r   *bufio.Reader
+	pos int64
+	crc uint64
 }
 
-func readPair(file []byte, bytesRead int) (string, string, int) {
+func (d *rdbDecoder) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s at offset %d", fmt.Sprintf(format, args...), d.pos)
+}
 
-	key := decodeString(file, bytesRead)
-	bytesRead += len(key) + 1
-	val := decodeString(file, bytesRead)
-	bytesRead += len(val) + 1
-	return key, val, bytesRead
+// readBytes reads n bytes, the lengths of a corrupted file can be huge so
+// the large reads grow with the bytes actually read
+func (d *rdbDecoder) readBytes(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, d.errorf("invalid length %d", n)
+	}
+	p := make([]byte, 0, min(n, RDB_READ_CHUNK))
+	for len(p) < n {
+		chunk := min(n-len(p), RDB_READ_CHUNK)
+		p = slices.Grow(p, chunk)
+		read, err := io.ReadFull(d.r, p[len(p):len(p)+chunk])
+		p = p[:len(p)+read]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, d.errorf("unexpected end of file reading %d bytes", n)
+		}
+		if err != nil {
+			return nil, d.errorf("error reading the file: %s", err)
+		}
+	}
+	d.crc = updateCRC64(d.crc, p)
+	d.pos += int64(n)
+	return p, nil
 }
 
-func readAuxiliarData(data []byte) (map[string]string, int) {
-	bytesRead := 0
+func (d *rdbDecoder) readByte() (byte, error) {
+	p, err := d.readBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
 
-	result := make(map[string]string)
-	for {
-		if data[bytesRead] > 0xFB {
-			break
-		}
-		if data[bytesRead] == AUXILIAR_FILE_HEADER {
-			bytesRead++
+// readHeader checks the magic number and returns the version of the file
+func (d *rdbDecoder) readHeader() (int, error) {
+	magic, err := d.readBytes(len(MAGIC_NUMBER))
+	if err != nil || string(magic) != MAGIC_NUMBER {
+		return 0, fmt.Errorf("wrong signature trying to load DB from file")
+	}
+	p, err := d.readBytes(len(VERSION))
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(p))
+	if err != nil || version < 1 || version > RDB_VERSION {
+		return 0, fmt.Errorf("can't handle RDB format version %s", p)
+	}
+	return version, nil
+}
+
+// readLengthEncoding reads a length in 1, 2, 5 or 9 bytes, the first byte
+// of a special string encoding is returned as is with encoded set
+func (d *rdbDecoder) readLengthEncoding() (n uint64, encoded bool, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := d.readByte()
+		return uint64(b&0x3F)<<8 | uint64(next), false, err
+	case 3:
+		return uint64(b), true, nil
+	}
+	switch b {
+	case RDB_LEN_32BIT:
+		p, err := d.readBytes(4)
+		if err != nil {
+			return 0, false, err
 		}
-		dataKey := decodeString(data, bytesRead)
-		bytesRead += len(dataKey) + 1
-		switch dataKey {
-		case REDIS_VERSION:
-			version := decodeString(data, bytesRead)
-
-			result[string(dataKey)] = version
-			bytesRead += len(version) + 1
-		case REDIS_BITS:
-			redisBits := decodeInteger(data, bytesRead+1, getIntergerSize(data[bytesRead]))
-			result[string(dataKey)] = redisBits
-			bytesRead += 2
-		default:
-			bytesRead++
+		return uint64(binary.BigEndian.Uint32(p)), false, nil
+	case RDB_LEN_64BIT:
+		p, err := d.readBytes(8)
+		if err != nil {
+			return 0, false, err
 		}
+		return binary.BigEndian.Uint64(p), false, nil
 	}
+	return 0, false, d.errorf("unknown length encoding 0x%x", b)
+}
 
-	return result, bytesRead
+func (d *rdbDecoder) readLength() (uint64, error) {
+	n, encoded, err := d.readLengthEncoding()
+	if err == nil && encoded {
+		err = d.errorf("unexpected string encoding 0x%x reading a length", n)
+	}
+	return n, err
 }
 
-func BuildRDBFromFileSystem(reader io.Reader, size int64) []byte {
+// readString reads a string with its length, an integer or an lzf
+// compressed string
+func (d *rdbDecoder) readString() (string, error) {
+	n, encoded, err := d.readLengthEncoding()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		if n > math.MaxInt32 {
+			return "", d.errorf("invalid string length %d", n)
+		}
+		p, err := d.readBytes(int(n))
+		return string(p), err
+	}
 
-	buffer := make([]byte, size)
+	switch n {
+	case RDB_ENC_INT8:
+		b, err := d.readByte()
+		return strconv.Itoa(int(int8(b))), err
+	case RDB_ENC_INT16:
+		p, err := d.readBytes(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(p)))), nil
+	case RDB_ENC_INT32:
+		p, err := d.readBytes(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(p)))), nil
+	case RDB_ENC_LZF:
+		compressed, err := d.readLength()
+		if err != nil {
+			return "", err
+		}
+		length, err := d.readLength()
+		if err != nil {
+			return "", err
+		}
+		if compressed > math.MaxInt32 || length > math.MaxInt32 {
+			return "", d.errorf("invalid lzf string lengths %d and %d", compressed, length)
+		}
+		p, err := d.readBytes(int(compressed))
+		if err != nil {
+			return "", err
+		}
+		s, err := lzfDecompress(p, int(length))
+		if err != nil {
+			return "", d.errorf("%s", err)
+		}
+		return string(s), nil
+	}
+	return "", d.errorf("unknown string encoding 0x%x", n)
+}
 
-	bytesRead, err := reader.Read(buffer)
+// verifyChecksum checks the crc64 after the end of the file against the
+// one of all the bytes before it, a 0 checksum means it was not computed
+func (d *rdbDecoder) verifyChecksum(version int) error {
+	if version < RDB_VERSION_CHECKSUM {
+		return nil
+	}
+	crc := d.crc
+	p, err := d.readBytes(8)
+	if err != nil {
+		return err
+	}
+	if expected := binary.LittleEndian.Uint64(p); expected != 0 && expected != crc {
+		return d.errorf("wrong RDB checksum expected: (%016x) got: (%016x)", expected, crc)
+	}
+	return nil
+}
 
+// BuildRDBFromFileSystem reads up to size bytes of reader, less when it
+// ends before
+func BuildRDBFromFileSystem(reader io.Reader, size int64) []byte {
+	buffer, err := io.ReadAll(io.LimitReader(reader, size))
 	if err != nil {
-		if err == io.EOF {
-			return buffer[:bytesRead]
-		}
 		log.Println("Error reading rbd file", err)
 	}
-
 	return buffer
 }
 
@@ -206,52 +432,6 @@ func BuildRDBFromMemory() []byte {
 	return data
 }
 
-func getIntergerSize(size byte) int {
-	msbs := size >> 6
-
-	possibleSize := msbs & 0b11
-	switch possibleSize {
-	case 0b00:
-		return 1
-	case 0b01:
-		return 2
-	case 0b10:
-		return 4
-	}
-	return 0
-}
-
-func decodeInteger(data []byte, index, size int) string {
-
-	switch size {
-	case 1:
-		return strconv.Itoa(int(data[index]))
-	default:
-		return ""
-	}
-}
-
-func decodeLong(data []byte, index int) uint64 {
-
-	longbytes := data[index : index+8]
-	long := binary.BigEndian.Uint64(longbytes)
-	return long
-}
-
-func decodeString(data []byte, index int) string {
-	stringLength := int(data[index])
-	res := make([]byte, 0, stringLength)
-
-	for i := 0; i <= stringLength; i++ {
-
-		someByte := data[index+i]
-		if someByte > 32 {
-			res = append(res, someByte)
-		}
-	}
-	return string(res)
-}
-
 func encodeString(s string) ([]byte, error) {
 
 	res := make([]byte, 0, len(s)+1)
@@ -1,7 +1,12 @@
 package redisdb
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -60,9 +65,9 @@ func Test_LoadRDBFile(t *testing.T) {
 			expect: RDBFile{
 				Kv: []RDBSimplePair{},
 				ExpKv: []RDBExpirationPair{
-					{Exp: 0x9cef127e010000, RDBSimplePair: RDBSimplePair{Key: "pear", Value: "grape"}},
-					{Exp: 0xc288ac7010000, RDBSimplePair: RDBSimplePair{Key: "pineapple", Value: "mango"}},
-					{Exp: 0xc288ac7010000, RDBSimplePair: RDBSimplePair{Key: "mango", Value: "pear"}}},
+					{Exp: 1640995200000, RDBSimplePair: RDBSimplePair{Key: "pear", Value: "grape"}},
+					{Exp: 1956528000000, RDBSimplePair: RDBSimplePair{Key: "pineapple", Value: "mango"}},
+					{Exp: 1956528000000, RDBSimplePair: RDBSimplePair{Key: "mango", Value: "pear"}}},
 			},
 		},
 	}
@@ -75,6 +80,268 @@ func Test_LoadRDBFile(t *testing.T) {
 
 }
 
+func Test_LoadRDBFileDatabases(t *testing.T) {
+
+	tests := []struct {
+		input  []byte
+		expect RDBFile
+	}{
+		{
+			input: []byte{
+				82, 69, 68, 73, 83, 48, 48, 49, 49,
+				254, 0, 251, 1, 0, 0, 1, 'a', 1, 'x',
+				254, 3, 251, 1, 0, 0, 1, 'b', 1, 'y',
+				255, 0, 0, 0, 0, 0, 0, 0, 0,
+			},
+			expect: RDBFile{
+				Kv: []RDBSimplePair{
+					{Key: "a", Value: "x", DB: 0},
+					{Key: "b", Value: "y", DB: 3},
+				},
+				ExpKv: []RDBExpirationPair{},
+			},
+		}, {
+			input: []byte{
+				82, 69, 68, 73, 83, 48, 48, 49, 49,
+				254, 0x40, 0x64, 251, 1, 1, 252, 0, 12, 40, 138, 199, 1, 0, 0, 0, 1, 'c', 1, 'z',
+				255, 0, 0, 0, 0, 0, 0, 0, 0,
+			},
+			expect: RDBFile{
+				Kv: []RDBSimplePair{},
+				ExpKv: []RDBExpirationPair{
+					{Exp: 1956528000000, RDBSimplePair: RDBSimplePair{Key: "c", Value: "z", DB: 100}},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		file, err := LoadRDBFile(tc.input)
+		assert.Nil(t, err)
+		assert.Equal(t, &tc.expect, file)
+	}
+}
+
+func Test_LoadRDBFileEncodings(t *testing.T) {
+
+	binaryValue := "a b\x00\n\xff"
+	long := strings.Repeat("x", 20000)
+	tests := []struct {
+		name   string
+		body   []byte
+		expect RDBFile
+	}{
+		{
+			name: "14 and 32 bits lengths and binary strings",
+			body: concat(
+				[]byte{RDB_TYPE_STRING, byte(len(binaryValue))}, []byte(binaryValue), []byte{0x40, 100}, []byte(strings.Repeat("v", 100)),
+				[]byte{RDB_TYPE_STRING, 4, 'l', 'o', 'n', 'g', RDB_LEN_32BIT, 0, 0, 0x4E, 0x20}, []byte(long),
+			),
+			expect: RDBFile{
+				Kv: []RDBSimplePair{
+					{Key: binaryValue, Value: strings.Repeat("v", 100)},
+					{Key: "long", Value: long},
+				},
+			},
+		},
+		{
+			name: "integer strings",
+			body: []byte{
+				RDB_TYPE_STRING, RDB_ENC_INT8, 0xFF, RDB_ENC_INT16, 0x30, 0x75,
+				RDB_TYPE_STRING, RDB_ENC_INT8, 7, RDB_ENC_INT32, 0x00, 0x6D, 0xCA, 0xFF,
+			},
+			expect: RDBFile{
+				Kv: []RDBSimplePair{
+					{Key: "-1", Value: "30000"},
+					{Key: "7", Value: "-3511040"},
+				},
+			},
+		},
+		{
+			// a literal run of one byte and a back reference copying it 39
+			// times
+			name: "lzf string",
+			body: []byte{RDB_TYPE_STRING, 1, 'z', RDB_ENC_LZF, 5, 40, 0x00, 'a', 0xE0, 30, 0x00},
+			expect: RDBFile{
+				Kv: []RDBSimplePair{{Key: "z", Value: strings.Repeat("a", 40)}},
+			},
+		},
+		{
+			name: "expirations in seconds and milliseconds, lru and lfu",
+			body: []byte{
+				EXPIRE_PAIR_4_BYTE, 0x80, 0x9F, 0xCF, 0x61, RDB_TYPE_STRING, 1, 's', 1, 'v',
+				EXPIRE_PAIR_8_BYTE, 0x15, 0x72, 0xE7, 0x07, 0x8F, 0x01, 0x00, 0x00, RDB_OPCODE_IDLE, 0x40, 0xFF, RDB_TYPE_STRING, 2, 'm', 's', 1, 'v',
+				RDB_OPCODE_FREQ, 5, RDB_TYPE_STRING, 1, 'p', 1, 'v',
+			},
+			expect: RDBFile{
+				Kv: []RDBSimplePair{{Key: "p", Value: "v"}},
+				ExpKv: []RDBExpirationPair{
+					{Exp: 1640996736000, RDBSimplePair: RDBSimplePair{Key: "s", Value: "v"}},
+					{Exp: 1713824559637, RDBSimplePair: RDBSimplePair{Key: "ms", Value: "v"}},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		file, err := LoadRDBFile(rdbFixture(concat([]byte{DB_SELECTOR, 0}, tc.body)))
+		assert.NoError(t, err, tc.name)
+		if tc.expect.Kv == nil {
+			tc.expect.Kv = []RDBSimplePair{}
+		}
+		if tc.expect.ExpKv == nil {
+			tc.expect.ExpKv = []RDBExpirationPair{}
+		}
+		assert.Equal(t, &tc.expect, file, tc.name)
+	}
+}
+
+func Test_LoadRDBFileErrors(t *testing.T) {
+	valid := rdbFixture([]byte{DB_SELECTOR, 0, RDB_TYPE_STRING, 1, 'k', 1, 'v'})
+
+	wrongChecksum := bytes.Clone(valid)
+	wrongChecksum[len(wrongChecksum)-1] ^= 1
+	unchecked := bytes.Clone(valid)
+	copy(unchecked[len(unchecked)-8:], make([]byte, 8))
+
+	tests := []struct {
+		name  string
+		input []byte
+		err   string
+	}{
+		{name: "valid", input: valid},
+		{name: "checksum not computed", input: unchecked},
+		{name: "wrong checksum", input: wrongChecksum, err: "wrong RDB checksum"},
+		{name: "truncated", input: valid[:len(valid)-12], err: "unexpected end of file reading 1 bytes at offset 13"},
+		{name: "truncated checksum", input: valid[:len(valid)-4], err: "unexpected end of file"},
+		{name: "empty", input: []byte{}, err: "wrong signature"},
+		{name: "newer version", input: []byte("REDIS0099\xff"), err: "can't handle RDB format version 0099"},
+		{name: "bad lzf", input: rdbFixture([]byte{RDB_TYPE_STRING, 1, 'k', RDB_ENC_LZF, 3, 10, 0x00, 'a', 0xE0}), err: "invalid lzf"},
+		{
+			// 2 bytes declaring 2GB are rejected before allocating them
+			name:  "lzf length too big",
+			input: rdbFixture([]byte{RDB_TYPE_STRING, 1, 'k', RDB_ENC_LZF, 2, RDB_LEN_32BIT, 0x7F, 0xFF, 0xFF, 0xFF, 0x00, 'a'}),
+			err:   "lzf string of 2 bytes can't expand to 2147483647",
+		},
+		{name: "bad length", input: rdbFixture([]byte{DB_SELECTOR, 0x82}), err: "unknown length encoding 0x82 at offset 11"},
+		{name: "unknown type", input: rdbFixture([]byte{8, 1, 'k'}), err: "unknown RDB object type 8 at offset 12"},
+		{
+			name:  "module type",
+			input: rdbFixture([]byte{RDB_TYPE_MODULE_2, 1, 'k', RDB_LEN_64BIT, 0x45, 0xE2, 0x52, 0x38, 0xDF, 0x91, 0x2C, 0x03}),
+			err:   "module type 'ReJSON-RL' that is not supported",
+		},
+	}
+
+	for _, tc := range tests {
+		_, err := LoadRDBFile(tc.input)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.name)
+			continue
+		}
+		if assert.Error(t, err, tc.name) {
+			assert.Contains(t, err.Error(), tc.err, tc.name)
+		}
+	}
+}
+
+func Test_LoadRDBFileFromRedis(t *testing.T) {
+	// written by redis 7.2 with its aux fields and no key
+	file, err := LoadRDBFile(BuildRDBFromMemory())
+	assert.NoError(t, err)
+	assert.Equal(t, &RDBFile{Kv: []RDBSimplePair{}, ExpKv: []RDBExpirationPair{}}, file)
+}
+
+func Test_LoadWrittenRDBFile(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewRDBWriter(&buf)
+	w.WriteAux(REDIS_VERSION, "7.2.0")
+	w.WriteAux(REDIS_BITS, "64")
+	w.WriteDB(0, []RDBEntry{
+		{Key: "k", Value: RDBString("v")},
+		{Key: "100", Value: RDBString("-70000"), Expires: 1713824559637},
+	})
+	w.WriteDB(15, []RDBEntry{{Key: "big", Value: RDBString(strings.Repeat("b", 1000))}})
+	assert.NoError(t, w.Close())
+
+	file, err := LoadRDBFile(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, &RDBFile{
+		Kv: []RDBSimplePair{
+			{Key: "k", Value: "v"},
+			{Key: "big", Value: strings.Repeat("b", 1000), DB: 15},
+		},
+		ExpKv: []RDBExpirationPair{{Exp: 1713824559637, RDBSimplePair: RDBSimplePair{Key: "100", Value: "-70000"}}},
+	}, file)
+}
+
+func Test_RDBReader(t *testing.T) {
+	entries := []RDBEntry{
+		{Key: "h", Value: RDBHash{{Field: "f", Value: "v"}}},
+		{Key: "x", Value: &RDBStream{
+			Entries: []RDBStreamEntry{{ID: RDBStreamID{Ms: 1}, Fields: []string{"f", "v"}}},
+			Groups:  []RDBStreamGroup{{Name: "g", EntriesRead: -1, Consumers: []RDBStreamConsumer{{Name: "c"}}}},
+		}},
+	}
+	write := func(entries []RDBEntry) []byte {
+		var buf bytes.Buffer
+		w := NewRDBWriter(&buf)
+		w.WriteAux(REDIS_BITS, "64")
+		w.WriteDB(0, entries)
+		assert.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	// the reads of a reader can be short
+	file := write(append([]RDBEntry{{Key: "s", Value: RDBString(strings.Repeat("v", 3*RDB_READ_CHUNK))}}, entries...))
+	rr, err := NewRDBReader(iotest.OneByteReader(bytes.NewReader(file)))
+	assert.NoError(t, err)
+	keys := []string{}
+	for {
+		object, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		keys = append(keys, object.Key)
+	}
+	assert.Equal(t, []string{"s", "h", "x"}, keys)
+	assert.Equal(t, []RDBField{{Field: REDIS_BITS, Value: "64"}}, rr.Aux())
+	assert.Equal(t, int64(len(file)), rr.Offset())
+	_, err = rr.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// a truncated file is an error wherever it ends
+	file = write(entries)
+	for i := range len(file) {
+		_, err := LoadRDBFile(file[:i])
+		assert.Error(t, err, i)
+	}
+
+	// a corrupted length is not allocated before the bytes are there
+	_, err = LoadRDBFile(rdbFixture([]byte{RDB_TYPE_STRING, 1, 'k', RDB_LEN_64BIT, 0, 0, 0, 0, 0x7F, 0xFF, 0xFF, 0xFF}))
+	assert.ErrorContains(t, err, "unexpected end of file reading 2147483647 bytes at offset 21")
+	_, err = LoadRDBFile(rdbFixture([]byte{RDB_TYPE_LIST, 1, 'k', RDB_LEN_64BIT, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}))
+	assert.Error(t, err)
+}
+
+func Test_BuildRDBFromFileSystem(t *testing.T) {
+	file := BuildRDBFromMemory()
+	assert.Equal(t, file, BuildRDBFromFileSystem(iotest.HalfReader(bytes.NewReader(file)), int64(len(file))))
+}
+
+// rdbFixture is a file of the current version with body between its
+// header and its end, followed by its checksum
+func rdbFixture(body []byte) []byte {
+	file := concat([]byte(MAGIC_NUMBER+VERSION), body, []byte{RDB_END})
+	return binary.LittleEndian.AppendUint64(file, updateCRC64(0, file))
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
 func Test_encodeString(t *testing.T) {
 	tests := []struct {
 		input         string
@@ -0,0 +1,272 @@
+package redisdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+)
+
+const (
+	// entries of a stream per listpack node, like stream-node-max-entries
+	STREAM_NODE_MAX_ENTRIES = 100
+
+	// flags of the stream entries in a listpack node
+	STREAM_ITEM_FLAG_NONE       = 0
+	STREAM_ITEM_FLAG_DELETED    = 1
+	STREAM_ITEM_FLAG_SAMEFIELDS = 2
+
+	// first byte of the integers stored as strings
+	RDB_ENC_INT8  = 0xC0
+	RDB_ENC_INT16 = 0xC1
+	RDB_ENC_INT32 = 0xC2
+	RDB_ENC_LZF   = 0xC3
+)
+
+// RDBWriter writes a snapshot in the rdb format, the header with its aux
+// fields, the keys of every database and the checksum of all of it
+type RDBWriter struct {
+	w   *bufio.Writer
+	crc uint64
+	err error
+	buf []byte
+}
+
+func NewRDBWriter(w io.Writer) *RDBWriter {
+	rw := &RDBWriter{w: bufio.NewWriter(w), buf: make([]byte, 0, 16)}
+	rw.write([]byte(MAGIC_NUMBER + VERSION))
+	return rw
+}
+
+func (rw *RDBWriter) write(p []byte) {
+	if rw.err != nil {
+		return
+	}
+	rw.crc = updateCRC64(rw.crc, p)
+	_, rw.err = rw.w.Write(p)
+}
+
+// WriteAux writes an aux field of the header, integer values take the
+// integer encodings
+func (rw *RDBWriter) WriteAux(name, value string) error {
+	rw.write([]byte{AUXILIAR_FILE_HEADER})
+	rw.writeString(name)
+	rw.writeString(value)
+	return rw.err
+}
+
+// WriteDB writes the keys of a database after its selector, empty
+// databases are skipped like redis does
+func (rw *RDBWriter) WriteDB(db int, entries []RDBEntry) error {
+	if len(entries) == 0 {
+		return rw.err
+	}
+	expires := 0
+	for _, entry := range entries {
+		if entry.Expires != 0 {
+			expires++
+		}
+	}
+	rw.write([]byte{DB_SELECTOR})
+	rw.writeLength(uint64(db))
+	rw.write([]byte{RESIZE_DB})
+	rw.writeLength(uint64(len(entries)))
+	rw.writeLength(uint64(expires))
+	for _, entry := range entries {
+		rw.WriteEntry(entry)
+	}
+	return rw.err
+}
+
+// WriteEntry writes a key with its expiration and value
+func (rw *RDBWriter) WriteEntry(entry RDBEntry) error {
+	if entry.Expires != 0 {
+		rw.write([]byte{EXPIRE_PAIR_8_BYTE})
+		rw.write(binary.LittleEndian.AppendUint64(rw.buf[:0], uint64(entry.Expires)))
+	}
+	rw.write([]byte{entry.Value.Type()})
+	rw.writeString(entry.Key)
+	rw.writeValue(entry.Value)
+	return rw.err
+}
+
+// Close ends the file with its checksum and flushes it, the underlying
+// writer is not closed
+func (rw *RDBWriter) Close() error {
+	rw.write([]byte{RDB_END})
+	if rw.err != nil {
+		return rw.err
+	}
+	if _, err := rw.w.Write(binary.LittleEndian.AppendUint64(nil, rw.crc)); err != nil {
+		return err
+	}
+	return rw.w.Flush()
+}
+
+func (rw *RDBWriter) writeValue(value RDBValue) {
+	switch v := value.(type) {
+	case RDBString:
+		rw.writeString(string(v))
+	case RDBList:
+		rw.writeLength(uint64(len(v)))
+		for _, element := range v {
+			rw.writeString(element)
+		}
+	case RDBHash:
+		rw.writeLength(uint64(len(v)))
+		for _, field := range v {
+			rw.writeString(field.Field)
+			rw.writeString(field.Value)
+		}
+	case RDBSet:
+		rw.writeLength(uint64(len(v)))
+		for _, member := range v {
+			rw.writeString(member)
+		}
+	case RDBSortedSet:
+		rw.writeLength(uint64(len(v)))
+		for _, member := range v {
+			rw.writeString(member.Member)
+			rw.write(binary.LittleEndian.AppendUint64(rw.buf[:0], math.Float64bits(member.Score)))
+		}
+	case *RDBStream:
+		rw.writeStream(v)
+	}
+}
+
+// writeStream writes the entries in listpack nodes keyed by the id of
+// their first entry, the fields of that entry are the master fields the
+// entries with the same ones do not repeat
+func (rw *RDBWriter) writeStream(stream *RDBStream) {
+	nodes := (len(stream.Entries) + STREAM_NODE_MAX_ENTRIES - 1) / STREAM_NODE_MAX_ENTRIES
+	rw.writeLength(uint64(nodes))
+	for start := 0; start < len(stream.Entries); start += STREAM_NODE_MAX_ENTRIES {
+		entries := stream.Entries[start:min(start+STREAM_NODE_MAX_ENTRIES, len(stream.Entries))]
+		master := entries[0].ID
+		masterFields := fieldNames(entries[0].Fields)
+
+		lp := &listpack{}
+		lp.appendInt(int64(len(entries)))
+		lp.appendInt(0)
+		lp.appendInt(int64(len(masterFields)))
+		for _, field := range masterFields {
+			lp.appendString(field)
+		}
+		lp.appendInt(0)
+		for _, entry := range entries {
+			fields := fieldNames(entry.Fields)
+			same := slices.Equal(fields, masterFields)
+			if same {
+				lp.appendInt(STREAM_ITEM_FLAG_SAMEFIELDS)
+			} else {
+				lp.appendInt(STREAM_ITEM_FLAG_NONE)
+			}
+			lp.appendInt(int64(entry.ID.Ms - master.Ms))
+			lp.appendInt(int64(entry.ID.Seq - master.Seq))
+			if same {
+				for i := 1; i < len(entry.Fields); i += 2 {
+					lp.appendString(entry.Fields[i])
+				}
+				lp.appendInt(int64(len(fields) + 3))
+				continue
+			}
+			lp.appendInt(int64(len(fields)))
+			for _, field := range entry.Fields {
+				lp.appendString(field)
+			}
+			lp.appendInt(int64(2*len(fields) + 4))
+		}
+
+		key := binary.BigEndian.AppendUint64(nil, master.Ms)
+		rw.writeString(string(binary.BigEndian.AppendUint64(key, master.Seq)))
+		rw.writeString(string(lp.bytes()))
+	}
+
+	first := RDBStreamID{}
+	if len(stream.Entries) > 0 {
+		first = stream.Entries[0].ID
+	}
+	rw.writeLength(uint64(len(stream.Entries)))
+	rw.writeStreamID(stream.LastID)
+	rw.writeStreamID(first)
+	rw.writeStreamID(stream.MaxDeletedID)
+	rw.writeLength(max(stream.EntriesAdded, uint64(len(stream.Entries))))
+
+	rw.writeLength(uint64(len(stream.Groups)))
+	for _, group := range stream.Groups {
+		rw.writeString(group.Name)
+		rw.writeStreamID(group.LastID)
+		rw.writeLength(uint64(group.EntriesRead))
+		rw.writeLength(uint64(len(group.Pending)))
+		for _, pending := range group.Pending {
+			rw.writeRawStreamID(pending.ID)
+			rw.write(binary.LittleEndian.AppendUint64(rw.buf[:0], uint64(pending.DeliveryTime)))
+			rw.writeLength(pending.DeliveryCount)
+		}
+		rw.writeLength(uint64(len(group.Consumers)))
+		for _, consumer := range group.Consumers {
+			rw.writeString(consumer.Name)
+			rw.write(binary.LittleEndian.AppendUint64(rw.buf[:0], uint64(consumer.SeenTime)))
+			rw.write(binary.LittleEndian.AppendUint64(rw.buf[:0], uint64(consumer.ActiveTime)))
+			rw.writeLength(uint64(len(consumer.Pending)))
+			for _, id := range consumer.Pending {
+				rw.writeRawStreamID(id)
+			}
+		}
+	}
+}
+
+func (rw *RDBWriter) writeStreamID(id RDBStreamID) {
+	rw.writeLength(id.Ms)
+	rw.writeLength(id.Seq)
+}
+
+// writeRawStreamID writes the 16 big endian bytes of an id, how the ids
+// of the pending lists are stored
+func (rw *RDBWriter) writeRawStreamID(id RDBStreamID) {
+	rw.write(binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(rw.buf[:0], id.Ms), id.Seq))
+}
+
+func fieldNames(pairs []string) []string {
+	names := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		names = append(names, pairs[i])
+	}
+	return names
+}
+
+// writeLength writes a length in 1, 2, 5 or 9 bytes
+func (rw *RDBWriter) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		rw.write(append(rw.buf[:0], byte(n)))
+	case n < 1<<14:
+		rw.write(append(rw.buf[:0], 0x40|byte(n>>8), byte(n)))
+	case n <= math.MaxUint32:
+		rw.write(binary.BigEndian.AppendUint32(append(rw.buf[:0], 0x80), uint32(n)))
+	default:
+		rw.write(binary.BigEndian.AppendUint64(append(rw.buf[:0], 0x81), n))
+	}
+}
+
+// writeString writes s with its length, strings that are the canonical
+// form of a 32 bits integer are stored as one
+func (rw *RDBWriter) writeString(s string) {
+	if len(s) <= 11 {
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+			switch {
+			case n >= math.MinInt8 && n <= math.MaxInt8:
+				rw.write(append(rw.buf[:0], RDB_ENC_INT8, byte(n)))
+			case n >= math.MinInt16 && n <= math.MaxInt16:
+				rw.write(binary.LittleEndian.AppendUint16(append(rw.buf[:0], RDB_ENC_INT16), uint16(n)))
+			default:
+				rw.write(binary.LittleEndian.AppendUint32(append(rw.buf[:0], RDB_ENC_INT32), uint32(n)))
+			}
+			return
+		}
+	}
+	rw.writeLength(uint64(len(s)))
+	rw.write([]byte(s))
+}
@@ -0,0 +1,205 @@
+package redisdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CRC64(t *testing.T) {
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), updateCRC64(0, []byte("123456789")))
+	// the crc can be computed in chunks
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), updateCRC64(updateCRC64(0, []byte("1234")), []byte("56789")))
+}
+
+func Test_RDBWriter(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		entries []RDBEntry
+		expect  []byte
+	}{
+		{
+			name: "string with expiration",
+			entries: []RDBEntry{
+				{Key: "fruit", Expires: 1713824559637, Value: RDBString("mango")},
+			},
+			expect: []byte{
+				DB_SELECTOR, 0, RESIZE_DB, 1, 1,
+				EXPIRE_PAIR_8_BYTE, 0x15, 0x72, 0xE7, 0x07, 0x8F, 0x01, 0x00, 0x00,
+				RDB_TYPE_STRING, 5, 'f', 'r', 'u', 'i', 't', 5, 'm', 'a', 'n', 'g', 'o',
+			},
+		},
+		{
+			name: "integer strings",
+			entries: []RDBEntry{
+				{Key: "1", Value: RDBString("-300")},
+				{Key: "01", Value: RDBString("70000")},
+			},
+			expect: []byte{
+				DB_SELECTOR, 0, RESIZE_DB, 2, 0,
+				RDB_TYPE_STRING, RDB_ENC_INT8, 1, RDB_ENC_INT16, 0xD4, 0xFE,
+				RDB_TYPE_STRING, 2, '0', '1', RDB_ENC_INT32, 0x70, 0x11, 0x01, 0x00,
+			},
+		},
+		{
+			name: "hash, set and sorted set",
+			entries: []RDBEntry{
+				{Key: "h", Value: RDBHash{{Field: "f", Value: "v"}}},
+				{Key: "s", Value: RDBSet{"m"}},
+				{Key: "z", Value: RDBSortedSet{{Member: "m", Score: 1.5}}},
+			},
+			expect: append([]byte{
+				DB_SELECTOR, 0, RESIZE_DB, 3, 0,
+				RDB_TYPE_HASH, 1, 'h', 1, 1, 'f', 1, 'v',
+				RDB_TYPE_SET, 1, 's', 1, 1, 'm',
+				RDB_TYPE_ZSET_2, 1, 'z', 1, 1, 'm'},
+				binary.LittleEndian.AppendUint64(nil, math.Float64bits(1.5))...),
+		},
+	}
+
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		w := NewRDBWriter(&buf)
+		assert.NoError(t, w.WriteDB(0, tc.entries), tc.name)
+		assert.NoError(t, w.Close(), tc.name)
+
+		file := buf.Bytes()
+		body := file[:len(file)-8]
+		assert.Equal(t, []byte(MAGIC_NUMBER+VERSION), body[:9], tc.name)
+		assert.Equal(t, tc.expect, body[9:len(body)-1], tc.name)
+		assert.Equal(t, byte(RDB_END), body[len(body)-1], tc.name)
+		assert.Equal(t, updateCRC64(0, body), binary.LittleEndian.Uint64(file[len(file)-8:]), tc.name)
+	}
+}
+
+func Test_RDBWriterSkipsEmptyDBs(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewRDBWriter(&buf)
+	w.WriteAux(REDIS_BITS, "64")
+	w.WriteDB(0, nil)
+	w.WriteDB(3, []RDBEntry{{Key: "k", Value: RDBString("v")}})
+	assert.NoError(t, w.Close())
+
+	expect := []byte{
+		AUXILIAR_FILE_HEADER, 10, 'r', 'e', 'd', 'i', 's', '-', 'b', 'i', 't', 's', RDB_ENC_INT8, 64,
+		DB_SELECTOR, 3, RESIZE_DB, 1, 0, RDB_TYPE_STRING, 1, 'k', 1, 'v', RDB_END,
+	}
+	assert.Equal(t, expect, buf.Bytes()[9:buf.Len()-8])
+}
+
+func Test_Listpack(t *testing.T) {
+
+	tests := []struct {
+		elements []string
+		expect   []byte
+	}{
+		{elements: []string{}, expect: []byte{7, 0, 0, 0, 0, 0, LISTPACK_END}},
+		{
+			// integer strings take the integer encodings, non canonical
+			// ones are kept as strings
+			elements: []string{"a", "1", "-1", "1000", "01"},
+			expect: []byte{
+				22, 0, 0, 0, 5, 0,
+				0x81, 'a', 2,
+				1, 1,
+				0xDF, 0xFF, 2,
+				0xC3, 0xE8, 2,
+				0x82, '0', '1', 3,
+				LISTPACK_END,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		lp := &listpack{}
+		for _, element := range tc.elements {
+			lp.appendString(element)
+		}
+		assert.Equal(t, tc.expect, lp.bytes(), tc.elements)
+	}
+}
+
+func Test_ListpackBacklen(t *testing.T) {
+
+	tests := []struct {
+		// size of the element, its header included
+		size    int
+		backlen []byte
+	}{
+		{size: 127, backlen: []byte{127}},
+		{size: 128, backlen: []byte{0x01, 0x80}},
+		{size: 16382, backlen: []byte{0x7F, 0xFE}},
+		{size: 16383, backlen: []byte{0x00, 0xFF, 0xFF}},
+		{size: 2097150, backlen: []byte{0x7F, 0xFF, 0xFE}},
+		{size: 2097151, backlen: []byte{0x00, 0xFF, 0xFF, 0xFF}},
+	}
+
+	for _, tc := range tests {
+		// strings up to 4095 bytes take a 2 byte header, 5 bytes above
+		header := 2
+		if tc.size-2 >= 4096 {
+			header = 5
+		}
+		element := strings.Repeat("x", tc.size-header)
+		lp := &listpack{}
+		lp.appendString(element)
+		lp.appendString("a")
+		assert.Equal(t, tc.backlen, lp.entries[tc.size:tc.size+len(tc.backlen)], tc.size)
+
+		elements, err := listpackElements(lp.bytes())
+		assert.NoError(t, err, tc.size)
+		assert.Equal(t, []string{element, "a"}, elements, tc.size)
+	}
+}
+
+func Test_RDBWriterStream(t *testing.T) {
+	stream := &RDBStream{
+		Entries: []RDBStreamEntry{
+			{ID: RDBStreamID{Ms: 1, Seq: 0}, Fields: []string{"f", "a"}},
+			{ID: RDBStreamID{Ms: 2, Seq: 1}, Fields: []string{"f", "b"}},
+			{ID: RDBStreamID{Ms: 3, Seq: 0}, Fields: []string{"g", "c"}},
+		},
+		LastID: RDBStreamID{Ms: 3, Seq: 0},
+	}
+	var buf bytes.Buffer
+	w := NewRDBWriter(&buf)
+	w.WriteEntry(RDBEntry{Key: "s", Value: stream})
+	assert.NoError(t, w.Close())
+
+	lp := &listpack{}
+	for _, n := range []int64{3, 0, 1} {
+		lp.appendInt(n)
+	}
+	lp.appendString("f")
+	lp.appendInt(0)
+	// same fields as the master entry
+	for _, n := range []int64{STREAM_ITEM_FLAG_SAMEFIELDS, 0, 0} {
+		lp.appendInt(n)
+	}
+	lp.appendString("a")
+	lp.appendInt(4)
+	for _, n := range []int64{STREAM_ITEM_FLAG_SAMEFIELDS, 1, 1} {
+		lp.appendInt(n)
+	}
+	lp.appendString("b")
+	lp.appendInt(4)
+	// fields of its own
+	for _, n := range []int64{STREAM_ITEM_FLAG_NONE, 2, 0, 1} {
+		lp.appendInt(n)
+	}
+	lp.appendString("g")
+	lp.appendString("c")
+	lp.appendInt(6)
+	node := lp.bytes()
+
+	expect := []byte{RDB_TYPE_STREAM_LISTPACKS_3, 1, 's', 1, 16, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, byte(len(node))}
+	expect = append(expect, node...)
+	// length, last id, first id, max deleted id, entries added and groups
+	expect = append(expect, 3, 3, 0, 1, 0, 0, 0, 3, 0)
+	assert.Equal(t, expect, buf.Bytes()[9:buf.Len()-9])
+}
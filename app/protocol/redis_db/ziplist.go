@@ -0,0 +1,179 @@
+package redisdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// the encodings small collections took before listpacks, files written by
+// older versions of redis still have them
+
+const (
+	ZIPLIST_HEADER_SIZE = 10
+	ZIPLIST_END         = 0xFF
+	// a previous entry length that does not fit one byte
+	ZIPLIST_BIG_PREVLEN = 0xFE
+
+	ZIPMAP_BIGLEN = 0xFE
+	ZIPMAP_END    = 0xFF
+)
+
+// ziplistElements decodes the elements of a ziplist, the integers in their
+// decimal form
+func ziplistElements(zl []byte) ([]string, error) {
+	if len(zl) < ZIPLIST_HEADER_SIZE+1 || int(binary.LittleEndian.Uint32(zl)) != len(zl) {
+		return nil, fmt.Errorf("invalid ziplist header")
+	}
+	elements := []string{}
+	for i := ZIPLIST_HEADER_SIZE; ; {
+		if i >= len(zl) {
+			return nil, fmt.Errorf("ziplist without end")
+		}
+		if zl[i] == ZIPLIST_END {
+			return elements, nil
+		}
+		// the length of the previous entry is only used walking backwards
+		if zl[i] == ZIPLIST_BIG_PREVLEN {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(zl) {
+			return nil, fmt.Errorf("truncated ziplist entry")
+		}
+		element, size, err := ziplistElement(zl[i:])
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		i += size
+	}
+}
+
+// ziplistElement decodes the entry at the start of p, after its previous
+// length, returning it with its size
+func ziplistElement(p []byte) (string, int, error) {
+	b := p[0]
+	switch b >> 6 {
+	case 0:
+		return ziplistString(p, 1, int(b&0x3F))
+	case 1:
+		if len(p) < 2 {
+			return "", 0, fmt.Errorf("truncated ziplist entry")
+		}
+		return ziplistString(p, 2, int(b&0x3F)<<8|int(p[1]))
+	case 2:
+		if len(p) < 5 {
+			return "", 0, fmt.Errorf("truncated ziplist entry")
+		}
+		return ziplistString(p, 5, int(binary.BigEndian.Uint32(p[1:])))
+	}
+
+	// integers, an immediate 4 bits value or 1 to 8 bytes after the header
+	if b >= 0xF1 && b <= 0xFD {
+		return strconv.Itoa(int(b&0x0F) - 1), 1, nil
+	}
+	sizes := map[byte]int{0xC0: 2, 0xD0: 4, 0xE0: 8, 0xF0: 3, 0xFE: 1}
+	size, ok := sizes[b]
+	if !ok {
+		return "", 0, fmt.Errorf("invalid ziplist encoding 0x%x", b)
+	}
+	if len(p) < 1+size {
+		return "", 0, fmt.Errorf("truncated ziplist entry")
+	}
+	var n int64
+	switch size {
+	case 1:
+		n = int64(int8(p[1]))
+	case 2:
+		n = int64(int16(binary.LittleEndian.Uint16(p[1:])))
+	case 3:
+		n = int64(int32(uint32(p[1])<<8|uint32(p[2])<<16|uint32(p[3])<<24) >> 8)
+	case 4:
+		n = int64(int32(binary.LittleEndian.Uint32(p[1:])))
+	case 8:
+		n = int64(binary.LittleEndian.Uint64(p[1:]))
+	}
+	return strconv.FormatInt(n, 10), 1 + size, nil
+}
+
+func ziplistString(p []byte, header, length int) (string, int, error) {
+	if length < 0 || header+length > len(p) {
+		return "", 0, fmt.Errorf("truncated ziplist entry")
+	}
+	return string(p[header : header+length]), header + length, nil
+}
+
+// zipmapElements decodes the field value pairs of a zipmap, the hash
+// encoding of redis before 2.6
+func zipmapElements(zm []byte) ([]string, error) {
+	elements := []string{}
+	i := 1
+	for {
+		if i >= len(zm) {
+			return nil, fmt.Errorf("zipmap without end")
+		}
+		if zm[i] == ZIPMAP_END {
+			if len(elements)%2 != 0 {
+				return nil, fmt.Errorf("zipmap field without value")
+			}
+			return elements, nil
+		}
+		length, size, err := zipmapLength(zm[i:])
+		if err != nil {
+			return nil, err
+		}
+		i += size
+		// values are followed by the unused bytes they can grow into
+		free := 0
+		if len(elements)%2 == 1 {
+			if i >= len(zm) {
+				return nil, fmt.Errorf("truncated zipmap")
+			}
+			free = int(zm[i])
+			i++
+		}
+		if i+length > len(zm) {
+			return nil, fmt.Errorf("truncated zipmap")
+		}
+		elements = append(elements, string(zm[i:i+length]))
+		i += length + free
+	}
+}
+
+func zipmapLength(p []byte) (int, int, error) {
+	if p[0] < ZIPMAP_BIGLEN {
+		return int(p[0]), 1, nil
+	}
+	if len(p) < 5 {
+		return 0, 0, fmt.Errorf("truncated zipmap")
+	}
+	return int(binary.LittleEndian.Uint32(p[1:])), 5, nil
+}
+
+// intsetElements decodes a sorted set of integers of 2, 4 or 8 bytes each
+func intsetElements(is []byte) ([]string, error) {
+	if len(is) < 8 {
+		return nil, fmt.Errorf("invalid intset header")
+	}
+	size := int(binary.LittleEndian.Uint32(is))
+	length := int(binary.LittleEndian.Uint32(is[4:]))
+	if (size != 2 && size != 4 && size != 8) || len(is)-8 != size*length {
+		return nil, fmt.Errorf("invalid intset of %d elements of %d bytes in %d bytes", length, size, len(is))
+	}
+	elements := make([]string, 0, length)
+	for i := 8; i < len(is); i += size {
+		var n int64
+		switch size {
+		case 2:
+			n = int64(int16(binary.LittleEndian.Uint16(is[i:])))
+		case 4:
+			n = int64(int32(binary.LittleEndian.Uint32(is[i:])))
+		case 8:
+			n = int64(binary.LittleEndian.Uint64(is[i:]))
+		}
+		elements = append(elements, strconv.FormatInt(n, 10))
+	}
+	return elements, nil
+}
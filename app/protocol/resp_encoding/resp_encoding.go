@@ -2,6 +2,7 @@ package respencoding
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 
 	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
@@ -79,3 +80,124 @@ func EncodeSimpleError(s string) []byte {
 func addCRNL(s string) []byte {
 	return []byte(fmt.Sprintf("%s\r\n", s))
 }
+
+// RESP3 types, every encoder takes the protocol version of the client and
+// falls back to the closest RESP2 type when it is 2
+
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+func EncodeNull(proto int) []byte {
+	if proto >= RESP3 {
+		return []byte("_\r\n")
+	}
+	return []byte("$-1\r\n")
+}
+
+func EncodeNullArray(proto int) []byte {
+	if proto >= RESP3 {
+		return []byte("_\r\n")
+	}
+	return []byte("*-1\r\n")
+}
+
+// BuildMap takes the already encoded keys and values one after the other,
+// RESP2 clients get a flat array
+func BuildMap(proto int, encodedPairs [][]byte) []byte {
+	if proto >= RESP3 {
+		return buildAggregate('%', len(encodedPairs)/2, encodedPairs)
+	}
+	return BuildArray(encodedPairs)
+}
+
+func BuildSet(proto int, encodedElements [][]byte) []byte {
+	if proto >= RESP3 {
+		return buildAggregate('~', len(encodedElements), encodedElements)
+	}
+	return BuildArray(encodedElements)
+}
+
+// BuildPush is used for out of band data, pub/sub messages and
+// invalidations, RESP2 clients get it as an array
+func BuildPush(proto int, encodedElements [][]byte) []byte {
+	if proto >= RESP3 {
+		return buildAggregate('>', len(encodedElements), encodedElements)
+	}
+	return BuildArray(encodedElements)
+}
+
+// BuildAttribute prefixes a reply with auxiliary data, RESP2 has no way to
+// represent it so nothing is sent
+func BuildAttribute(proto int, encodedPairs [][]byte) []byte {
+	if proto >= RESP3 {
+		return buildAggregate('|', len(encodedPairs)/2, encodedPairs)
+	}
+	return []byte{}
+}
+
+func EncodeDouble(proto int, f float64) []byte {
+	var s string
+	switch {
+	case math.IsInf(f, 1):
+		s = "inf"
+	case math.IsInf(f, -1):
+		s = "-inf"
+	case math.IsNaN(f):
+		s = "nan"
+	default:
+		s = strconv.FormatFloat(f, 'g', 17, 64)
+	}
+	if proto >= RESP3 {
+		return append([]byte{','}, addCRNL(s)...)
+	}
+	return EncodeBulkString([]byte(s))
+}
+
+func EncodeBoolean(proto int, b bool) []byte {
+	if proto >= RESP3 {
+		if b {
+			return []byte("#t\r\n")
+		}
+		return []byte("#f\r\n")
+	}
+	if b {
+		return EncodeInteger(1)
+	}
+	return EncodeInteger(0)
+}
+
+func EncodeBigNumber(proto int, n string) []byte {
+	if proto >= RESP3 {
+		return append([]byte{'('}, addCRNL(n)...)
+	}
+	return EncodeBulkString([]byte(n))
+}
+
+// EncodeVerbatimString format is a three chars type like txt or mkd
+func EncodeVerbatimString(proto int, format string, s []byte) []byte {
+	if proto < RESP3 {
+		return EncodeBulkString(s)
+	}
+	res := make([]byte, 0, len(s)+len(format)+10)
+	res = append(res, '=')
+	res = append(res, []byte(strconv.Itoa(len(format)+1+len(s)))...)
+	res = append(res, []byte(parser.CRNL)...)
+	res = append(res, []byte(format)...)
+	res = append(res, ':')
+	res = append(res, s...)
+	res = append(res, []byte(parser.CRNL)...)
+	return res
+}
+
+func buildAggregate(prefix byte, size int, encodedElements [][]byte) []byte {
+	res := make([]byte, 0, len(encodedElements)+10)
+	res = append(res, prefix)
+	res = append(res, []byte(strconv.Itoa(size))...)
+	res = append(res, []byte(parser.CRNL)...)
+	for _, ele := range encodedElements {
+		res = append(res, ele...)
+	}
+	return res
+}
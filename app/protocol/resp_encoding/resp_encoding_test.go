@@ -0,0 +1,86 @@
+package respencoding
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RESP3Encoders(t *testing.T) {
+
+	pair := [][]byte{EncodeBulkString([]byte("k")), EncodeInteger(1)}
+
+	tests := []struct {
+		name   string
+		encode func(proto int) []byte
+		resp2  string
+		resp3  string
+	}{
+		{
+			name:   "null",
+			encode: EncodeNull,
+			resp2:  "$-1\r\n",
+			resp3:  "_\r\n",
+		},
+		{
+			name:   "map",
+			encode: func(proto int) []byte { return BuildMap(proto, pair) },
+			resp2:  "*2\r\n$1\r\nk\r\n:1\r\n",
+			resp3:  "%1\r\n$1\r\nk\r\n:1\r\n",
+		},
+		{
+			name:   "set",
+			encode: func(proto int) []byte { return BuildSet(proto, pair) },
+			resp2:  "*2\r\n$1\r\nk\r\n:1\r\n",
+			resp3:  "~2\r\n$1\r\nk\r\n:1\r\n",
+		},
+		{
+			name:   "push",
+			encode: func(proto int) []byte { return BuildPush(proto, pair) },
+			resp2:  "*2\r\n$1\r\nk\r\n:1\r\n",
+			resp3:  ">2\r\n$1\r\nk\r\n:1\r\n",
+		},
+		{
+			name:   "attribute",
+			encode: func(proto int) []byte { return BuildAttribute(proto, pair) },
+			resp2:  "",
+			resp3:  "|1\r\n$1\r\nk\r\n:1\r\n",
+		},
+		{
+			name:   "double",
+			encode: func(proto int) []byte { return EncodeDouble(proto, 1.5) },
+			resp2:  "$3\r\n1.5\r\n",
+			resp3:  ",1.5\r\n",
+		},
+		{
+			name:   "infinite double",
+			encode: func(proto int) []byte { return EncodeDouble(proto, math.Inf(-1)) },
+			resp2:  "$4\r\n-inf\r\n",
+			resp3:  ",-inf\r\n",
+		},
+		{
+			name:   "boolean",
+			encode: func(proto int) []byte { return EncodeBoolean(proto, true) },
+			resp2:  ":1\r\n",
+			resp3:  "#t\r\n",
+		},
+		{
+			name:   "big number",
+			encode: func(proto int) []byte { return EncodeBigNumber(proto, "3492890328409238509324850943850943825024385") },
+			resp2:  "$43\r\n3492890328409238509324850943850943825024385\r\n",
+			resp3:  "(3492890328409238509324850943850943825024385\r\n",
+		},
+		{
+			name:   "verbatim string",
+			encode: func(proto int) []byte { return EncodeVerbatimString(proto, "txt", []byte("Some string")) },
+			resp2:  "$11\r\nSome string\r\n",
+			resp3:  "=15\r\ntxt:Some string\r\n",
+		},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.resp2, string(tc.encode(RESP2)), tc.name)
+		assert.Equal(t, tc.resp3, string(tc.encode(RESP3)), tc.name)
+	}
+}
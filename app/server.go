@@ -2,17 +2,17 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/codecrafters-io/redis-starter-go/app/info"
-	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
 	"github.com/codecrafters-io/redis-starter-go/app/services"
-	"github.com/google/uuid"
 )
 
 func main() {
@@ -22,41 +22,73 @@ func main() {
 
 const (
 	// Server options
-	PORT        = "--port"
-	REPLICA_OF  = "--replicaof"
-	DIR         = "--dir"
-	DB_FILENAME = "--dbfilename"
+	PORT       = "--port"
+	REPLICA_OF = "--replicaof"
 )
 
 type Server struct {
 	QChan              chan any
 	connChan           chan net.Conn
 	serverInfo         info.ServerInfo
-	kvs                services.Kvs
+	dbs                *services.KvsDatabases
 	rs                 *services.RedisService
 	masterService      services.MasterService
 	replicationService services.ReplicationService
 	metrics            *info.Metrics
+	config             *info.Config
+	clients            *services.ClientRegistry
 }
 
 type serverOptions struct {
-	port        int
-	role        string
-	masterHost  string
-	masterPort  int
-	rbdDir      string
-	rbdFileName string
+	port       int
+	role       string
+	masterHost string
+	masterPort int
+	// any other --name value pair, applied to the runtime config
+	configs map[string]string
 }
 
 func NewServer() *Server {
 	streamSetEvent := make(chan string)
-	kvs := services.NewKvSService()
+	pubsub := services.NewPubSubService()
+	clients := services.NewClientRegistry()
+	tracking := services.NewTrackingService(clients, pubsub)
+	notifier := services.NewKeyspaceNotifier(pubsub)
+	dbs := services.NewKvsDatabases(services.DEFAULT_DATABASES, func(db int) services.Kvs {
+		return services.NewKvSService(notifier, tracking, db)
+	})
+
+	config := info.NewConfig()
+	config.Define(info.CONFIG_NOTIFY_KEYSPACE_EVENTS, "", notifier.SetFlags)
+	config.Define(info.CONFIG_REQUIREPASS, "", nil)
+	config.Define(info.CONFIG_OUTPUT_BUFFER_LIMIT, services.DEFAULT_OUTPUT_BUFFER_LIMIT, clients.SetOutputBufferLimits)
+	config.DefineImmutable(info.CONFIG_DATABASES, strconv.Itoa(services.DEFAULT_DATABASES), dbs.SetCount)
+	config.Define(info.CONFIG_MAXMEMORY, "0", dbs.Evictor().SetMaxMemory)
+	config.Define(info.CONFIG_MAXMEMORY_POLICY, services.MAXMEMORY_NO_EVICTION, dbs.Evictor().SetPolicy)
+	config.Define(info.CONFIG_MAXMEMORY_SAMPLES, strconv.Itoa(services.DEFAULT_MAXMEMORY_SAMPLES), dbs.Evictor().SetSamples)
+	config.Define(info.CONFIG_LFU_LOG_FACTOR, strconv.Itoa(services.DEFAULT_LFU_LOG_FACTOR), services.SetLFULogFactor)
+	config.Define(info.CONFIG_LFU_DECAY_TIME, strconv.Itoa(services.DEFAULT_LFU_DECAY_TIME), services.SetLFUDecayTime)
+	services.DefineEncodingConfigs(config)
+	config.Define(info.CONFIG_DIR, services.DEFAULT_DIR, dbs.RDB().SetDir)
+	config.Define(info.CONFIG_DBFILENAME, services.DEFAULT_DB_FILENAME, dbs.RDB().SetFilename)
+	config.Define(info.CONFIG_SAVE, services.DEFAULT_SAVE_PARAMS, dbs.RDB().SetSaveParams)
+	config.Define(info.CONFIG_APPENDONLY, "no", dbs.AOF().SetEnabled)
+	config.DefineImmutable(info.CONFIG_APPENDFILENAME, services.DEFAULT_APPENDFILENAME, dbs.AOF().SetFilename)
+	config.Define(info.CONFIG_APPENDFSYNC, services.APPENDFSYNC_EVERYSEC, dbs.AOF().SetFsync)
+	config.Define(info.CONFIG_AOF_LOAD_TRUNCATED, "yes", dbs.AOF().SetLoadTruncated)
+	config.DefineImmutable(info.CONFIG_APPENDDIRNAME, services.DEFAULT_APPENDDIRNAME, dbs.AOF().SetDirname)
+	config.Define(info.CONFIG_AOF_USE_RDB_PREAMBLE, "yes", dbs.AOF().SetUseRDBPreamble)
+	config.Define(info.CONFIG_AUTO_AOF_REWRITE_PERC, services.DEFAULT_AUTO_AOF_REWRITE_PERCENTAGE, dbs.AOF().SetRewritePercentage)
+	config.Define(info.CONFIG_AUTO_AOF_REWRITE_MIN, services.DEFAULT_AUTO_AOF_REWRITE_MIN_SIZE, dbs.AOF().SetRewriteMinSize)
+
 	return &Server{
-		kvs:        kvs,
+		dbs:        dbs,
 		connChan:   make(chan net.Conn),
-		rs:         services.NewRedisService(kvs, streamSetEvent),
+		rs:         services.NewRedisService(dbs, pubsub, clients, tracking, streamSetEvent),
 		serverInfo: make(info.ServerInfo),
 		metrics:    info.NewMetrics(),
+		config:     config,
+		clients:    clients,
 	}
 }
 
@@ -66,37 +98,41 @@ func (s *Server) Start() {
 	s.serverInfo[info.SERVER_ROLE] = serverOps.role
 	s.serverInfo[info.SERVER_PORT] = strconv.Itoa(serverOps.port)
 	s.serverInfo[info.SERVER_MASTER_REPLID] = "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb"
-	if serverOps.rbdDir != "" && serverOps.rbdFileName != "" {
-		s.serverInfo[info.SERVER_RDB_DIR] = serverOps.rbdDir
-
-		file, err := os.Open(fmt.Sprintf("%s/%s", serverOps.rbdDir, serverOps.rbdFileName))
-		if err != nil {
-			log.Printf("Error reading file at %s, error: %s", serverOps.rbdDir, err)
-		} else {
-
-			info, _ := file.Stat()
+	for name, value := range serverOps.configs {
+		if err := s.config.Set(name, value); err != nil {
+			log.Fatalf("Invalid config %s: %s", name, err)
+		}
+	}
+	if s.serverInfo[info.SERVER_ROLE] == info.ROLE_MASTER {
 
-			rdbBytes := redisdb.BuildRDBFromFileSystem(file, info.Size())
-			rdbFile, err := redisdb.LoadRDBFile(rdbBytes)
+		s.masterService = services.NewMasterService(s.metrics, s.clients)
+		s.dbs.SetReplication(s.masterService.Propagate)
+		go s.masterService.HandleEvents()
+	}
 
-			if err != nil {
-				log.Fatalf("Error loading rdb file %s", err)
-			}
+	log.Println("Starting server\n INFO", s.serverInfo)
+	var listener net.Listener
+	var err error
 
-			for _, simplePair := range rdbFile.Kv {
-				s.kvs.Set(simplePair.Key, []byte(simplePair.Value))
-			}
+	if serverOps.port != 0 {
+		listener, err = s.createConnection(serverOps.port)
+	} else {
+		listener, err = s.createDefaultConnection()
+	}
 
-			for _, expPair := range rdbFile.ExpKv {
+	if err != nil {
+		log.Fatal("Could not create connection" + err.Error())
+	}
 
-				s.kvs.SetWithOptions(expPair.Key, []byte(expPair.Value), services.NewKvsOptionsWithTimestamp(expPair.Exp))
-			}
-		}
+	defer listener.Close()
+	go s.handleConn(s.connChan, s.rs)
+	go listen(listener, s)
 
-	}
+	// clients are accepted while loading, to follow its progress in INFO
+	s.loadDataFromDisk()
 
 	if s.serverInfo[info.SERVER_ROLE] == info.ROLE_SLAVE {
-		s.replicationService = services.NewReplicationService(s.kvs, s.metrics)
+		s.replicationService = services.NewReplicationService(s.rs, s.metrics)
 		portString := strconv.Itoa(serverOps.masterPort)
 		s.serverInfo[info.SERVER_MASTER_HOST] = serverOps.masterHost
 		s.serverInfo[info.SERVER_MASTER_PORT] = portString
@@ -110,31 +146,51 @@ func (s *Server) Start() {
 		go s.replicationService.HandleMasterConn(masterConn, s.buildCtx())
 	}
 
-	if s.serverInfo[info.SERVER_ROLE] == info.ROLE_MASTER {
-
-		s.masterService = services.NewMasterService(s.metrics, s.connChan)
-		go s.masterService.HandleEvents()
-	}
+	go s.dbs.HandleActiveExpire()
+	go s.dbs.RDB().HandleSaveRules()
+	go s.dbs.AOF().HandleFsync()
+	go s.dbs.AOF().HandleRewriteRules()
+	go s.handleSignals()
 
-	log.Println("Starting server\n INFO", s.serverInfo)
-	var listener net.Listener
-	var err error
+	<-s.QChan
+}
 
-	if serverOps.port != 0 {
-		listener, err = s.createConnection(serverOps.port)
+// loadDataFromDisk loads the append only file when it is on and exists,
+// otherwise the rdb file, the server can not start with data it fails to
+// load. The append only file is opened once the data is loaded
+func (s *Server) loadDataFromDisk() {
+	start := time.Now()
+	aof := s.dbs.AOF()
+	if aof.Enabled() && aof.Exists() {
+		if err := s.rs.LoadAppendOnlyFile(s.buildCtx()); err != nil {
+			log.Fatalf("Error loading the append only file %s: %s", aof.Path(), err)
+		}
+		log.Printf("DB loaded from append only file: %.3f seconds", time.Since(start).Seconds())
 	} else {
-		listener, err = s.createDefaultConnection()
+		if err := s.dbs.RDB().Load(); err != nil {
+			log.Fatalf("Error loading rdb file %s: %s", s.dbs.RDB().Path(), err)
+		}
+		log.Printf("DB loaded from disk: %.3f seconds", time.Since(start).Seconds())
 	}
-
-	if err != nil {
-		log.Fatal("Could not create connection" + err.Error())
+	if err := aof.Start(); err != nil {
+		log.Fatalf("Can't open the append-only file %s: %s", aof.Path(), err)
 	}
+}
 
-	defer listener.Close()
-	go s.handleConn(s.connChan, s.rs)
-	go listen(listener, s)
-
-	<-s.QChan
+// handleSignals saves the final snapshot on SIGINT and SIGTERM before
+// exiting, the server keeps running when it can not be saved
+func (s *Server) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range signals {
+		log.Printf("Received %s scheduling shutdown...", sig)
+		if err := s.dbs.RDB().Shutdown("", false); err != nil {
+			log.Println("Error trying to save the DB, can't exit.")
+			continue
+		}
+		log.Println("Redis is now ready to exit, bye bye...")
+		os.Exit(0)
+	}
 }
 
 func (s *Server) createDefaultConnection() (net.Listener, error) {
@@ -170,12 +226,11 @@ func (s *Server) handleConn(connChan chan net.Conn, rs *services.RedisService) {
 func (s *Server) buildCtx() context.Context {
 	ctx := context.Background()
 	ctx = context.WithoutCancel(ctx)
-	ctx = context.WithValue(ctx, info.CTX_SESSION_ID, uuid.New())
 	ctx = context.WithValue(ctx, info.CTX_SERVER_INFO, s.serverInfo)
 	ctx = context.WithValue(ctx, info.CTX_METRICS, s.metrics)
+	ctx = context.WithValue(ctx, info.CTX_CONFIG, s.config)
 	if s.serverInfo[info.SERVER_ROLE] == info.ROLE_MASTER {
-		ctx = context.WithValue(ctx, info.CTX_REPLICATION_EVENTS, s.masterService.GetReplicationCmdChan())
-		ctx = context.WithValue(ctx, info.CTX_REPLACATION_REGISTRATION, s.masterService.GetReplicaRegistrationChan())
+		ctx = context.WithValue(ctx, info.CTX_REPLACATION_REGISTRATION, s.masterService.RegisterReplica)
 		ctx = context.WithValue(ctx, info.CTX_ACK_EVENT, s.masterService.GetAckEventChan())
 		log.Println("Master ctx set", s.masterService.GetAckEventChan())
 	}
@@ -199,7 +254,7 @@ func listen(l net.Listener, s *Server) {
 
 func buildServerOptions() serverOptions {
 	args := os.Args
-	ops := serverOptions{}
+	ops := serverOptions{configs: make(map[string]string)}
 	ops.port = 6379
 	ops.role = info.ROLE_MASTER
 
@@ -239,30 +294,12 @@ func buildServerOptions() serverOptions {
 			ops.masterHost = masterHost
 			ops.masterPort = masterPort
 			ops.role = info.ROLE_SLAVE
-		case DIR:
-			if processedArgs+1 >= len(args) {
-				log.Fatal("missing dir argument")
-			}
-			processedArgs++
-			dir := args[processedArgs]
-			if dir == "" {
-				log.Fatal("dir is empty")
-			}
-			ops.rbdDir = dir
-
-		case DB_FILENAME:
-
-			if processedArgs+1 >= len(args) {
-				log.Fatal("missing dir name argument")
-			}
-			processedArgs++
-			dirName := args[processedArgs]
-			if dirName == "" {
-				log.Fatal("dir name is empty")
-			}
-			ops.rbdFileName = dirName
-
 		default:
+			name, isOption := strings.CutPrefix(args[processedArgs], "--")
+			if isOption && processedArgs+1 < len(args) {
+				processedArgs++
+				ops.configs[name] = args[processedArgs]
+			}
 			processedArgs++
 		}
 
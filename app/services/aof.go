@@ -0,0 +1,1004 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+	aofmanifest "github.com/codecrafters-io/redis-starter-go/app/protocol/aof_manifest"
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
+	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
+)
+
+const (
+	DEFAULT_APPENDFILENAME = "appendonly.aof"
+	DEFAULT_APPENDDIRNAME  = "appendonlydir"
+
+	// fsync policies of the append only file
+	APPENDFSYNC_ALWAYS   = "always"
+	APPENDFSYNC_EVERYSEC = "everysec"
+	APPENDFSYNC_NO       = "no"
+
+	// how often the everysec policy syncs the file
+	AOF_FSYNC_INTERVAL = time.Second
+
+	// the file is rewritten once it doubled since the last rewrite, when
+	// it has at least 64mb
+	DEFAULT_AUTO_AOF_REWRITE_PERCENTAGE = "100"
+	DEFAULT_AUTO_AOF_REWRITE_MIN_SIZE   = "64mb"
+	// how often the rewrite rules are checked
+	AOF_REWRITE_RULES_INTERVAL = 100 * time.Millisecond
+	// a failed automatic rewrite is retried after this delay
+	AOF_REWRITE_RETRY_DELAY = 5 * time.Second
+	// elements of a collection per command of a base file of commands
+	AOF_REWRITE_ITEMS_PER_CMD = 64
+)
+
+// AOFService logs the write commands to the append only file, so the
+// writes made after the last snapshot survive a restart. Like redis 7 the
+// file is made of the files listed in a manifest in appenddirname, a base
+// file with the dataset of the last rewrite and incr files with the
+// commands that followed it. A rewrite starts a new incr file and writes
+// a new base file in a goroutine, the files it replaces are deleted
+type AOFService struct {
+	dbs            *KvsDatabases
+	enabled        atomic.Bool
+	filename       atomic.Pointer[string]
+	dirname        atomic.Pointer[string]
+	fsync          atomic.Pointer[string]
+	loadTruncated  atomic.Bool
+	rdbPreamble    atomic.Bool
+	rewritePerc    atomic.Int64
+	rewriteMinSize atomic.Int64
+	// the file is only opened once the data was loaded on startup
+	started atomic.Bool
+
+	// one rewrite at a time, a scheduled one starts once it is possible
+	rewriting atomic.Bool
+	scheduled atomic.Bool
+	// unix seconds of the start of the last rewrite, its state and how
+	// long it took
+	rewriteStart    atomic.Int64
+	lastRewriteOK   atomic.Bool
+	lastRewriteTime atomic.Int64
+	rewrites        atomic.Int64
+
+	mx sync.Mutex
+	// files of the append only file, nil until it is loaded or created
+	manifest *aofmanifest.Manifest
+	// incr file the commands are appended to, nil while the file is off
+	file *os.File
+	// database of the last command written, -1 writes a SELECT first
+	selected int
+	// commands written since the last fsync
+	unsynced bool
+	// size of all the files and what it was after the last rewrite
+	size     int64
+	baseSize int64
+	writeOK  atomic.Bool
+}
+
+func NewAOFService(dbs *KvsDatabases) *AOFService {
+	a := &AOFService{dbs: dbs, selected: -1}
+	filename, dirname, fsync := DEFAULT_APPENDFILENAME, DEFAULT_APPENDDIRNAME, APPENDFSYNC_EVERYSEC
+	a.filename.Store(&filename)
+	a.dirname.Store(&dirname)
+	a.fsync.Store(&fsync)
+	a.loadTruncated.Store(true)
+	a.rdbPreamble.Store(true)
+	a.SetRewritePercentage(DEFAULT_AUTO_AOF_REWRITE_PERCENTAGE)
+	a.SetRewriteMinSize(DEFAULT_AUTO_AOF_REWRITE_MIN_SIZE)
+	a.lastRewriteOK.Store(true)
+	a.lastRewriteTime.Store(-1)
+	a.writeOK.Store(true)
+	return a
+}
+
+// SetEnabled is the appendonly config apply function, once the server
+// started turning it on rewrites the file with the current dataset
+func (a *AOFService) SetEnabled(value string) (string, error) {
+	enabled, err := info.ParseBool(value)
+	if err != nil {
+		return "", err
+	}
+	if a.started.Load() && enabled != a.enabled.Load() {
+		a.enabled.Store(enabled)
+		if !enabled {
+			a.close()
+		} else if a.rewriting.Load() {
+			// the rewrite in progress does not have the commands run
+			// before the file is on, the next one writes them
+			a.scheduled.Store(true)
+		} else if err := a.BackgroundRewrite(); err != nil {
+			a.enabled.Store(false)
+			return "", err
+		}
+	}
+	a.enabled.Store(enabled)
+	return strings.ToLower(value), nil
+}
+
+// SetFilename is the appendfilename config apply function
+func (a *AOFService) SetFilename(value string) (string, error) {
+	if value == "" || filepath.Base(value) != value {
+		return "", fmt.Errorf("appendfilename can't be a path, just a filename")
+	}
+	a.filename.Store(&value)
+	return value, nil
+}
+
+// SetDirname is the appenddirname config apply function
+func (a *AOFService) SetDirname(value string) (string, error) {
+	if value == "" || filepath.Base(value) != value {
+		return "", fmt.Errorf("appenddirname can't be a path, just a dirname")
+	}
+	a.dirname.Store(&value)
+	return value, nil
+}
+
+// SetFsync is the appendfsync config apply function
+func (a *AOFService) SetFsync(value string) (string, error) {
+	value = strings.ToLower(value)
+	switch value {
+	case APPENDFSYNC_ALWAYS, APPENDFSYNC_EVERYSEC, APPENDFSYNC_NO:
+		a.fsync.Store(&value)
+		return value, nil
+	}
+	return "", fmt.Errorf("argument(s) must be one of the following: always, everysec, no")
+}
+
+// SetLoadTruncated is the aof-load-truncated config apply function
+func (a *AOFService) SetLoadTruncated(value string) (string, error) {
+	loadTruncated, err := info.ParseBool(value)
+	if err != nil {
+		return "", err
+	}
+	a.loadTruncated.Store(loadTruncated)
+	return strings.ToLower(value), nil
+}
+
+// SetUseRDBPreamble is the aof-use-rdb-preamble config apply function,
+// the base files are written in the rdb format or as commands
+func (a *AOFService) SetUseRDBPreamble(value string) (string, error) {
+	rdbPreamble, err := info.ParseBool(value)
+	if err != nil {
+		return "", err
+	}
+	a.rdbPreamble.Store(rdbPreamble)
+	return strings.ToLower(value), nil
+}
+
+// SetRewritePercentage is the auto-aof-rewrite-percentage config apply
+// function, 0 disables the automatic rewrites
+func (a *AOFService) SetRewritePercentage(value string) (string, error) {
+	perc, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || perc < 0 {
+		return "", fmt.Errorf("argument must be a non negative integer")
+	}
+	a.rewritePerc.Store(perc)
+	return strconv.FormatInt(perc, 10), nil
+}
+
+// SetRewriteMinSize is the auto-aof-rewrite-min-size config apply function
+func (a *AOFService) SetRewriteMinSize(value string) (string, error) {
+	size, err := info.ParseMemory(value)
+	if err != nil {
+		return "", err
+	}
+	a.rewriteMinSize.Store(size)
+	return strconv.FormatInt(size, 10), nil
+}
+
+// Dir holds the files of the append only file, in the dir of the rdb file
+func (a *AOFService) Dir() string {
+	return filepath.Join(filepath.Dir(a.dbs.RDB().Path()), *a.dirname.Load())
+}
+
+// Path is the manifest of the files
+func (a *AOFService) Path() string {
+	return filepath.Join(a.Dir(), aofmanifest.ManifestName(*a.filename.Load()))
+}
+
+// legacyPath is the single append only file of the versions without a
+// manifest
+func (a *AOFService) legacyPath() string {
+	return filepath.Join(filepath.Dir(a.dbs.RDB().Path()), *a.filename.Load())
+}
+
+func (a *AOFService) Enabled() bool {
+	return a.enabled.Load()
+}
+
+// Exists reports if there is a file to load, the manifest or a file of
+// the versions without one
+func (a *AOFService) Exists() bool {
+	for _, path := range []string{a.Path(), a.legacyPath()} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readManifest reads the manifest, a file of the versions without one is
+// moved to the dir as the base file of a new manifest
+func (a *AOFService) readManifest() (*aofmanifest.Manifest, error) {
+	path := a.Path()
+	file, err := os.Open(path)
+	if err == nil {
+		defer file.Close()
+		m, err := aofmanifest.Parse(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the AOF manifest %s: %w", path, err)
+		}
+		return m, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	legacy := a.legacyPath()
+	if _, err := os.Stat(legacy); err != nil {
+		return nil, err
+	}
+	filename := *a.filename.Load()
+	m := &aofmanifest.Manifest{Base: &aofmanifest.File{Name: filename, Seq: 1, Type: aofmanifest.FILE_TYPE_BASE}, BaseSeq: 1}
+	if err := os.MkdirAll(a.Dir(), 0755); err != nil {
+		return nil, fmt.Errorf("can't create the AOF directory %s: %w", a.Dir(), err)
+	}
+	if err := a.persistManifest(m); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(legacy, filepath.Join(a.Dir(), filename)); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("error moving the AOF file %s to %s: %w", legacy, a.Dir(), err)
+	}
+	log.Printf("Successfully migrated an old-style AOF into the AOF directory %s", a.Dir())
+	return m, nil
+}
+
+// persistManifest writes the manifest to a temporary file renamed over
+// the current one
+func (a *AOFService) persistManifest(m *aofmanifest.Manifest) error {
+	file, err := os.CreateTemp(a.Dir(), "temp-*"+aofmanifest.MANIFEST_SUFFIX)
+	if err != nil {
+		return fmt.Errorf("failed opening the temp AOF manifest: %w", err)
+	}
+	_, err = file.Write(m.Bytes())
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), a.Path())
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("error writing the AOF manifest: %w", err)
+	}
+	return nil
+}
+
+// deleteHistory deletes the files replaced by a rewrite and writes the
+// manifest without them, caller must hold the lock
+func (a *AOFService) deleteHistory() {
+	if len(a.manifest.History) == 0 {
+		return
+	}
+	for _, file := range a.manifest.History {
+		if err := os.Remove(filepath.Join(a.Dir(), file.Name)); err != nil && !os.IsNotExist(err) {
+			log.Println("Error deleting the AOF history file:", err)
+		}
+	}
+	m := a.manifest.Clone()
+	m.History = nil
+	if err := a.persistManifest(m); err != nil {
+		log.Println("Error removing the history files from the AOF manifest:", err)
+		return
+	}
+	a.manifest = m
+}
+
+// filesSize is the size of the files of the manifest
+func (a *AOFService) filesSize(m *aofmanifest.Manifest) int64 {
+	size := int64(0)
+	for _, file := range m.Files() {
+		if stat, err := os.Stat(filepath.Join(a.Dir(), file.Name)); err == nil {
+			size += stat.Size()
+		}
+	}
+	return size
+}
+
+// Start opens the last incr file for the commands once the data was
+// loaded, when there was no file it is created with the dataset
+func (a *AOFService) Start() error {
+	defer a.started.Store(true)
+	if !a.enabled.Load() {
+		return nil
+	}
+	a.mx.Lock()
+	loaded := a.manifest != nil
+	a.mx.Unlock()
+	if !loaded {
+		return a.rewrite(false)
+	}
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.deleteHistory()
+	m := a.manifest
+	if len(m.Incrs) == 0 {
+		m = m.Clone()
+		m.NextIncr(*a.filename.Load())
+		if err := a.persistManifest(m); err != nil {
+			return err
+		}
+	}
+	incr := m.Incrs[len(m.Incrs)-1]
+	file, err := os.OpenFile(filepath.Join(a.Dir(), incr.Name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	a.manifest, a.file, a.selected = m, file, -1
+	return nil
+}
+
+// BackgroundRewrite starts a new incr file and writes the base file of
+// the dataset in a goroutine, the commands run meanwhile go to the new
+// incr file
+func (a *AOFService) BackgroundRewrite() error {
+	return a.rewrite(true)
+}
+
+// Rewriting reports if a rewrite is in progress
+func (a *AOFService) Rewriting() bool {
+	return a.rewriting.Load()
+}
+
+func (a *AOFService) rewrite(background bool) error {
+	if !a.rewriting.CompareAndSwap(false, true) {
+		return fmt.Errorf("ERR Background append only file rewriting already in progress")
+	}
+	start := time.Now()
+	a.rewriteStart.Store(start.Unix())
+	keep, snapshot, err := a.startRewrite()
+	if err != nil {
+		a.rewriteDone(start, err)
+		return err
+	}
+	if !background {
+		err := a.finishRewrite(keep, snapshot)
+		a.rewriteDone(start, err)
+		return err
+	}
+
+	log.Println("Background append only file rewriting started")
+	go func() {
+		if err := a.finishRewrite(keep, snapshot); err != nil {
+			log.Println("Background AOF rewrite error:", err)
+			a.rewriteDone(start, err)
+			return
+		}
+		log.Println("Background AOF rewrite terminated with success")
+		a.rewriteDone(start, nil)
+	}()
+	return nil
+}
+
+// rewriteDone records the end of a rewrite started at start
+func (a *AOFService) rewriteDone(start time.Time, err error) {
+	a.lastRewriteOK.Store(err == nil)
+	a.lastRewriteTime.Store(int64(time.Since(start).Seconds()))
+	a.rewriting.Store(false)
+}
+
+// startRewrite switches the commands to a new incr file and takes the
+// snapshot of the dataset the base file is written from, the incr files
+// from the sequence number keep on have the commands that follow it
+func (a *AOFService) startRewrite() (keep int64, snapshot [][]redisdb.RDBEntry, err error) {
+	snapshot, err = a.dbs.SnapshotAt(func() error {
+		keep, err = a.switchIncr()
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return keep, snapshot, nil
+}
+
+// switchIncr opens the incr file of the next rewrite, the writes are held
+// so the ones before it are the ones of the snapshot
+func (a *AOFService) switchIncr() (keep int64, err error) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if err := os.MkdirAll(a.Dir(), 0755); err != nil {
+		return 0, fmt.Errorf("can't create the AOF directory %s: %w", a.Dir(), err)
+	}
+	m := &aofmanifest.Manifest{}
+	if a.manifest != nil {
+		m = a.manifest.Clone()
+	} else if read, err := a.readManifest(); err == nil {
+		m = read
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	keep = m.IncrSeq + 1
+	if a.enabled.Load() {
+		incr := m.NextIncr(*a.filename.Load())
+		file, err := os.OpenFile(filepath.Join(a.Dir(), incr.Name), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return 0, fmt.Errorf("can't open the append-only file %s: %w", incr.Name, err)
+		}
+		if err := a.persistManifest(m); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return 0, err
+		}
+		if a.file != nil {
+			a.file.Sync()
+			a.file.Close()
+		}
+		a.file, a.selected, a.unsynced = file, -1, false
+	}
+	a.manifest = m
+	a.rewrites.Add(1)
+	return keep, nil
+}
+
+// finishRewrite writes the base file of the snapshot and makes it the one
+// of the manifest, the files it replaces are deleted
+func (a *AOFService) finishRewrite(keep int64, snapshot [][]redisdb.RDBEntry) error {
+	file, err := os.CreateTemp(a.Dir(), "temp-rewriteaof-bg-*.aof")
+	if err != nil {
+		return fmt.Errorf("failed opening the temp AOF file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	rdb := a.rdbPreamble.Load()
+	if rdb {
+		err = a.dbs.RDB().encode(file, snapshot, true)
+	} else {
+		err = writeCommands(file, snapshot)
+	}
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing the AOF base file: %w", err)
+	}
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	m := a.manifest.Clone()
+	base := m.NextBase(*a.filename.Load(), rdb)
+	m.DropIncrs(keep)
+	path := filepath.Join(a.Dir(), base.Name)
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("error renaming the AOF base file: %w", err)
+	}
+	if err := a.persistManifest(m); err != nil {
+		os.Remove(path)
+		return err
+	}
+	a.manifest = m
+	a.deleteHistory()
+	a.size = a.filesSize(m)
+	a.baseSize = a.size
+	return nil
+}
+
+// writeCommands writes the snapshot as the commands that create it, the
+// collections take a command every AOF_REWRITE_ITEMS_PER_CMD elements
+func writeCommands(out io.Writer, snapshot [][]redisdb.RDBEntry) error {
+	w := bufio.NewWriter(out)
+	var buf []byte
+	for db, entries := range snapshot {
+		if len(entries) == 0 {
+			continue
+		}
+		buf = encodeCommand(buf[:0], []string{strings.ToUpper(SELECT), strconv.Itoa(db)})
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			commands, err := entryCommands(entry)
+			if err != nil {
+				return err
+			}
+			for _, args := range commands {
+				buf = encodeCommand(buf[:0], args)
+				if _, err := w.Write(buf); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// entryCommands are the commands that create a key, the values no command
+// creates can only be written to an rdb base file
+func entryCommands(entry redisdb.RDBEntry) ([][]string, error) {
+	key := entry.Key
+	var commands [][]string
+	// batches adds the commands for items, width items per element
+	batches := func(name string, items []string, width int) {
+		for start := 0; start < len(items); start += width * AOF_REWRITE_ITEMS_PER_CMD {
+			end := min(start+width*AOF_REWRITE_ITEMS_PER_CMD, len(items))
+			commands = append(commands, append([]string{strings.ToUpper(name), key}, items[start:end]...))
+		}
+	}
+	unsupported := fmt.Errorf("the value of the key '%s' can't be written as commands, set aof-use-rdb-preamble to yes", key)
+
+	if v, ok := entry.Value.(redisdb.RDBString); ok {
+		args := []string{strings.ToUpper(SET), key, string(v)}
+		if entry.Expires != 0 {
+			args = append(args, "PXAT", strconv.FormatInt(entry.Expires, 10))
+		}
+		return [][]string{args}, nil
+	}
+	if entry.Expires != 0 {
+		return nil, unsupported
+	}
+	switch v := entry.Value.(type) {
+	case redisdb.RDBHash:
+		items := make([]string, 0, 2*len(v))
+		for _, field := range v {
+			items = append(items, field.Field, field.Value)
+		}
+		batches(HSET, items, 2)
+	case redisdb.RDBSet:
+		batches(SADD, v, 1)
+	case redisdb.RDBSortedSet:
+		items := make([]string, 0, 2*len(v))
+		for _, member := range v {
+			items = append(items, formatScore(member.Score), member.Member)
+		}
+		batches(ZADD, items, 2)
+	case *redisdb.RDBStream:
+		// without XSETID and XGROUP the last id and the groups are lost
+		if len(v.Groups) > 0 || len(v.Entries) == 0 || v.Entries[len(v.Entries)-1].ID != v.LastID {
+			return nil, unsupported
+		}
+		for _, entry := range v.Entries {
+			id := strconv.FormatUint(entry.ID.Ms, 10) + "-" + strconv.FormatUint(entry.ID.Seq, 10)
+			commands = append(commands, append([]string{strings.ToUpper(XADD), key, id}, entry.Fields...))
+		}
+	default:
+		return nil, unsupported
+	}
+	return commands, nil
+}
+
+// close syncs and closes the file, the commands are no longer logged
+func (a *AOFService) close() {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if a.file == nil {
+		return
+	}
+	a.file.Sync()
+	a.file.Close()
+	a.file = nil
+}
+
+// Append writes a command run on the database db, the policy always
+// syncs it before the client gets the reply
+func (a *AOFService) Append(db int, args []string) {
+	if !a.enabled.Load() {
+		return
+	}
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if a.file == nil {
+		return
+	}
+
+	var buf []byte
+	if db != a.selected {
+		buf = encodeCommand(buf, []string{strings.ToUpper(SELECT), strconv.Itoa(db)})
+	}
+	buf = encodeCommand(buf, args)
+	n, err := a.file.Write(buf)
+	a.size += int64(n)
+	if err == nil && *a.fsync.Load() == APPENDFSYNC_ALWAYS {
+		err = a.file.Sync()
+	} else {
+		a.unsynced = true
+	}
+	if err != nil {
+		// the next command selects its database again after a short write
+		a.selected = -1
+		if a.writeOK.Swap(false) {
+			log.Println("Error writing to the AOF file:", err)
+		}
+		return
+	}
+	a.selected = db
+	if !a.writeOK.Swap(true) {
+		log.Println("AOF write error looks solved, Redis can write again.")
+	}
+}
+
+func encodeCommand(buf []byte, args []string) []byte {
+	elements := make([][]byte, len(args))
+	for i, arg := range args {
+		elements[i] = []byte(arg)
+	}
+	return append(buf, respencoding.EncodeArray(elements)...)
+}
+
+// Flush syncs the commands written, before the server exits
+func (a *AOFService) Flush() error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	if err := a.file.Sync(); err != nil {
+		return err
+	}
+	a.unsynced = false
+	return nil
+}
+
+// HandleFsync syncs the file every second with the everysec policy
+func (a *AOFService) HandleFsync() {
+	ticker := time.NewTicker(AOF_FSYNC_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.mx.Lock()
+		if a.file != nil && a.unsynced && *a.fsync.Load() == APPENDFSYNC_EVERYSEC {
+			if err := a.file.Sync(); err != nil {
+				log.Println("Error syncing the AOF file:", err)
+			}
+			a.unsynced = false
+		}
+		a.mx.Unlock()
+	}
+}
+
+// Load replays the files of the manifest in order, an rdb base file is
+// loaded as is and every command of the others is run with exec. The last
+// file can end in the middle of a command, with aof-load-truncated it is
+// truncated after the last complete one
+func (a *AOFService) Load(exec func(cmd *parser.CmdInfo) error) error {
+	m, err := a.readManifest()
+	if err != nil {
+		return err
+	}
+	files := m.Files()
+	total := int64(0)
+	for _, file := range files {
+		stat, err := os.Stat(filepath.Join(a.Dir(), file.Name))
+		if err != nil {
+			return fmt.Errorf("the AOF file %s doesn't exist", file.Name)
+		}
+		total += stat.Size()
+	}
+
+	rdb := a.dbs.RDB()
+	rdb.startLoading(total)
+	defer rdb.stopLoading()
+	for i, file := range files {
+		if err := a.loadFile(filepath.Join(a.Dir(), file.Name), i == len(files)-1, exec); err != nil {
+			return err
+		}
+		if file.Type == aofmanifest.FILE_TYPE_BASE {
+			log.Printf("DB loaded from base file %s", file.Name)
+		} else {
+			log.Printf("DB loaded from incr file %s", file.Name)
+		}
+	}
+
+	a.mx.Lock()
+	a.manifest = m
+	a.size = a.filesSize(m)
+	a.baseSize = a.size
+	a.mx.Unlock()
+	rdb.resetDirty()
+	return nil
+}
+
+// loadFile replays a file of the manifest, only the last one can be
+// truncated
+func (a *AOFService) loadFile(path string, last bool, exec func(cmd *parser.CmdInfo) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(a.dbs.RDB().loadingReader(file))
+	offset := int64(0)
+	if magic, _ := reader.Peek(len(redisdb.MAGIC_NUMBER)); string(magic) == redisdb.MAGIC_NUMBER {
+		log.Println("Reading RDB base file on AOF loading...")
+		rr, err := redisdb.NewRDBReader(reader)
+		if err == nil {
+			err = a.dbs.Load(rr)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading the RDB base file %s: %w", path, err)
+		}
+		offset = rr.Offset()
+	}
+
+	p := parser.NewParser(reader)
+	// offset of the MULTI waiting for its EXEC, -1 outside a transaction
+	multi := int64(-1)
+	for {
+		incoming, err := p.ParseIncomingData()
+		if err == io.EOF && multi < 0 {
+			return nil
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			if multi >= 0 {
+				// the commands queued by the MULTI never run
+				log.Printf("Revert incomplete MULTI/EXEC transaction in AOF file %s", path)
+				offset = multi
+			}
+			if !last {
+				return fmt.Errorf("Fatal error: the truncated file %s is not the last file", path)
+			}
+			if !a.loadTruncated.Load() {
+				return fmt.Errorf("Unexpected end of file reading the append only file %s. You can: "+
+					"1) Make a backup of your AOF file, then use ./redis-check-aof --fix <filename.manifest>. "+
+					"2) Alternatively you can set the 'aof-load-truncated' configuration option to yes and restart the server.", path)
+			}
+			log.Printf("!!! Warning: short read while loading the AOF file %s!!!", path)
+			if err := os.Truncate(path, offset); err != nil {
+				return fmt.Errorf("error truncating the AOF file %s: %w", path, err)
+			}
+			log.Printf("AOF %s loaded anyway because aof-load-truncated is enabled, truncated at offset %d", path, offset)
+			return nil
+		}
+		cmd, ok := incoming.(parser.CmdInfo)
+		if err != nil || !ok {
+			return fmt.Errorf("Bad file format reading the append only file %s at offset %d: "+
+				"make a backup of your AOF file, then use ./redis-check-aof --fix <filename.manifest>", path, offset)
+		}
+		switch cmd.CmdName {
+		case MULTI:
+			multi = offset
+		case EXEC:
+			multi = -1
+		}
+		if err := exec(&cmd); err != nil {
+			return fmt.Errorf("%s reading the append only file %s at offset %d", err, path, offset)
+		}
+		offset += int64(cmd.Size)
+	}
+}
+
+// HandleRewriteRules starts the scheduled rewrites and the automatic ones,
+// once the file grew auto-aof-rewrite-percentage since the last rewrite
+// and has at least auto-aof-rewrite-min-size
+func (a *AOFService) HandleRewriteRules() {
+	ticker := time.NewTicker(AOF_REWRITE_RULES_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if a.rewriting.Load() || a.dbs.RDB().InProgress() {
+			continue
+		}
+		if a.scheduled.Swap(false) || a.ruleMet(time.Now().Unix()) {
+			if err := a.BackgroundRewrite(); err != nil {
+				log.Println("Background AOF rewrite not started:", err)
+			}
+		}
+	}
+}
+
+func (a *AOFService) ruleMet(now int64) bool {
+	perc := a.rewritePerc.Load()
+	if !a.enabled.Load() || !a.started.Load() || perc == 0 {
+		return false
+	}
+	if !a.lastRewriteOK.Load() && now-a.rewriteStart.Load() <= int64(AOF_REWRITE_RETRY_DELAY.Seconds()) {
+		return false
+	}
+	a.mx.Lock()
+	size, base := a.size, a.baseSize
+	a.mx.Unlock()
+	if size < a.rewriteMinSize.Load() {
+		return false
+	}
+	base = max(base, 1)
+	growth := (size - base) * 100 / base
+	if growth < perc {
+		return false
+	}
+	log.Printf("Starting automatic rewriting of AOF on %d%% growth", growth)
+	return true
+}
+
+// Info is the aof part of INFO persistence, the sizes are only reported
+// while it is on
+func (a *AOFService) Info() []string {
+	rewriteStatus, writeStatus := "ok", "ok"
+	if !a.lastRewriteOK.Load() {
+		rewriteStatus = "err"
+	}
+	if !a.writeOK.Load() {
+		writeStatus = "err"
+	}
+	current := int64(-1)
+	if a.rewriting.Load() {
+		current = time.Now().Unix() - a.rewriteStart.Load()
+	}
+	lines := []string{
+		"aof_enabled:" + strconv.Itoa(boolToInt(a.enabled.Load())),
+		"aof_rewrite_in_progress:" + strconv.Itoa(boolToInt(a.rewriting.Load())),
+		"aof_rewrite_scheduled:" + strconv.Itoa(boolToInt(a.scheduled.Load())),
+		"aof_last_rewrite_time_sec:" + strconv.FormatInt(a.lastRewriteTime.Load(), 10),
+		"aof_current_rewrite_time_sec:" + strconv.FormatInt(current, 10),
+		"aof_last_bgrewrite_status:" + rewriteStatus,
+		"aof_rewrites:" + strconv.FormatInt(a.rewrites.Load(), 10),
+		"aof_last_write_status:" + writeStatus,
+	}
+	if !a.enabled.Load() {
+		return lines
+	}
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	return append(lines,
+		"aof_current_size:"+strconv.FormatInt(a.size, 10),
+		"aof_base_size:"+strconv.FormatInt(a.baseSize, 10),
+		"aof_pending_rewrite:"+strconv.Itoa(boolToInt(a.scheduled.Load())),
+		"aof_buffer_length:0",
+		"aof_delayed_fsync:0",
+	)
+}
+
+// bgrewriteaofCmd implements BGREWRITEAOF, while a background save is in
+// progress the rewrite is scheduled to start after it
+func (rs *RedisService) bgrewriteaofCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	aof := rs.dbs.AOF()
+	if aof.Rewriting() {
+		return respencoding.EncodeSimpleError("ERR Background append only file rewriting already in progress"), false
+	}
+	if rs.dbs.RDB().InProgress() {
+		aof.scheduled.Store(true)
+		return respencoding.EncodeSimpleString("Background append only file rewriting scheduled"), false
+	}
+	if err := aof.BackgroundRewrite(); err != nil {
+		log.Println("Background AOF rewrite not started:", err)
+		return respencoding.EncodeSimpleError("ERR Can't execute an AOF background rewriting. Please check the server logs for more information."), false
+	}
+	return respencoding.EncodeSimpleString("Background append only file rewriting started"), false
+}
+
+// LoadAppendOnlyFile replays the append only file as a client without a
+// connection, commands that do not exist make the file invalid
+func (rs *RedisService) LoadAppendOnlyFile(ctx context.Context) error {
+	client := rs.clients.Register(nil, true)
+	defer rs.clients.Unregister(client)
+	ctx = context.WithValue(ctx, info.CTX_CLIENT, client)
+
+	return rs.dbs.AOF().Load(func(cmd *parser.CmdInfo) error {
+		if _, err := lookupCommand(cmd.CmdName, cmd.Args); err != nil {
+			return err
+		}
+		rs.replayCmd(cmd, ctx)
+		return nil
+	})
+}
+
+// replayCmd runs a command of the append only file or of the replication
+// stream, the ones after a MULTI are queued until its EXEC
+func (rs *RedisService) replayCmd(cmd *parser.CmdInfo, ctx context.Context) {
+	client := ctx.Value(info.CTX_CLIENT).(*Client)
+	if client.InMulti() && cmd.CmdName != EXEC && cmd.CmdName != DISCARD && cmd.CmdName != MULTI {
+		client.QueueMulti(cmd)
+		return
+	}
+	rs.executeCmd(cmd, ctx)
+}
+
+// propagate logs a write command that did not fail to the append only
+// file and the replicas, the arguments that depend on when it runs are
+// made absolute
+func (rs *RedisService) propagate(cmdInfo *parser.CmdInfo, resp []byte, ctx context.Context) {
+	if len(resp) > 0 && (resp[0] == '-' || resp[0] == '!') {
+		return
+	}
+	args := append([]string{strings.ToUpper(cmdInfo.CmdName)}, cmdInfo.Args...)
+	switch cmdInfo.CmdName {
+	case SET:
+		args = absoluteExpiration(args)
+	case RESTORE:
+		args = absoluteRestoreTTL(args)
+	case MIGRATE:
+		// the keys migrated are written as DEL by the command
+		return
+	case XADD:
+		// the id generated replaces the one with *
+		if id, ok := strings.CutPrefix(string(resp), "$"); ok && len(args) > 2 {
+			if _, id, ok = strings.Cut(id, parser.CRNL); ok {
+				args[2] = strings.TrimSuffix(id, parser.CRNL)
+			}
+		}
+	}
+	rs.propagateWrite(args, ctx)
+}
+
+// propagateWrite propagates a write run on the database of the client,
+// the first one of an EXEC is preceded by its MULTI
+func (rs *RedisService) propagateWrite(args []string, ctx context.Context) {
+	if tx, ok := ctx.Value(info.CTX_TRANSACTION).(*transaction); ok && !tx.multi {
+		tx.multi = true
+		rs.dbs.Propagate(clientDB(ctx), []string{strings.ToUpper(MULTI)})
+	}
+	rs.dbs.Propagate(clientDB(ctx), args)
+}
+
+// absoluteRestoreTTL makes the ttl of a RESTORE the unix milliseconds of
+// ABSTTL, like redis propagates it
+func absoluteRestoreTTL(args []string) []string {
+	if len(args) < 4 || args[2] == "0" {
+		return args
+	}
+	for _, arg := range args[4:] {
+		if strings.EqualFold(arg, "ABSTTL") {
+			return args
+		}
+	}
+	ttl, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return args
+	}
+	args[2] = strconv.FormatInt(time.Now().UnixMilli()+ttl, 10)
+	return append(args, "ABSTTL")
+}
+
+// absoluteExpiration turns the relative expirations of a SET into the
+// unix milliseconds of PXAT, so replaying it later expires it on time
+func absoluteExpiration(args []string) []string {
+	for i := 3; i+1 < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option != "EX" && option != "PX" && option != "EXAT" {
+			continue
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return args
+		}
+		at := n * 1000
+		switch option {
+		case "EX":
+			at = time.Now().UnixMilli() + n*1000
+		case "PX":
+			at = time.Now().UnixMilli() + n
+		}
+		args = append([]string{}, args...)
+		args[i], args[i+1] = "PXAT", strconv.FormatInt(at, 10)
+		return args
+	}
+	return args
+}
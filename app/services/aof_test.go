@@ -0,0 +1,418 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AbsoluteExpiration(t *testing.T) {
+	now := time.Now().UnixMilli()
+
+	tests := []struct {
+		args   []string
+		expect int64
+	}{
+		{args: []string{"SET", "k", "v", "EX", "10"}, expect: now + 10000},
+		{args: []string{"SET", "k", "v", "NX", "px", "500"}, expect: now + 500},
+		{args: []string{"SET", "k", "v", "EXAT", "2000000000"}, expect: 2000000000000},
+		{args: []string{"SET", "k", "v", "PXAT", "2000000000001"}, expect: 2000000000001},
+		{args: []string{"SET", "k", "v"}},
+	}
+
+	for _, tc := range tests {
+		got := absoluteExpiration(tc.args)
+		if tc.expect == 0 {
+			assert.Equal(t, tc.args, got)
+			continue
+		}
+		at, err := strconv.ParseInt(got[len(got)-1], 10, 64)
+		assert.NoError(t, err, tc.args)
+		assert.Equal(t, "PXAT", got[len(got)-2], tc.args)
+		assert.InDelta(t, tc.expect, at, 1000, tc.args)
+	}
+}
+
+func newAOFTestService(t *testing.T, dir string) (*RedisService, context.Context) {
+	rs, ctx := newObjectTestService()
+	_, err := rs.dbs.RDB().SetDir(dir)
+	assert.NoError(t, err)
+	_, err = rs.dbs.AOF().SetEnabled("yes")
+	assert.NoError(t, err)
+	return rs, ctx
+}
+
+func execCommands(rs *RedisService, ctx context.Context, commands ...[]string) {
+	for _, command := range commands {
+		rs.executeCmd(&parser.CmdInfo{CmdName: strings.ToLower(command[0]), Args: command[1:]}, ctx)
+	}
+}
+
+// assertSameData compares the keys of both services with their values
+// and expirations
+func assertSameData(t *testing.T, rs *RedisService, loaded *RedisService) {
+	expect, got := rs.dbs.Snapshot(), loaded.dbs.Snapshot()
+	for _, snapshot := range [][][]redisdb.RDBEntry{expect, got} {
+		for _, entries := range snapshot {
+			slices.SortFunc(entries, func(a, b redisdb.RDBEntry) int { return strings.Compare(a.Key, b.Key) })
+		}
+	}
+	assert.Equal(t, expect, got)
+}
+
+func readManifest(t *testing.T, a *AOFService) string {
+	manifest, err := os.ReadFile(a.Path())
+	assert.NoError(t, err)
+	return string(manifest)
+}
+
+func waitRewrite(a *AOFService) {
+	for a.Rewriting() {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_AOFAppendAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	rs, ctx := newAOFTestService(t, dir)
+	aof := rs.dbs.AOF()
+
+	// the keys before the start are in the base file
+	execCommands(rs, ctx, []string{"SET", "before", "1"})
+	assert.NoError(t, aof.Start())
+	execCommands(rs, ctx,
+		[]string{"SET", "k", "v", "EX", "100"},
+		[]string{"INCR", "before"},
+		[]string{"HSET", "h", "f", "v"},
+		[]string{"XADD", "x", "*", "f", "v"},
+		// failed commands are not logged
+		[]string{"INCR", "h"},
+	)
+	aof.Flush()
+
+	assert.Equal(t, "file appendonly.aof.1.base.rdb seq 1 type b\n"+
+		"file appendonly.aof.1.incr.aof seq 1 type i\n", readManifest(t, aof))
+	base, err := os.ReadFile(filepath.Join(aof.Dir(), "appendonly.aof.1.base.rdb"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(base), redisdb.MAGIC_NUMBER))
+	incr, err := os.ReadFile(filepath.Join(aof.Dir(), "appendonly.aof.1.incr.aof"))
+	assert.NoError(t, err)
+	commands := string(incr)
+	assert.True(t, strings.HasPrefix(commands, "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*5\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n$4\r\nPXAT\r\n"))
+	assert.Equal(t, 1, strings.Count(commands, "SELECT"))
+	assert.NotContains(t, commands, "*\r\n")
+	assert.Equal(t, 1, strings.Count(commands, "INCR"))
+	assert.Contains(t, persistence(rs), "aof_current_size:"+strconv.Itoa(len(base)+len(incr)))
+	assert.Contains(t, persistence(rs), "aof_base_size:"+strconv.Itoa(len(base)))
+
+	loaded, loadedCtx := newAOFTestService(t, dir)
+	assert.NoError(t, loaded.LoadAppendOnlyFile(loadedCtx))
+	assertSameData(t, rs, loaded)
+	assert.Contains(t, persistence(loaded), "rdb_changes_since_last_save:0")
+}
+
+func Test_AOFPropagatesDeletions(t *testing.T) {
+	dir := t.TempDir()
+	rs, ctx := newAOFTestService(t, dir)
+	aof := rs.dbs.AOF()
+	assert.NoError(t, aof.Start())
+	execCommands(rs, ctx,
+		[]string{"SET", "lazy", "v", "PX", "1"},
+		[]string{"SET", "active", "v", "PX", "1"},
+		[]string{"SET", "evicted", "v"},
+		[]string{"SET", "kept", "v"},
+	)
+	time.Sleep(5 * time.Millisecond)
+
+	// expired when it is read, by the cycle or evicted
+	execCommands(rs, ctx, []string{"GET", "lazy"})
+	rs.dbs.Get(0).ActiveExpireCycle()
+	assert.True(t, rs.dbs.Get(0).Evict("evicted"))
+	assert.NoError(t, aof.Flush())
+
+	incr, err := os.ReadFile(filepath.Join(aof.Dir(), "appendonly.aof.1.incr.aof"))
+	assert.NoError(t, err)
+	for _, key := range []string{"lazy", "active", "evicted"} {
+		assert.Contains(t, string(incr), "*2\r\n$3\r\nDEL\r\n$"+strconv.Itoa(len(key))+"\r\n"+key+"\r\n", key)
+	}
+	assert.Equal(t, 3, strings.Count(string(incr), "DEL"))
+
+	loaded, loadedCtx := newAOFTestService(t, dir)
+	assert.NoError(t, loaded.LoadAppendOnlyFile(loadedCtx))
+	assertSameData(t, rs, loaded)
+}
+
+func Test_AOFRewrite(t *testing.T) {
+	dir := t.TempDir()
+	rs, ctx := newAOFTestService(t, dir)
+	aof := rs.dbs.AOF()
+	assert.NoError(t, aof.Start())
+	execCommands(rs, ctx, []string{"SET", "a", "1"}, []string{"SADD", "s", "x", "y"})
+
+	got, _ := rs.executeCmd(&parser.CmdInfo{CmdName: BGREWRITEAOF}, ctx)
+	assert.Equal(t, "+Background append only file rewriting started\r\n", string(got))
+	execCommands(rs, ctx, []string{"SET", "b", "2"})
+	waitRewrite(aof)
+	assert.Equal(t, "file appendonly.aof.2.base.rdb seq 2 type b\n"+
+		"file appendonly.aof.2.incr.aof seq 2 type i\n", readManifest(t, aof))
+	entries, _ := os.ReadDir(aof.Dir())
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"appendonly.aof.2.base.rdb", "appendonly.aof.2.incr.aof", "appendonly.aof.manifest"}, names)
+	incr, _ := os.ReadFile(filepath.Join(aof.Dir(), "appendonly.aof.2.incr.aof"))
+	assert.Equal(t, "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2\r\n", string(incr))
+	// the first one created the file on start
+	assert.Contains(t, persistence(rs), "aof_rewrites:2")
+	assert.Contains(t, persistence(rs), "aof_last_bgrewrite_status:ok")
+
+	// a base file of commands
+	aof.SetUseRDBPreamble("no")
+	execCommands(rs, ctx, []string{"ZADD", "z", "1.5", "m", "-inf", "n"}, []string{"SET", "e", "v", "PX", "100000"})
+	assert.NoError(t, aof.BackgroundRewrite())
+	waitRewrite(aof)
+	assert.Contains(t, readManifest(t, aof), "file appendonly.aof.3.base.aof seq 3 type b\n")
+	base, _ := os.ReadFile(filepath.Join(aof.Dir(), "appendonly.aof.3.base.aof"))
+	assert.Contains(t, string(base), "*4\r\n$4\r\nSADD\r\n$1\r\ns\r\n")
+	assert.Contains(t, string(base), "$4\r\nPXAT\r\n")
+
+	loaded, loadedCtx := newAOFTestService(t, dir)
+	assert.NoError(t, loaded.LoadAppendOnlyFile(loadedCtx))
+	assertSameData(t, rs, loaded)
+
+	// lists have no command to write them
+	rs.dbs.Get(0).AddObject("l", &KvsList{elements: []string{"x"}}, 0)
+	assert.NoError(t, aof.BackgroundRewrite())
+	waitRewrite(aof)
+	assert.Contains(t, persistence(rs), "aof_last_bgrewrite_status:err")
+	assert.Contains(t, readManifest(t, aof), "file appendonly.aof.3.base.aof seq 3 type b\n")
+}
+
+func Test_AOFRewriteConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	rs, ctx := newAOFTestService(t, dir)
+	aof := rs.dbs.AOF()
+	assert.NoError(t, aof.Start())
+
+	// a write running when a rewrite starts is either in its snapshot or
+	// in the incr file after it, never in both
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clientCtx := context.WithValue(ctx, info.CTX_CLIENT, rs.clients.Register(nil, true))
+			for range 500 {
+				execCommands(rs, clientCtx, []string{"INCR", "n"})
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for rewrites := 0; ; rewrites++ {
+		select {
+		case <-done:
+			waitRewrite(aof)
+			loaded, loadedCtx := newAOFTestService(t, dir)
+			assert.NoError(t, loaded.LoadAppendOnlyFile(loadedCtx))
+			value, _ := loaded.dbs.Get(0).Get("n")
+			assert.Equal(t, "4000", string(value), rewrites)
+			return
+		default:
+			assert.NoError(t, aof.BackgroundRewrite())
+			waitRewrite(aof)
+		}
+	}
+}
+
+func Test_AOFTransaction(t *testing.T) {
+	dir := t.TempDir()
+	rs, ctx := newAOFTestService(t, dir)
+	aof := rs.dbs.AOF()
+	assert.NoError(t, aof.Start())
+
+	// the writes of an EXEC are logged between MULTI and EXEC, one
+	// without writes logs nothing
+	for _, command := range [][]string{
+		{"MULTI"}, {"SET", "a", "1"}, {"GET", "a"}, {"INCR", "a"}, {"EXEC"},
+		{"MULTI"}, {"GET", "a"}, {"EXEC"},
+	} {
+		rs.replayCmd(&parser.CmdInfo{CmdName: strings.ToLower(command[0]), Args: command[1:]}, ctx)
+	}
+	incr, _ := os.ReadFile(filepath.Join(aof.Dir(), "appendonly.aof.1.incr.aof"))
+	assert.Equal(t, "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*1\r\n$5\r\nMULTI\r\n"+
+		"*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$4\r\nINCR\r\n$1\r\na\r\n*1\r\n$4\r\nEXEC\r\n", string(incr))
+
+	loaded, loadedCtx := newAOFTestService(t, dir)
+	assert.NoError(t, loaded.LoadAppendOnlyFile(loadedCtx))
+	assertSameData(t, rs, loaded)
+}
+
+func Test_AOFWriteOrder(t *testing.T) {
+	dir := t.TempDir()
+	rs, ctx := newAOFTestService(t, dir)
+	aof := rs.dbs.AOF()
+	assert.NoError(t, aof.Start())
+
+	// the writes of a key are logged in the order they ran
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clientCtx := context.WithValue(ctx, info.CTX_CLIENT, rs.clients.Register(nil, true))
+			for j := range 200 {
+				execCommands(rs, clientCtx, []string{"SET", "k", strconv.Itoa(i*1000 + j)})
+			}
+		}()
+	}
+	wg.Wait()
+
+	loaded, loadedCtx := newAOFTestService(t, dir)
+	assert.NoError(t, loaded.LoadAppendOnlyFile(loadedCtx))
+	assertSameData(t, rs, loaded)
+}
+
+func Test_AOFRewriteRules(t *testing.T) {
+	rs, _ := newAOFTestService(t, t.TempDir())
+	aof := rs.dbs.AOF()
+	assert.NoError(t, aof.Start())
+	aof.SetRewriteMinSize("100")
+
+	tests := []struct {
+		size, base int64
+		perc       string
+		expect     bool
+	}{
+		{size: 99, base: 10, perc: "100", expect: false},
+		{size: 200, base: 100, perc: "100", expect: true},
+		{size: 199, base: 100, perc: "100", expect: false},
+		{size: 150, base: 100, perc: "50", expect: true},
+		{size: 1000, base: 0, perc: "100", expect: true},
+		{size: 1000, base: 100, perc: "0", expect: false},
+	}
+
+	for _, tc := range tests {
+		aof.SetRewritePercentage(tc.perc)
+		aof.mx.Lock()
+		aof.size, aof.baseSize = tc.size, tc.base
+		aof.mx.Unlock()
+		assert.Equal(t, tc.expect, aof.ruleMet(time.Now().Unix()), tc)
+	}
+}
+
+func Test_AOFLoadTruncated(t *testing.T) {
+	dir := t.TempDir()
+	rs, ctx := newAOFTestService(t, dir)
+	aof := rs.dbs.AOF()
+	assert.NoError(t, aof.Start())
+	execCommands(rs, ctx, []string{"SET", "a", "1"}, []string{"SET", "b", "2"})
+	aof.close()
+
+	path := filepath.Join(aof.Dir(), "appendonly.aof.1.incr.aof")
+	file, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	truncated := file[:len(file)-3]
+	assert.NoError(t, os.WriteFile(path, truncated, 0644))
+
+	loaded, loadedCtx := newAOFTestService(t, dir)
+	loaded.dbs.AOF().SetLoadTruncated("no")
+	assert.ErrorContains(t, loaded.LoadAppendOnlyFile(loadedCtx), "Unexpected end of file")
+
+	// only the last file can be truncated
+	manifest := readManifest(t, aof)
+	assert.NoError(t, os.WriteFile(aof.Path(), []byte(manifest+"file appendonly.aof.2.incr.aof seq 2 type i\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(aof.Dir(), "appendonly.aof.2.incr.aof"), nil, 0644))
+	loaded, loadedCtx = newAOFTestService(t, dir)
+	assert.ErrorContains(t, loaded.LoadAppendOnlyFile(loadedCtx), "is not the last file")
+	assert.NoError(t, os.WriteFile(aof.Path(), []byte(manifest), 0644))
+
+	// the incomplete command is removed from the file
+	loaded, loadedCtx = newAOFTestService(t, dir)
+	assert.NoError(t, loaded.LoadAppendOnlyFile(loadedCtx))
+	got, _ := loaded.executeCmd(&parser.CmdInfo{CmdName: GET, Args: []string{"a"}}, loadedCtx)
+	assert.Equal(t, "$1\r\n1\r\n", string(got))
+	assert.Equal(t, 1, loaded.dbs.Get(0).Size())
+	file, _ = os.ReadFile(path)
+	assert.Equal(t, len(truncated)-len("*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n"), len(file))
+
+	// a command cut right before its last CRLF is incomplete too
+	assert.NoError(t, os.WriteFile(path, append(bytes.Clone(file), "*2\r\n$3\r\nDEL\r\n$1\r\na"...), 0644))
+	loaded, loadedCtx = newAOFTestService(t, dir)
+	assert.NoError(t, loaded.LoadAppendOnlyFile(loadedCtx))
+	assert.Equal(t, 1, loaded.dbs.Get(0).Size())
+	truncatedFile, _ := os.ReadFile(path)
+	assert.Equal(t, file, truncatedFile)
+
+	// a MULTI without its EXEC is reverted, the commands it queued never run
+	multi := "*1\r\n$5\r\nMULTI\r\n*2\r\n$3\r\nDEL\r\n$1\r\na\r\n"
+	assert.NoError(t, os.WriteFile(path, append(bytes.Clone(file), multi...), 0644))
+	loaded, loadedCtx = newAOFTestService(t, dir)
+	loaded.dbs.AOF().SetLoadTruncated("no")
+	assert.ErrorContains(t, loaded.LoadAppendOnlyFile(loadedCtx), "Unexpected end of file")
+	loaded, loadedCtx = newAOFTestService(t, dir)
+	assert.NoError(t, loaded.LoadAppendOnlyFile(loadedCtx))
+	assert.Equal(t, 1, loaded.dbs.Get(0).Size())
+	truncatedFile, _ = os.ReadFile(path)
+	assert.Equal(t, file, truncatedFile)
+	assert.NoError(t, os.WriteFile(path, append(bytes.Clone(file), multi+"*1\r\n$4\r\nEXEC\r\n"...), 0644))
+	loaded, loadedCtx = newAOFTestService(t, dir)
+	assert.NoError(t, loaded.LoadAppendOnlyFile(loadedCtx))
+	assert.Equal(t, 0, loaded.dbs.Get(0).Size())
+
+	assert.NoError(t, os.WriteFile(path, []byte("*1\r\n$4\r\nNOPE\r\n"), 0644))
+	assert.ErrorContains(t, loaded.LoadAppendOnlyFile(loadedCtx), "unknown command")
+	assert.NoError(t, os.WriteFile(path, []byte("*1\r\n+SET\r\n"), 0644))
+	assert.ErrorContains(t, loaded.LoadAppendOnlyFile(loadedCtx), "Bad file format")
+	assert.NoError(t, os.Remove(path))
+	assert.ErrorContains(t, loaded.LoadAppendOnlyFile(loadedCtx), "doesn't exist")
+}
+
+func Test_AOFLoadLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, DEFAULT_APPENDFILENAME), []byte("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"), 0644))
+
+	rs, ctx := newAOFTestService(t, dir)
+	aof := rs.dbs.AOF()
+	assert.True(t, aof.Exists())
+	assert.NoError(t, rs.LoadAppendOnlyFile(ctx))
+	assert.Equal(t, 1, rs.dbs.Get(0).Size())
+	assert.NoError(t, aof.Start())
+	assert.Equal(t, "file appendonly.aof seq 1 type b\n"+
+		"file appendonly.aof.1.incr.aof seq 1 type i\n", readManifest(t, aof))
+	_, err := os.Stat(filepath.Join(dir, DEFAULT_APPENDFILENAME))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_AOFConfig(t *testing.T) {
+	a := NewAOFService(nil)
+	_, err := a.SetFsync("sometimes")
+	assert.Error(t, err)
+	got, err := a.SetFsync("ALWAYS")
+	assert.NoError(t, err)
+	assert.Equal(t, APPENDFSYNC_ALWAYS, got)
+	_, err = a.SetEnabled("maybe")
+	assert.Error(t, err)
+	_, err = a.SetFilename("dir/appendonly.aof")
+	assert.Error(t, err)
+}
+
+func persistence(rs *RedisService) string {
+	return string(rs.persistenceInfo())
+}
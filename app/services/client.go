@@ -0,0 +1,379 @@
+package services
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
+)
+
+const DEFAULT_USER = "default"
+
+// why a blocked client was woken up by CLIENT UNBLOCK
+const (
+	UNBLOCK_TIMEOUT = iota
+	UNBLOCK_ERROR
+)
+
+// Client is the server side state of a connection
+type Client struct {
+	id       int64
+	conn     net.Conn
+	tracking clientTracking
+	reply    replyBuffer
+	limits   *outputBufferLimits
+
+	replica    atomic.Bool
+	subscribed atomic.Bool
+	noEvict    atomic.Bool
+	// set by commands that end the connection, like CLIENT KILL on itself
+	closeAfterReply atomic.Bool
+	createdAt       time.Time
+	// unix nanoseconds of the last command
+	lastInteraction atomic.Int64
+
+	// guards the fields below, other connections can read them
+	mx            sync.RWMutex
+	protocol      int
+	name          string
+	user          string
+	authenticated bool
+	db            int
+	lastCmd       string
+	// set while the client waits in a blocking command
+	unblock chan int
+	// commands queued by MULTI, nil outside a transaction
+	multi []*parser.CmdInfo
+	// a command was rejected while queuing, EXEC aborts the transaction
+	multiDirty bool
+}
+
+func (c *Client) Id() int64 {
+	return c.id
+}
+
+func (c *Client) Conn() net.Conn {
+	return c.conn
+}
+
+// Class returns the client class used for the output buffer limits
+func (c *Client) Class() int {
+	if c.replica.Load() {
+		return CLIENT_CLASS_REPLICA
+	}
+	if c.subscribed.Load() {
+		return CLIENT_CLASS_PUBSUB
+	}
+	return CLIENT_CLASS_NORMAL
+}
+
+// SetReplica makes the client a replica once it got its snapshot, the
+// replication stream held back meanwhile follows it
+func (c *Client) SetReplica() {
+	c.replica.Store(true)
+	c.releaseReplication()
+}
+
+func (c *Client) Protocol() int {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.protocol
+}
+
+func (c *Client) SetProtocol(protocol int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.protocol = protocol
+}
+
+func (c *Client) Name() string {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.name
+}
+
+func (c *Client) SetName(name string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.name = name
+}
+
+func (c *Client) IsAuthenticated() bool {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.authenticated
+}
+
+func (c *Client) Authenticate(user string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.user = user
+	c.authenticated = true
+}
+
+// ResetAuth logs the client back as the default user
+func (c *Client) ResetAuth(authenticated bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.user = DEFAULT_USER
+	c.authenticated = authenticated
+}
+
+// DB returns the database index selected with SELECT
+func (c *Client) DB() int {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.db
+}
+
+func (c *Client) SelectDB(db int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.db = db
+}
+
+func (c *Client) User() string {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.user
+}
+
+// Touch records the command the client is running
+func (c *Client) Touch(cmdName string) {
+	c.lastInteraction.Store(time.Now().UnixNano())
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.lastCmd = cmdName
+}
+
+func (c *Client) LastCmd() string {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.lastCmd
+}
+
+func (c *Client) Age() time.Duration {
+	return time.Since(c.createdAt)
+}
+
+func (c *Client) Idle() time.Duration {
+	return time.Since(time.Unix(0, c.lastInteraction.Load()))
+}
+
+func (c *Client) SetNoEvict(noEvict bool) {
+	c.noEvict.Store(noEvict)
+}
+
+func (c *Client) CloseAfterReply() {
+	c.closeAfterReply.Store(true)
+}
+
+func (c *Client) StartMulti() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.multi = make([]*parser.CmdInfo, 0, 10)
+}
+
+func (c *Client) InMulti() bool {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.multi != nil
+}
+
+// MultiLen returns the queued commands, -1 outside a transaction
+func (c *Client) MultiLen() int {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	if c.multi == nil {
+		return -1
+	}
+	return len(c.multi)
+}
+
+func (c *Client) QueueMulti(cmd *parser.CmdInfo) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.multi = append(c.multi, cmd)
+}
+
+func (c *Client) MarkMultiDirty() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.multi != nil {
+		c.multiDirty = true
+	}
+}
+
+// EndMulti leaves the transaction returning the queued commands and
+// whether it has to be aborted
+func (c *Client) EndMulti() ([]*parser.CmdInfo, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	queue, dirty := c.multi, c.multiDirty
+	c.multi, c.multiDirty = nil, false
+	return queue, dirty
+}
+
+// Block marks the client as waiting in a blocking command, the returned
+// channel gets the reason when CLIENT UNBLOCK wakes it up
+func (c *Client) Block() <-chan int {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.unblock = make(chan int, 1)
+	return c.unblock
+}
+
+func (c *Client) Unblock() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.unblock = nil
+}
+
+func (c *Client) IsBlocked() bool {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.unblock != nil
+}
+
+// Wake unblocks the client, returning false when it was not blocked
+func (c *Client) Wake(reason int) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.unblock == nil {
+		return false
+	}
+	c.unblock <- reason
+	c.unblock = nil
+	return true
+}
+
+type ClientRegistry struct {
+	mx      sync.RWMutex
+	nextId  atomic.Int64
+	clients map[int64]*Client
+	limits  outputBufferLimits
+
+	// CLIENT PAUSE state
+	pauseMx  sync.Mutex
+	pauseEnd time.Time
+	pauseAll bool
+	unpaused chan struct{}
+}
+
+func NewClientRegistry() *ClientRegistry {
+	r := &ClientRegistry{clients: make(map[int64]*Client)}
+	r.limits.set(DEFAULT_OUTPUT_BUFFER_LIMIT)
+	return r
+}
+
+// SetOutputBufferLimits is the client-output-buffer-limit config apply
+// function
+func (r *ClientRegistry) SetOutputBufferLimits(value string) (string, error) {
+	return r.limits.set(value)
+}
+
+// Register creates the client for a new connection, it starts using RESP2
+// and logged in as the default user when it does not require a password
+func (r *ClientRegistry) Register(conn net.Conn, authenticated bool) *Client {
+	c := &Client{
+		id:            r.nextId.Add(1),
+		conn:          conn,
+		protocol:      respencoding.RESP2,
+		user:          DEFAULT_USER,
+		authenticated: authenticated,
+		limits:        &r.limits,
+		createdAt:     time.Now(),
+	}
+	c.lastInteraction.Store(c.createdAt.UnixNano())
+	if conn != nil {
+		c.reply.cond = sync.NewCond(&c.reply.mx)
+		c.reply.done = make(chan struct{})
+		go c.writeLoop()
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.clients[c.id] = c
+	return c
+}
+
+func (r *ClientRegistry) Unregister(c *Client) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	delete(r.clients, c.id)
+}
+
+func (r *ClientRegistry) Get(id int64) (*Client, bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	c, ok := r.clients[id]
+	return c, ok
+}
+
+// All returns the connected clients sorted by id
+func (r *ClientRegistry) All() []*Client {
+	r.mx.RLock()
+	clients := make([]*Client, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	r.mx.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// Pause stops serving commands until the timeout, all of them or only the
+// ones that write, a running pause is only extended
+func (r *ClientRegistry) Pause(timeout time.Duration, all bool) {
+	r.pauseMx.Lock()
+	defer r.pauseMx.Unlock()
+
+	end := time.Now().Add(timeout)
+	if r.unpaused == nil || time.Now().After(r.pauseEnd) {
+		r.unpaused = make(chan struct{})
+		r.pauseEnd, r.pauseAll = end, all
+		return
+	}
+	if end.After(r.pauseEnd) {
+		r.pauseEnd = end
+	}
+	r.pauseAll = r.pauseAll || all
+}
+
+func (r *ClientRegistry) Unpause() {
+	r.pauseMx.Lock()
+	defer r.pauseMx.Unlock()
+	if r.unpaused != nil {
+		close(r.unpaused)
+		r.unpaused = nil
+	}
+}
+
+// IsPaused tells whether CLIENT PAUSE holds back a command
+func (r *ClientRegistry) IsPaused(write bool) bool {
+	r.pauseMx.Lock()
+	defer r.pauseMx.Unlock()
+	return r.unpaused != nil && time.Now().Before(r.pauseEnd) && (r.pauseAll || write)
+}
+
+// WaitIfPaused blocks while the clients are paused for the command
+func (r *ClientRegistry) WaitIfPaused(write bool) {
+	for {
+		r.pauseMx.Lock()
+		unpaused, remaining := r.unpaused, time.Until(r.pauseEnd)
+		if unpaused == nil || remaining <= 0 || (!r.pauseAll && !write) {
+			r.pauseMx.Unlock()
+			return
+		}
+		r.pauseMx.Unlock()
+
+		select {
+		case <-unpaused:
+		case <-time.After(remaining):
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ClientKill(t *testing.T) {
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{args: []string{"USER", "alice"}, expected: ":3\r\n"},
+		{args: []string{"ID", "2"}, expected: ":1\r\n"},
+		{args: []string{"ID", "1"}, expected: ":0\r\n"},
+		{args: []string{"ID", "1", "SKIPME", "no"}, expected: ":1\r\n"},
+		{args: []string{"TYPE", "normal"}, expected: ":3\r\n"},
+		{args: []string{"TYPE", "pubsub"}, expected: ":0\r\n"},
+		{args: []string{"TYPE", "foo"}, expected: "-ERR Unknown client type 'foo'\r\n"},
+		{args: []string{"ID", "0"}, expected: "-ERR client-id should be greater than 0\r\n"},
+		{args: []string{"USER", "alice", "ID"}, expected: "-ERR syntax error\r\n"},
+		{args: []string{"127.0.0.1:1"}, expected: "-ERR No such client\r\n"},
+	}
+
+	for _, tc := range tests {
+		clients := NewClientRegistry()
+		pubsub := NewPubSubService()
+		rs := NewRedisService(nil, pubsub, clients, NewTrackingService(clients, pubsub), nil)
+		caller := clients.Register(nil, true)
+		for range 3 {
+			clients.Register(nil, true).Authenticate("alice")
+		}
+
+		got := rs.client(append([]string{"KILL"}, tc.args...), caller)
+
+		assert.Equal(t, tc.expected, string(got), strings.Join(tc.args, " "))
+	}
+}
+
+func Test_ClientInfo(t *testing.T) {
+
+	clients := NewClientRegistry()
+	pubsub := NewPubSubService()
+	rs := NewRedisService(nil, pubsub, clients, NewTrackingService(clients, pubsub), nil)
+	client := clients.Register(nil, true)
+	client.SetName("worker")
+	client.Touch("client|info")
+	client.StartMulti()
+	pubsub.PSubscribe(client, "news.*")
+
+	assert.Equal(t,
+		"id=1 addr= laddr= name=worker age=0 idle=0 flags=Px db=0 sub=0 psub=1 ssub=0 multi=0 watch=0 omem=0 cmd=client|info user=default redir=-1 resp=2",
+		rs.clientInfo(client))
+}
+
+func Test_ClientReplyMode(t *testing.T) {
+
+	tests := []struct {
+		mode     int
+		expected string
+	}{
+		{mode: CLIENT_REPLY_ON, expected: "+1\r\n+2\r\n+3\r\n"},
+		{mode: CLIENT_REPLY_SKIP, expected: "+2\r\n+3\r\n"},
+		{mode: CLIENT_REPLY_OFF, expected: ""},
+	}
+
+	for _, tc := range tests {
+		server, conn := net.Pipe()
+		client := NewClientRegistry().Register(server, true)
+
+		// the mode is set by the running command, it never gets a reply
+		client.SetReplyMode(tc.mode)
+		client.EndCommand()
+		for _, reply := range []string{"+1\r\n", "+2\r\n", "+3\r\n"} {
+			client.Write([]byte(reply))
+			client.EndCommand()
+		}
+
+		go client.Close()
+		got, _ := io.ReadAll(conn)
+
+		assert.Equal(t, tc.expected, string(got))
+	}
+}
+
+func Test_ClientPause(t *testing.T) {
+
+	tests := []struct {
+		all    bool
+		write  bool
+		paused bool
+	}{
+		{all: true, write: false, paused: true},
+		{all: true, write: true, paused: true},
+		{all: false, write: true, paused: true},
+		{all: false, write: false, paused: false},
+	}
+
+	for _, tc := range tests {
+		clients := NewClientRegistry()
+		clients.Pause(50*time.Millisecond, tc.all)
+
+		start := time.Now()
+		clients.WaitIfPaused(tc.write)
+
+		assert.Equal(t, tc.paused, time.Since(start) >= 50*time.Millisecond)
+		// a pause for writes is still running after a read went through
+		assert.Equal(t, !tc.paused, clients.IsPaused(true))
+	}
+}
+
+func Test_ResetAndQuit(t *testing.T) {
+	rs, ctx := newObjectTestService()
+	server, conn := net.Pipe()
+	go rs.HandleConn(server, ctx)
+
+	// RESET and QUIT are not queued by MULTI and QUIT ends the connection
+	go conn.Write([]byte("SUBSCRIBE ch\r\nGET k\r\nRESET\r\nMULTI\r\nRESET\r\nEXEC\r\nCLIENT SETNAME x\r\nRESET\r\nCLIENT GETNAME\r\nQUIT\r\nPING\r\n"))
+	got, _ := io.ReadAll(conn)
+
+	assert.Equal(t, "*3\r\n$9\r\nsubscribe\r\n$2\r\nch\r\n:1\r\n"+
+		"-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n"+
+		"+RESET\r\n+OK\r\n+RESET\r\n-ERR EXEC without MULTI\r\n+OK\r\n+RESET\r\n$-1\r\n+OK\r\n", string(got))
+}
@@ -0,0 +1,646 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
+)
+
+const (
+	// command flags, same meaning as in the redis command table
+	CMD_WRITE = 1 << iota
+	CMD_READONLY
+	CMD_DENYOOM
+	CMD_ADMIN
+	CMD_PUBSUB
+	CMD_NOSCRIPT
+	CMD_BLOCKING
+	CMD_LOADING
+	CMD_STALE
+	CMD_SKIP_MONITOR
+	CMD_SKIP_SLOWLOG
+	CMD_FAST
+	CMD_NO_AUTH
+	CMD_MAY_REPLICATE
+	CMD_NO_MULTI
+	CMD_ALLOW_BUSY
+	CMD_MOVABLE_KEYS
+)
+
+// names of the flags as shown by COMMAND INFO, in bit order
+var commandFlagNames = []string{
+	"write", "readonly", "denyoom", "admin", "pubsub", "noscript", "blocking",
+	"loading", "stale", "skip_monitor", "skip_slowlog", "fast", "no_auth",
+	"may_replicate", "no_multi", "allow_busy", "movablekeys",
+}
+
+type CommandHandler func(rs *RedisService, cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool)
+
+// Command describes a command like the redis command table does. Arity
+// counts the command name, a negative arity means at least -Arity
+// arguments. FirstKey, LastKey and Step are argument positions, a negative
+// LastKey counts from the end. Commands with keys after a keyword, like
+// XREAD STREAMS, use KeyKeyword and take 1/KeyLimit of the arguments left.
+// The other movable keys are found by getKeys.
+type Command struct {
+	Name       string
+	Arity      int
+	Flags      int
+	FirstKey   int
+	LastKey    int
+	Step       int
+	KeyKeyword string
+	KeyLimit   int
+	// explicit ACL categories, the ones implied by the flags are added
+	Categories []string
+	Group      string
+	Since      string
+	Summary    string
+
+	Subcommands map[string]*Command
+	parent      *Command
+	handler     CommandHandler
+	getKeys     func(args []string) []string
+}
+
+var commandTable = map[string]*Command{}
+
+func init() {
+	connection := []string{"@connection"}
+	transaction := []string{"@transaction"}
+
+	registerCommand(&Command{Name: PING, Arity: -1, Flags: CMD_FAST, Categories: connection, Group: "connection", Since: "1.0.0",
+		Summary: "Returns the server's liveliness response.", handler: (*RedisService).pingCmd})
+	registerCommand(&Command{Name: ECHO, Arity: 2, Flags: CMD_FAST, Categories: connection, Group: "connection", Since: "1.0.0",
+		Summary: "Returns the given string.", handler: (*RedisService).echoCmd})
+	registerCommand(&Command{Name: SET, Arity: -3, Flags: CMD_WRITE | CMD_DENYOOM, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@string"}, Group: "string", Since: "1.0.0",
+		Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", handler: (*RedisService).setCmd})
+	registerCommand(&Command{Name: GET, Arity: 2, Flags: CMD_READONLY | CMD_FAST, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@string"}, Group: "string", Since: "1.0.0",
+		Summary: "Returns the string value of a key.", handler: (*RedisService).getCmd})
+	registerCommand(&Command{Name: INCR, Arity: 2, Flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@string"}, Group: "string", Since: "1.0.0",
+		Summary: "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", handler: (*RedisService).incrCmd})
+	registerCommand(&Command{Name: DEL, Arity: -2, Flags: CMD_WRITE, FirstKey: 1, LastKey: -1, Step: 1,
+		Categories: []string{"@keyspace"}, Group: "generic", Since: "1.0.0",
+		Summary: "Deletes one or more keys.", handler: (*RedisService).delCmd})
+	registerCommand(&Command{Name: KEYS, Arity: 2, Flags: CMD_READONLY, Categories: []string{"@keyspace", "@dangerous"}, Group: "generic", Since: "1.0.0",
+		Summary: "Returns all key names that match a pattern.", handler: (*RedisService).keysCmd})
+	registerCommand(&Command{Name: TYPE, Arity: 2, Flags: CMD_READONLY | CMD_FAST, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace"}, Group: "generic", Since: "1.0.0",
+		Summary: "Determines the type of value stored at a key.", handler: (*RedisService).typeCmd})
+	registerCommand(&Command{Name: MOVE, Arity: 3, Flags: CMD_WRITE | CMD_FAST, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace"}, Group: "generic", Since: "1.0.0",
+		Summary: "Moves a key to another database.", handler: (*RedisService).moveCmd})
+	registerCommand(&Command{Name: SWAPDB, Arity: 3, Flags: CMD_WRITE | CMD_FAST, Categories: []string{"@keyspace", "@dangerous"}, Group: "server", Since: "4.0.0",
+		Summary: "Swaps two Redis databases.", handler: (*RedisService).swapdbCmd})
+	registerCommand(&Command{Name: DUMP, Arity: 2, Flags: CMD_READONLY, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace"}, Group: "generic", Since: "2.6.0",
+		Summary: "Returns a serialized representation of the value stored at a key.", handler: (*RedisService).dumpCmd})
+	registerCommand(&Command{Name: RESTORE, Arity: -4, Flags: CMD_WRITE | CMD_DENYOOM, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@keyspace", "@dangerous"}, Group: "generic", Since: "2.6.0",
+		Summary: "Creates a key from the serialized representation of a value.", handler: (*RedisService).restoreCmd})
+	registerCommand(&Command{Name: MIGRATE, Arity: -6, Flags: CMD_WRITE | CMD_MOVABLE_KEYS, FirstKey: 3, LastKey: 3, Step: 1,
+		Categories: []string{"@keyspace", "@dangerous"}, Group: "generic", Since: "2.6.0",
+		Summary: "Atomically transfers a key from one Redis instance to another.", handler: (*RedisService).migrateCmd, getKeys: migrateKeys})
+	registerCommand(&Command{Name: FLUSHDB, Arity: -1, Flags: CMD_WRITE, Categories: []string{"@keyspace", "@dangerous"}, Group: "server", Since: "1.0.0",
+		Summary: "Remove all keys from the current database.", handler: (*RedisService).flushCmd})
+	registerCommand(&Command{Name: FLUSHALL, Arity: -1, Flags: CMD_WRITE, Categories: []string{"@keyspace", "@dangerous"}, Group: "server", Since: "1.0.0",
+		Summary: "Removes all keys from all databases.", handler: (*RedisService).flushCmd})
+	registerCommand(&Command{Name: SAVE, Arity: 1, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_NO_MULTI, Group: "server", Since: "1.0.0",
+		Summary: "Synchronously saves the database(s) to disk.", handler: (*RedisService).saveCmd})
+	registerCommand(&Command{Name: BGSAVE, Arity: -1, Flags: CMD_ADMIN | CMD_NOSCRIPT, Group: "server", Since: "1.0.0",
+		Summary: "Asynchronously saves the database(s) to disk.", handler: (*RedisService).bgsaveCmd})
+	registerCommand(&Command{Name: BGREWRITEAOF, Arity: 1, Flags: CMD_ADMIN | CMD_NOSCRIPT, Group: "server", Since: "1.0.0",
+		Summary: "Asynchronously rewrites the append-only file to disk.", handler: (*RedisService).bgrewriteaofCmd})
+	registerCommand(&Command{Name: LASTSAVE, Arity: 1, Flags: CMD_LOADING | CMD_STALE | CMD_FAST, Categories: []string{"@dangerous"}, Group: "server", Since: "1.0.0",
+		Summary: "Returns the Unix timestamp of the last successful save to disk.", handler: (*RedisService).lastsaveCmd})
+	registerCommand(&Command{Name: SHUTDOWN, Arity: -1, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_NO_MULTI, Group: "server", Since: "1.0.0",
+		Summary: "Synchronously saves the database(s) to disk and shuts down the Redis server.", handler: (*RedisService).shutdownCmd})
+	registerCommand(&Command{Name: DBSIZE, Arity: 1, Flags: CMD_READONLY | CMD_FAST, Categories: []string{"@keyspace"}, Group: "server", Since: "1.0.0",
+		Summary: "Returns the number of keys in the database.", handler: (*RedisService).dbsizeCmd})
+	registerCommand(&Command{Name: SCAN, Arity: -2, Flags: CMD_READONLY, Categories: []string{"@keyspace"}, Group: "generic", Since: "2.8.0",
+		Summary: "Iterates over the key names in the database.", handler: (*RedisService).scanCmd})
+	registerCommand(&Command{Name: HSET, Arity: -4, Flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@hash"}, Group: "hash", Since: "2.0.0",
+		Summary: "Creates or modifies the value of a field in a hash.", handler: (*RedisService).hsetCmd})
+	registerCommand(&Command{Name: HSCAN, Arity: -3, Flags: CMD_READONLY, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@hash"}, Group: "hash", Since: "2.8.0",
+		Summary: "Iterates over fields and values of a hash.", handler: (*RedisService).scanElementsCmd})
+	registerCommand(&Command{Name: SADD, Arity: -3, Flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@set"}, Group: "set", Since: "1.0.0",
+		Summary: "Adds one or more members to a set. Creates the key if it doesn't exist.", handler: (*RedisService).saddCmd})
+	registerCommand(&Command{Name: SSCAN, Arity: -3, Flags: CMD_READONLY, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@set"}, Group: "set", Since: "2.8.0",
+		Summary: "Iterates over members of a set.", handler: (*RedisService).scanElementsCmd})
+	registerCommand(&Command{Name: ZADD, Arity: -4, Flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@sortedset"}, Group: "sorted_set", Since: "1.2.0",
+		Summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.", handler: (*RedisService).zaddCmd})
+	registerCommand(&Command{Name: ZSCAN, Arity: -3, Flags: CMD_READONLY, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@sortedset"}, Group: "sorted_set", Since: "2.8.0",
+		Summary: "Iterates over members and scores of a sorted set.", handler: (*RedisService).scanElementsCmd})
+	registerCommand(&Command{Name: XADD, Arity: -5, Flags: CMD_WRITE | CMD_DENYOOM | CMD_FAST, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@stream"}, Group: "stream", Since: "5.0.0",
+		Summary: "Appends a new message to a stream. Creates the key if it doesn't exist.", handler: (*RedisService).xaddCmd})
+	registerCommand(&Command{Name: XRANGE, Arity: -4, Flags: CMD_READONLY, FirstKey: 1, LastKey: 1, Step: 1,
+		Categories: []string{"@stream"}, Group: "stream", Since: "5.0.0",
+		Summary: "Returns the messages from a stream within a range of IDs.", handler: (*RedisService).xrangeCmd})
+	registerCommand(&Command{Name: XREAD, Arity: -4, Flags: CMD_READONLY | CMD_BLOCKING | CMD_MOVABLE_KEYS, KeyKeyword: "STREAMS", KeyLimit: 2,
+		Categories: []string{"@stream"}, Group: "stream", Since: "5.0.0",
+		Summary: "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise.",
+		handler: (*RedisService).xreadCmd})
+
+	registerCommand(&Command{Name: INFO, Arity: -1, Flags: CMD_LOADING | CMD_STALE, Categories: []string{"@dangerous"}, Group: "server", Since: "1.0.0",
+		Summary: "Returns information and statistics about the server.", handler: (*RedisService).infoCmd})
+	registerCommand(&Command{Name: CONFIG, Arity: -2, Group: "server", Since: "2.0.0",
+		Summary: "A container for server configuration commands.",
+		Subcommands: subcommands(
+			&Command{Name: "get", Arity: -3, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Since: "2.0.0",
+				Summary: "Returns the effective values of configuration parameters.", handler: (*RedisService).configGetCmd},
+			&Command{Name: "set", Arity: -4, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Since: "2.0.0",
+				Summary: "Sets configuration parameters in-flight.", handler: (*RedisService).configSetCmd},
+		)})
+	registerCommand(&Command{Name: OBJECT, Arity: -2, Group: "generic", Since: "2.2.3",
+		Summary: "A container for object introspection commands.",
+		Subcommands: subcommands(
+			&Command{Name: "encoding", Arity: 3, Flags: CMD_READONLY, FirstKey: 2, LastKey: 2, Step: 1, Categories: []string{"@keyspace"}, Since: "2.2.3",
+				Summary: "Returns the internal encoding of a Redis object.", handler: (*RedisService).objectEncodingCmd},
+			&Command{Name: "freq", Arity: 3, Flags: CMD_READONLY, FirstKey: 2, LastKey: 2, Step: 1, Categories: []string{"@keyspace"}, Since: "4.0.0",
+				Summary: "Returns the logarithmic access frequency counter of a Redis object.", handler: (*RedisService).objectFreqCmd},
+			&Command{Name: "idletime", Arity: 3, Flags: CMD_READONLY, FirstKey: 2, LastKey: 2, Step: 1, Categories: []string{"@keyspace"}, Since: "2.2.3",
+				Summary: "Returns the time since the last access to a Redis object.", handler: (*RedisService).objectIdletimeCmd},
+			&Command{Name: "refcount", Arity: 3, Flags: CMD_READONLY, FirstKey: 2, LastKey: 2, Step: 1, Categories: []string{"@keyspace"}, Since: "2.2.3",
+				Summary: "Returns the reference count of a value of a key.", handler: (*RedisService).objectRefcountCmd},
+			&Command{Name: "help", Arity: 2, Flags: CMD_LOADING | CMD_STALE, Categories: []string{"@keyspace"}, Since: "6.2.0",
+				Summary: "Returns helpful text about the different subcommands.", handler: (*RedisService).objectHelpCmd},
+		)})
+	registerCommand(&Command{Name: MEMORY, Arity: -2, Group: "server", Since: "4.0.0",
+		Summary: "A container for memory diagnostics commands.",
+		Subcommands: subcommands(
+			&Command{Name: "usage", Arity: -3, Flags: CMD_READONLY, FirstKey: 2, LastKey: 2, Step: 1, Since: "4.0.0",
+				Summary: "Estimates the memory usage of a key.", handler: (*RedisService).memoryUsageCmd},
+			&Command{Name: "stats", Arity: 2, Since: "4.0.0",
+				Summary: "Returns details about memory usage.", handler: (*RedisService).memoryStatsCmd},
+			&Command{Name: "doctor", Arity: 2, Since: "4.0.0",
+				Summary: "Outputs a memory problems report.", handler: (*RedisService).memoryDoctorCmd},
+			&Command{Name: "purge", Arity: 2, Since: "4.0.0",
+				Summary: "Asks the allocator to release memory.", handler: (*RedisService).memoryPurgeCmd},
+			&Command{Name: "malloc-stats", Arity: 2, Since: "4.0.0",
+				Summary: "Returns the allocator statistics.", handler: (*RedisService).memoryMallocStatsCmd},
+			&Command{Name: "help", Arity: 2, Flags: CMD_LOADING | CMD_STALE, Since: "4.0.0",
+				Summary: "Returns helpful text about the different subcommands.", handler: (*RedisService).memoryHelpCmd},
+		)})
+	registerCommand(&Command{Name: COMMAND, Arity: -1, Flags: CMD_LOADING | CMD_STALE, Categories: connection, Group: "server", Since: "2.8.13",
+		Summary: "Returns detailed information about all commands.", handler: (*RedisService).commandCmd,
+		Subcommands: subcommands(
+			&Command{Name: "count", Arity: 2, Flags: CMD_LOADING | CMD_STALE, Categories: connection, Since: "2.8.13",
+				Summary: "Returns a count of commands.", handler: (*RedisService).commandCountCmd},
+			&Command{Name: "info", Arity: -2, Flags: CMD_LOADING | CMD_STALE, Categories: connection, Since: "2.8.13",
+				Summary: "Returns information about one, multiple or all commands.", handler: (*RedisService).commandInfoCmd},
+			&Command{Name: "docs", Arity: -2, Flags: CMD_LOADING | CMD_STALE, Categories: connection, Since: "7.0.0",
+				Summary: "Returns documentary information about one, multiple or all commands.", handler: (*RedisService).commandDocsCmd},
+			&Command{Name: "getkeys", Arity: -3, Flags: CMD_LOADING | CMD_STALE, Categories: connection, Since: "2.8.13",
+				Summary: "Extracts the key names from an arbitrary command.", handler: (*RedisService).commandGetKeysCmd},
+			&Command{Name: "list", Arity: 2, Flags: CMD_LOADING | CMD_STALE, Categories: connection, Since: "7.0.0",
+				Summary: "Returns a list of command names.", handler: (*RedisService).commandListCmd},
+		)})
+
+	registerCommand(&Command{Name: REPLCONF, Arity: -1, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_ALLOW_BUSY,
+		Group: "server", Since: "3.0.0", Summary: "An internal command for configuring the replication stream.", handler: (*RedisService).replconfCmd})
+	registerCommand(&Command{Name: PSYNC, Arity: -3, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_NO_MULTI,
+		Group: "server", Since: "2.8.0", Summary: "An internal command used in replication.", handler: (*RedisService).psyncCmd})
+	registerCommand(&Command{Name: WAIT, Arity: 3, Flags: CMD_NOSCRIPT | CMD_NO_MULTI, Categories: connection, Group: "generic", Since: "3.0.0",
+		Summary: "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed.",
+		handler: (*RedisService).waitCmd})
+
+	registerCommand(&Command{Name: MULTI, Arity: 1, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST | CMD_ALLOW_BUSY,
+		Categories: transaction, Group: "transactions", Since: "1.2.0", Summary: "Starts a transaction.", handler: (*RedisService).multiCmd})
+	registerCommand(&Command{Name: EXEC, Arity: 1, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_SKIP_SLOWLOG,
+		Categories: transaction, Group: "transactions", Since: "1.2.0", Summary: "Executes all commands in a transaction.", handler: (*RedisService).execCmd})
+	registerCommand(&Command{Name: DISCARD, Arity: 1, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST | CMD_ALLOW_BUSY,
+		Categories: transaction, Group: "transactions", Since: "2.0.0", Summary: "Discards a transaction.", handler: (*RedisService).discardCmd})
+
+	registerCommand(&Command{Name: CLIENT, Arity: -2, Group: "connection", Since: "2.4.0",
+		Summary: "A container for client connection commands.", handler: (*RedisService).clientCmd,
+		Subcommands: subcommands(
+			&Command{Name: "id", Arity: 2, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "5.0.0",
+				Summary: "Returns the unique client ID of the connection."},
+			&Command{Name: "tracking", Arity: -3, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "6.0.0",
+				Summary: "Controls server-assisted client-side caching for the connection."},
+			&Command{Name: "caching", Arity: 3, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "6.0.0",
+				Summary: "Instructs the server whether to track the keys in the next request."},
+			&Command{Name: "getredir", Arity: 2, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "6.0.0",
+				Summary: "Returns the client ID to which the connection's tracking notifications are redirected."},
+			&Command{Name: "trackinginfo", Arity: 2, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "6.2.0",
+				Summary: "Returns information about server-assisted client-side caching for the connection."},
+			&Command{Name: "info", Arity: 2, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "6.2.0",
+				Summary: "Returns information about the connection."},
+			&Command{Name: "list", Arity: -2, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "2.4.0",
+				Summary: "Lists open connections."},
+			&Command{Name: "setname", Arity: 3, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "2.6.9",
+				Summary: "Sets the connection name."},
+			&Command{Name: "getname", Arity: 2, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "2.6.9",
+				Summary: "Returns the name of the connection."},
+			&Command{Name: "kill", Arity: -3, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "2.4.0",
+				Summary: "Terminates open connections."},
+			&Command{Name: "pause", Arity: -3, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "3.0.0",
+				Summary: "Suspends commands processing."},
+			&Command{Name: "unpause", Arity: 2, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "6.2.0",
+				Summary: "Resumes processing commands from paused clients."},
+			&Command{Name: "reply", Arity: 3, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "3.2.0",
+				Summary: "Instructs the server whether to reply to commands."},
+			&Command{Name: "no-evict", Arity: 3, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "7.0.0",
+				Summary: "Sets the client eviction mode of the connection."},
+			&Command{Name: "unblock", Arity: -3, Flags: CMD_ADMIN | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE, Categories: connection, Since: "5.0.0",
+				Summary: "Unblocks a client blocked by a blocking command from a different connection."},
+		)})
+	registerCommand(&Command{Name: SELECT, Arity: 2, Flags: CMD_LOADING | CMD_STALE | CMD_FAST, Categories: connection, Group: "connection", Since: "1.0.0",
+		Summary: "Changes the selected database.", handler: (*RedisService).selectCmd})
+	registerCommand(&Command{Name: HELLO, Arity: -1, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST | CMD_NO_AUTH | CMD_ALLOW_BUSY,
+		Categories: connection, Group: "connection", Since: "6.0.0", Summary: "Handshakes with the Redis server.", handler: (*RedisService).helloCmd})
+	registerCommand(&Command{Name: AUTH, Arity: -2, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST | CMD_NO_AUTH | CMD_ALLOW_BUSY,
+		Categories: connection, Group: "connection", Since: "1.0.0", Summary: "Authenticates the connection.", handler: (*RedisService).authCmd})
+	registerCommand(&Command{Name: QUIT, Arity: -1, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST | CMD_NO_AUTH | CMD_ALLOW_BUSY,
+		Categories: connection, Group: "connection", Since: "1.0.0", Summary: "Closes the connection.", handler: (*RedisService).quitCmd})
+	registerCommand(&Command{Name: RESET, Arity: 1, Flags: CMD_NOSCRIPT | CMD_LOADING | CMD_STALE | CMD_FAST | CMD_NO_AUTH | CMD_ALLOW_BUSY,
+		Categories: connection, Group: "connection", Since: "6.2.0", Summary: "Resets the connection.", handler: (*RedisService).resetCmd})
+
+	pubsubFlags := CMD_PUBSUB | CMD_NOSCRIPT | CMD_LOADING | CMD_STALE
+	registerCommand(&Command{Name: SUBSCRIBE, Arity: -2, Flags: pubsubFlags, Group: "pubsub", Since: "2.0.0",
+		Summary: "Listens for messages published to channels.", handler: (*RedisService).subscribeCmd})
+	registerCommand(&Command{Name: UNSUBSCRIBE, Arity: -1, Flags: pubsubFlags, Group: "pubsub", Since: "2.0.0",
+		Summary: "Stops listening to messages posted to channels.", handler: (*RedisService).unsubscribeCmd})
+	registerCommand(&Command{Name: PSUBSCRIBE, Arity: -2, Flags: pubsubFlags, Group: "pubsub", Since: "2.0.0",
+		Summary: "Listens for messages published to channels that match one or more patterns.", handler: (*RedisService).subscribeCmd})
+	registerCommand(&Command{Name: PUNSUBSCRIBE, Arity: -1, Flags: pubsubFlags, Group: "pubsub", Since: "2.0.0",
+		Summary: "Stops listening to messages published to channels that match one or more patterns.", handler: (*RedisService).unsubscribeCmd})
+	registerCommand(&Command{Name: PUBLISH, Arity: 3, Flags: CMD_PUBSUB | CMD_LOADING | CMD_STALE | CMD_FAST | CMD_MAY_REPLICATE,
+		Group: "pubsub", Since: "2.0.0", Summary: "Posts a message to a channel.", handler: (*RedisService).publishCmd})
+}
+
+func registerCommand(cmd *Command) {
+	commandTable[cmd.Name] = cmd
+	for _, sub := range cmd.Subcommands {
+		sub.parent = cmd
+		sub.Group = cmd.Group
+	}
+}
+
+func subcommands(cmds ...*Command) map[string]*Command {
+	res := make(map[string]*Command, len(cmds))
+	for _, cmd := range cmds {
+		res[cmd.Name] = cmd
+	}
+	return res
+}
+
+// encodeHelp builds the reply of a container command HELP subcommand
+func encodeHelp(name string, lines []string) []byte {
+	help := make([][]byte, 0, len(lines)+3)
+	help = append(help, respencoding.EncodeSimpleString(strings.ToUpper(name)+" <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"))
+	for _, line := range append(lines, "HELP", "    Print this help.") {
+		help = append(help, respencoding.EncodeSimpleString(line))
+	}
+	return respencoding.BuildArray(help)
+}
+
+// lookupCommand finds the command, or subcommand, of a request and checks
+// its arity
+func lookupCommand(name string, args []string) (*Command, error) {
+	cmd, ok := commandTable[strings.ToLower(name)]
+	if !ok {
+		var argsPreview strings.Builder
+		for _, arg := range args {
+			fmt.Fprintf(&argsPreview, "'%.128s' ", arg)
+		}
+		return nil, fmt.Errorf("ERR unknown command '%.128s', with args beginning with: %s", name, argsPreview.String())
+	}
+
+	if len(cmd.Subcommands) > 0 && len(args) > 0 {
+		sub, ok := cmd.Subcommands[strings.ToLower(args[0])]
+		if !ok {
+			return nil, fmt.Errorf("ERR unknown subcommand '%.128s'. Try %s HELP.", args[0], strings.ToUpper(cmd.Name))
+		}
+		cmd = sub
+	}
+
+	if !cmd.checkArity(len(args) + 1) {
+		return nil, fmt.Errorf("ERR wrong number of arguments for '%s' command", cmd.FullName())
+	}
+	return cmd, nil
+}
+
+// FullName is the name used in replies, container|subcommand for
+// subcommands
+func (cmd *Command) FullName() string {
+	if cmd.parent != nil {
+		return cmd.parent.Name + "|" + cmd.Name
+	}
+	return cmd.Name
+}
+
+func (cmd *Command) checkArity(argc int) bool {
+	if cmd.Arity >= 0 {
+		return argc == cmd.Arity
+	}
+	return argc >= -cmd.Arity
+}
+
+func (cmd *Command) getHandler() CommandHandler {
+	if cmd.handler == nil && cmd.parent != nil {
+		return cmd.parent.handler
+	}
+	return cmd.handler
+}
+
+// Keys returns the key arguments of a request, args does not include the
+// command name
+func (cmd *Command) Keys(args []string) []string {
+	if cmd.getKeys != nil {
+		return cmd.getKeys(args)
+	}
+	if cmd.KeyKeyword != "" {
+		for i, arg := range args {
+			if strings.EqualFold(arg, cmd.KeyKeyword) {
+				keys := args[i+1:]
+				return keys[:len(keys)/cmd.KeyLimit]
+			}
+		}
+		return nil
+	}
+	if cmd.FirstKey == 0 {
+		return nil
+	}
+
+	last := cmd.LastKey
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+	keys := make([]string, 0, 1)
+	for i := cmd.FirstKey; i <= last && i <= len(args); i += cmd.Step {
+		keys = append(keys, args[i-1])
+	}
+	return keys
+}
+
+func (cmd *Command) flagNames() []string {
+	names := make([]string, 0, 4)
+	for i, name := range commandFlagNames {
+		if cmd.Flags&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// aclCategories adds the categories implied by the flags to the explicit
+// ones, like redis setImplicitACLCategories
+func (cmd *Command) aclCategories() []string {
+	categories := make([]string, 0, 4)
+	if cmd.Flags&CMD_WRITE != 0 {
+		categories = append(categories, "@write")
+	}
+	if cmd.Flags&CMD_READONLY != 0 {
+		categories = append(categories, "@read")
+	}
+	if cmd.Flags&CMD_ADMIN != 0 {
+		categories = append(categories, "@admin", "@dangerous")
+	}
+	if cmd.Flags&CMD_PUBSUB != 0 {
+		categories = append(categories, "@pubsub")
+	}
+	if cmd.Flags&CMD_FAST != 0 {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	if cmd.Flags&CMD_BLOCKING != 0 {
+		categories = append(categories, "@blocking")
+	}
+	for _, category := range cmd.Categories {
+		if !contains(categories, category) {
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
+
+// encodeInfo builds the COMMAND INFO reply of the command
+func (cmd *Command) encodeInfo(proto int) []byte {
+	subs := make([][]byte, 0, len(cmd.Subcommands))
+	for _, name := range sortedCommandNames(cmd.Subcommands) {
+		subs = append(subs, cmd.Subcommands[name].encodeInfo(proto))
+	}
+
+	return respencoding.BuildArray([][]byte{
+		respencoding.EncodeBulkString([]byte(cmd.FullName())),
+		respencoding.EncodeInteger(cmd.Arity),
+		respencoding.BuildSet(proto, encodeSimpleStrings(cmd.flagNames())),
+		respencoding.EncodeInteger(cmd.FirstKey),
+		respencoding.EncodeInteger(cmd.LastKey),
+		respencoding.EncodeInteger(cmd.Step),
+		respencoding.BuildSet(proto, encodeSimpleStrings(cmd.aclCategories())),
+		respencoding.BuildArray([][]byte{}),
+		cmd.encodeKeySpecs(proto),
+		respencoding.BuildArray(subs),
+	})
+}
+
+func (cmd *Command) encodeKeySpecs(proto int) []byte {
+	var beginSearch, findKeys []byte
+	switch {
+	case cmd.KeyKeyword != "":
+		beginSearch = encodeKeySpecPart(proto, "keyword", "keyword", respencoding.EncodeBulkString([]byte(cmd.KeyKeyword)),
+			"startfrom", respencoding.EncodeInteger(1))
+		findKeys = encodeKeySpecPart(proto, "range", "lastkey", respencoding.EncodeInteger(-1),
+			"keystep", respencoding.EncodeInteger(1), "limit", respencoding.EncodeInteger(cmd.KeyLimit))
+	case cmd.FirstKey > 0:
+		lastKey := cmd.LastKey
+		if lastKey > 0 {
+			lastKey -= cmd.FirstKey
+		}
+		beginSearch = encodeKeySpecPart(proto, "index", "index", respencoding.EncodeInteger(cmd.FirstKey))
+		findKeys = encodeKeySpecPart(proto, "range", "lastkey", respencoding.EncodeInteger(lastKey),
+			"keystep", respencoding.EncodeInteger(cmd.Step), "limit", respencoding.EncodeInteger(0))
+	default:
+		return respencoding.BuildArray([][]byte{})
+	}
+
+	flags := []string{"RO", "ACCESS"}
+	if cmd.Flags&CMD_WRITE != 0 {
+		flags = []string{"RW", "UPDATE"}
+	}
+	return respencoding.BuildArray([][]byte{respencoding.BuildMap(proto, [][]byte{
+		respencoding.EncodeBulkString([]byte("flags")),
+		respencoding.BuildSet(proto, encodeSimpleStrings(flags)),
+		respencoding.EncodeBulkString([]byte("begin_search")),
+		beginSearch,
+		respencoding.EncodeBulkString([]byte("find_keys")),
+		findKeys,
+	})})
+}
+
+// encodeKeySpecPart builds {type: kind, spec: {name: value...}}
+func encodeKeySpecPart(proto int, kind string, spec ...any) []byte {
+	pairs := make([][]byte, 0, len(spec))
+	for i := 0; i < len(spec); i += 2 {
+		pairs = append(pairs, respencoding.EncodeBulkString([]byte(spec[i].(string))), spec[i+1].([]byte))
+	}
+	return respencoding.BuildMap(proto, [][]byte{
+		respencoding.EncodeBulkString([]byte("type")),
+		respencoding.EncodeBulkString([]byte(kind)),
+		respencoding.EncodeBulkString([]byte("spec")),
+		respencoding.BuildMap(proto, pairs),
+	})
+}
+
+// encodeDocs builds the COMMAND DOCS reply of the command
+func (cmd *Command) encodeDocs(proto int) []byte {
+	docs := [][]byte{
+		respencoding.EncodeBulkString([]byte("summary")),
+		respencoding.EncodeBulkString([]byte(cmd.Summary)),
+		respencoding.EncodeBulkString([]byte("since")),
+		respencoding.EncodeBulkString([]byte(cmd.Since)),
+		respencoding.EncodeBulkString([]byte("group")),
+		respencoding.EncodeBulkString([]byte(cmd.Group)),
+	}
+	if len(cmd.Subcommands) > 0 {
+		subs := make([][]byte, 0, 2*len(cmd.Subcommands))
+		for _, name := range sortedCommandNames(cmd.Subcommands) {
+			sub := cmd.Subcommands[name]
+			subs = append(subs, respencoding.EncodeBulkString([]byte(sub.FullName())), sub.encodeDocs(proto))
+		}
+		docs = append(docs, respencoding.EncodeBulkString([]byte("subcommands")), respencoding.BuildMap(proto, subs))
+	}
+	return respencoding.BuildMap(proto, docs)
+}
+
+func sortedCommandNames(cmds map[string]*Command) []string {
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func encodeSimpleStrings(s []string) [][]byte {
+	res := make([][]byte, 0, len(s))
+	for _, str := range s {
+		res = append(res, respencoding.EncodeSimpleString(str))
+	}
+	return res
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs *RedisService) commandCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	proto := clientProtocol(ctx)
+	infos := make([][]byte, 0, len(commandTable))
+	for _, name := range sortedCommandNames(commandTable) {
+		infos = append(infos, commandTable[name].encodeInfo(proto))
+	}
+	return respencoding.BuildArray(infos), false
+}
+
+func (rs *RedisService) commandCountCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return respencoding.EncodeInteger(len(commandTable)), false
+}
+
+func (rs *RedisService) commandListCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return respencoding.EncodeArray(stringsToBytes(sortedCommandNames(commandTable))), false
+}
+
+// commandInfoCmd replies with the info of every requested command, or of
+// all of them when none is given, unknown commands get a null
+func (rs *RedisService) commandInfoCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	names := cmdInfo.Args[1:]
+	if len(names) == 0 {
+		return rs.commandCmd(cmdInfo, ctx)
+	}
+
+	proto := clientProtocol(ctx)
+	infos := make([][]byte, 0, len(names))
+	for _, name := range names {
+		if cmd := findCommand(name); cmd != nil {
+			infos = append(infos, cmd.encodeInfo(proto))
+		} else {
+			infos = append(infos, respencoding.EncodeNullArray(proto))
+		}
+	}
+	return respencoding.BuildArray(infos), false
+}
+
+// commandDocsCmd replies with a map of command name to its docs, unknown
+// commands are left out
+func (rs *RedisService) commandDocsCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	names := cmdInfo.Args[1:]
+	if len(names) == 0 {
+		names = sortedCommandNames(commandTable)
+	}
+
+	proto := clientProtocol(ctx)
+	docs := make([][]byte, 0, 2*len(names))
+	for _, name := range names {
+		if cmd := findCommand(name); cmd != nil {
+			docs = append(docs, respencoding.EncodeBulkString([]byte(cmd.FullName())), cmd.encodeDocs(proto))
+		}
+	}
+	return respencoding.BuildMap(proto, docs), false
+}
+
+func (rs *RedisService) commandGetKeysCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	name, args := cmdInfo.Args[1], cmdInfo.Args[2:]
+	cmd, ok := commandTable[strings.ToLower(name)]
+	if !ok {
+		return respencoding.EncodeSimpleError("ERR Invalid command specified"), false
+	}
+	if len(cmd.Subcommands) > 0 && len(args) > 0 {
+		if sub, ok := cmd.Subcommands[strings.ToLower(args[0])]; ok {
+			cmd = sub
+		}
+	}
+	if !cmd.checkArity(len(args) + 1) {
+		return respencoding.EncodeSimpleError("ERR Invalid number of arguments specified for command"), false
+	}
+
+	keys := cmd.Keys(args)
+	if len(keys) == 0 {
+		return respencoding.EncodeSimpleError("ERR The command has no key arguments"), false
+	}
+	return respencoding.EncodeArray(stringsToBytes(keys)), false
+}
+
+// findCommand looks up a command by name, container|subcommand names
+// find subcommands
+func findCommand(name string) *Command {
+	container, subName, isSub := strings.Cut(strings.ToLower(name), "|")
+	cmd, ok := commandTable[container]
+	if !ok {
+		return nil
+	}
+	if isSub {
+		return cmd.Subcommands[subName]
+	}
+	return cmd
+}
+
+func stringsToBytes(s []string) [][]byte {
+	res := make([][]byte, 0, len(s))
+	for _, str := range s {
+		res = append(res, []byte(str))
+	}
+	return res
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_lookupCommand(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		args          []string
+		expected      string
+		expectedError string
+	}{
+		{name: "GET", args: []string{"foo"}, expected: "get"},
+		{name: "del", args: []string{"a", "b", "c"}, expected: "del"},
+		{name: "config", args: []string{"GET", "maxmemory"}, expected: "config|get"},
+		{name: "command", expected: "command"},
+		{name: "get", expectedError: "ERR wrong number of arguments for 'get' command"},
+		{name: "echo", args: []string{"a", "b"}, expectedError: "ERR wrong number of arguments for 'echo' command"},
+		{name: "config", expectedError: "ERR wrong number of arguments for 'config' command"},
+		{name: "config", args: []string{"set", "foo"}, expectedError: "ERR wrong number of arguments for 'config|set' command"},
+		{name: "config", args: []string{"foo"}, expectedError: "ERR unknown subcommand 'foo'. Try CONFIG HELP."},
+		{name: "foo", args: []string{"a", "b"}, expectedError: "ERR unknown command 'foo', with args beginning with: 'a' 'b' "},
+	}
+
+	for _, tc := range tests {
+		cmd, err := lookupCommand(tc.name, tc.args)
+
+		if tc.expectedError != "" {
+			assert.EqualError(t, err, tc.expectedError)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, cmd.FullName())
+	}
+}
+
+func Test_CommandKeys(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{name: GET, args: []string{"foo"}, expected: []string{"foo"}},
+		{name: SET, args: []string{"foo", "bar", "px", "100"}, expected: []string{"foo"}},
+		{name: DEL, args: []string{"a", "b", "c"}, expected: []string{"a", "b", "c"}},
+		{name: XREAD, args: []string{"count", "2", "STREAMS", "s1", "s2", "0", "0"}, expected: []string{"s1", "s2"}},
+		{name: PING, args: []string{}, expected: nil},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, commandTable[tc.name].Keys(tc.args), tc.name)
+	}
+}
+
+func Test_CommandCmd(t *testing.T) {
+
+	ctx := context.WithValue(context.Background(), info.CTX_SERVER_INFO, make(info.ServerInfo))
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{
+			args:     []string{"getkeys", "set", "foo", "bar"},
+			expected: "*1\r\n$3\r\nfoo\r\n",
+		},
+		{
+			args:     []string{"getkeys", "xread", "streams", "a", "b", "0", "0"},
+			expected: "*2\r\n$1\r\na\r\n$1\r\nb\r\n",
+		},
+		{
+			args:     []string{"getkeys", "migrate", "host", "6379", "key", "0", "1000", "AUTH", "keys"},
+			expected: "*1\r\n$3\r\nkey\r\n",
+		},
+		{
+			// the password KEYS is not the option
+			args:     []string{"getkeys", "migrate", "host", "6379", "", "0", "1000", "AUTH", "keys", "KEYS", "a", "b"},
+			expected: "*2\r\n$1\r\na\r\n$1\r\nb\r\n",
+		},
+		{
+			args:     []string{"getkeys", "migrate", "host", "6379", "", "0", "1000"},
+			expected: "-ERR The command has no key arguments\r\n",
+		},
+		{
+			args:     []string{"getkeys", "ping"},
+			expected: "-ERR The command has no key arguments\r\n",
+		},
+		{
+			args:     []string{"getkeys", "get"},
+			expected: "-ERR Invalid number of arguments specified for command\r\n",
+		},
+		{
+			args:     []string{"getkeys", "foo", "bar"},
+			expected: "-ERR Invalid command specified\r\n",
+		},
+		{
+			args: []string{"info", "get", "foo"},
+			expected: "*2\r\n*10\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n" +
+				"*3\r\n+@read\r\n+@fast\r\n+@string\r\n*0\r\n" +
+				"*1\r\n*6\r\n$5\r\nflags\r\n*2\r\n+RO\r\n+ACCESS\r\n" +
+				"$12\r\nbegin_search\r\n*4\r\n$4\r\ntype\r\n$5\r\nindex\r\n$4\r\nspec\r\n*2\r\n$5\r\nindex\r\n:1\r\n" +
+				"$9\r\nfind_keys\r\n*4\r\n$4\r\ntype\r\n$5\r\nrange\r\n$4\r\nspec\r\n*6\r\n$7\r\nlastkey\r\n:0\r\n$7\r\nkeystep\r\n:1\r\n$5\r\nlimit\r\n:0\r\n" +
+				"*0\r\n*-1\r\n",
+		},
+		{
+			args:     []string{"docs", "echo"},
+			expected: "*2\r\n$4\r\necho\r\n*6\r\n$7\r\nsummary\r\n$25\r\nReturns the given string.\r\n$5\r\nsince\r\n$5\r\n1.0.0\r\n$5\r\ngroup\r\n$10\r\nconnection\r\n",
+		},
+	}
+
+	for _, tc := range tests {
+		rs := RedisService{}
+		got, _ := rs.getCmdResponse(&parser.CmdInfo{CmdName: COMMAND, Args: tc.args}, ctx)
+
+		assert.Equal(t, tc.expected, string(got), tc.args)
+	}
+}
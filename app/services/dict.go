@@ -0,0 +1,147 @@
+package services
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand/v2"
+)
+
+const (
+	DICT_INITIAL_SIZE = 4
+
+	// SCAN visits at most COUNT*SCAN_MAX_EMPTY_BUCKETS buckets per call
+	SCAN_MAX_EMPTY_BUCKETS = 10
+)
+
+type dictEntry[V any] struct {
+	key   string
+	value V
+}
+
+// dict is a chained hash table with a power of two size, it is iterated
+// with the reverse binary cursor of redis SCAN so an element present for
+// the whole iteration is always returned, even when the table is resized
+// between calls
+type dict[V any] struct {
+	seed    maphash.Seed
+	buckets [][]dictEntry[V]
+	size    int
+}
+
+func newDict[V any]() *dict[V] {
+	return &dict[V]{seed: maphash.MakeSeed(), buckets: make([][]dictEntry[V], DICT_INITIAL_SIZE)}
+}
+
+func (d *dict[V]) Len() int {
+	return d.size
+}
+
+func (d *dict[V]) bucket(key string) int {
+	return int(maphash.String(d.seed, key) & uint64(len(d.buckets)-1))
+}
+
+func (d *dict[V]) Get(key string) (V, bool) {
+	for _, e := range d.buckets[d.bucket(key)] {
+		if e.key == key {
+			return e.value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// Set stores the value and reports if the key is new
+func (d *dict[V]) Set(key string, value V) bool {
+	i := d.bucket(key)
+	for j := range d.buckets[i] {
+		if d.buckets[i][j].key == key {
+			d.buckets[i][j].value = value
+			return false
+		}
+	}
+	d.buckets[i] = append(d.buckets[i], dictEntry[V]{key: key, value: value})
+	d.size++
+	if d.size > len(d.buckets) {
+		d.resize(len(d.buckets) * 2)
+	}
+	return true
+}
+
+func (d *dict[V]) Delete(key string) bool {
+	i := d.bucket(key)
+	for j, e := range d.buckets[i] {
+		if e.key != key {
+			continue
+		}
+		last := len(d.buckets[i]) - 1
+		d.buckets[i][j] = d.buckets[i][last]
+		d.buckets[i][last] = dictEntry[V]{}
+		d.buckets[i] = d.buckets[i][:last]
+		d.size--
+		// shrink below 1/8 of fill like redis does at 10%
+		if len(d.buckets) > DICT_INITIAL_SIZE && d.size*8 < len(d.buckets) {
+			d.resize(len(d.buckets) / 2)
+		}
+		return true
+	}
+	return false
+}
+
+func (d *dict[V]) resize(size int) {
+	old := d.buckets
+	d.buckets = make([][]dictEntry[V], size)
+	for _, bucket := range old {
+		for _, e := range bucket {
+			i := d.bucket(e.key)
+			d.buckets[i] = append(d.buckets[i], e)
+		}
+	}
+}
+
+// Scan visits every element of the bucket the cursor points to and returns
+// the cursor of the next bucket, 0 once the table has been covered. The
+// cursor is incremented on its reversed bits so the buckets a resize splits
+// or merges keep the same order
+func (d *dict[V]) Scan(cursor uint64, visit func(key string, value V)) uint64 {
+	mask := uint64(len(d.buckets) - 1)
+	for _, e := range d.buckets[cursor&mask] {
+		visit(e.key, e.value)
+	}
+
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// Sample visits about count elements from a random bucket on, like
+// dictGetSomeKeys it is cheap but the elements are not evenly distributed
+func (d *dict[V]) Sample(count int, visit func(key string, value V)) {
+	start := rand.IntN(len(d.buckets))
+	visited := 0
+	for i := range len(d.buckets) {
+		for _, e := range d.buckets[(start+i)&(len(d.buckets)-1)] {
+			visit(e.key, e.value)
+			visited++
+		}
+		if visited >= count {
+			return
+		}
+	}
+}
+
+// scanDict runs Scan until count elements were visited or the iteration is
+// over, like SCAN it gives up after visiting too many empty buckets
+func scanDict[V any](d *dict[V], cursor uint64, count int, visit func(key string, value V)) uint64 {
+	visited := 0
+	for buckets := count * SCAN_MAX_EMPTY_BUCKETS; buckets > 0; buckets-- {
+		cursor = d.Scan(cursor, func(key string, value V) {
+			visited++
+			visit(key, value)
+		})
+		if cursor == 0 || visited >= count {
+			break
+		}
+	}
+	return cursor
+}
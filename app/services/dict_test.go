@@ -0,0 +1,48 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DictScan(t *testing.T) {
+
+	tests := []struct {
+		name string
+		// size of the dict before the scan and once the first call returned
+		before int
+		after  int
+	}{
+		{name: "stable", before: 100, after: 100},
+		{name: "grow", before: 10, after: 1000},
+		{name: "shrink", before: 1000, after: 20},
+	}
+
+	for _, tc := range tests {
+		d := newDict[struct{}]()
+		for i := range tc.before {
+			d.Set(strconv.Itoa(i), struct{}{})
+		}
+
+		seen := make(map[string]bool)
+		visit := func(key string, _ struct{}) { seen[key] = true }
+		cursor := scanDict(d, 0, 5, visit)
+		for i := tc.before; i < tc.after; i++ {
+			d.Set(strconv.Itoa(i), struct{}{})
+		}
+		for i := tc.after; i < tc.before; i++ {
+			d.Delete(strconv.Itoa(i))
+		}
+		for cursor != 0 {
+			cursor = scanDict(d, cursor, 5, visit)
+		}
+
+		// every key present for the whole scan is returned
+		for i := range min(tc.before, tc.after) {
+			assert.True(t, seen[strconv.Itoa(i)], "%s: key %d", tc.name, i)
+		}
+		assert.Equal(t, tc.after, d.Len(), tc.name)
+	}
+}
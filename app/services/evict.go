@@ -0,0 +1,317 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+)
+
+const (
+	// maxmemory-policy values
+	MAXMEMORY_VOLATILE_LRU    = "volatile-lru"
+	MAXMEMORY_ALLKEYS_LRU     = "allkeys-lru"
+	MAXMEMORY_VOLATILE_LFU    = "volatile-lfu"
+	MAXMEMORY_ALLKEYS_LFU     = "allkeys-lfu"
+	MAXMEMORY_VOLATILE_RANDOM = "volatile-random"
+	MAXMEMORY_ALLKEYS_RANDOM  = "allkeys-random"
+	MAXMEMORY_VOLATILE_TTL    = "volatile-ttl"
+	MAXMEMORY_NO_EVICTION     = "noeviction"
+
+	DEFAULT_MAXMEMORY_SAMPLES = 5
+	DEFAULT_LFU_LOG_FACTOR    = 10
+	DEFAULT_LFU_DECAY_TIME    = 1
+
+	// best candidates kept between evictions
+	EVPOOL_SIZE = 16
+	// counter of new keys so they are not evicted before being accessed
+	LFU_INIT_VAL = 5
+	// greatest value of the logarithmic counter
+	LFU_MAX_FREQ = 255
+)
+
+type evictionPolicy struct {
+	name string
+	// only keys with an expiration are evicted
+	volatile bool
+	// score ranks the candidates, the higher the better to evict, random
+	// policies have none
+	score func(candidate evictionCandidate) uint64
+}
+
+var evictionPolicies = map[string]*evictionPolicy{}
+
+func init() {
+	lru := func(c evictionCandidate) uint64 { return uint64(max(c.idle, 0)) }
+	lfu := func(c evictionCandidate) uint64 { return uint64(255 - c.freq) }
+	ttl := func(c evictionCandidate) uint64 { return math.MaxUint64 - uint64(c.expires) }
+
+	for _, policy := range []*evictionPolicy{
+		{name: MAXMEMORY_VOLATILE_LRU, volatile: true, score: lru},
+		{name: MAXMEMORY_ALLKEYS_LRU, score: lru},
+		{name: MAXMEMORY_VOLATILE_LFU, volatile: true, score: lfu},
+		{name: MAXMEMORY_ALLKEYS_LFU, score: lfu},
+		{name: MAXMEMORY_VOLATILE_RANDOM, volatile: true},
+		{name: MAXMEMORY_ALLKEYS_RANDOM},
+		{name: MAXMEMORY_VOLATILE_TTL, volatile: true, score: ttl},
+		{name: MAXMEMORY_NO_EVICTION},
+	} {
+		evictionPolicies[policy.name] = policy
+	}
+
+	lfuLogFactor.Store(DEFAULT_LFU_LOG_FACTOR)
+	lfuDecayTime.Store(DEFAULT_LFU_DECAY_TIME)
+}
+
+// evictionCandidate is a sampled key with what the policies rank it by
+type evictionCandidate struct {
+	key string
+	// milliseconds since the last access
+	idle int64
+	// access counter after its decay
+	freq uint8
+	// unix milliseconds of the expiration, 0 without one
+	expires int64
+}
+
+type evictionPoolEntry struct {
+	score uint64
+	key   string
+	db    int
+}
+
+// evictionPool keeps the best candidates sampled so far sorted by
+// ascending score, so the next sample only has to beat them
+type evictionPool []evictionPoolEntry
+
+func (pool *evictionPool) insert(entry evictionPoolEntry) {
+	p := *pool
+	i := sort.Search(len(p), func(i int) bool { return p[i].score >= entry.score })
+	if len(p) < EVPOOL_SIZE {
+		p = append(p, evictionPoolEntry{})
+		copy(p[i+1:], p[i:])
+		p[i] = entry
+		*pool = p
+		return
+	}
+	// the pool is full, the worst candidate makes room unless it is
+	// better than the new one
+	if i == 0 {
+		return
+	}
+	copy(p[:i-1], p[1:i])
+	p[i-1] = entry
+}
+
+func (pool *evictionPool) pop() (evictionPoolEntry, bool) {
+	p := *pool
+	if len(p) == 0 {
+		return evictionPoolEntry{}, false
+	}
+	*pool = p[:len(p)-1]
+	return p[len(p)-1], true
+}
+
+// Evictor frees memory deleting keys when the dataset grows over
+// maxmemory, following the approximated policies of redis: a few keys are
+// sampled per database and the best ones wait in the eviction pool
+type Evictor struct {
+	dbs       *KvsDatabases
+	maxmemory atomic.Int64
+	policy    atomic.Pointer[evictionPolicy]
+	samples   atomic.Int64
+	evicted   atomic.Int64
+
+	// guards the pool and the database the random policies go on from
+	mx     sync.Mutex
+	pool   evictionPool
+	nextDB int
+}
+
+func NewEvictor(dbs *KvsDatabases) *Evictor {
+	e := &Evictor{dbs: dbs, pool: make(evictionPool, 0, EVPOOL_SIZE)}
+	e.policy.Store(evictionPolicies[MAXMEMORY_NO_EVICTION])
+	e.samples.Store(DEFAULT_MAXMEMORY_SAMPLES)
+	return e
+}
+
+// SetMaxMemory is the maxmemory config apply function, 0 is no limit
+func (e *Evictor) SetMaxMemory(value string) (string, error) {
+	maxmemory, err := info.ParseMemory(value)
+	if err != nil || maxmemory < 0 {
+		return "", fmt.Errorf("argument must be a memory value")
+	}
+	e.maxmemory.Store(maxmemory)
+	return strconv.FormatInt(maxmemory, 10), nil
+}
+
+// SetPolicy is the maxmemory-policy config apply function
+func (e *Evictor) SetPolicy(value string) (string, error) {
+	policy, ok := evictionPolicies[strings.ToLower(value)]
+	if !ok {
+		return "", fmt.Errorf("argument(s) must be one of the following: %s, %s, %s, %s, %s, %s, %s, %s",
+			MAXMEMORY_VOLATILE_LRU, MAXMEMORY_ALLKEYS_LRU, MAXMEMORY_VOLATILE_LFU, MAXMEMORY_ALLKEYS_LFU,
+			MAXMEMORY_VOLATILE_RANDOM, MAXMEMORY_ALLKEYS_RANDOM, MAXMEMORY_VOLATILE_TTL, MAXMEMORY_NO_EVICTION)
+	}
+	e.policy.Store(policy)
+	e.mx.Lock()
+	e.pool = e.pool[:0]
+	e.mx.Unlock()
+	return policy.name, nil
+}
+
+// SetSamples is the maxmemory-samples config apply function
+func (e *Evictor) SetSamples(value string) (string, error) {
+	samples, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || samples < 1 || samples > 64 {
+		return "", fmt.Errorf("argument must be between 1 and 64 inclusive")
+	}
+	e.samples.Store(samples)
+	return value, nil
+}
+
+func (e *Evictor) MaxMemory() int64 {
+	return e.maxmemory.Load()
+}
+
+func (e *Evictor) Policy() string {
+	return e.policy.Load().name
+}
+
+// IsLFU reports if the policy tracks the access frequency, not the idle time
+func (e *Evictor) IsLFU() bool {
+	name := e.policy.Load().name
+	return name == MAXMEMORY_ALLKEYS_LFU || name == MAXMEMORY_VOLATILE_LFU
+}
+
+// Evicted is the number of keys evicted since the start
+func (e *Evictor) Evicted() int64 {
+	return e.evicted.Load()
+}
+
+// PerformEvictions evicts keys until the used memory is back under
+// maxmemory, it reports false when that is not possible
+func (e *Evictor) PerformEvictions() bool {
+	maxmemory := e.maxmemory.Load()
+	if maxmemory == 0 {
+		return true
+	}
+
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	policy := e.policy.Load()
+	for e.dbs.UsedMemory() > maxmemory {
+		if policy.name == MAXMEMORY_NO_EVICTION {
+			return false
+		}
+		db, key, ok := e.selectVictim(policy)
+		if !ok {
+			return false
+		}
+		// a pooled key could have been deleted since it was sampled
+		if e.dbs.Get(db).Evict(key) {
+			e.evicted.Add(1)
+		}
+	}
+	return true
+}
+
+// selectVictim returns the best key to evict, caller must hold e.mx
+func (e *Evictor) selectVictim(policy *evictionPolicy) (int, string, bool) {
+	dbs := e.dbs.Len()
+	if policy.score == nil {
+		// the random policies go over the databases in turn
+		for i := range dbs {
+			db := (e.nextDB + i) % dbs
+			if candidates := e.dbs.Get(db).EvictionSample(1, policy.volatile); len(candidates) > 0 {
+				e.nextDB = db + 1
+				return db, candidates[rand.IntN(len(candidates))].key, true
+			}
+		}
+		return 0, "", false
+	}
+
+	samples := int(e.samples.Load())
+	for db := range dbs {
+		for _, candidate := range e.dbs.Get(db).EvictionSample(samples, policy.volatile) {
+			e.pool.insert(evictionPoolEntry{score: policy.score(candidate), key: candidate.key, db: db})
+		}
+	}
+	entry, ok := e.pool.pop()
+	return entry.db, entry.key, ok
+}
+
+// LFU tuning shared by every keyspace, the counters are updated on each
+// access so they are not read from the config
+var (
+	lfuLogFactor atomic.Int64
+	lfuDecayTime atomic.Int64
+)
+
+// SetLFULogFactor is the lfu-log-factor config apply function
+func SetLFULogFactor(value string) (string, error) {
+	factor, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || factor < 0 {
+		return "", fmt.Errorf("argument must be between 0 and 2147483647 inclusive")
+	}
+	lfuLogFactor.Store(factor)
+	return value, nil
+}
+
+// SetLFUDecayTime is the lfu-decay-time config apply function, in minutes
+func SetLFUDecayTime(value string) (string, error) {
+	decay, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || decay < 0 {
+		return "", fmt.Errorf("argument must be between 0 and 2147483647 inclusive")
+	}
+	lfuDecayTime.Store(decay)
+	return value, nil
+}
+
+// lfuTimeInMinutes is the 16 bits clock of the last counter decrement
+func lfuTimeInMinutes(now time.Time) uint32 {
+	return uint32(now.Unix()/60) & 0xFFFF
+}
+
+func lfuTimeElapsed(ldt uint32, now time.Time) uint32 {
+	current := lfuTimeInMinutes(now)
+	if current >= ldt {
+		return current - ldt
+	}
+	return 0xFFFF - ldt + current
+}
+
+// lfuLogIncr increments the counter with a probability that falls as it
+// grows, so 255 stands for about a million accesses with the default factor
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return counter
+	}
+	base := max(float64(counter)-LFU_INIT_VAL, 0)
+	if rand.Float64() < 1.0/(base*float64(lfuLogFactor.Load())+1) {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecrAndReturn returns the counter decremented once per lfu-decay-time
+// minutes elapsed since its last decrement
+func lfuDecrAndReturn(lfu uint32, now time.Time) uint8 {
+	counter := uint8(lfu & 0xFF)
+	decay := lfuDecayTime.Load()
+	if decay == 0 {
+		return counter
+	}
+	periods := int64(lfuTimeElapsed(lfu>>8, now)) / decay
+	if periods >= int64(counter) {
+		return 0
+	}
+	return counter - uint8(periods)
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EvictionPool(t *testing.T) {
+
+	pool := make(evictionPool, 0, EVPOOL_SIZE)
+	for _, score := range []uint64{5, 1, 30, 7, 2} {
+		pool.insert(evictionPoolEntry{score: score, key: strconv.FormatUint(score, 10)})
+	}
+	for i := range EVPOOL_SIZE - 5 {
+		pool.insert(evictionPoolEntry{score: uint64(100 + i)})
+	}
+	// a full pool drops its worst candidate for a better one and ignores
+	// the candidates worse than all of its own
+	pool.insert(evictionPoolEntry{score: 3})
+	pool.insert(evictionPoolEntry{score: 0})
+
+	assert.Len(t, pool, EVPOOL_SIZE)
+	assert.Equal(t, []uint64{2, 3, 5}, []uint64{pool[0].score, pool[1].score, pool[2].score})
+	entry, ok := pool.pop()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(100+EVPOOL_SIZE-6), entry.score)
+}
+
+func Test_PerformEvictions(t *testing.T) {
+
+	tests := []struct {
+		policy string
+		// keys 0 to 4 expire, the higher the key the sooner, the older its
+		// last access and the lower its access counter
+		evicted []string
+		ok      bool
+	}{
+		{policy: MAXMEMORY_ALLKEYS_LRU, evicted: []string{"9", "8", "7", "6", "5"}, ok: true},
+		{policy: MAXMEMORY_ALLKEYS_LFU, evicted: []string{"9", "8", "7", "6", "5"}, ok: true},
+		{policy: MAXMEMORY_VOLATILE_LRU, evicted: []string{"4", "3", "2", "1", "0"}, ok: true},
+		{policy: MAXMEMORY_VOLATILE_TTL, evicted: []string{"4", "3", "2", "1", "0"}, ok: true},
+		{policy: MAXMEMORY_NO_EVICTION, evicted: []string{}, ok: false},
+	}
+
+	for _, tc := range tests {
+		clients := NewClientRegistry()
+		pubsub := NewPubSubService()
+		notifier := NewKeyspaceNotifier(pubsub)
+		dbs := NewKvsDatabases(1, func(db int) Kvs { return NewKvSService(notifier, NewTrackingService(clients, pubsub), db) })
+		kvs := dbs.Get(0).(*kvSService)
+
+		now := time.Now()
+		for i := range 10 {
+			key := strconv.Itoa(i)
+			if i < 5 {
+				kvs.SetWithOptions(key, []byte("v"), KvsOptions{expires: time.Duration(1000 * (10 - i))})
+			} else {
+				kvs.Set(key, []byte("v"))
+			}
+			v, _ := kvs.store.Load(key)
+			v.(*kvsEntry).access.Store(now.UnixMilli() - int64(i*1000))
+			v.(*kvsEntry).lfu.Store(lfuTimeInMinutes(now)<<8 | uint32(100-i))
+		}
+
+		evictor := dbs.Evictor()
+		evictor.SetPolicy(tc.policy)
+		evictor.SetSamples("64")
+		evictor.SetMaxMemory(strconv.FormatInt(kvs.UsedMemory()/2, 10))
+
+		assert.Equal(t, tc.ok, evictor.PerformEvictions(), tc.policy)
+		evicted := []string{}
+		for i := 9; i >= 0; i-- {
+			if _, found := kvs.store.Load(strconv.Itoa(i)); !found {
+				evicted = append(evicted, strconv.Itoa(i))
+			}
+		}
+		assert.ElementsMatch(t, tc.evicted, evicted, tc.policy)
+		assert.Equal(t, int64(len(tc.evicted)), evictor.Evicted(), tc.policy)
+	}
+}
+
+func Test_LFUCounter(t *testing.T) {
+
+	now := time.Now()
+	tests := []struct {
+		counter uint8
+		// minutes since the last decrement
+		elapsed  int
+		expected uint8
+	}{
+		{counter: 10, elapsed: 0, expected: 10},
+		{counter: 10, elapsed: 3, expected: 7},
+		{counter: 10, elapsed: 30, expected: 0},
+	}
+
+	for _, tc := range tests {
+		ldt := lfuTimeInMinutes(now.Add(-time.Duration(tc.elapsed) * time.Minute))
+		assert.Equal(t, tc.expected, lfuDecrAndReturn(ldt<<8|uint32(tc.counter), now))
+	}
+	// new keys always get the first increments
+	assert.Equal(t, uint8(LFU_INIT_VAL), lfuLogIncr(LFU_INIT_VAL-1))
+	assert.Equal(t, uint8(255), lfuLogIncr(255))
+}
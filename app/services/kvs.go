@@ -1,16 +1,36 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
 	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
 )
 
-const NEVER_EXPIRE = -1
+const (
+	NEVER_EXPIRE = -1
+
+	DEFAULT_DATABASES = 16
+
+	// active expire cycle, same defaults as redis
+	ACTIVE_EXPIRE_CYCLE_INTERVAL      = 100 * time.Millisecond
+	ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP = 20
+	// a cycle takes at most 25% of the interval
+	ACTIVE_EXPIRE_CYCLE_TIME_LIMIT = ACTIVE_EXPIRE_CYCLE_INTERVAL / 4
+
+	// estimated bytes a key takes in the store and an element in a
+	// collection on top of the bytes of their strings
+	KEY_OVERHEAD     = 64
+	ELEMENT_OVERHEAD = 32
+)
 
 type Kvs interface {
 	Set(k string, v []byte) bool
@@ -22,27 +42,165 @@ type Kvs interface {
 	GetStream(k string) *KvsStream
 	SubscriveStreamEventListener(k string, listener chan string)
 	UnsubscriveStreamEventListener(k string)
+	Del(k string) bool
+	IncrBy(k string, delta int) (int, error)
+	Size() int
+	// Move transfers k to dst unless dst already has it
+	Move(k string, dst Kvs) bool
+	// AddObject stores obj unless k already exists, expires is the unix
+	// milliseconds of its expiration or 0
+	AddObject(k string, obj KvsObject, expires int64) bool
+	// LoadObject is AddObject while a file is loaded, nothing is notified
+	// and it is not counted as a change
+	LoadObject(k string, obj KvsObject, expires int64) bool
+	// DumpKey returns the value of k with its expiration, as DUMP reads it
+	DumpKey(k string) (redisdb.RDBEntry, bool)
+	// Restore stores obj at k expiring at expires, an existing key is only
+	// replaced with replace. A non negative idle or freq seeds the access
+	// metadata
+	Restore(k string, obj KvsObject, expires int64, replace bool, idle time.Duration, freq int) bool
+	// SetDB changes the index used in notifications, after a SWAPDB
+	SetDB(db int)
+	// SetPropagate sets where the keyspace propagates the DEL of the keys
+	// it expires or evicts on its own
+	SetPropagate(propagate func(db int, args []string))
+	ActiveExpireCycle() (sampled int, expired int)
+	// Scan returns the keys of the buckets from cursor on until about count
+	// keys were found, with the cursor to continue, 0 once it is over
+	Scan(cursor uint64, count int) ([]string, uint64)
+	// ScanElements is Scan over the collection of type kind at k, hash
+	// fields and zset members are followed by their value and score
+	ScanElements(k, kind string, cursor uint64, count int) ([]string, uint64, error)
+	HSet(k string, pairs []string) (int, error)
+	SAdd(k string, members []string) (int, error)
+	ZAdd(k string, scores []float64, members []string) (int, error)
+	// UsedMemory is the estimated size of every key and its object
+	UsedMemory() int64
+	// EvictionSample returns about count random keys, only the ones with an
+	// expiration when volatile is set
+	EvictionSample(count int, volatile bool) []evictionCandidate
+	// Evict deletes k to free memory and notifies it
+	Evict(k string) bool
+	// Inspect returns what OBJECT reports of k without counting an access
+	Inspect(k string) (objectInfo, bool)
+	// MemoryUsage estimates the bytes of k sampling that many elements of
+	// a collection, all of them with 0
+	MemoryUsage(k string, samples int) (int64, bool)
+	// Lock and Unlock hold the writes, a snapshot locks every database to
+	// dump all of them at the same point in time
+	Lock()
+	Unlock()
+	// Dump returns the keys that are not expired, caller must hold the lock
+	Dump() []redisdb.RDBEntry
+	// Dirty is the number of changes made to the keys since the start
+	Dirty() int64
 }
 
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
 type KvsObject interface {
 	GetType() string
+	// GetEncoding is the internal representation reported by OBJECT
+	// ENCODING, collections must be read under the keyspace lock
+	GetEncoding() string
+}
+
+type objectInfo struct {
+	encoding string
+	idle     time.Duration
+	freq     uint8
+	// the value is one of the shared integers
+	shared bool
 }
 
 type KvsOptions struct {
-	expires   time.Duration
+	expires time.Duration
+	// unix milliseconds the key expires at
 	timestamp uint64
 }
 
+// KvsStringObject is a string value, the canonical form of a 64 bits
+// integer is kept as a number like the int encoding of redis
 type KvsStringObject struct {
-	data    []byte
-	created *time.Time
-	expires *time.Time
+	data  []byte
+	num   int64
+	isInt bool
+}
+
+func newKvsStringObject(value []byte) KvsStringObject {
+	if n, ok := parseInteger(string(value)); ok {
+		return KvsStringObject{num: n, isInt: true}
+	}
+	return KvsStringObject{data: value}
+}
+
+func newKvsIntegerObject(n int64) KvsStringObject {
+	return KvsStringObject{num: n, isInt: true}
 }
 
 func (kvsString KvsStringObject) GetType() string {
 	return "string"
 }
 
+// Bytes returns the value, the shared integers must not be modified
+func (kvsString KvsStringObject) Bytes() []byte {
+	if !kvsString.isInt {
+		return kvsString.data
+	}
+	if kvsString.isShared() {
+		return sharedIntegers[kvsString.num]
+	}
+	return strconv.AppendInt(nil, kvsString.num, 10)
+}
+
+// Size is the bytes the value takes, an integer only needs its number
+// unless it is shared
+func (kvsString KvsStringObject) Size() int64 {
+	switch {
+	case kvsString.isShared():
+		return 0
+	case kvsString.isInt:
+		return 8
+	}
+	return int64(len(kvsString.data))
+}
+
+type KvsHash struct {
+	fields *compactDict[string]
+}
+
+func (h *KvsHash) GetType() string {
+	return "hash"
+}
+
+// KvsSet is an intset while members is nil
+type KvsSet struct {
+	intset  intset
+	members *compactDict[struct{}]
+}
+
+func (set *KvsSet) GetType() string {
+	return "set"
+}
+
+type KvsSortedSet struct {
+	scores *compactDict[float64]
+}
+
+func (z *KvsSortedSet) GetType() string {
+	return "zset"
+}
+
+// KvsList only comes from rdb files, there are no list commands yet so
+// it is kept as loaded to be saved again
+type KvsList struct {
+	elements []string
+}
+
+func (l *KvsList) GetType() string {
+	return "list"
+}
+
 type KvsStreamId struct {
 	milli    int64
 	sequence int
@@ -87,6 +245,8 @@ func (sid KvsStreamId) generateNextSequence(millis int64) KvsStreamId {
 type KvsStream struct {
 	lastId  KvsStreamId
 	objects []KvsStreamObject
+	// consumer groups loaded from an rdb file, kept to be saved again
+	groups []redisdb.RDBStreamGroup
 }
 
 func (kvsStream KvsStream) GetType() string {
@@ -153,14 +313,162 @@ func (kvsStream KvsStreamObject) GetRespEncodign() [][]byte {
 	return res
 }
 
+// kvsEntry is what the store keeps for every key, the object with the
+// access metadata of the eviction policies. The object is never replaced
+// in place, readers load the entry without the lock
+type kvsEntry struct {
+	obj KvsObject
+	// unix milliseconds of the expiration, 0 when it never expires
+	expires int64
+	// estimated bytes of the key and the object, guarded by kvs.mx
+	size int64
+	// unix milliseconds of the last access
+	access atomic.Int64
+	// minutes of the last decrement << 8 | logarithmic access counter
+	lfu atomic.Uint32
+}
+
+func newKvsEntry(key string, obj KvsObject, expires int64) *kvsEntry {
+	entry := &kvsEntry{obj: obj, expires: expires, size: KEY_OVERHEAD + int64(len(key)) + objectSize(obj, 0)}
+	entry.access.Store(time.Now().UnixMilli())
+	entry.lfu.Store(lfuTimeInMinutes(time.Now())<<8 | LFU_INIT_VAL)
+	return entry
+}
+
+func (entry *kvsEntry) isExpired(now time.Time) bool {
+	return entry.expires != 0 && entry.expires < now.UnixMilli()
+}
+
+// touch records an access to the entry
+func (entry *kvsEntry) touch() {
+	now := time.Now()
+	entry.access.Store(now.UnixMilli())
+	counter := lfuLogIncr(lfuDecrAndReturn(entry.lfu.Load(), now))
+	entry.lfu.Store(lfuTimeInMinutes(now)<<8 | uint32(counter))
+}
+
 type kvSService struct {
-	size           int64
-	store          *sync.Map
+	// serializes writes, reads go straight to the store
+	mx    sync.Mutex
+	db    atomic.Int64
+	size  int64
+	used  atomic.Int64
+	dirty atomic.Int64
+	store *sync.Map
+	// every key of the store, it gives SCAN a stable iteration order
+	keys *dict[struct{}]
+	// the keys with an expiration, sampled by the volatile policies
+	expires        *dict[struct{}]
 	setStreamEvent map[string]chan string
+	notifier       *KeyspaceNotifier
+	tracking       *TrackingService
+	propagate      func(db int, args []string)
+}
+
+func NewKvSService(notifier *KeyspaceNotifier, tracking *TrackingService, db int) Kvs {
+	kvs := &kvSService{
+		store:          &sync.Map{},
+		keys:           newDict[struct{}](),
+		expires:        newDict[struct{}](),
+		setStreamEvent: make(map[string]chan string),
+		notifier:       notifier,
+		tracking:       tracking,
+		propagate:      func(int, []string) {},
+	}
+	kvs.db.Store(int64(db))
+	return kvs
+}
+
+func (kvs *kvSService) SetDB(db int) {
+	kvs.db.Store(int64(db))
+}
+
+func (kvs *kvSService) SetPropagate(propagate func(db int, args []string)) {
+	kvs.propagate = propagate
+}
+
+func (kvs *kvSService) id() int {
+	return int(kvs.db.Load())
+}
+
+func (kvs *kvSService) Size() int {
+	kvs.mx.Lock()
+	defer kvs.mx.Unlock()
+	return int(kvs.size)
+}
+
+func (kvs *kvSService) AddObject(k string, obj KvsObject, expires int64) bool {
+	kvs.lookup(k)
+
+	kvs.mx.Lock()
+	defer kvs.mx.Unlock()
+	if _, found := kvs.store.Load(k); found {
+		return false
+	}
+	kvs.storeObject(k, obj, expires)
+	return true
+}
+
+func (kvs *kvSService) LoadObject(k string, obj KvsObject, expires int64) bool {
+	kvs.mx.Lock()
+	defer kvs.mx.Unlock()
+	if _, found := kvs.store.Load(k); found {
+		return false
+	}
+	kvs.setObject(k, obj, expires)
+	return true
 }
 
-func NewKvSService() Kvs {
-	return &kvSService{store: &sync.Map{}, setStreamEvent: make(map[string]chan string)}
+func (kvs *kvSService) DumpKey(k string) (redisdb.RDBEntry, bool) {
+	if _, ok := kvs.lookup(k); !ok {
+		return redisdb.RDBEntry{}, false
+	}
+
+	kvs.mx.Lock()
+	defer kvs.mx.Unlock()
+	entry, ok := kvs.loadEntry(k)
+	if !ok {
+		return redisdb.RDBEntry{}, false
+	}
+	return redisdb.RDBEntry{Key: k, Value: toRDBValue(entry.obj), Expires: entry.expires}, true
+}
+
+func (kvs *kvSService) Restore(k string, obj KvsObject, expires int64, replace bool, idle time.Duration, freq int) bool {
+	kvs.lookup(k)
+
+	kvs.mx.Lock()
+	if _, found := kvs.store.Load(k); found && !replace {
+		kvs.mx.Unlock()
+		return false
+	}
+	isNew := kvs.storeObject(k, obj, expires)
+	entry, _ := kvs.loadEntry(k)
+	now := time.Now()
+	if idle >= 0 {
+		entry.access.Store(now.Add(-idle).UnixMilli())
+	}
+	if freq >= 0 {
+		entry.lfu.Store(lfuTimeInMinutes(now)<<8 | uint32(freq))
+	}
+	kvs.mx.Unlock()
+
+	if isNew {
+		kvs.notifier.Notify(NOTIFY_NEW, "new", k, kvs.id())
+	}
+	kvs.notifier.Notify(NOTIFY_GENERIC, "restore", k, kvs.id())
+	return true
+}
+
+func (kvs *kvSService) Move(k string, dst Kvs) bool {
+	entry, ok := kvs.lookupEntry(k)
+	if !ok || !dst.AddObject(k, entry.obj, entry.expires) {
+		return false
+	}
+
+	kvs.mx.Lock()
+	kvs.removeObject(k)
+	kvs.mx.Unlock()
+	return true
 }
 
 func (kvs *kvSService) UnsubscriveStreamEventListener(k string) {
@@ -172,7 +480,7 @@ func (kvs *kvSService) SubscriveStreamEventListener(k string, listener chan stri
 }
 
 func (kvs *kvSService) GetStream(k string) *KvsStream {
-	streamObject, found := kvs.store.Load(k)
+	streamObject, found := kvs.lookup(k)
 	if found {
 		stream, ok := streamObject.(KvsStream)
 		if ok {
@@ -183,7 +491,10 @@ func (kvs *kvSService) GetStream(k string) *KvsStream {
 }
 
 func (kvs *kvSService) SetStream(k, id string, data map[string]any) (string, error) {
-	streamObject, found := kvs.store.Load(k)
+	kvs.mx.Lock()
+	defer kvs.mx.Unlock()
+
+	streamEntry, found := kvs.loadEntry(k)
 
 	prevId := ""
 	var currentStreamId KvsStreamId
@@ -203,12 +514,13 @@ func (kvs *kvSService) SetStream(k, id string, data map[string]any) (string, err
 			currentStreamId = KvsStreamId{}
 		}
 
-		kvs.store.Store(k, KvsStream{lastId: currentStreamId, objects: []KvsStreamObject{
+		kvs.storeObject(k, KvsStream{lastId: currentStreamId, objects: []KvsStreamObject{
 			{id: currentStreamId, data: data},
-		}})
+		}}, 0)
+		kvs.notifier.Notify(NOTIFY_NEW, "new", k, kvs.id())
 	} else {
 
-		stream, ok := streamObject.(KvsStream)
+		stream, ok := streamEntry.obj.(KvsStream)
 		prevId = stream.lastId.String()
 		if !ok {
 			return "", fmt.Errorf("Could not verify stream")
@@ -226,9 +538,11 @@ func (kvs *kvSService) SetStream(k, id string, data map[string]any) (string, err
 
 		stream.objects = append(stream.objects, KvsStreamObject{currentStreamId, data})
 		stream.lastId = currentStreamId
-		kvs.store.Store(k, stream)
+		kvs.storeObject(k, stream, streamEntry.expires)
 	}
 
+	kvs.notifier.Notify(NOTIFY_STREAM, "xadd", k, kvs.id())
+
 	go func() {
 		kvs.setStreamEvent[k] <- fmt.Sprintf("%s,%s", k, prevId)
 	}()
@@ -237,11 +551,7 @@ func (kvs *kvSService) SetStream(k, id string, data map[string]any) (string, err
 }
 
 func (kvs *kvSService) GetType(k string) string {
-	res, ok := kvs.store.Load(k)
-	if !ok {
-		return "none"
-	}
-	obj, ok := res.(KvsObject)
+	obj, ok := kvs.lookup(k)
 	if !ok {
 		return "none"
 	}
@@ -250,58 +560,258 @@ func (kvs *kvSService) GetType(k string) string {
 
 func (kvs *kvSService) Set(key string, value []byte) bool {
 
-	now := time.Now()
-	object := KvsStringObject{
-		data:    value,
-		created: &now,
-	}
-	kvs.size++
-	kvs.store.Store(key, object)
+	object := newKvsStringObject(value)
+	kvs.mx.Lock()
+	isNew := kvs.storeObject(key, object, 0)
+	kvs.mx.Unlock()
 
+	if isNew {
+		kvs.notifier.Notify(NOTIFY_NEW, "new", key, kvs.id())
+	}
+	kvs.notifier.Notify(NOTIFY_STRING, "set", key, kvs.id())
 	return true
 }
 
 func (kvs *kvSService) SetWithOptions(key string, value []byte, options KvsOptions) bool {
 
-	obj := KvsStringObject{
-		data: value,
-	}
+	obj := newKvsStringObject(value)
+	expires := int64(0)
 	if options.expires != 0 {
-		kvs.stampObject(&obj, options.expires)
+		expires = time.Now().Add(options.expires * time.Millisecond).UnixMilli()
 	}
 
 	if options.timestamp != 0 {
-
-		t := convertTimestampToTime(int64(options.timestamp))
-		if t.Before(time.Now()) {
+		if int64(options.timestamp) < time.Now().UnixMilli() {
 			return false
 		}
-		created := time.Now()
-		obj.created = &created
-		obj.expires = &t
+		expires = int64(options.timestamp)
+	}
+	kvs.mx.Lock()
+	isNew := kvs.storeObject(key, obj, expires)
+	kvs.mx.Unlock()
 
+	if isNew {
+		kvs.notifier.Notify(NOTIFY_NEW, "new", key, kvs.id())
+	}
+	kvs.notifier.Notify(NOTIFY_STRING, "set", key, kvs.id())
+	if expires != 0 {
+		kvs.notifier.Notify(NOTIFY_GENERIC, "expire", key, kvs.id())
 	}
-	kvs.size++
-	kvs.store.Store(key, obj)
 	return true
 }
 
 func (kvs *kvSService) Get(k string) ([]byte, bool) {
 
-	anyV, ok := kvs.store.Load(k)
+	anyV, ok := kvs.lookup(k)
+	if !ok {
+		kvs.notifier.Notify(NOTIFY_KEY_MISS, "keymiss", k, kvs.id())
+		return nil, false
+	}
 	obj, ok := anyV.(KvsStringObject)
-
-	if obj.expires != nil && obj.expires.Before(time.Now()) {
+	if !ok {
 		return nil, false
 	}
+	return obj.Bytes(), true
+}
+
+func (kvs *kvSService) Del(k string) bool {
+	if _, ok := kvs.lookup(k); !ok {
+		return false
+	}
+
+	kvs.mx.Lock()
+	deleted := kvs.removeObject(k)
+	kvs.mx.Unlock()
+
+	if deleted {
+		kvs.notifier.Notify(NOTIFY_GENERIC, "del", k, kvs.id())
+	}
+	return deleted
+}
+
+// IncrBy adds delta to the integer stored at k keeping its expiration,
+// missing keys are considered to be 0
+func (kvs *kvSService) IncrBy(k string, delta int) (int, error) {
+	// an expired value must not be incremented
+	kvs.lookup(k)
+
+	kvs.mx.Lock()
+	current := 0
+	expires := int64(0)
+	isNew := true
+	if entry, found := kvs.loadEntry(k); found {
+		stored, ok := entry.obj.(KvsStringObject)
+		if !ok {
+			kvs.mx.Unlock()
+			return 0, errWrongType
+		}
+		if !stored.isInt {
+			kvs.mx.Unlock()
+			return 0, fmt.Errorf("ERR value is not an integer or out of range")
+		}
+		current = int(stored.num)
+		expires = entry.expires
+		isNew = false
+	}
+
+	if (delta > 0 && current > math.MaxInt-delta) || (delta < 0 && current < math.MinInt-delta) {
+		kvs.mx.Unlock()
+		return 0, fmt.Errorf("ERR increment or decrement would overflow")
+	}
+	current += delta
+	kvs.storeObject(k, newKvsIntegerObject(int64(current)), expires)
+	kvs.mx.Unlock()
+
+	if isNew {
+		kvs.notifier.Notify(NOTIFY_NEW, "new", k, kvs.id())
+	}
+	kvs.notifier.Notify(NOTIFY_STRING, "incrby", k, kvs.id())
+	return current, nil
+}
+
+func (kvs *kvSService) Scan(cursor uint64, count int) ([]string, uint64) {
+	kvs.mx.Lock()
+	defer kvs.mx.Unlock()
+
+	keys := make([]string, 0, count)
+	cursor = scanDict(kvs.keys, cursor, count, func(key string, _ struct{}) {
+		keys = append(keys, key)
+	})
+	return keys, cursor
+}
+
+func (kvs *kvSService) ScanElements(k, kind string, cursor uint64, count int) ([]string, uint64, error) {
+	// an expired key is scanned as an empty one
+	kvs.lookup(k)
+
+	kvs.mx.Lock()
+	defer kvs.mx.Unlock()
+	v, found := kvs.load(k)
+	if !found {
+		return []string{}, 0, nil
+	}
+	if v.GetType() != kind {
+		return nil, 0, errWrongType
+	}
+
+	elements := make([]string, 0, count)
+	switch obj := v.(type) {
+	case *KvsHash:
+		cursor = obj.fields.Scan(cursor, count, func(field string, value string) {
+			elements = append(elements, field, value)
+		})
+	case *KvsSet:
+		cursor = obj.Scan(cursor, count, func(member string) {
+			elements = append(elements, member)
+		})
+	case *KvsSortedSet:
+		cursor = obj.scores.Scan(cursor, count, func(member string, score float64) {
+			elements = append(elements, member, formatScore(score))
+		})
+	}
+	return elements, cursor, nil
+}
+
+// HSet sets the field value pairs returning how many fields are new
+func (kvs *kvSService) HSet(k string, pairs []string) (int, error) {
+	kvs.mx.Lock()
+	hash, isNew, err := loadCollection(kvs, k, newKvsHash)
+	added := 0
+	if err == nil {
+		for i := 0; i < len(pairs); i += 2 {
+			if hash.set(pairs[i], pairs[i+1]) {
+				added++
+			}
+		}
+		kvs.resizeObject(k)
+	}
+	kvs.mx.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	if isNew {
+		kvs.notifier.Notify(NOTIFY_NEW, "new", k, kvs.id())
+	}
+	kvs.notifier.Notify(NOTIFY_HASH, "hset", k, kvs.id())
+	return added, nil
+}
+
+func (kvs *kvSService) SAdd(k string, members []string) (int, error) {
+	kvs.mx.Lock()
+	set, isNew, err := loadCollection(kvs, k, func() *KvsSet { return newKvsSet(members[0], len(members)) })
+	added := 0
+	if err == nil {
+		for _, member := range members {
+			if set.add(member) {
+				added++
+			}
+		}
+		kvs.resizeObject(k)
+	}
+	kvs.mx.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	if isNew {
+		kvs.notifier.Notify(NOTIFY_NEW, "new", k, kvs.id())
+	}
+	if added > 0 {
+		kvs.notifier.Notify(NOTIFY_SET, "sadd", k, kvs.id())
+	}
+	return added, nil
+}
+
+// ZAdd sets the score of each member returning how many members are new
+func (kvs *kvSService) ZAdd(k string, scores []float64, members []string) (int, error) {
+	kvs.mx.Lock()
+	zset, isNew, err := loadCollection(kvs, k, newKvsSortedSet)
+	added := 0
+	if err == nil {
+		for i, member := range members {
+			if zset.add(member, scores[i]) {
+				added++
+			}
+		}
+		kvs.resizeObject(k)
+	}
+	kvs.mx.Unlock()
 
-	return obj.data, ok
+	if err != nil {
+		return 0, err
+	}
+	if isNew {
+		kvs.notifier.Notify(NOTIFY_NEW, "new", k, kvs.id())
+	}
+	kvs.notifier.Notify(NOTIFY_ZSET, "zadd", k, kvs.id())
+	return added, nil
+}
+
+// loadCollection returns the collection at k creating it when missing,
+// caller must hold kvs.mx
+func loadCollection[T KvsObject](kvs *kvSService, k string, create func() T) (T, bool, error) {
+	entry, found := kvs.loadEntry(k)
+	if found && !entry.isExpired(time.Now()) {
+		obj, ok := entry.obj.(T)
+		if !ok {
+			return obj, false, errWrongType
+		}
+		return obj, false, nil
+	}
+	obj := create()
+	kvs.storeObject(k, obj, 0)
+	return obj, true, nil
 }
 
 func (kvs *kvSService) Keys() [][]byte {
-	res := make([][]byte, 0, kvs.size)
+	res := make([][]byte, 0, kvs.Size())
 
+	now := time.Now()
 	kvs.store.Range(func(k, v any) bool {
+		if v.(*kvsEntry).isExpired(now) {
+			return true
+		}
 		key := k.(string)
 		res = append(res, []byte(key))
 		return true
@@ -310,30 +820,528 @@ func (kvs *kvSService) Keys() [][]byte {
 	return res
 }
 
+// ActiveExpireCycle samples keys with an expiration deleting the expired
+// ones, it returns how many were sampled and deleted
+func (kvs *kvSService) ActiveExpireCycle() (int, int) {
+	sampled := 0
+	candidates := make([]string, 0, ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP)
+	now := time.Now()
+
+	kvs.mx.Lock()
+	kvs.expires.Sample(ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP, func(key string, _ struct{}) {
+		entry, ok := kvs.loadEntry(key)
+		if !ok {
+			return
+		}
+		sampled++
+		if entry.isExpired(now) {
+			candidates = append(candidates, key)
+		}
+	})
+	kvs.mx.Unlock()
+
+	expired := 0
+	for _, k := range candidates {
+		if kvs.expireIfNeeded(k) {
+			expired++
+		}
+	}
+	return sampled, expired
+}
+
+// lookup loads k deleting it first if it is already expired, it counts
+// as an access for the eviction policies
+func (kvs *kvSService) lookup(k string) (KvsObject, bool) {
+	entry, ok := kvs.lookupEntry(k)
+	if !ok {
+		return nil, false
+	}
+	return entry.obj, true
+}
+
+func (kvs *kvSService) lookupEntry(k string) (*kvsEntry, bool) {
+	entry, ok := kvs.loadEntry(k)
+	if !ok {
+		return nil, false
+	}
+	if entry.isExpired(time.Now()) {
+		kvs.expireIfNeeded(k)
+		return nil, false
+	}
+	entry.touch()
+	return entry, true
+}
+
+// load returns the object of k even if it is expired
+func (kvs *kvSService) load(k string) (KvsObject, bool) {
+	entry, ok := kvs.loadEntry(k)
+	if !ok {
+		return nil, false
+	}
+	return entry.obj, true
+}
+
+func (kvs *kvSService) loadEntry(k string) (*kvsEntry, bool) {
+	v, ok := kvs.store.Load(k)
+	if !ok {
+		return nil, false
+	}
+	return v.(*kvsEntry), true
+}
+
+// expireIfNeeded deletes k if it is expired and notifies it, the value is
+// loaded again under the lock since it could have been replaced
+func (kvs *kvSService) expireIfNeeded(k string) bool {
+	kvs.mx.Lock()
+	entry, ok := kvs.loadEntry(k)
+	expired := ok && entry.isExpired(time.Now())
+	if expired {
+		kvs.removeObject(k)
+		// propagated under the lock so a write of k can't be logged first
+		kvs.propagate(kvs.id(), []string{strings.ToUpper(DEL), k})
+	}
+	kvs.mx.Unlock()
+
+	if expired {
+		kvs.notifier.Notify(NOTIFY_EXPIRED, "expired", k, kvs.id())
+		kvs.tracking.InvalidateKeys(nil, []string{k})
+	}
+	return expired
+}
+
+// storeObject replaces the value of key and reports if the key is new,
+// caller must hold kvs.mx
+func (kvs *kvSService) storeObject(key string, obj KvsObject, expires int64) bool {
+	kvs.dirty.Add(1)
+	return kvs.setObject(key, obj, expires)
+}
+
+// setObject is storeObject without counting a change, caller must hold
+// kvs.mx
+func (kvs *kvSService) setObject(key string, obj KvsObject, expires int64) bool {
+	entry := newKvsEntry(key, obj, expires)
+	prev, loaded := kvs.store.Swap(key, entry)
+	kvs.used.Add(entry.size)
+	if expires != 0 {
+		kvs.expires.Set(key, struct{}{})
+	} else {
+		kvs.expires.Delete(key)
+	}
+	if !loaded {
+		kvs.size++
+		kvs.keys.Set(key, struct{}{})
+		return true
+	}
+
+	prevEntry := prev.(*kvsEntry)
+	kvs.used.Add(-prevEntry.size)
+	if prevEntry.isExpired(time.Now()) {
+		return true
+	}
+	// like redis an overwritten key keeps its access frequency
+	entry.lfu.Store(prevEntry.lfu.Load())
+	return false
+}
+
+// removeObject deletes key reporting if it existed, caller must hold kvs.mx
+func (kvs *kvSService) removeObject(key string) bool {
+	prev, deleted := kvs.store.LoadAndDelete(key)
+	if !deleted {
+		return false
+	}
+	kvs.size--
+	kvs.used.Add(-prev.(*kvsEntry).size)
+	kvs.dirty.Add(1)
+	kvs.keys.Delete(key)
+	kvs.expires.Delete(key)
+	return true
+}
+
+// resizeObject updates the size of the collection at key after it was
+// changed in place, caller must hold kvs.mx
+func (kvs *kvSService) resizeObject(key string) {
+	if v, ok := kvs.store.Load(key); ok {
+		entry := v.(*kvsEntry)
+		size := KEY_OVERHEAD + int64(len(key)) + objectSize(entry.obj, 0)
+		kvs.used.Add(size - entry.size)
+		entry.size = size
+		kvs.dirty.Add(1)
+	}
+}
+
+func (kvs *kvSService) UsedMemory() int64 {
+	return kvs.used.Load()
+}
+
+func (kvs *kvSService) EvictionSample(count int, volatile bool) []evictionCandidate {
+	kvs.mx.Lock()
+	defer kvs.mx.Unlock()
+
+	keys := kvs.keys
+	if volatile {
+		keys = kvs.expires
+	}
+	now := time.Now()
+	candidates := make([]evictionCandidate, 0, count)
+	keys.Sample(count, func(key string, _ struct{}) {
+		v, ok := kvs.store.Load(key)
+		if !ok {
+			return
+		}
+		entry := v.(*kvsEntry)
+		candidates = append(candidates, evictionCandidate{
+			key:     key,
+			idle:    now.UnixMilli() - entry.access.Load(),
+			freq:    lfuDecrAndReturn(entry.lfu.Load(), now),
+			expires: entry.expires,
+		})
+	})
+	return candidates
+}
+
+func (kvs *kvSService) Inspect(k string) (objectInfo, bool) {
+	if _, ok := kvs.store.Load(k); !ok || kvs.expireIfNeeded(k) {
+		return objectInfo{}, false
+	}
+
+	kvs.mx.Lock()
+	defer kvs.mx.Unlock()
+	v, ok := kvs.store.Load(k)
+	if !ok {
+		return objectInfo{}, false
+	}
+	entry := v.(*kvsEntry)
+	now := time.Now()
+	str, ok := entry.obj.(KvsStringObject)
+	return objectInfo{
+		encoding: entry.obj.GetEncoding(),
+		idle:     now.Sub(time.UnixMilli(entry.access.Load())),
+		freq:     lfuDecrAndReturn(entry.lfu.Load(), now),
+		shared:   ok && str.isShared(),
+	}, true
+}
+
+func (kvs *kvSService) MemoryUsage(k string, samples int) (int64, bool) {
+	if _, ok := kvs.store.Load(k); !ok || kvs.expireIfNeeded(k) {
+		return 0, false
+	}
+
+	kvs.mx.Lock()
+	defer kvs.mx.Unlock()
+	obj, ok := kvs.load(k)
+	if !ok {
+		return 0, false
+	}
+	return KEY_OVERHEAD + int64(len(k)) + objectSize(obj, samples), true
+}
+
+func (kvs *kvSService) Evict(k string) bool {
+	kvs.mx.Lock()
+	evicted := kvs.removeObject(k)
+	if evicted {
+		kvs.propagate(kvs.id(), []string{strings.ToUpper(DEL), k})
+	}
+	kvs.mx.Unlock()
+
+	if evicted {
+		kvs.notifier.Notify(NOTIFY_EVICTED, "evicted", k, kvs.id())
+		kvs.tracking.InvalidateKeys(nil, []string{k})
+	}
+	return evicted
+}
+
+func (kvs *kvSService) Lock() {
+	kvs.mx.Lock()
+}
+
+func (kvs *kvSService) Unlock() {
+	kvs.mx.Unlock()
+}
+
+func (kvs *kvSService) Dump() []redisdb.RDBEntry {
+	entries := make([]redisdb.RDBEntry, 0, kvs.size)
+	now := time.Now()
+	kvs.store.Range(func(k, v any) bool {
+		entry := v.(*kvsEntry)
+		if entry.isExpired(now) {
+			return true
+		}
+		entries = append(entries, redisdb.RDBEntry{Key: k.(string), Value: toRDBValue(entry.obj), Expires: entry.expires})
+		return true
+	})
+	return entries
+}
+
+func (kvs *kvSService) Dirty() int64 {
+	return kvs.dirty.Load()
+}
+
+// formatScore renders a zset score like redis, with the shortest
+// representation that parses back to the same value
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
 func NewKvsOptionsWithTimestamp(timestamp uint64) KvsOptions {
 	return KvsOptions{timestamp: timestamp}
 }
 
-func (kvs *kvSService) stampObject(ko *KvsStringObject, ex time.Duration) {
-	created := time.Now()
-	expires := created.Add(ex * time.Millisecond)
+// KvsDatabases holds the numbered keyspaces clients switch between with
+// SELECT
+type KvsDatabases struct {
+	// held by a write command until it is propagated, the change and its
+	// place in the aof and the replication stream are one step
+	writes  sync.Mutex
+	mx      sync.RWMutex
+	dbs     []Kvs
+	newDB   func(db int) Kvs
+	evictor *Evictor
+	rdb     *RDBService
+	aof     *AOFService
+	// sends the propagated writes to the replicas of a master
+	replicate func(db int, args []string)
+	// changes of the databases flushed since the start
+	flushed atomic.Int64
+}
 
-	ko.created = &created
-	ko.expires = &expires
+func NewKvsDatabases(count int, newDB func(db int) Kvs) *KvsDatabases {
+	d := &KvsDatabases{newDB: newDB}
+	d.resize(count)
+	d.evictor = NewEvictor(d)
+	d.rdb = NewRDBService(d)
+	d.aof = NewAOFService(d)
+	return d
+}
 
+func (d *KvsDatabases) resize(count int) {
+	d.dbs = make([]Kvs, count)
+	for i := range d.dbs {
+		d.dbs[i] = d.create(i)
+	}
 }
 
-func convertTimestampToTime(timestamp int64) time.Time {
-	var t time.Time
-	if timestamp > 1e16 {
-		seconds := timestamp / 1e9
-		nanoseconds := timestamp % 1e9
-		t = time.Unix(seconds, nanoseconds)
-	} else {
-		// Assuming timestamp is in microseconds
-		seconds := timestamp / 1e6
-		nanoseconds := (timestamp % 1e6) * 1e3
-		t = time.Unix(seconds, nanoseconds)
+// create makes an empty database that propagates its own deletions
+func (d *KvsDatabases) create(db int) Kvs {
+	kvs := d.newDB(db)
+	kvs.SetPropagate(d.Propagate)
+	return kvs
+}
+
+// SetReplication sets where the writes are sent to the replicas, it is
+// set on startup by a master
+func (d *KvsDatabases) SetReplication(replicate func(db int, args []string)) {
+	d.replicate = replicate
+}
+
+// Propagate logs a write command run on the database db to the append
+// only file and sends it to the replicas
+func (d *KvsDatabases) Propagate(db int, args []string) {
+	d.aof.Append(db, args)
+	if d.replicate != nil {
+		d.replicate(db, args)
+	}
+}
+
+// SetCount is the databases config apply function, it is only used on
+// startup before any key is loaded
+func (d *KvsDatabases) SetCount(value string) (string, error) {
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 {
+		return "", fmt.Errorf("argument must be between 1 and 2147483647 inclusive")
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if count != len(d.dbs) {
+		d.resize(count)
+	}
+	return strconv.Itoa(count), nil
+}
+
+func (d *KvsDatabases) Len() int {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+	return len(d.dbs)
+}
+
+// Get returns the keyspace of a valid index
+func (d *KvsDatabases) Get(db int) Kvs {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+	return d.dbs[db]
+}
+
+func (d *KvsDatabases) Evictor() *Evictor {
+	return d.evictor
+}
+
+func (d *KvsDatabases) RDB() *RDBService {
+	return d.rdb
+}
+
+func (d *KvsDatabases) AOF() *AOFService {
+	return d.aof
+}
+
+// UsedMemory is the estimated size of the keys of every database
+func (d *KvsDatabases) UsedMemory() int64 {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+	used := int64(0)
+	for _, db := range d.dbs {
+		used += db.UsedMemory()
+	}
+	return used
+}
+
+// Swap exchanges the keys of two databases, clients keep their selected
+// index and see the other keys
+func (d *KvsDatabases) Swap(a, b int) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.dbs[a], d.dbs[b] = d.dbs[b], d.dbs[a]
+	d.dbs[a].SetDB(a)
+	d.dbs[b].SetDB(b)
+}
+
+// Flush replaces the database with an empty one, the old keys are freed by
+// the garbage collector so there is no difference between SYNC and ASYNC
+func (d *KvsDatabases) Flush(db int) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.retire(d.dbs[db])
+	d.dbs[db] = d.create(db)
+}
+
+func (d *KvsDatabases) FlushAll() {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	for _, db := range d.dbs {
+		d.retire(db)
+	}
+	d.resize(len(d.dbs))
+}
+
+// retire keeps the changes of a flushed database, every deleted key
+// counts as one more
+func (d *KvsDatabases) retire(db Kvs) {
+	d.flushed.Add(db.Dirty() + int64(db.Size()))
+}
+
+// Dirty is the number of changes made to every database since the start
+func (d *KvsDatabases) Dirty() int64 {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+	dirty := d.flushed.Load()
+	for _, db := range d.dbs {
+		dirty += db.Dirty()
+	}
+	return dirty
+}
+
+// LockWrites holds the write commands, each one holds it from its change
+// to its propagation
+func (d *KvsDatabases) LockWrites() {
+	d.writes.Lock()
+}
+
+func (d *KvsDatabases) UnlockWrites() {
+	d.writes.Unlock()
+}
+
+// Snapshot dumps every database holding the locks of all of them, so
+// the dump is a consistent point in time view of the keyspace
+func (d *KvsDatabases) Snapshot() [][]redisdb.RDBEntry {
+	snapshot, _ := d.snapshot(nil)
+	return snapshot
+}
+
+// SnapshotAt is Snapshot holding the writes too, at runs at the point of
+// the dump so the writes propagated before it are in the snapshot and the
+// ones after it are not. Nothing is dumped when at fails
+func (d *KvsDatabases) SnapshotAt(at func() error) ([][]redisdb.RDBEntry, error) {
+	d.LockWrites()
+	defer d.UnlockWrites()
+	return d.snapshot(at)
+}
+
+func (d *KvsDatabases) snapshot(at func() error) ([][]redisdb.RDBEntry, error) {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+	for _, db := range d.dbs {
+		db.Lock()
+	}
+	defer func() {
+		for _, db := range d.dbs {
+			db.Unlock()
+		}
+	}()
+	if at != nil {
+		if err := at(); err != nil {
+			return nil, err
+		}
+	}
+	snapshot := make([][]redisdb.RDBEntry, len(d.dbs))
+	for i, db := range d.dbs {
+		snapshot[i] = db.Dump()
+	}
+	return snapshot, nil
+}
+
+// Load adds the keys read from an rdb file to the databases skipping the
+// ones already expired, like redis loading notifies nothing and makes no
+// change to save
+func (d *KvsDatabases) Load(rr *redisdb.RDBReader) error {
+	count := d.Len()
+	for {
+		object, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if object.DB >= count {
+			return fmt.Errorf("FATAL: Data file was created with a Redis server configured to handle more than %d databases. Exiting", count)
+		}
+
+		if object.Expires != 0 && object.Expires <= time.Now().UnixMilli() {
+			continue
+		}
+		if obj := fromRDBValue(object.Value); obj != nil {
+			d.Get(object.DB).LoadObject(object.Key, obj, object.Expires)
+		}
+	}
+}
+
+// HandleActiveExpire removes expired keys that are never accessed again,
+// each cycle samples keys with an expiration in every database and repeats
+// while more than 25% of the sample was expired, until its time is over
+func (d *KvsDatabases) HandleActiveExpire() {
+	ticker := time.NewTicker(ACTIVE_EXPIRE_CYCLE_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.activeExpireCycle(time.Now().Add(ACTIVE_EXPIRE_CYCLE_TIME_LIMIT))
+	}
+}
+
+func (d *KvsDatabases) activeExpireCycle(deadline time.Time) {
+	for db := range d.Len() {
+		kvs := d.Get(db)
+		for {
+			if time.Now().After(deadline) {
+				return
+			}
+			sampled, expired := kvs.ActiveExpireCycle()
+			if sampled == 0 || expired*4 <= sampled {
+				break
+			}
+		}
 	}
-	return t
 }
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ActiveExpireCycle(t *testing.T) {
+	rs, ctx := newObjectTestService()
+	kvs := rs.db(ctx)
+	for i := range 1000 {
+		kvs.Set("persistent:"+strconv.Itoa(i), []byte("v"))
+	}
+	for i := range 50 {
+		kvs.SetWithOptions("volatile:"+strconv.Itoa(i), []byte("v"), KvsOptions{expires: 1})
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// only the keys with an expiration are sampled
+	sampled, expired := kvs.ActiveExpireCycle()
+	assert.LessOrEqual(t, sampled, 50)
+	assert.Greater(t, sampled, 0)
+	assert.Equal(t, sampled, expired)
+
+	// the cycle stops once its time is over
+	size := kvs.Size()
+	rs.dbs.activeExpireCycle(time.Now().Add(-time.Millisecond))
+	assert.Equal(t, size, kvs.Size())
+
+	rs.dbs.activeExpireCycle(time.Now().Add(time.Second))
+	assert.Equal(t, 1000, kvs.Size())
+	sampled, _ = kvs.ActiveExpireCycle()
+	assert.Equal(t, 0, sampled)
+}
+
+func Test_MoveKeepsExpiration(t *testing.T) {
+	rs, ctx := newObjectTestService()
+	src := rs.db(ctx)
+	dst := NewKvSService(NewKeyspaceNotifier(NewPubSubService()), rs.tracking, 1)
+	expires := time.Now().Add(time.Hour).UnixMilli()
+	assert.True(t, src.AddObject("h", newKvsHash(), expires))
+
+	assert.True(t, src.Move("h", dst))
+	entry, ok := dst.DumpKey("h")
+	assert.True(t, ok)
+	assert.Equal(t, expires, entry.Expires)
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+)
+
+const (
+	// estimated bytes of an element in a listpack and in an intset, the
+	// hash table ones take ELEMENT_OVERHEAD
+	LISTPACK_ELEMENT_OVERHEAD = 16
+	INTSET_ELEMENT_SIZE       = 8
+
+	// encoding limits, same defaults as redis
+	DEFAULT_LISTPACK_MAX_ENTRIES = 128
+	DEFAULT_LISTPACK_MAX_VALUE   = 64
+	DEFAULT_INTSET_MAX_ENTRIES   = 512
+)
+
+// limits of the compact encodings shared by every keyspace, set from the
+// config like the LFU tuning
+var (
+	hashMaxListpackEntries atomic.Int64
+	hashMaxListpackValue   atomic.Int64
+	setMaxIntsetEntries    atomic.Int64
+	setMaxListpackEntries  atomic.Int64
+	setMaxListpackValue    atomic.Int64
+	zsetMaxListpackEntries atomic.Int64
+	zsetMaxListpackValue   atomic.Int64
+)
+
+var encodingLimits = []struct {
+	name  string
+	value int64
+	limit *atomic.Int64
+}{
+	{info.CONFIG_HASH_MAX_LISTPACK_ENTRIES, DEFAULT_LISTPACK_MAX_ENTRIES, &hashMaxListpackEntries},
+	{info.CONFIG_HASH_MAX_LISTPACK_VALUE, DEFAULT_LISTPACK_MAX_VALUE, &hashMaxListpackValue},
+	{info.CONFIG_SET_MAX_INTSET_ENTRIES, DEFAULT_INTSET_MAX_ENTRIES, &setMaxIntsetEntries},
+	{info.CONFIG_SET_MAX_LISTPACK_ENTRIES, DEFAULT_LISTPACK_MAX_ENTRIES, &setMaxListpackEntries},
+	{info.CONFIG_SET_MAX_LISTPACK_VALUE, DEFAULT_LISTPACK_MAX_VALUE, &setMaxListpackValue},
+	{info.CONFIG_ZSET_MAX_LISTPACK_ENTRIES, DEFAULT_LISTPACK_MAX_ENTRIES, &zsetMaxListpackEntries},
+	{info.CONFIG_ZSET_MAX_LISTPACK_VALUE, DEFAULT_LISTPACK_MAX_VALUE, &zsetMaxListpackValue},
+}
+
+func init() {
+	for _, limit := range encodingLimits {
+		limit.limit.Store(limit.value)
+	}
+}
+
+// DefineEncodingConfigs registers the limits of the compact encodings,
+// they apply to the collections changed afterwards
+func DefineEncodingConfigs(config *info.Config) {
+	for _, limit := range encodingLimits {
+		limit := limit
+		config.Define(limit.name, strconv.FormatInt(limit.value, 10), func(value string) (string, error) {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return "", fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			if n < 0 {
+				return "", fmt.Errorf("argument must be between 0 and 9223372036854775807 inclusive")
+			}
+			limit.limit.Store(n)
+			return value, nil
+		})
+	}
+}
+
+// compactDict holds the elements of a collection in a listpack, a slice
+// searched linearly that saves the hash table overhead while the
+// collection is small, and moves them to a dict once it grows past the
+// listpack limits. Like in redis the conversion is never undone
+type compactDict[V any] struct {
+	listpack []dictEntry[V]
+	table    *dict[V]
+	// bytes of the keys and values, to estimate the memory used
+	payload   int64
+	valueSize func(value V) int64
+}
+
+func newCompactDict[V any](valueSize func(value V) int64) *compactDict[V] {
+	return &compactDict[V]{valueSize: valueSize}
+}
+
+func (c *compactDict[V]) Len() int {
+	if c.table != nil {
+		return c.table.Len()
+	}
+	return len(c.listpack)
+}
+
+func (c *compactDict[V]) IsListpack() bool {
+	return c.table == nil
+}
+
+func (c *compactDict[V]) Get(key string) (V, bool) {
+	if c.table != nil {
+		return c.table.Get(key)
+	}
+	for _, e := range c.listpack {
+		if e.key == key {
+			return e.value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// Set stores the value and reports if the key is new, the listpack is
+// converted when the element is not small or there are more than
+// maxEntries elements
+func (c *compactDict[V]) Set(key string, value V, small bool, maxEntries int64) bool {
+	prev, found := c.Get(key)
+	if found {
+		c.payload += c.valueSize(value) - c.valueSize(prev)
+	} else {
+		c.payload += int64(len(key)) + c.valueSize(value)
+	}
+
+	if c.table == nil && (!small || (!found && int64(len(c.listpack)) >= maxEntries)) {
+		c.convert()
+	}
+	if c.table != nil {
+		return c.table.Set(key, value)
+	}
+
+	for i := range c.listpack {
+		if c.listpack[i].key == key {
+			c.listpack[i].value = value
+			return false
+		}
+	}
+	c.listpack = append(c.listpack, dictEntry[V]{key: key, value: value})
+	return true
+}
+
+func (c *compactDict[V]) convert() {
+	c.table = newDict[V]()
+	for _, e := range c.listpack {
+		c.table.Set(e.key, e.value)
+	}
+	c.listpack = nil
+}
+
+// Scan visits about count elements from the cursor on like scanDict, a
+// listpack is visited at once
+func (c *compactDict[V]) Scan(cursor uint64, count int, visit func(key string, value V)) uint64 {
+	if c.table != nil {
+		return scanDict(c.table, cursor, count, visit)
+	}
+	for _, e := range c.listpack {
+		visit(e.key, e.value)
+	}
+	return 0
+}
+
+// Size estimates the bytes of the elements, the ones of a hash table are
+// extrapolated from the first samples elements when samples is not 0
+func (c *compactDict[V]) Size(samples int) int64 {
+	if c.table == nil {
+		return c.payload + int64(len(c.listpack))*LISTPACK_ELEMENT_OVERHEAD
+	}
+	size := int64(c.table.Len()) * ELEMENT_OVERHEAD
+	if samples == 0 {
+		return size + c.payload
+	}
+	payload, seen := int64(0), 0
+	for cursor := uint64(0); ; {
+		cursor = c.table.Scan(cursor, func(key string, value V) {
+			if seen < samples {
+				payload += int64(len(key)) + c.valueSize(value)
+				seen++
+			}
+		})
+		if cursor == 0 || seen >= samples {
+			break
+		}
+	}
+	return size + extrapolate(payload, seen, c.table.Len())
+}
+
+func stringSize(value string) int64 {
+	return int64(len(value))
+}
+
+func noSize(struct{}) int64 {
+	return 0
+}
+
+func scoreSize(float64) int64 {
+	return 8
+}
+
+// intset is the encoding of small sets of integers, kept sorted so a
+// member is found with a binary search
+type intset []int64
+
+func (s *intset) add(n int64) bool {
+	i, found := slices.BinarySearch(*s, n)
+	if found {
+		return false
+	}
+	*s = slices.Insert(*s, i, n)
+	return true
+}
+
+func newKvsHash() *KvsHash {
+	return &KvsHash{fields: newCompactDict(stringSize)}
+}
+
+func (h *KvsHash) set(field, value string) bool {
+	limit := hashMaxListpackValue.Load()
+	small := int64(len(field)) <= limit && int64(len(value)) <= limit
+	return h.fields.Set(field, value, small, hashMaxListpackEntries.Load())
+}
+
+// newKvsSet picks the encoding from the first member and the number of
+// members about to be added, like setTypeCreate
+func newKvsSet(first string, size int) *KvsSet {
+	if isInteger(first) && int64(size) <= setMaxIntsetEntries.Load() {
+		return &KvsSet{intset: intset{}}
+	}
+	set := &KvsSet{members: newCompactDict(noSize)}
+	if int64(size) > setMaxListpackEntries.Load() {
+		set.members.convert()
+	}
+	return set
+}
+
+func (set *KvsSet) add(member string) bool {
+	if set.members == nil {
+		if n, err := strconv.ParseInt(member, 10, 64); err == nil && isInteger(member) {
+			added := set.intset.add(n)
+			if int64(len(set.intset)) > setMaxIntsetEntries.Load() {
+				set.convertIntset(false)
+			}
+			return added
+		}
+		// a member that is not an integer ends the intset
+		set.convertIntset(int64(len(set.intset)) < setMaxListpackEntries.Load())
+	}
+	small := int64(len(member)) <= setMaxListpackValue.Load()
+	return set.members.Set(member, struct{}{}, small, setMaxListpackEntries.Load())
+}
+
+func (set *KvsSet) convertIntset(toListpack bool) {
+	set.members = newCompactDict(noSize)
+	for _, n := range set.intset {
+		set.members.Set(strconv.FormatInt(n, 10), struct{}{}, true, int64(len(set.intset)))
+	}
+	if !toListpack {
+		set.members.convert()
+	}
+	set.intset = nil
+}
+
+func (set *KvsSet) Len() int {
+	if set.members == nil {
+		return len(set.intset)
+	}
+	return set.members.Len()
+}
+
+func (set *KvsSet) Scan(cursor uint64, count int, visit func(member string)) uint64 {
+	if set.members == nil {
+		for _, n := range set.intset {
+			visit(strconv.FormatInt(n, 10))
+		}
+		return 0
+	}
+	return set.members.Scan(cursor, count, func(member string, _ struct{}) { visit(member) })
+}
+
+func (set *KvsSet) Size(samples int) int64 {
+	if set.members == nil {
+		return int64(len(set.intset)) * INTSET_ELEMENT_SIZE
+	}
+	return set.members.Size(samples)
+}
+
+func newKvsSortedSet() *KvsSortedSet {
+	return &KvsSortedSet{scores: newCompactDict(scoreSize)}
+}
+
+func (z *KvsSortedSet) add(member string, score float64) bool {
+	small := int64(len(member)) <= zsetMaxListpackValue.Load()
+	return z.scores.Set(member, score, small, zsetMaxListpackEntries.Load())
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CompactEncodings(t *testing.T) {
+
+	numbers := func(from, count int) []string {
+		members := make([]string, count)
+		for i := range members {
+			members[i] = strconv.Itoa(from + i)
+		}
+		return members
+	}
+
+	tests := []struct {
+		name     string
+		add      func(kvs Kvs)
+		expected string
+		len      int
+	}{
+		{
+			name:     "hash over the entries limit",
+			add:      func(kvs Kvs) { kvs.HSet("k", numbers(0, 2*(DEFAULT_LISTPACK_MAX_ENTRIES+1))) },
+			expected: ENCODING_HASHTABLE,
+			len:      DEFAULT_LISTPACK_MAX_ENTRIES + 1,
+		},
+		{
+			name: "hash at the entries limit",
+			add: func(kvs Kvs) {
+				kvs.HSet("k", numbers(0, 2*DEFAULT_LISTPACK_MAX_ENTRIES))
+				kvs.HSet("k", []string{"0", "overwritten"})
+			},
+			expected: ENCODING_LISTPACK,
+			len:      DEFAULT_LISTPACK_MAX_ENTRIES,
+		},
+		{
+			name:     "intset over the entries limit",
+			add:      func(kvs Kvs) { kvs.SAdd("k", numbers(0, DEFAULT_INTSET_MAX_ENTRIES+1)) },
+			expected: ENCODING_HASHTABLE,
+			len:      DEFAULT_INTSET_MAX_ENTRIES + 1,
+		},
+		{
+			name: "small intset with a string",
+			add: func(kvs Kvs) {
+				kvs.SAdd("k", []string{"3", "1", "2", "1"})
+				kvs.SAdd("k", []string{"a"})
+			},
+			expected: ENCODING_LISTPACK,
+			len:      4,
+		},
+		{
+			name: "big intset with a string",
+			add: func(kvs Kvs) {
+				kvs.SAdd("k", numbers(0, DEFAULT_LISTPACK_MAX_ENTRIES))
+				kvs.SAdd("k", []string{"a"})
+			},
+			expected: ENCODING_HASHTABLE,
+			len:      DEFAULT_LISTPACK_MAX_ENTRIES + 1,
+		},
+		{
+			name: "zset with a long member",
+			add: func(kvs Kvs) {
+				kvs.ZAdd("k", []float64{1, 2}, []string{"a", strings.Repeat("x", DEFAULT_LISTPACK_MAX_VALUE+1)})
+			},
+			expected: ENCODING_SKIPLIST,
+			len:      2,
+		},
+	}
+
+	for _, tc := range tests {
+		kvs := newEncodingTestKvs()
+		tc.add(kvs)
+
+		object, _ := kvs.Inspect("k")
+		assert.Equal(t, tc.expected, object.encoding, tc.name)
+
+		elements, cursor := []string{}, uint64(0)
+		for {
+			var page []string
+			page, cursor, _ = kvs.ScanElements("k", kvs.GetType("k"), cursor, 10)
+			elements = append(elements, page...)
+			if cursor == 0 {
+				break
+			}
+		}
+		if kvs.GetType("k") != "set" {
+			assert.Len(t, elements, 2*tc.len, tc.name)
+		} else {
+			assert.Len(t, elements, tc.len, tc.name)
+		}
+		// the tracked size matches the one of the final encoding
+		usage, _ := kvs.MemoryUsage("k", 0)
+		assert.Equal(t, kvs.UsedMemory(), usage, tc.name)
+	}
+}
+
+func Test_IntegerStrings(t *testing.T) {
+
+	kvs := newEncodingTestKvs()
+	kvs.Set("shared", []byte("42"))
+	kvs.Set("big", []byte("-9223372036854775808"))
+	kvs.Set("padded", []byte("042"))
+
+	for key, expected := range map[string]string{"shared": ENCODING_INT, "big": ENCODING_INT, "padded": ENCODING_EMBSTR} {
+		object, _ := kvs.Inspect(key)
+		assert.Equal(t, expected, object.encoding, key)
+		assert.Equal(t, key == "shared", object.shared, key)
+	}
+	value, _ := kvs.Get("big")
+	assert.Equal(t, "-9223372036854775808", string(value))
+	value, _ = kvs.Get("padded")
+	assert.Equal(t, "042", string(value))
+
+	n, err := kvs.IncrBy("shared", 9958)
+	assert.NoError(t, err)
+	assert.Equal(t, 10000, n)
+	object, _ := kvs.Inspect("shared")
+	assert.False(t, object.shared)
+	value, _ = kvs.Get("shared")
+	assert.Equal(t, "10000", string(value))
+
+	_, err = kvs.IncrBy("padded", 1)
+	assert.EqualError(t, err, "ERR value is not an integer or out of range")
+}
+
+func Test_EncodingConfigs(t *testing.T) {
+
+	config := info.NewConfig()
+	DefineEncodingConfigs(config)
+	defer func() {
+		for _, limit := range encodingLimits {
+			limit.limit.Store(limit.value)
+		}
+	}()
+
+	value, _ := config.Get(info.CONFIG_HASH_MAX_LISTPACK_ENTRIES)
+	assert.Equal(t, strconv.Itoa(DEFAULT_LISTPACK_MAX_ENTRIES), value)
+	assert.Error(t, config.Set(info.CONFIG_SET_MAX_INTSET_ENTRIES, "-1"))
+	assert.Error(t, config.Set(info.CONFIG_SET_MAX_INTSET_ENTRIES, "many"))
+	assert.NoError(t, config.Set(info.CONFIG_HASH_MAX_LISTPACK_ENTRIES, "1"))
+
+	kvs := newEncodingTestKvs()
+	kvs.HSet("k", []string{"f1", "v1", "f2", "v2"})
+	object, _ := kvs.Inspect("k")
+	assert.Equal(t, ENCODING_HASHTABLE, object.encoding)
+}
+
+func newEncodingTestKvs() Kvs {
+	pubsub := NewPubSubService()
+	return NewKvSService(NewKeyspaceNotifier(pubsub), NewTrackingService(NewClientRegistry(), pubsub), 0)
+}
+
+// heapPerKey reports the heap each of the b.N keys stored by fill takes
+func heapPerKey(b *testing.B, fill func(kvs Kvs, i int)) {
+	kvs := newEncodingTestKvs()
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := range b.N {
+		fill(kvs, i)
+	}
+	b.StopTimer()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/float64(b.N), "heap-B/key")
+	runtime.KeepAlive(kvs)
+}
+
+func BenchmarkStringEncoding(b *testing.B) {
+	for _, bc := range []struct {
+		name  string
+		value func(i int) []byte
+	}{
+		{name: "shared-int", value: func(i int) []byte { return []byte(strconv.Itoa(i % OBJ_SHARED_INTEGERS)) }},
+		{name: "int", value: func(i int) []byte { return []byte(strconv.Itoa(OBJ_SHARED_INTEGERS + i)) }},
+		{name: "embstr", value: func(i int) []byte { return []byte("v" + strconv.Itoa(OBJ_SHARED_INTEGERS+i)) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			heapPerKey(b, func(kvs Kvs, i int) { kvs.Set(strconv.Itoa(i), bc.value(i)) })
+		})
+	}
+}
+
+func BenchmarkCollectionEncoding(b *testing.B) {
+	fields := make([]string, 2*16)
+	members := make([]string, 16)
+	for i := range members {
+		members[i] = strconv.Itoa(i)
+		fields[2*i], fields[2*i+1] = "field"+members[i], "value"+members[i]
+	}
+
+	for _, bc := range []struct {
+		name string
+		// the hashtable cases disable the compact encodings
+		limits []*atomic.Int64
+		fill   func(kvs Kvs, key string)
+	}{
+		{name: "hash-listpack", fill: func(kvs Kvs, key string) { kvs.HSet(key, fields) }},
+		{name: "hash-hashtable", limits: []*atomic.Int64{&hashMaxListpackEntries}, fill: func(kvs Kvs, key string) { kvs.HSet(key, fields) }},
+		{name: "set-intset", fill: func(kvs Kvs, key string) { kvs.SAdd(key, members) }},
+		{name: "set-hashtable", limits: []*atomic.Int64{&setMaxIntsetEntries, &setMaxListpackEntries}, fill: func(kvs Kvs, key string) { kvs.SAdd(key, members) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for _, limit := range bc.limits {
+				defer limit.Store(limit.Load())
+				limit.Store(0)
+			}
+			heapPerKey(b, func(kvs Kvs, i int) { bc.fill(kvs, strconv.Itoa(i)) })
+		})
+	}
+}
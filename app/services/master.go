@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"io"
 	"log"
-	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/codecrafters-io/redis-starter-go/app/info"
@@ -14,38 +16,66 @@ import (
 
 type MasterService interface {
 	HandleEvents()
-	GetReplicationCmdChan() chan parser.CmdInfo
-	GetReplicaRegistrationChan() chan net.Conn
+	// Propagate sends a write run on the database db to the replicas
+	Propagate(db int, args []string)
+	// RegisterReplica adds a replica, it gets the writes propagated after
+	// the call
+	RegisterReplica(replica *Client)
 	GetAckEventChan() chan NotifyReplicationAck
 }
 
 type NotifyReplicationAck struct {
-	minimumNotifs   int
-	timeout         int
-	redisClientConn net.Conn
+	minimumNotifs int
+	timeout       int
+	client        *Client
+	// closed once the reply has been sent
+	done chan struct{}
+}
+
+// replicationEvent is a write propagated with the database it ran on, or
+// the registration of a replica
+type replicationEvent struct {
+	db      int
+	args    []string
+	replica *Client
+}
+
+// pendingWait is a WAIT counting the acks of the replicas until it has
+// enough of them or its timeout
+type pendingWait struct {
+	NotifyReplicationAck
+	acks  int
+	timer *time.Timer
 }
 
 type masterServiceImpl struct {
-	replicationEventChan    chan parser.CmdInfo
-	replicaRegistrationChan chan net.Conn
-	ackEventChan            chan NotifyReplicationAck
-	ackRepliedEventChan     chan struct{}
-	serverConnChan          chan net.Conn
-	replicaConns            []net.Conn
-	metrics                 *info.Metrics
-	replicationHappened     bool
+	// writes and registrations in the order they happened, the loop is
+	// woken up on eventsPending so Propagate never waits for it
+	mx                  sync.Mutex
+	events              []replicationEvent
+	eventsPending       chan struct{}
+	ackEventChan        chan NotifyReplicationAck
+	ackRepliedEventChan chan struct{}
+	waitTimeoutChan     chan *pendingWait
+	waits               []*pendingWait
+	replicas            []*Client
+	clients             *ClientRegistry
+	metrics             *info.Metrics
+	replicationHappened bool
+	// database selected in the replication stream
+	replDB int
 }
 
-func NewMasterService(metrics *info.Metrics, scc chan net.Conn) MasterService {
+func NewMasterService(metrics *info.Metrics, clients *ClientRegistry) MasterService {
 
 	return &masterServiceImpl{
-		replicationEventChan:    make(chan parser.CmdInfo),
-		replicaRegistrationChan: make(chan net.Conn),
-		ackEventChan:            make(chan NotifyReplicationAck),
-		ackRepliedEventChan:     make(chan struct{}),
-		serverConnChan:          scc,
-		replicaConns:            make([]net.Conn, 0, 2),
-		metrics:                 metrics,
+		eventsPending:       make(chan struct{}, 1),
+		ackEventChan:        make(chan NotifyReplicationAck),
+		ackRepliedEventChan: make(chan struct{}),
+		waitTimeoutChan:     make(chan *pendingWait),
+		replicas:            make([]*Client, 0, 2),
+		clients:             clients,
+		metrics:             metrics,
 	}
 }
 
@@ -53,32 +83,66 @@ func (m *masterServiceImpl) GetAckEventChan() chan NotifyReplicationAck {
 	return m.ackEventChan
 }
 
-func (m *masterServiceImpl) GetReplicationCmdChan() chan parser.CmdInfo {
-	return m.replicationEventChan
+func (m *masterServiceImpl) Propagate(db int, args []string) {
+	m.queue(replicationEvent{db: db, args: args})
+}
+
+func (m *masterServiceImpl) RegisterReplica(replica *Client) {
+	m.queue(replicationEvent{replica: replica})
 }
 
-func (m *masterServiceImpl) GetReplicaRegistrationChan() chan net.Conn {
-	return m.replicaRegistrationChan
+func (m *masterServiceImpl) queue(event replicationEvent) {
+	m.mx.Lock()
+	m.events = append(m.events, event)
+	m.mx.Unlock()
+	select {
+	case m.eventsPending <- struct{}{}:
+	default:
+	}
+}
+
+// sendEvents sends the writes propagated so far to the replicas
+// registered before them
+func (m *masterServiceImpl) sendEvents() {
+	m.mx.Lock()
+	events := m.events
+	m.events = nil
+	m.mx.Unlock()
+	for _, event := range events {
+		if event.replica != nil {
+			m.registerReplica(event.replica)
+			go m.handleReplicationConn(event.replica)
+			continue
+		}
+		m.handleReplicationEvents(event)
+	}
 }
 
 func (m *masterServiceImpl) HandleEvents() {
 
 	for {
 		select {
-		case cmd := <-m.replicationEventChan:
-			m.handleReplicationEvents(cmd)
-		case conn := <-m.replicaRegistrationChan:
-			m.registerReplica(conn)
-			go m.handleReplicationConn(conn)
+		case <-m.eventsPending:
+			m.sendEvents()
 		case ackEvent := <-m.ackEventChan:
+			// the GETACK follows the writes of the client waiting
+			m.sendEvents()
 			m.sendReplicationAck()
 			m.handleWaitCmd(ackEvent)
+		case <-m.ackRepliedEventChan:
+			m.handleAck()
+		case wait := <-m.waitTimeoutChan:
+			m.replyWait(wait)
 		}
 	}
 }
 
-func (m *masterServiceImpl) handleReplicationConn(conn net.Conn) {
+func (m *masterServiceImpl) handleReplicationConn(replica *Client) {
+
+	// replicas stay in the client registry until they disconnect
+	defer m.clients.Unregister(replica)
 
+	conn := replica.Conn()
 	log.Println("handling replication for", conn.RemoteAddr())
 	reader := bufio.NewReader(conn)
 	p := parser.NewParser(reader)
@@ -91,6 +155,8 @@ func (m *masterServiceImpl) handleReplicationConn(conn net.Conn) {
 				return
 			}
 			log.Println("replication parse error", err)
+			replica.Close()
+			return
 		}
 
 		switch incoming.(type) {
@@ -106,7 +172,7 @@ func (m *masterServiceImpl) handleReplicationConn(conn net.Conn) {
 						[]byte("ack"),
 						{'0'},
 					}
-					conn.Write(respencoding.EncodeArray(ackRply))
+					replica.Send(respencoding.EncodeArray(ackRply))
 				}
 
 				if cmdInfo.Args[0] == "ACK" {
@@ -121,32 +187,34 @@ func (m *masterServiceImpl) handleReplicationConn(conn net.Conn) {
 	}
 }
 
-func (m *masterServiceImpl) handleReplicationEvents(cmd parser.CmdInfo) {
+func (m *masterServiceImpl) handleReplicationEvents(event replicationEvent) {
 
-	cmdStrings := make([][]byte, 0, 3)
-
-	switch cmd.CmdName {
-
-	case SET:
-		cmdStrings = append(cmdStrings, []byte(cmd.CmdName))
-		cmdStrings = append(cmdStrings, []byte(cmd.Args[0]))
-		cmdStrings = append(cmdStrings, []byte(cmd.Args[1]))
+	var stream []byte
+	// the SELECT is only sent when the database changes
+	if event.db != m.replDB {
+		stream = encodeCommand(stream, []string{strings.ToUpper(SELECT), strconv.Itoa(event.db)})
+		m.replDB = event.db
 	}
+	stream = encodeCommand(stream, event.args)
 
 	size := 0
-	for _, conn := range m.replicaConns {
-		res := respencoding.EncodeArray(cmdStrings)
-		conn.Write(res)
-		size = len(res)
+	for _, replica := range m.replicas {
+		replica.SendReplication(stream)
+		size = len(stream)
 	}
 	m.metrics.AddToOffset(int64(size))
 	m.replicationHappened = true
 }
 
-func (m *masterServiceImpl) registerReplica(conn net.Conn) {
+func (m *masterServiceImpl) registerReplica(replica *Client) {
 
-	m.replicaConns = append(m.replicaConns, conn)
-	log.Printf("Connection from %s registered", conn.RemoteAddr())
+	m.replicas = append(m.replicas, replica)
+	// new replicas start on database 0, make sure the next command selects
+	// the right one
+	if m.replDB != 0 {
+		m.replDB = -1
+	}
+	log.Printf("Connection from %s registered", replica.Conn().RemoteAddr())
 	m.metrics.PlusReplicationCount()
 
 }
@@ -161,48 +229,54 @@ func (m *masterServiceImpl) sendReplicationAck() {
 
 	ack := respencoding.EncodeArray(replAck)
 	m.metrics.ResetReplicationCount()
-	for _, conn := range m.replicaConns {
-		log.Println("sending repl ack to", conn.RemoteAddr())
-		conn.Write(ack)
+	for _, replica := range m.replicas {
+		log.Println("sending repl ack to", replica.Conn().RemoteAddr())
+		replica.SendReplication(ack)
 	}
 
 	m.metrics.AddToOffset(int64(len(ack)))
 }
 
+// handleWaitCmd replies at once without writes to wait for, otherwise the
+// WAIT is pending until the acks it needs or its timeout
 func (m *masterServiceImpl) handleWaitCmd(n NotifyReplicationAck) {
 
 	if !m.replicationHappened {
+		n.client.Send(respencoding.EncodeInteger(len(m.replicas)))
+		close(n.done)
+		return
+	}
+	log.Println("handling wait", n)
+	wait := &pendingWait{NotifyReplicationAck: n}
+	m.waits = append(m.waits, wait)
+	wait.timer = time.AfterFunc(time.Duration(n.timeout)*time.Millisecond, func() {
+		m.waitTimeoutChan <- wait
+	})
+}
 
-		n.redisClientConn.Write(respencoding.EncodeInteger(len(m.replicaConns)))
-	} else {
-
-		timeoutChan := make(chan struct{})
-		totalEvents := 0
-		log.Println("handling wait", n)
-		go func() {
-			log.Println("before sleep")
-			time.Sleep(time.Duration(n.timeout) * time.Millisecond)
-			timeoutChan <- struct{}{}
-			log.Println("sleep done")
-		}()
-	WaitLoop:
-		for {
-			select {
-			case <-m.ackRepliedEventChan:
-				totalEvents++
-				log.Println("total", totalEvents >= n.minimumNotifs)
-				if totalEvents >= n.minimumNotifs {
-					log.Println("minimum replication achieved")
-					break WaitLoop
-				}
-			case <-timeoutChan:
-				log.Println("timeout reached")
-				break WaitLoop
-			}
+// handleAck counts an ack for every pending WAIT
+func (m *masterServiceImpl) handleAck() {
+	for _, wait := range append([]*pendingWait{}, m.waits...) {
+		wait.acks++
+		if wait.acks >= wait.minimumNotifs {
+			log.Println("minimum replication achieved")
+			m.replyWait(wait)
+		}
+	}
+}
+
+// replyWait sends the acks counted to a pending WAIT, it is no longer
+// pending after that
+func (m *masterServiceImpl) replyWait(wait *pendingWait) {
+	for i, pending := range m.waits {
+		if pending != wait {
+			continue
 		}
-		resp := respencoding.EncodeInteger(m.metrics.GetReplicationCount())
-		n.redisClientConn.Write(resp)
+		m.waits = append(m.waits[:i], m.waits[i+1:]...)
+		wait.timer.Stop()
+		wait.client.Send(respencoding.EncodeInteger(wait.acks))
+		log.Println("wait rep done to", wait.client.Conn().RemoteAddr())
+		close(wait.done)
+		return
 	}
-	log.Println("wait rep done to", n.redisClientConn.RemoteAddr())
-	m.serverConnChan <- n.redisClientConn
 }
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WaitDoesNotBlockPropagation(t *testing.T) {
+	clients := NewClientRegistry()
+	m := NewMasterService(info.NewMetrics(), clients)
+	go m.HandleEvents()
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+	replica := clients.Register(server, true)
+	replica.SetReplica()
+	m.RegisterReplica(replica)
+	stream := parser.NewParser(bufio.NewReader(conn))
+	next := func() []string {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		cmd, err := stream.ReadCommand()
+		assert.NoError(t, err)
+		return append([]string{cmd.CmdName}, cmd.Args...)
+	}
+
+	m.Propagate(0, []string{"SET", "a", "1"})
+	assert.Equal(t, []string{"set", "a", "1"}, next())
+
+	waiterServer, waiterConn := net.Pipe()
+	defer waiterConn.Close()
+	done := make(chan struct{})
+	m.GetAckEventChan() <- NotifyReplicationAck{minimumNotifs: 1, timeout: 5000, client: clients.Register(waiterServer, true), done: done}
+	assert.Equal(t, []string{"replconf", "GETACK", "*"}, next())
+
+	// the writes keep going to the replicas while WAIT is pending
+	m.Propagate(0, []string{"SET", "b", "2"})
+	assert.Equal(t, []string{"set", "b", "2"}, next())
+
+	go conn.Write([]byte("*3\r\n$8\r\nREPLCONF\r\n$3\r\nACK\r\n$2\r\n31\r\n"))
+	reply := make([]byte, 4)
+	waiterConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := waiterConn.Read(reply)
+	assert.NoError(t, err)
+	assert.Equal(t, ":1\r\n", string(reply))
+	<-done
+}
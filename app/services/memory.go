@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
+)
+
+const (
+	// elements MEMORY USAGE samples by default
+	MEMORY_USAGE_SAMPLES = 5
+
+	// MEMORY DOCTOR does not look into instances using less than this
+	MEMORY_DOCTOR_MIN_ALLOCATED = 5 * 1024 * 1024
+	// output buffers MEMORY DOCTOR considers too big, per client
+	MEMORY_DOCTOR_BIG_CLIENT_BUFFERS = 200 * 1024
+)
+
+type memoryStats struct {
+	peak        uint64
+	total       uint64
+	startup     uint64
+	active      uint64
+	resident    uint64
+	clients     int64
+	replicas    int64
+	keys        int
+	dataset     int64
+	normalCount int
+}
+
+// memoryStats reads the heap of the process and the estimated dataset
+// size, it records the peak seen so far
+func (rs *RedisService) memoryStats() memoryStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	stats := memoryStats{
+		total:    ms.HeapAlloc,
+		startup:  rs.startupAllocated,
+		active:   ms.HeapInuse,
+		resident: ms.Sys,
+		dataset:  rs.dbs.UsedMemory(),
+	}
+	for peak := rs.peakAllocated.Load(); peak < stats.total; peak = rs.peakAllocated.Load() {
+		if rs.peakAllocated.CompareAndSwap(peak, stats.total) {
+			break
+		}
+	}
+	stats.peak = rs.peakAllocated.Load()
+
+	for _, client := range rs.clients.All() {
+		if client.Class() == CLIENT_CLASS_REPLICA {
+			stats.replicas += int64(client.OutputBufferSize())
+		} else {
+			stats.clients += int64(client.OutputBufferSize())
+			stats.normalCount++
+		}
+	}
+	for db := range rs.dbs.Len() {
+		stats.keys += rs.dbs.Get(db).Size()
+	}
+	return stats
+}
+
+// memoryUsageCmd implements MEMORY USAGE key [SAMPLES count]
+func (rs *RedisService) memoryUsageCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	args := cmdInfo.Args[1:]
+	samples := MEMORY_USAGE_SAMPLES
+	if len(args) > 1 {
+		if len(args) != 3 || !strings.EqualFold(args[1], "SAMPLES") {
+			return respencoding.EncodeSimpleError("ERR syntax error"), false
+		}
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return respencoding.EncodeSimpleError("ERR value is not an integer or out of range"), false
+		}
+		if n < 0 {
+			return respencoding.EncodeSimpleError("ERR syntax error"), false
+		}
+		samples = n
+	}
+
+	usage, ok := rs.db(ctx).MemoryUsage(args[0], samples)
+	if !ok {
+		return respencoding.EncodeNull(clientProtocol(ctx)), false
+	}
+	return respencoding.EncodeInteger(int(usage)), false
+}
+
+func (rs *RedisService) memoryStatsCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	proto := clientProtocol(ctx)
+	stats := rs.memoryStats()
+	overhead := stats.total - min(stats.total, uint64(max(stats.dataset, 0)))
+	net := float64(stats.total) - float64(stats.startup)
+
+	pairs := [][]byte{}
+	integer := func(name string, value int64) {
+		pairs = append(pairs, respencoding.EncodeBulkString([]byte(name)), respencoding.EncodeInteger(int(value)))
+	}
+	double := func(name string, value float64) {
+		pairs = append(pairs, respencoding.EncodeBulkString([]byte(name)), respencoding.EncodeDouble(proto, value))
+	}
+	integer("peak.allocated", int64(stats.peak))
+	integer("total.allocated", int64(stats.total))
+	integer("startup.allocated", int64(stats.startup))
+	integer("clients.slaves", stats.replicas)
+	integer("clients.normal", stats.clients)
+	integer("overhead.total", int64(overhead))
+	integer("keys.count", int64(stats.keys))
+	if stats.keys > 0 {
+		integer("keys.bytes-per-key", int64(net)/int64(stats.keys))
+	} else {
+		integer("keys.bytes-per-key", 0)
+	}
+	integer("dataset.bytes", stats.dataset)
+	if net > 0 {
+		double("dataset.percentage", float64(stats.dataset)*100/net)
+	} else {
+		double("dataset.percentage", 0)
+	}
+	double("peak.percentage", float64(stats.total)*100/float64(max(stats.peak, 1)))
+	integer("allocator.allocated", int64(stats.total))
+	integer("allocator.active", int64(stats.active))
+	integer("allocator.resident", int64(stats.resident))
+	double("allocator.fragmentation.ratio", float64(stats.active)/float64(max(stats.total, 1)))
+	return respencoding.BuildMap(proto, pairs), false
+}
+
+// memoryDoctorCmd reports the memory issues found, with the words of the
+// redis doctor
+func (rs *RedisService) memoryDoctorCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	stats := rs.memoryStats()
+	var report string
+	switch issues := memoryIssues(stats); {
+	case stats.total < MEMORY_DOCTOR_MIN_ALLOCATED:
+		report = "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. " +
+			"Please, leave for your mission on Earth and fill it with some data. " +
+			"The new Sam and I will be back to our programming as soon as I finished rebooting."
+	case len(issues) == 0:
+		report = "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	default:
+		report = "Sam, I detected a few issues in this Redis instance memory implants:\n\n" +
+			strings.Join(issues, "") + "I'm here to keep you safe, Sam. I want to help you.\n"
+	}
+	return respencoding.EncodeVerbatimString(clientProtocol(ctx), "txt", []byte(report)), false
+}
+
+func memoryIssues(stats memoryStats) []string {
+	issues := []string{}
+	if stats.peak > stats.total*3/2 {
+		issues = append(issues, " * Peak memory: In the past this instance used more than 150% the memory that is currently using. "+
+			"The allocator is normally not able to release memory after a peak, so you can expect to see a big fragmentation ratio, "+
+			"however this is actually harmless and is only due to the memory peak, and if the Redis instance Resident Set Size (RSS) "+
+			"is currently bigger than expected, the memory will be used as soon as you fill the Redis instance with more data. "+
+			"If the memory peak was only occasional and you want to try to reclaim memory, please try the MEMORY PURGE command, "+
+			"otherwise the only other option is to shutdown and restart the instance.\n\n")
+	}
+	if stats.active > stats.total*14/10 {
+		issues = append(issues, fmt.Sprintf(" * High allocator fragmentation: This instance has an allocator fragmentation greater than 1.4 (%.2f). "+
+			"This problem is usually due either to a large peak memory (check if there is a peak memory entry above in the report) "+
+			"or may result from a workload that causes the allocator to fragment memory a lot. "+
+			"You may try the MEMORY PURGE command to reclaim memory.\n\n", float64(stats.active)/float64(stats.total)))
+	}
+	if stats.normalCount > 0 && stats.clients/int64(stats.normalCount) > MEMORY_DOCTOR_BIG_CLIENT_BUFFERS {
+		issues = append(issues, " * Big client buffers: The clients output buffers are in general too big, "+
+			"over 200k per client on average. This may result from different causes, like Pub/Sub clients subscribed "+
+			"to channels bot not receiving data fast enough, so that data piles on the Redis instance output buffer, "+
+			"or clients sending commands with large replies or very large sequences of commands in the same pipeline. "+
+			"Please use the CLIENT LIST command in order to investigate the issue if it causes problems in your instance, "+
+			"or to understand better why certain clients are using a big amount of memory.\n\n")
+	}
+	if stats.replicas > MEMORY_DOCTOR_BIG_CLIENT_BUFFERS*10 {
+		issues = append(issues, " * Big replica buffers: The replica output buffers are in general too big, "+
+			"over 2MB per replica on average. This likely means that there is some replica instance that is not receiving data fast enough. "+
+			"Please use the CLIENT LIST command in order to investigate the issue if it causes problems in your instance.\n\n")
+	}
+	return issues
+}
+
+// memoryPurgeCmd returns the freed heap to the operating system
+func (rs *RedisService) memoryPurgeCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	debug.FreeOSMemory()
+	return respencoding.EncodeSimpleString("OK"), false
+}
+
+func (rs *RedisService) memoryMallocStatsCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return respencoding.EncodeBulkString([]byte("Stats not supported for the current allocator")), false
+}
+
+func (rs *RedisService) memoryHelpCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return encodeHelp(MEMORY, []string{
+		"DOCTOR",
+		"    Return memory problems reports.",
+		"MALLOC-STATS",
+		"    Return internal statistics report from the memory allocator.",
+		"PURGE",
+		"    Attempt to purge dirty pages for reclamation by the allocator.",
+		"STATS",
+		"    Return information about the memory usage of the server.",
+		"USAGE <key> [SAMPLES <count>]",
+		"    Return memory in bytes used by <key> and its value. Nested values are",
+		"    sampled up to <count> times (default: 5, 0 means sample all).",
+	}), false
+}
+
+// objectSize estimates the bytes used by an object, the size of a
+// collection in a hash table is extrapolated from its first samples
+// elements, 0 samples all of them
+func objectSize(obj KvsObject, samples int) int64 {
+	switch o := obj.(type) {
+	case KvsStringObject:
+		return o.Size()
+	case KvsStream:
+		total, seen := int64(0), 0
+		for _, entry := range o.objects {
+			if samples > 0 && seen == samples {
+				break
+			}
+			total += ELEMENT_OVERHEAD
+			for k, v := range entry.data {
+				total += int64(len(k)) + int64(len(fmt.Sprint(v)))
+			}
+			seen++
+		}
+		return extrapolate(total, seen, len(o.objects))
+	case *KvsList:
+		total, seen := int64(0), 0
+		for _, element := range o.elements {
+			if samples > 0 && seen == samples {
+				break
+			}
+			total += ELEMENT_OVERHEAD + int64(len(element))
+			seen++
+		}
+		return extrapolate(total, seen, len(o.elements))
+	case *KvsHash:
+		return o.fields.Size(samples)
+	case *KvsSet:
+		return o.Size(samples)
+	case *KvsSortedSet:
+		return o.scores.Size(samples)
+	}
+	return 0
+}
+
+func extrapolate(total int64, seen, count int) int64 {
+	if seen == 0 {
+		return 0
+	}
+	return total * int64(count) / int64(seen)
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
+	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
+)
+
+// timeout of MIGRATE when it is not positive, in milliseconds
+const MIGRATE_DEFAULT_TIMEOUT = 1000
+
+func (rs *RedisService) dumpCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	entry, ok := rs.db(ctx).DumpKey(cmdInfo.Args[0])
+	if !ok {
+		return respencoding.EncodeNull(clientProtocol(ctx)), false
+	}
+	return respencoding.EncodeBulkString(redisdb.DumpValue(entry.Value)), false
+}
+
+// restoreCmd creates a key from a DUMP payload
+func (rs *RedisService) restoreCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	args := cmdInfo.Args
+	key := args[0]
+	ttl, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return respencoding.EncodeSimpleError("ERR value is not an integer or out of range"), false
+	}
+	if ttl < 0 {
+		return respencoding.EncodeSimpleError("ERR Invalid TTL value, must be >= 0"), false
+	}
+
+	replace, absttl := false, false
+	idle, freq := time.Duration(-1), -1
+	for i := 3; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch {
+		case option == "REPLACE":
+			replace = true
+		case option == "ABSTTL":
+			absttl = true
+		case option == "IDLETIME" && i+1 < len(args) && freq == -1:
+			i++
+			seconds, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return respencoding.EncodeSimpleError("ERR value is not an integer or out of range"), false
+			}
+			if seconds < 0 {
+				return respencoding.EncodeSimpleError("ERR Invalid IDLETIME value, must be >= 0"), false
+			}
+			idle = time.Duration(seconds) * time.Second
+		case option == "FREQ" && i+1 < len(args) && idle == -1:
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				return respencoding.EncodeSimpleError("ERR value is not an integer or out of range"), false
+			}
+			if n < 0 || n > LFU_MAX_FREQ {
+				return respencoding.EncodeSimpleError("ERR Invalid FREQ value, must be >= 0 and <= 255"), false
+			}
+			freq = n
+		default:
+			return respencoding.EncodeSimpleError("ERR syntax error"), false
+		}
+	}
+
+	db := rs.db(ctx)
+	if !replace && db.GetType(key) != "none" {
+		return respencoding.EncodeSimpleError("BUSYKEY Target key name already exists."), false
+	}
+	value, err := redisdb.RestoreValue([]byte(args[2]))
+	if err != nil {
+		return respencoding.EncodeSimpleError("ERR " + err.Error()), false
+	}
+	obj := fromRDBValue(value)
+	if obj == nil {
+		return respencoding.EncodeSimpleError("ERR Bad data format"), false
+	}
+
+	if ttl > 0 {
+		now := time.Now().UnixMilli()
+		if !absttl {
+			ttl += now
+		}
+		// the key is only deleted when it was going to be replaced
+		if ttl < now {
+			if replace {
+				db.Del(key)
+			}
+			return respencoding.EncodeSimpleString("OK"), false
+		}
+	}
+	if !db.Restore(key, obj, ttl, replace, idle, freq) {
+		return respencoding.EncodeSimpleError("BUSYKEY Target key name already exists."), false
+	}
+	return respencoding.EncodeSimpleString("OK"), false
+}
+
+// migrateKeys returns the key of MIGRATE, or the ones after KEYS when it
+// is empty. The arguments of AUTH and AUTH2 are skipped, a password can
+// be KEYS
+func migrateKeys(args []string) []string {
+	if args[2] != "" {
+		return args[2:3]
+	}
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			i++
+		case "AUTH2":
+			i += 2
+		case "KEYS":
+			return args[i+1:]
+		}
+	}
+	return nil
+}
+
+// migrateCmd sends the keys to another instance with RESTORE and deletes
+// them once the target restored them, unless COPY is given. The deletions
+// are written to the aof here as DEL, MIGRATE itself is not propagated
+func (rs *RedisService) migrateCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	args := cmdInfo.Args
+	dbid, err := strconv.Atoi(args[3])
+	if err != nil {
+		return respencoding.EncodeSimpleError("ERR value is not an integer or out of range"), false
+	}
+	timeout, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		return respencoding.EncodeSimpleError("ERR value is not an integer or out of range"), false
+	}
+	if timeout <= 0 {
+		timeout = MIGRATE_DEFAULT_TIMEOUT
+	}
+
+	copyKeys, replace := false, false
+	var auth []string
+	keys := args[2:3]
+	for i := 5; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch {
+		case option == "COPY":
+			copyKeys = true
+		case option == "REPLACE":
+			replace = true
+		case option == "AUTH" && i+1 < len(args):
+			auth = args[i+1 : i+2]
+			i++
+		case option == "AUTH2" && i+2 < len(args):
+			auth = args[i+1 : i+3]
+			i += 2
+		case option == "KEYS":
+			if args[2] != "" {
+				return respencoding.EncodeSimpleError("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string"), false
+			}
+			keys = args[i+1:]
+			i = len(args)
+		default:
+			return respencoding.EncodeSimpleError("ERR syntax error"), false
+		}
+	}
+
+	db := rs.db(ctx)
+	entries := make([]redisdb.RDBEntry, 0, len(keys))
+	for _, key := range keys {
+		if entry, ok := db.DumpKey(key); ok {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return respencoding.EncodeSimpleString("NOKEY"), false
+	}
+
+	deadline := time.Duration(timeout) * time.Millisecond
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(args[0], args[1]), deadline)
+	if err != nil {
+		return respencoding.EncodeSimpleError("IOERR error or timeout connecting to the client"), false
+	}
+	defer conn.Close()
+
+	// the commands are pipelined, their replies are read after
+	commands := make([][]string, 0, len(entries)+2)
+	if len(auth) > 0 {
+		commands = append(commands, append([]string{"AUTH"}, auth...))
+	}
+	commands = append(commands, []string{"SELECT", strconv.Itoa(dbid)})
+	now := time.Now().UnixMilli()
+	for _, entry := range entries {
+		ttl := int64(0)
+		if entry.Expires != 0 {
+			ttl = max(entry.Expires-now, 1)
+		}
+		restore := []string{"RESTORE", entry.Key, strconv.FormatInt(ttl, 10), string(redisdb.DumpValue(entry.Value))}
+		if replace {
+			restore = append(restore, "REPLACE")
+		}
+		commands = append(commands, restore)
+	}
+	var buf []byte
+	for _, command := range commands {
+		buf = encodeCommand(buf, command)
+	}
+	conn.SetDeadline(time.Now().Add(deadline))
+	if _, err := conn.Write(buf); err != nil {
+		return respencoding.EncodeSimpleError("IOERR error or timeout writing to target instance"), false
+	}
+
+	p := parser.NewParser(bufio.NewReader(conn))
+	// replies before the first RESTORE are the ones of AUTH and SELECT
+	first := len(commands) - len(entries)
+	migrated := make([]string, 0, len(entries))
+	targetErr, ioErr := "", false
+	for i := range commands {
+		conn.SetDeadline(time.Now().Add(deadline))
+		reply, err := p.ReadValue()
+		if err != nil {
+			ioErr = true
+			break
+		}
+		if reply.Type == parser.RESP_SIMPLE_ERROR {
+			targetErr = reply.String()
+			// no key is restored without the right database
+			if i < first {
+				return respencoding.EncodeSimpleError("ERR Target instance replied with error: " + targetErr), false
+			}
+			continue
+		}
+		if i >= first {
+			migrated = append(migrated, entries[i-first].Key)
+		}
+	}
+
+	if !copyKeys && len(migrated) > 0 {
+		deleted := make([]string, 0, len(migrated))
+		for _, key := range migrated {
+			if db.Del(key) {
+				deleted = append(deleted, key)
+			}
+		}
+		if len(deleted) > 0 {
+			rs.tracking.InvalidateKeys(nil, deleted)
+			rs.propagateWrite(append([]string{strings.ToUpper(DEL)}, deleted...), ctx)
+		}
+	}
+	switch {
+	case ioErr:
+		return respencoding.EncodeSimpleError("IOERR error or timeout reading to target instance"), false
+	case targetErr != "":
+		return respencoding.EncodeSimpleError("ERR Target instance replied with error: " + targetErr), false
+	}
+	return respencoding.EncodeSimpleString("OK"), false
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func execCommand(rs *RedisService, ctx context.Context, args ...string) string {
+	resp, _ := rs.executeCmd(&parser.CmdInfo{CmdName: strings.ToLower(args[0]), Args: args[1:]}, ctx)
+	return string(resp)
+}
+
+// dumpPayload runs DUMP and returns the payload of its bulk string reply
+func dumpPayload(t *testing.T, rs *RedisService, ctx context.Context, key string) string {
+	reply, err := parser.NewParser(bufio.NewReader(strings.NewReader(execCommand(rs, ctx, "DUMP", key)))).ReadValue()
+	assert.NoError(t, err)
+	return reply.String()
+}
+
+// newMigrateTarget serves a service that requires requirepass on a random
+// local port
+func newMigrateTarget(t *testing.T, requirepass string) (*RedisService, string, string) {
+	target, ctx := newObjectTestService()
+	config := info.NewConfig()
+	config.Define(info.CONFIG_REQUIREPASS, requirepass, nil)
+	ctx = context.WithValue(ctx, info.CTX_CONFIG, config)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go target.HandleConn(conn, ctx)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return target, host, port
+}
+
+func Test_DumpRestore(t *testing.T) {
+	rs, ctx := newObjectTestService()
+	execCommands(rs, ctx, []string{"SET", "s", "v"}, []string{"HSET", "h", "f", "v"}, []string{"SADD", "set", "1", "2"})
+
+	assert.Equal(t, "$-1\r\n", execCommand(rs, ctx, "DUMP", "missing"))
+	for _, key := range []string{"s", "h", "set"} {
+		payload := dumpPayload(t, rs, ctx, key)
+		assert.Equal(t, "BUSYKEY Target key name already exists.", strings.TrimSpace(execCommand(rs, ctx, "RESTORE", key, "0", payload)[1:]))
+		assert.Equal(t, "+OK\r\n", execCommand(rs, ctx, "RESTORE", key+"2", "0", payload))
+		assert.Equal(t, payload, dumpPayload(t, rs, ctx, key+"2"))
+		assert.Equal(t, "+OK\r\n", execCommand(rs, ctx, "RESTORE", key, "0", payload, "REPLACE"))
+	}
+
+	payload := dumpPayload(t, rs, ctx, "s")
+	assert.Equal(t, "+OK\r\n", execCommand(rs, ctx, "RESTORE", "ttl", "100000", payload))
+	entry, ok := rs.db(ctx).DumpKey("ttl")
+	assert.True(t, ok)
+	assert.InDelta(t, time.Now().UnixMilli()+100000, entry.Expires, 1000)
+
+	// every type keeps its expiration
+	assert.Equal(t, "+OK\r\n", execCommand(rs, ctx, "RESTORE", "httl", "100000", dumpPayload(t, rs, ctx, "h")))
+	entry, ok = rs.db(ctx).DumpKey("httl")
+	assert.True(t, ok)
+	assert.InDelta(t, time.Now().UnixMilli()+100000, entry.Expires, 1000)
+
+	// an expiration in the past only deletes the key it replaces
+	assert.Equal(t, "+OK\r\n", execCommand(rs, ctx, "RESTORE", "past", "1", payload, "ABSTTL"))
+	assert.Equal(t, "none", rs.db(ctx).GetType("past"))
+	assert.Equal(t, "+OK\r\n", execCommand(rs, ctx, "RESTORE", "ttl", "1", payload, "ABSTTL", "REPLACE"))
+	assert.Equal(t, "none", rs.db(ctx).GetType("ttl"))
+
+	assert.Equal(t, "+OK\r\n", execCommand(rs, ctx, "RESTORE", "idle", "0", payload, "IDLETIME", "1000"))
+	object, _ := rs.db(ctx).Inspect("idle")
+	assert.InDelta(t, 1000, object.idle.Seconds(), 1)
+	assert.Equal(t, "+OK\r\n", execCommand(rs, ctx, "RESTORE", "freq", "0", payload, "FREQ", "100"))
+	object, _ = rs.db(ctx).Inspect("freq")
+	assert.Equal(t, uint8(100), object.freq)
+
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{args: []string{"x", "-1", payload}, err: "ERR Invalid TTL value, must be >= 0"},
+		{args: []string{"x", "0", payload, "IDLETIME", "-1"}, err: "ERR Invalid IDLETIME value, must be >= 0"},
+		{args: []string{"x", "0", payload, "FREQ", "256"}, err: "ERR Invalid FREQ value, must be >= 0 and <= 255"},
+		{args: []string{"x", "0", payload, "FREQ", "1", "IDLETIME", "1"}, err: "ERR syntax error"},
+		{args: []string{"x", "0", payload, "NOPE"}, err: "ERR syntax error"},
+		{args: []string{"x", "0", payload[:len(payload)-1] + "x"}, err: "ERR DUMP payload version or checksum are wrong"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, "-"+tc.err+"\r\n", execCommand(rs, ctx, append([]string{"RESTORE"}, tc.args...)...), tc.args)
+	}
+}
+
+func Test_AbsoluteRestoreTTL(t *testing.T) {
+	assert.Equal(t, []string{"RESTORE", "k", "0", "p"}, absoluteRestoreTTL([]string{"RESTORE", "k", "0", "p"}))
+	assert.Equal(t, []string{"RESTORE", "k", "5", "p", "abSTTL"}, absoluteRestoreTTL([]string{"RESTORE", "k", "5", "p", "abSTTL"}))
+
+	args := absoluteRestoreTTL([]string{"RESTORE", "k", "1000", "p", "REPLACE"})
+	at, err := strconv.ParseInt(args[2], 10, 64)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Now().UnixMilli()+1000, at, 1000)
+	assert.Equal(t, []string{"p", "REPLACE", "ABSTTL"}, args[3:])
+}
+
+func Test_Migrate(t *testing.T) {
+	target, host, port := newMigrateTarget(t, "secret")
+	targetDB := target.dbs.Get(0)
+	rs, ctx := newObjectTestService()
+	execCommands(rs, ctx,
+		[]string{"SET", "a", "1", "PX", "100000"},
+		[]string{"SET", "b", "2"},
+		[]string{"HSET", "h", "f", "v"},
+	)
+	// key, db and timeout are followed by the password of the target
+	migrate := func(args ...string) string {
+		command := append([]string{"MIGRATE", host, port}, args[:3]...)
+		command = append(append(command, "AUTH", "secret"), args[3:]...)
+		return execCommand(rs, ctx, command...)
+	}
+
+	assert.Equal(t, "+NOKEY\r\n", migrate("missing", "0", "1000"))
+
+	assert.Equal(t, "+OK\r\n", migrate("a", "0", "1000"))
+	assert.Equal(t, "none", rs.db(ctx).GetType("a"))
+	entry, ok := targetDB.DumpKey("a")
+	assert.True(t, ok)
+	assert.InDelta(t, time.Now().UnixMilli()+100000, entry.Expires, 1000)
+
+	assert.Equal(t, "+OK\r\n", migrate("", "0", "1000", "COPY", "KEYS", "b", "h", "missing"))
+	assert.Equal(t, "string", rs.db(ctx).GetType("b"))
+	assert.Equal(t, "hash", targetDB.GetType("h"))
+
+	// the keys the target refused stay
+	assert.Equal(t, "-ERR Target instance replied with error: BUSYKEY Target key name already exists.\r\n", migrate("b", "0", "1000"))
+	assert.Equal(t, "string", rs.db(ctx).GetType("b"))
+	execCommands(rs, ctx, []string{"SET", "b", "3"})
+	assert.Equal(t, "+OK\r\n", migrate("b", "0", "1000", "REPLACE"))
+	value, _ := targetDB.Get("b")
+	assert.Equal(t, []byte("3"), value)
+
+	assert.Equal(t, "-ERR Target instance replied with error: WRONGPASS invalid username-password pair or user is disabled.\r\n",
+		migrate("h", "0", "1000", "AUTH2", "default", "wrong"))
+	assert.Equal(t, "hash", rs.db(ctx).GetType("h"))
+	assert.Equal(t, "-ERR Target instance replied with error: ERR DB index is out of range\r\n", migrate("h", "5", "1000"))
+
+	assert.Equal(t, "-ERR When using MIGRATE KEYS option, the key argument must be set to the empty string\r\n",
+		execCommand(rs, ctx, "MIGRATE", host, port, "h", "0", "1000", "KEYS", "h"))
+	assert.Equal(t, "-ERR syntax error\r\n", migrate("h", "0", "1000", "NOPE"))
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	_, closed, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	assert.Equal(t, "-IOERR error or timeout connecting to the client\r\n", execCommand(rs, ctx, "MIGRATE", host, closed, "h", "0", "1000"))
+}
+
+func Test_MigrateAOF(t *testing.T) {
+	_, host, port := newMigrateTarget(t, "")
+	rs, ctx := newAOFTestService(t, t.TempDir())
+	aof := rs.dbs.AOF()
+	assert.NoError(t, aof.Start())
+	execCommands(rs, ctx, []string{"SET", "a", "1"}, []string{"SET", "b", "2"})
+	assert.Equal(t, "+OK\r\n", execCommand(rs, ctx, "MIGRATE", host, port, "", "0", "1000", "KEYS", "a", "b"))
+	aof.Flush()
+
+	incr, err := os.ReadFile(filepath.Join(aof.Dir(), "appendonly.aof.1.incr.aof"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(incr), "MIGRATE")
+	assert.True(t, strings.HasSuffix(string(incr), "*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n"))
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// Keyspace notification classes, see notify-keyspace-events
+	NOTIFY_KEYSPACE = 1 << iota // K
+	NOTIFY_KEYEVENT             // E
+	NOTIFY_GENERIC              // g
+	NOTIFY_STRING               // $
+	NOTIFY_LIST                 // l
+	NOTIFY_SET                  // s
+	NOTIFY_HASH                 // h
+	NOTIFY_ZSET                 // z
+	NOTIFY_EXPIRED              // x
+	NOTIFY_EVICTED              // e
+	NOTIFY_STREAM               // t
+	NOTIFY_KEY_MISS             // m
+	NOTIFY_MODULE               // d
+	NOTIFY_NEW                  // n
+
+	// A
+	NOTIFY_ALL = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_LIST | NOTIFY_SET | NOTIFY_HASH |
+		NOTIFY_ZSET | NOTIFY_EXPIRED | NOTIFY_EVICTED | NOTIFY_STREAM | NOTIFY_MODULE
+
+	KEYSPACE_CHANNEL_PREFIX = "__keyspace@"
+	KEYEVENT_CHANNEL_PREFIX = "__keyevent@"
+)
+
+// order used to render the flags back to a string, A is handled apart
+var notifyClassChars = []struct {
+	class int
+	char  byte
+}{
+	{NOTIFY_GENERIC, 'g'},
+	{NOTIFY_STRING, '$'},
+	{NOTIFY_LIST, 'l'},
+	{NOTIFY_SET, 's'},
+	{NOTIFY_HASH, 'h'},
+	{NOTIFY_ZSET, 'z'},
+	{NOTIFY_EXPIRED, 'x'},
+	{NOTIFY_EVICTED, 'e'},
+	{NOTIFY_STREAM, 't'},
+	{NOTIFY_MODULE, 'd'},
+}
+
+// KeyspaceNotifier publishes keyspace and keyevent messages for the classes
+// enabled in notify-keyspace-events
+type KeyspaceNotifier struct {
+	flags  atomic.Int32
+	pubsub PubSubService
+}
+
+func NewKeyspaceNotifier(pubsub PubSubService) *KeyspaceNotifier {
+	return &KeyspaceNotifier{pubsub: pubsub}
+}
+
+// SetFlags parses a notify-keyspace-events string and returns it in its
+// canonical form, it is meant to be used as the config apply function
+func (n *KeyspaceNotifier) SetFlags(flags string) (string, error) {
+	parsed, err := parseNotifyFlags(flags)
+	if err != nil {
+		return "", err
+	}
+	n.flags.Store(int32(parsed))
+	return notifyFlagsToString(parsed), nil
+}
+
+func (n *KeyspaceNotifier) Flags() int {
+	return int(n.flags.Load())
+}
+
+// Notify publishes the event for the key if its class is enabled,
+// a nil notifier is valid and never publishes
+func (n *KeyspaceNotifier) Notify(class int, event, key string, dbId int) {
+	if n == nil {
+		return
+	}
+	flags := n.Flags()
+	if flags&class == 0 {
+		return
+	}
+
+	db := strconv.Itoa(dbId)
+	if flags&NOTIFY_KEYSPACE != 0 {
+		n.pubsub.Publish(KEYSPACE_CHANNEL_PREFIX+db+"__:"+key, []byte(event))
+	}
+	if flags&NOTIFY_KEYEVENT != 0 {
+		n.pubsub.Publish(KEYEVENT_CHANNEL_PREFIX+db+"__:"+event, []byte(key))
+	}
+}
+
+func parseNotifyFlags(flags string) (int, error) {
+	res := 0
+	for i := 0; i < len(flags); i++ {
+		switch flags[i] {
+		case 'A':
+			res |= NOTIFY_ALL
+		case 'g':
+			res |= NOTIFY_GENERIC
+		case '$':
+			res |= NOTIFY_STRING
+		case 'l':
+			res |= NOTIFY_LIST
+		case 's':
+			res |= NOTIFY_SET
+		case 'h':
+			res |= NOTIFY_HASH
+		case 'z':
+			res |= NOTIFY_ZSET
+		case 'x':
+			res |= NOTIFY_EXPIRED
+		case 'e':
+			res |= NOTIFY_EVICTED
+		case 't':
+			res |= NOTIFY_STREAM
+		case 'd':
+			res |= NOTIFY_MODULE
+		case 'm':
+			res |= NOTIFY_KEY_MISS
+		case 'n':
+			res |= NOTIFY_NEW
+		case 'K':
+			res |= NOTIFY_KEYSPACE
+		case 'E':
+			res |= NOTIFY_KEYEVENT
+		default:
+			return 0, fmt.Errorf("Invalid event class character. Use 'Ag$lshzxeKEtmdn'.")
+		}
+	}
+	return res, nil
+}
+
+func notifyFlagsToString(flags int) string {
+	var sb strings.Builder
+	if flags&NOTIFY_ALL == NOTIFY_ALL {
+		sb.WriteByte('A')
+	} else {
+		for _, c := range notifyClassChars {
+			if flags&c.class != 0 {
+				sb.WriteByte(c.char)
+			}
+		}
+	}
+	if flags&NOTIFY_KEYSPACE != 0 {
+		sb.WriteByte('K')
+	}
+	if flags&NOTIFY_KEYEVENT != 0 {
+		sb.WriteByte('E')
+	}
+	if flags&NOTIFY_KEY_MISS != 0 {
+		sb.WriteByte('m')
+	}
+	if flags&NOTIFY_NEW != 0 {
+		sb.WriteByte('n')
+	}
+	return sb.String()
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SetFlags(t *testing.T) {
+
+	tests := []struct {
+		input         string
+		expected      string
+		expectedError bool
+	}{
+		{input: "", expected: ""},
+		{input: "KEA", expected: "AKE"},
+		{input: "Ex", expected: "xE"},
+		{input: "g$lshzxetdK", expected: "AK"},
+		{input: "Kmn", expected: "Kmn"},
+		{input: "KEQ", expectedError: true},
+	}
+
+	for _, tc := range tests {
+		notifier := NewKeyspaceNotifier(NewPubSubService())
+		got, err := notifier.SetFlags(tc.input)
+
+		if tc.expectedError {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, got)
+	}
+}
+
+func Test_Notify(t *testing.T) {
+
+	tests := []struct {
+		flags    string
+		class    int
+		event    string
+		key      string
+		expected string
+	}{
+		{
+			flags:    "Ex",
+			class:    NOTIFY_EXPIRED,
+			event:    "expired",
+			key:      "session:1",
+			expected: "*3\r\n$7\r\nmessage\r\n$22\r\n__keyevent@0__:expired\r\n$9\r\nsession:1\r\n",
+		},
+		{
+			flags:    "K$",
+			class:    NOTIFY_STRING,
+			event:    "set",
+			key:      "foo",
+			expected: "*3\r\n$7\r\nmessage\r\n$18\r\n__keyspace@0__:foo\r\n$3\r\nset\r\n",
+		},
+		{
+			flags: "K$",
+			class: NOTIFY_GENERIC,
+			event: "del",
+			key:   "foo",
+		},
+	}
+
+	for _, tc := range tests {
+		pubsub := NewPubSubService()
+		notifier := NewKeyspaceNotifier(pubsub)
+		_, err := notifier.SetFlags(tc.flags)
+		assert.Nil(t, err)
+
+		server, client := net.Pipe()
+		subscriber := NewClientRegistry().Register(server, true)
+		pubsub.Subscribe(subscriber, "__keyevent@0__:"+tc.event)
+		pubsub.Subscribe(subscriber, "__keyspace@0__:"+tc.key)
+
+		received := make(chan string, 1)
+		go func() {
+			data, _ := io.ReadAll(client)
+			received <- string(data)
+		}()
+
+		notifier.Notify(tc.class, tc.event, tc.key, 0)
+		subscriber.Close()
+
+		assert.Equal(t, tc.expected, <-received)
+	}
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
+)
+
+const (
+	// object encodings reported by OBJECT ENCODING
+	ENCODING_INT       = "int"
+	ENCODING_EMBSTR    = "embstr"
+	ENCODING_RAW       = "raw"
+	ENCODING_LISTPACK  = "listpack"
+	ENCODING_INTSET    = "intset"
+	ENCODING_HASHTABLE = "hashtable"
+	ENCODING_SKIPLIST  = "skiplist"
+	ENCODING_STREAM    = "stream"
+	ENCODING_QUICKLIST = "quicklist"
+
+	// bytes of the elements of a list that fit a single listpack node,
+	// the default list-max-listpack-size of -2
+	LIST_MAX_LISTPACK_SIZE = 8192
+
+	// longest string stored along its object header
+	EMBSTR_SIZE_LIMIT = 44
+
+	// integers from 0 to OBJ_SHARED_INTEGERS-1 share their representation,
+	// OBJECT REFCOUNT reports them with OBJ_SHARED_REFCOUNT like redis
+	OBJ_SHARED_INTEGERS = 10000
+	OBJ_SHARED_REFCOUNT = math.MaxInt32
+)
+
+// sharedIntegers holds the bytes of the shared integers, so reading a
+// counter does not allocate
+var sharedIntegers = func() [][]byte {
+	shared := make([][]byte, OBJ_SHARED_INTEGERS)
+	for i := range shared {
+		shared[i] = []byte(strconv.Itoa(i))
+	}
+	return shared
+}()
+
+func (kvsString KvsStringObject) GetEncoding() string {
+	if kvsString.isInt {
+		return ENCODING_INT
+	}
+	if len(kvsString.data) <= EMBSTR_SIZE_LIMIT {
+		return ENCODING_EMBSTR
+	}
+	return ENCODING_RAW
+}
+
+func (kvsString KvsStringObject) isShared() bool {
+	return kvsString.isInt && kvsString.num >= 0 && kvsString.num < OBJ_SHARED_INTEGERS
+}
+
+func (h *KvsHash) GetEncoding() string {
+	if h.fields.IsListpack() {
+		return ENCODING_LISTPACK
+	}
+	return ENCODING_HASHTABLE
+}
+
+func (set *KvsSet) GetEncoding() string {
+	switch {
+	case set.members == nil:
+		return ENCODING_INTSET
+	case set.members.IsListpack():
+		return ENCODING_LISTPACK
+	}
+	return ENCODING_HASHTABLE
+}
+
+func (z *KvsSortedSet) GetEncoding() string {
+	if z.scores.IsListpack() {
+		return ENCODING_LISTPACK
+	}
+	return ENCODING_SKIPLIST
+}
+
+func (l *KvsList) GetEncoding() string {
+	size := 0
+	for _, element := range l.elements {
+		if size += len(element); size > LIST_MAX_LISTPACK_SIZE {
+			return ENCODING_QUICKLIST
+		}
+	}
+	return ENCODING_LISTPACK
+}
+
+func (kvsStream KvsStream) GetEncoding() string {
+	return ENCODING_STREAM
+}
+
+// isInteger reports if s is the canonical form of a 64 bits integer, like
+// the strings redis stores as numbers
+func isInteger(s string) bool {
+	_, ok := parseInteger(s)
+	return ok
+}
+
+func parseInteger(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil && strconv.FormatInt(n, 10) == s
+}
+
+func (rs *RedisService) objectEncodingCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	object, ok := rs.db(ctx).Inspect(cmdInfo.Args[1])
+	if !ok {
+		return respencoding.EncodeNull(clientProtocol(ctx)), false
+	}
+	return respencoding.EncodeBulkString([]byte(object.encoding)), false
+}
+
+func (rs *RedisService) objectFreqCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	object, ok := rs.db(ctx).Inspect(cmdInfo.Args[1])
+	if !ok {
+		return respencoding.EncodeNull(clientProtocol(ctx)), false
+	}
+	if !rs.dbs.Evictor().IsLFU() {
+		return respencoding.EncodeSimpleError("ERR An LFU maxmemory policy is not selected, access frequency not tracked. " +
+			"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."), false
+	}
+	return respencoding.EncodeInteger(int(object.freq)), false
+}
+
+func (rs *RedisService) objectIdletimeCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	object, ok := rs.db(ctx).Inspect(cmdInfo.Args[1])
+	if !ok {
+		return respencoding.EncodeNull(clientProtocol(ctx)), false
+	}
+	if rs.dbs.Evictor().IsLFU() {
+		return respencoding.EncodeSimpleError("ERR An LFU maxmemory policy is selected, idle time not tracked. " +
+			"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."), false
+	}
+	return respencoding.EncodeInteger(int(object.idle.Seconds())), false
+}
+
+// objectRefcountCmd replies 1 but for the shared integers, other values
+// are never shared between keys
+func (rs *RedisService) objectRefcountCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	object, ok := rs.db(ctx).Inspect(cmdInfo.Args[1])
+	if !ok {
+		return respencoding.EncodeNull(clientProtocol(ctx)), false
+	}
+	if object.shared {
+		return respencoding.EncodeInteger(OBJ_SHARED_REFCOUNT), false
+	}
+	return respencoding.EncodeInteger(1), false
+}
+
+func (rs *RedisService) objectHelpCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return encodeHelp(OBJECT, []string{
+		"ENCODING <key>",
+		"    Return the kind of internal representation used in order to store the value",
+		"    associated with a <key>.",
+		"FREQ <key>",
+		"    Return the access frequency index of the <key>. The returned integer is",
+		"    proportional to the logarithm of the recent access frequency of the key.",
+		"IDLETIME <key>",
+		"    Return the idle time of the <key>, that is the approximated number of",
+		"    seconds elapsed since the last access to the key.",
+		"REFCOUNT <key>",
+		"    Return the number of references of the value associated with the specified",
+		"    <key>.",
+	}), false
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ObjectEncoding(t *testing.T) {
+
+	tests := []struct {
+		command  []string
+		expected string
+	}{
+		{command: []string{"SET", "k", "12345"}, expected: ENCODING_INT},
+		{command: []string{"SET", "k", "012345"}, expected: ENCODING_EMBSTR},
+		{command: []string{"SET", "k", strings.Repeat("x", EMBSTR_SIZE_LIMIT+1)}, expected: ENCODING_RAW},
+		{command: []string{"HSET", "k", "f", "v"}, expected: ENCODING_LISTPACK},
+		{command: []string{"HSET", "k", "f", strings.Repeat("x", DEFAULT_LISTPACK_MAX_VALUE+1)}, expected: ENCODING_HASHTABLE},
+		{command: []string{"SADD", "k", "1", "2"}, expected: ENCODING_INTSET},
+		{command: []string{"SADD", "k", "1", "a"}, expected: ENCODING_LISTPACK},
+		{command: []string{"ZADD", "k", "1", "a"}, expected: ENCODING_LISTPACK},
+		{command: []string{"XADD", "k", "1-1", "f", "v"}, expected: ENCODING_STREAM},
+	}
+
+	for _, tc := range tests {
+		rs, ctx := newObjectTestService()
+		rs.executeCmd(&parser.CmdInfo{CmdName: strings.ToLower(tc.command[0]), Args: tc.command[1:]}, ctx)
+
+		got, _ := rs.executeCmd(&parser.CmdInfo{CmdName: OBJECT, Args: []string{"ENCODING", "k"}}, ctx)
+
+		assert.Equal(t, "$"+strconv.Itoa(len(tc.expected))+"\r\n"+tc.expected+"\r\n", string(got), strings.Join(tc.command, " "))
+	}
+}
+
+func Test_ObjectAndMemoryCommands(t *testing.T) {
+
+	tests := []struct {
+		command  []string
+		expected string
+	}{
+		{command: []string{"OBJECT", "REFCOUNT", "k"}, expected: ":1\r\n"},
+		{command: []string{"OBJECT", "REFCOUNT", "n"}, expected: ":" + strconv.Itoa(OBJ_SHARED_REFCOUNT) + "\r\n"},
+		{command: []string{"OBJECT", "IDLETIME", "k"}, expected: ":0\r\n"},
+		{command: []string{"OBJECT", "FREQ", "k"}, expected: "-ERR An LFU maxmemory policy is not selected"},
+		{command: []string{"OBJECT", "ENCODING", "missing"}, expected: "$-1\r\n"},
+		{command: []string{"MEMORY", "USAGE", "k"}, expected: ":" + strconv.Itoa(KEY_OVERHEAD+1+5) + "\r\n"},
+		{command: []string{"MEMORY", "USAGE", "h", "SAMPLES", "0"}, expected: ":" + strconv.Itoa(KEY_OVERHEAD+1+2*(LISTPACK_ELEMENT_OVERHEAD+4)) + "\r\n"},
+		{command: []string{"MEMORY", "USAGE", "h", "SAMPLES", "1"}, expected: ":" + strconv.Itoa(KEY_OVERHEAD+1+2*(LISTPACK_ELEMENT_OVERHEAD+4)) + "\r\n"},
+		{command: []string{"MEMORY", "USAGE", "n"}, expected: ":" + strconv.Itoa(KEY_OVERHEAD+1) + "\r\n"},
+		{command: []string{"MEMORY", "USAGE", "missing"}, expected: "$-1\r\n"},
+		{command: []string{"MEMORY", "USAGE", "k", "SAMPLES", "-1"}, expected: "-ERR syntax error\r\n"},
+		{command: []string{"MEMORY", "USAGE", "k", "SAMPLE", "1"}, expected: "-ERR syntax error\r\n"},
+		{command: []string{"MEMORY", "DOCTOR"}, expected: "$"},
+	}
+
+	rs, ctx := newObjectTestService()
+	for _, command := range [][]string{{"SET", "k", "value"}, {"SET", "n", "100"}, {"HSET", "h", "f1", "v1", "f2", "v2"}} {
+		rs.executeCmd(&parser.CmdInfo{CmdName: strings.ToLower(command[0]), Args: command[1:]}, ctx)
+	}
+
+	for _, tc := range tests {
+		got, _ := rs.executeCmd(&parser.CmdInfo{CmdName: strings.ToLower(tc.command[0]), Args: tc.command[1:]}, ctx)
+		assert.True(t, strings.HasPrefix(string(got), tc.expected), "%s: %q", strings.Join(tc.command, " "), got)
+	}
+
+	rs.dbs.Evictor().SetPolicy(MAXMEMORY_ALLKEYS_LFU)
+	got, _ := rs.executeCmd(&parser.CmdInfo{CmdName: OBJECT, Args: []string{"FREQ", "k"}}, ctx)
+	assert.Equal(t, ":"+strconv.Itoa(LFU_INIT_VAL)+"\r\n", string(got))
+}
+
+func newObjectTestService() (*RedisService, context.Context) {
+	clients := NewClientRegistry()
+	pubsub := NewPubSubService()
+	tracking := NewTrackingService(clients, pubsub)
+	notifier := NewKeyspaceNotifier(pubsub)
+	dbs := NewKvsDatabases(1, func(db int) Kvs { return NewKvSService(notifier, tracking, db) })
+	rs := NewRedisService(dbs, pubsub, clients, tracking, nil)
+	ctx := context.WithValue(context.Background(), info.CTX_SERVER_INFO, make(info.ServerInfo))
+	ctx = context.WithValue(ctx, info.CTX_CLIENT, clients.Register(nil, true))
+	return rs, ctx
+}
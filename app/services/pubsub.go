@@ -0,0 +1,223 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/app/glob"
+	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
+)
+
+type PubSubService interface {
+	Subscribe(c *Client, channel string) int
+	Unsubscribe(c *Client, channel string) int
+	PSubscribe(c *Client, pattern string) int
+	PUnsubscribe(c *Client, pattern string) int
+	Subscriptions(c *Client) (channels []string, patterns []string)
+	SubscriptionCount(c *Client) int
+	UnsubscribeAll(c *Client)
+	Publish(channel string, message []byte) int
+}
+
+type subscriptions struct {
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+func (s *subscriptions) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+type pubSubServiceImpl struct {
+	mx          sync.RWMutex
+	channels    map[string]map[*Client]struct{}
+	patterns    map[string]map[*Client]struct{}
+	subscribers map[*Client]*subscriptions
+}
+
+func NewPubSubService() PubSubService {
+	return &pubSubServiceImpl{
+		channels:    make(map[string]map[*Client]struct{}),
+		patterns:    make(map[string]map[*Client]struct{}),
+		subscribers: make(map[*Client]*subscriptions),
+	}
+}
+
+func (ps *pubSubServiceImpl) Subscribe(c *Client, channel string) int {
+	ps.mx.Lock()
+	defer ps.mx.Unlock()
+
+	subs := ps.getSubscriptions(c)
+	subs.channels[channel] = struct{}{}
+	addSubscriber(ps.channels, channel, c)
+	return subs.count()
+}
+
+func (ps *pubSubServiceImpl) Unsubscribe(c *Client, channel string) int {
+	ps.mx.Lock()
+	defer ps.mx.Unlock()
+
+	subs, ok := ps.subscribers[c]
+	if !ok {
+		return 0
+	}
+	delete(subs.channels, channel)
+	removeSubscriber(ps.channels, channel, c)
+	return ps.releaseSubscriptions(c, subs)
+}
+
+func (ps *pubSubServiceImpl) PSubscribe(c *Client, pattern string) int {
+	ps.mx.Lock()
+	defer ps.mx.Unlock()
+
+	subs := ps.getSubscriptions(c)
+	subs.patterns[pattern] = struct{}{}
+	addSubscriber(ps.patterns, pattern, c)
+	return subs.count()
+}
+
+func (ps *pubSubServiceImpl) PUnsubscribe(c *Client, pattern string) int {
+	ps.mx.Lock()
+	defer ps.mx.Unlock()
+
+	subs, ok := ps.subscribers[c]
+	if !ok {
+		return 0
+	}
+	delete(subs.patterns, pattern)
+	removeSubscriber(ps.patterns, pattern, c)
+	return ps.releaseSubscriptions(c, subs)
+}
+
+func (ps *pubSubServiceImpl) Subscriptions(c *Client) ([]string, []string) {
+	ps.mx.RLock()
+	defer ps.mx.RUnlock()
+
+	subs, ok := ps.subscribers[c]
+	if !ok {
+		return nil, nil
+	}
+	channels := make([]string, 0, len(subs.channels))
+	for channel := range subs.channels {
+		channels = append(channels, channel)
+	}
+	patterns := make([]string, 0, len(subs.patterns))
+	for pattern := range subs.patterns {
+		patterns = append(patterns, pattern)
+	}
+	return channels, patterns
+}
+
+func (ps *pubSubServiceImpl) SubscriptionCount(c *Client) int {
+	ps.mx.RLock()
+	defer ps.mx.RUnlock()
+
+	subs, ok := ps.subscribers[c]
+	if !ok {
+		return 0
+	}
+	return subs.count()
+}
+
+func (ps *pubSubServiceImpl) UnsubscribeAll(c *Client) {
+	ps.mx.Lock()
+	defer ps.mx.Unlock()
+
+	subs, ok := ps.subscribers[c]
+	if !ok {
+		return
+	}
+	for channel := range subs.channels {
+		removeSubscriber(ps.channels, channel, c)
+	}
+	for pattern := range subs.patterns {
+		removeSubscriber(ps.patterns, pattern, c)
+	}
+	delete(ps.subscribers, c)
+	c.subscribed.Store(false)
+}
+
+// Publish delivers the message to the channel subscribers and to the
+// subscribers of every matching pattern, returning the number of receivers.
+func (ps *pubSubServiceImpl) Publish(channel string, message []byte) int {
+	type delivery struct {
+		client   *Client
+		elements [][]byte
+	}
+
+	ps.mx.RLock()
+	deliveries := make([]delivery, 0, len(ps.channels[channel]))
+	if subscribers, ok := ps.channels[channel]; ok {
+		elements := [][]byte{
+			respencoding.EncodeBulkString([]byte("message")),
+			respencoding.EncodeBulkString([]byte(channel)),
+			respencoding.EncodeBulkString(message),
+		}
+		for c := range subscribers {
+			deliveries = append(deliveries, delivery{c, elements})
+		}
+	}
+	for pattern, subscribers := range ps.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+		elements := [][]byte{
+			respencoding.EncodeBulkString([]byte("pmessage")),
+			respencoding.EncodeBulkString([]byte(pattern)),
+			respencoding.EncodeBulkString([]byte(channel)),
+			respencoding.EncodeBulkString(message),
+		}
+		for c := range subscribers {
+			deliveries = append(deliveries, delivery{c, elements})
+		}
+	}
+	ps.mx.RUnlock()
+
+	// writes happen outside the lock so a slow subscriber does not block
+	// new subscriptions
+	for _, d := range deliveries {
+		d.client.Send(respencoding.BuildPush(d.client.Protocol(), d.elements))
+	}
+	return len(deliveries)
+}
+
+func (ps *pubSubServiceImpl) getSubscriptions(c *Client) *subscriptions {
+	subs, ok := ps.subscribers[c]
+	if !ok {
+		subs = &subscriptions{
+			channels: make(map[string]struct{}),
+			patterns: make(map[string]struct{}),
+		}
+		ps.subscribers[c] = subs
+		c.subscribed.Store(true)
+	}
+	return subs
+}
+
+func (ps *pubSubServiceImpl) releaseSubscriptions(c *Client, subs *subscriptions) int {
+	count := subs.count()
+	if count == 0 {
+		delete(ps.subscribers, c)
+		c.subscribed.Store(false)
+	}
+	return count
+}
+
+func addSubscriber(index map[string]map[*Client]struct{}, name string, c *Client) {
+	subscribers, ok := index[name]
+	if !ok {
+		subscribers = make(map[*Client]struct{})
+		index[name] = subscribers
+	}
+	subscribers[c] = struct{}{}
+}
+
+func removeSubscriber(index map[string]map[*Client]struct{}, name string, c *Client) {
+	subscribers, ok := index[name]
+	if !ok {
+		return
+	}
+	delete(subscribers, c)
+	if len(subscribers) == 0 {
+		delete(index, name)
+	}
+}
@@ -0,0 +1,688 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
+	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
+)
+
+const (
+	// save rules, same defaults as redis
+	DEFAULT_SAVE_PARAMS = "3600 1 300 100 60 10000"
+	DEFAULT_DIR         = "."
+	DEFAULT_DB_FILENAME = "dump.rdb"
+
+	// how often the save rules are checked
+	SAVE_RULES_INTERVAL = 100 * time.Millisecond
+	// a failed background save is retried by the rules after this delay
+	BGSAVE_RETRY_DELAY = 5 * time.Second
+
+	INFO_PERSISTENCE = "persistence"
+)
+
+// saveParam is a save rule, a snapshot is taken once there were changes
+// changes and seconds seconds passed since the last one
+type saveParam struct {
+	seconds int64
+	changes int64
+}
+
+// RDBService writes the databases to the rdb file, in the foreground with
+// SAVE and on shutdown, and in a goroutine with BGSAVE or when a save rule
+// is met
+type RDBService struct {
+	dbs        *KvsDatabases
+	dir        atomic.Pointer[string]
+	filename   atomic.Pointer[string]
+	saveParams atomic.Pointer[[]saveParam]
+
+	// one snapshot is written at a time
+	mx        sync.Mutex
+	saving    atomic.Bool
+	scheduled atomic.Bool
+	// unix seconds of the last successful save and the changes it saw
+	lastSave      atomic.Int64
+	lastSaveDirty atomic.Int64
+	// state of the last background save and unix seconds of its start
+	lastBgsaveOK    atomic.Bool
+	lastBgsaveStart atomic.Int64
+	lastBgsaveTime  atomic.Int64
+
+	// progress of the file loaded on startup, commands without the
+	// loading flag are rejected meanwhile
+	loading       atomic.Bool
+	loadingStart  atomic.Int64
+	loadingTotal  atomic.Int64
+	loadingLoaded atomic.Int64
+
+	// closed by SHUTDOWN ABORT, nil when no shutdown is in progress
+	shutdownMx    sync.Mutex
+	shutdownAbort chan struct{}
+}
+
+func NewRDBService(dbs *KvsDatabases) *RDBService {
+	r := &RDBService{dbs: dbs}
+	dir, filename := DEFAULT_DIR, DEFAULT_DB_FILENAME
+	r.dir.Store(&dir)
+	r.filename.Store(&filename)
+	r.SetSaveParams(DEFAULT_SAVE_PARAMS)
+	r.lastSave.Store(time.Now().Unix())
+	r.lastBgsaveOK.Store(true)
+	r.lastBgsaveTime.Store(-1)
+	return r
+}
+
+// SetDir is the dir config apply function
+func (r *RDBService) SetDir(value string) (string, error) {
+	if stat, err := os.Stat(value); err != nil || !stat.IsDir() {
+		return "", fmt.Errorf("No such file or directory")
+	}
+	r.dir.Store(&value)
+	return value, nil
+}
+
+// SetFilename is the dbfilename config apply function
+func (r *RDBService) SetFilename(value string) (string, error) {
+	if value == "" || filepath.Base(value) != value {
+		return "", fmt.Errorf("dbfilename can't be a path, just a filename")
+	}
+	r.filename.Store(&value)
+	return value, nil
+}
+
+// SetSaveParams is the save config apply function, it takes pairs of
+// seconds and changes, an empty value disables the rules
+func (r *RDBService) SetSaveParams(value string) (string, error) {
+	args := strings.Fields(value)
+	if len(args)%2 != 0 {
+		return "", fmt.Errorf("Invalid save parameters")
+	}
+	params := make([]saveParam, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		seconds, err := strconv.ParseInt(args[i], 10, 64)
+		changes, err2 := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return "", fmt.Errorf("Invalid save parameters")
+		}
+		params = append(params, saveParam{seconds: seconds, changes: changes})
+	}
+	r.saveParams.Store(&params)
+	return strings.Join(args, " "), nil
+}
+
+// Path is where the rdb file is written and loaded from
+func (r *RDBService) Path() string {
+	return filepath.Join(*r.dir.Load(), *r.filename.Load())
+}
+
+func (r *RDBService) LastSave() int64 {
+	return r.lastSave.Load()
+}
+
+func (r *RDBService) InProgress() bool {
+	return r.saving.Load()
+}
+
+// Save writes the snapshot blocking until it is on disk
+func (r *RDBService) Save() error {
+	if r.saving.Load() {
+		return fmt.Errorf("ERR Background save already in progress")
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	dirty := r.dbs.Dirty()
+	if err := r.write(r.dbs.Snapshot()); err != nil {
+		log.Println("Error saving DB on disk:", err)
+		return err
+	}
+	r.saved(dirty)
+	log.Println("DB saved on disk")
+	return nil
+}
+
+// BackgroundSave takes the snapshot of the databases and writes it in a
+// goroutine, the keys can change meanwhile
+func (r *RDBService) BackgroundSave() error {
+	if !r.saving.CompareAndSwap(false, true) {
+		return fmt.Errorf("ERR Background save already in progress")
+	}
+	r.mx.Lock()
+	start := time.Now()
+	r.lastBgsaveStart.Store(start.Unix())
+	dirty := r.dbs.Dirty()
+	snapshot := r.dbs.Snapshot()
+	log.Println("Background saving started")
+
+	go func() {
+		defer r.mx.Unlock()
+		defer r.saving.Store(false)
+		err := r.write(snapshot)
+		r.lastBgsaveOK.Store(err == nil)
+		r.lastBgsaveTime.Store(int64(time.Since(start).Seconds()))
+		if err != nil {
+			log.Println("Background saving error:", err)
+			return
+		}
+		r.saved(dirty)
+		log.Println("Background saving terminated with success")
+	}()
+	return nil
+}
+
+// saved records a successful snapshot that saw dirty changes
+func (r *RDBService) saved(dirty int64) {
+	r.lastSave.Store(time.Now().Unix())
+	r.lastSaveDirty.Store(dirty)
+}
+
+// write stores the snapshot in a temporary file renamed over the rdb
+// file once it is complete, so the file is never left half written
+func (r *RDBService) write(snapshot [][]redisdb.RDBEntry) error {
+	path := r.Path()
+	file, err := os.CreateTemp(filepath.Dir(path), "temp-*.rdb")
+	if err != nil {
+		return fmt.Errorf("failed opening the temp RDB file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	err = r.encode(file, snapshot, false)
+	if err == nil {
+		// temp files are private, the rdb file is readable like redis' one
+		err = file.Chmod(0644)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write error saving DB on disk: %w", err)
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// encode writes the snapshot with the aux fields of redis, aofBase marks
+// the preamble of an append only file
+func (r *RDBService) encode(out io.Writer, snapshot [][]redisdb.RDBEntry, aofBase bool) error {
+	w := redisdb.NewRDBWriter(out)
+	w.WriteAux(redisdb.REDIS_VERSION, info.REDIS_VERSION)
+	w.WriteAux(redisdb.REDIS_BITS, strconv.Itoa(strconv.IntSize))
+	w.WriteAux(redisdb.CREATE_TIME, strconv.FormatInt(time.Now().Unix(), 10))
+	w.WriteAux(redisdb.USED_MEM, strconv.FormatInt(r.dbs.UsedMemory(), 10))
+	w.WriteAux(redisdb.AOF_BASE, strconv.Itoa(boolToInt(aofBase)))
+	for db, entries := range snapshot {
+		w.WriteDB(db, entries)
+	}
+	return w.Close()
+}
+
+// HandleSaveRules starts a background save whenever a save rule is met,
+// after a failure it waits BGSAVE_RETRY_DELAY before trying again
+func (r *RDBService) HandleSaveRules() {
+	ticker := time.NewTicker(SAVE_RULES_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if r.saving.Load() {
+			continue
+		}
+		now := time.Now().Unix()
+		if r.scheduled.Swap(false) || r.ruleMet(now) {
+			if err := r.BackgroundSave(); err != nil {
+				log.Println("Background save not started:", err)
+			}
+		}
+	}
+}
+
+func (r *RDBService) ruleMet(now int64) bool {
+	changes := r.dbs.Dirty() - r.lastSaveDirty.Load()
+	if !r.lastBgsaveOK.Load() && now-r.lastBgsaveStart.Load() <= int64(BGSAVE_RETRY_DELAY.Seconds()) {
+		return false
+	}
+	for _, param := range *r.saveParams.Load() {
+		if changes >= param.changes && changes > 0 && now-r.lastSave.Load() >= param.seconds {
+			log.Printf("%d changes in %d seconds. Saving...", param.changes, param.seconds)
+			return true
+		}
+	}
+	return false
+}
+
+// Shutdown saves the snapshot before the server exits, when save rules
+// are set or mode is SAVE, NOSAVE skips it. With force the errors of the
+// save and of the aof fsync do not prevent the exit. It can be aborted
+// while it waits for a background save
+func (r *RDBService) Shutdown(mode string, force bool) error {
+	log.Println("User requested shutdown...")
+	abort := r.startShutdown()
+	defer r.endShutdown(abort)
+
+	if r.dbs.AOF().Enabled() {
+		log.Println("Calling fsync() on the AOF file.")
+		if err := r.dbs.AOF().Flush(); err != nil {
+			log.Println("Error trying to fsync the AOF file:", err)
+			if !force {
+				return err
+			}
+		}
+	}
+	save := len(*r.saveParams.Load()) > 0
+	switch strings.ToLower(mode) {
+	case "save":
+		save = true
+	case "nosave":
+		save = false
+	}
+	if !save {
+		return nil
+	}
+	log.Println("Saving the final RDB snapshot before exiting.")
+	// a background save in progress is waited for and then replaced
+	for r.saving.Load() {
+		select {
+		case <-abort:
+			log.Println("Shutdown aborted")
+			return fmt.Errorf("shutdown aborted")
+		case <-time.After(SAVE_RULES_INTERVAL):
+		}
+	}
+	if err := r.Save(); err != nil {
+		if !force {
+			return err
+		}
+		log.Println("Error trying to save the DB, exiting anyway.")
+	}
+	return nil
+}
+
+// AbortShutdown cancels the shutdown in progress, it tells whether there
+// was one
+func (r *RDBService) AbortShutdown() bool {
+	r.shutdownMx.Lock()
+	defer r.shutdownMx.Unlock()
+	if r.shutdownAbort == nil {
+		return false
+	}
+	close(r.shutdownAbort)
+	r.shutdownAbort = nil
+	return true
+}
+
+// startShutdown returns the channel closed by AbortShutdown, concurrent
+// shutdowns share it
+func (r *RDBService) startShutdown() chan struct{} {
+	r.shutdownMx.Lock()
+	defer r.shutdownMx.Unlock()
+	if r.shutdownAbort == nil {
+		r.shutdownAbort = make(chan struct{})
+	}
+	return r.shutdownAbort
+}
+
+func (r *RDBService) endShutdown(abort chan struct{}) {
+	r.shutdownMx.Lock()
+	defer r.shutdownMx.Unlock()
+	if r.shutdownAbort == abort {
+		r.shutdownAbort = nil
+	}
+}
+
+// Load reads the rdb file into the databases a key at a time, a missing
+// file is an empty dataset. The keys loaded are not changes to be saved
+func (r *RDBService) Load() error {
+	file, err := os.Open(r.Path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return r.LoadFrom(file, stat.Size())
+}
+
+// LoadFrom adds the keys of the size bytes of rdb read from reader to the
+// databases, reporting the progress like the load of the file on startup
+func (r *RDBService) LoadFrom(reader io.Reader, size int64) error {
+	r.startLoading(size)
+	defer r.stopLoading()
+	rr, err := redisdb.NewRDBReader(r.loadingReader(reader))
+	if err == nil {
+		err = r.dbs.Load(rr)
+	}
+	if err != nil {
+		return err
+	}
+	r.resetDirty()
+	return nil
+}
+
+// Dump writes a snapshot of the databases in the rdb format to out, it is
+// what a full resync sends to a replica. at runs at the point of the
+// snapshot like with SnapshotAt
+func (r *RDBService) Dump(out io.Writer, at func() error) error {
+	snapshot, err := r.dbs.SnapshotAt(at)
+	if err != nil {
+		return err
+	}
+	return r.encode(out, snapshot, false)
+}
+
+// startLoading reports a load of size bytes in progress
+func (r *RDBService) startLoading(size int64) {
+	r.loadingStart.Store(time.Now().Unix())
+	r.loadingTotal.Store(size)
+	r.loadingLoaded.Store(0)
+	r.loading.Store(true)
+}
+
+// loadingReader counts the bytes read from reader as the ones loaded
+func (r *RDBService) loadingReader(reader io.Reader) io.Reader {
+	return &countingReader{r: reader, n: &r.loadingLoaded}
+}
+
+func (r *RDBService) stopLoading() {
+	r.loading.Store(false)
+}
+
+// resetDirty makes the current keys the saved ones, after they were
+// loaded from disk
+func (r *RDBService) resetDirty() {
+	r.lastSaveDirty.Store(r.dbs.Dirty())
+}
+
+// Loading reports if the rdb file is being loaded
+func (r *RDBService) Loading() bool {
+	return r.loading.Load()
+}
+
+// countingReader adds the bytes read to n
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// LoadingInfo is the loading part of INFO persistence, the progress is
+// only reported while loading
+func (r *RDBService) LoadingInfo() (status, progress []string) {
+	loading := r.loading.Load()
+	status = []string{"loading:" + strconv.Itoa(boolToInt(loading)), "async_loading:0"}
+	if !loading {
+		return status, nil
+	}
+	total, loaded := r.loadingTotal.Load(), r.loadingLoaded.Load()
+	elapsed := time.Now().Unix() - r.loadingStart.Load()
+	perc, eta := float64(0), int64(1)
+	if total > 0 {
+		perc = float64(loaded) * 100 / float64(total)
+	}
+	if loaded > 0 {
+		eta = elapsed * (total - loaded) / loaded
+	}
+	return status, []string{
+		"loading_start_time:" + strconv.FormatInt(r.loadingStart.Load(), 10),
+		"loading_total_bytes:" + strconv.FormatInt(total, 10),
+		"loading_loaded_bytes:" + strconv.FormatInt(loaded, 10),
+		"loading_loaded_perc:" + strconv.FormatFloat(perc, 'f', 2, 64),
+		"loading_eta_seconds:" + strconv.FormatInt(eta, 10),
+	}
+}
+
+// Info is the rdb part of INFO persistence
+func (r *RDBService) Info() []string {
+	status := "ok"
+	if !r.lastBgsaveOK.Load() {
+		status = "err"
+	}
+	current := int64(-1)
+	if r.saving.Load() {
+		current = time.Now().Unix() - r.lastBgsaveStart.Load()
+	}
+	return []string{
+		"rdb_changes_since_last_save:" + strconv.FormatInt(r.dbs.Dirty()-r.lastSaveDirty.Load(), 10),
+		"rdb_bgsave_in_progress:" + strconv.Itoa(boolToInt(r.saving.Load())),
+		"rdb_last_save_time:" + strconv.FormatInt(r.lastSave.Load(), 10),
+		"rdb_last_bgsave_status:" + status,
+		"rdb_last_bgsave_time_sec:" + strconv.FormatInt(r.lastBgsaveTime.Load(), 10),
+		"rdb_current_bgsave_time_sec:" + strconv.FormatInt(current, 10),
+	}
+}
+
+// toRDBValue copies the value of an object, caller must hold the lock of
+// its keyspace
+func toRDBValue(obj KvsObject) redisdb.RDBValue {
+	switch o := obj.(type) {
+	case KvsStringObject:
+		return redisdb.RDBString(o.Bytes())
+	case *KvsList:
+		return redisdb.RDBList(slices.Clone(o.elements))
+	case *KvsHash:
+		hash := make(redisdb.RDBHash, 0, o.fields.Len())
+		o.fields.each(func(field string, value string) {
+			hash = append(hash, redisdb.RDBField{Field: field, Value: value})
+		})
+		return hash
+	case *KvsSet:
+		set := make(redisdb.RDBSet, 0, o.Len())
+		add := func(member string) { set = append(set, member) }
+		for cursor := o.Scan(0, math.MaxInt, add); cursor != 0; {
+			cursor = o.Scan(cursor, math.MaxInt, add)
+		}
+		return set
+	case *KvsSortedSet:
+		zset := make(redisdb.RDBSortedSet, 0, o.scores.Len())
+		o.scores.each(func(member string, score float64) {
+			zset = append(zset, redisdb.RDBScoredMember{Member: member, Score: score})
+		})
+		return zset
+	case KvsStream:
+		stream := &redisdb.RDBStream{
+			Entries: make([]redisdb.RDBStreamEntry, 0, len(o.objects)),
+			LastID:  toRDBStreamID(o.lastId),
+			Groups:  o.groups,
+		}
+		for _, object := range o.objects {
+			// the fields of an entry are not ordered, they are sorted so
+			// the entries with the same fields share the master ones
+			fields := make([]string, 0, len(object.data))
+			for field := range object.data {
+				fields = append(fields, field)
+			}
+			slices.Sort(fields)
+			pairs := make([]string, 0, 2*len(fields))
+			for _, field := range fields {
+				pairs = append(pairs, field, fmt.Sprint(object.data[field]))
+			}
+			stream.Entries = append(stream.Entries, redisdb.RDBStreamEntry{ID: toRDBStreamID(object.id), Fields: pairs})
+		}
+		return stream
+	}
+	return nil
+}
+
+// fromRDBValue builds the object of a value loaded from an rdb file, the
+// collections take the encodings of the current limits. Empty collections
+// are nil, redis skips them as they can not exist
+func fromRDBValue(value redisdb.RDBValue) KvsObject {
+	switch v := value.(type) {
+	case redisdb.RDBString:
+		return newKvsStringObject(v)
+	case redisdb.RDBList:
+		if len(v) == 0 {
+			return nil
+		}
+		return &KvsList{elements: v}
+	case redisdb.RDBHash:
+		if len(v) == 0 {
+			return nil
+		}
+		hash := newKvsHash()
+		for _, field := range v {
+			hash.set(field.Field, field.Value)
+		}
+		return hash
+	case redisdb.RDBSet:
+		if len(v) == 0 {
+			return nil
+		}
+		set := newKvsSet(v[0], len(v))
+		for _, member := range v {
+			set.add(member)
+		}
+		return set
+	case redisdb.RDBSortedSet:
+		if len(v) == 0 {
+			return nil
+		}
+		zset := newKvsSortedSet()
+		for _, member := range v {
+			zset.add(member.Member, member.Score)
+		}
+		return zset
+	case *redisdb.RDBStream:
+		stream := KvsStream{lastId: fromRDBStreamID(v.LastID), groups: v.Groups}
+		for _, entry := range v.Entries {
+			data := make(map[string]any, len(entry.Fields)/2)
+			for i := 0; i+1 < len(entry.Fields); i += 2 {
+				data[entry.Fields[i]] = entry.Fields[i+1]
+			}
+			stream.objects = append(stream.objects, KvsStreamObject{id: fromRDBStreamID(entry.ID), data: data})
+		}
+		return stream
+	}
+	return nil
+}
+
+// each visits every element of the dict
+func (c *compactDict[V]) each(visit func(key string, value V)) {
+	for cursor := c.Scan(0, math.MaxInt, visit); cursor != 0; {
+		cursor = c.Scan(cursor, math.MaxInt, visit)
+	}
+}
+
+func toRDBStreamID(id KvsStreamId) redisdb.RDBStreamID {
+	return redisdb.RDBStreamID{Ms: uint64(id.milli), Seq: uint64(id.sequence)}
+}
+
+func fromRDBStreamID(id redisdb.RDBStreamID) KvsStreamId {
+	return KvsStreamId{milli: int64(id.Ms), sequence: int(id.Seq)}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// persistenceInfo is the persistence section of INFO
+func (rs *RedisService) persistenceInfo() []byte {
+	status, progress := rs.dbs.RDB().LoadingInfo()
+	lines := append([]string{"#" + INFO_PERSISTENCE}, status...)
+	lines = append(lines, rs.dbs.RDB().Info()...)
+	lines = append(lines, rs.dbs.AOF().Info()...)
+	lines = append(lines, progress...)
+	lines = append(lines, parser.CRNL)
+	return []byte(strings.Join(lines, parser.CRNL))
+}
+
+func (rs *RedisService) saveCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	if err := rs.dbs.RDB().Save(); err != nil {
+		if strings.HasPrefix(err.Error(), "ERR ") {
+			return respencoding.EncodeSimpleError(err.Error()), false
+		}
+		return respencoding.EncodeSimpleError("ERR"), false
+	}
+	return respencoding.EncodeSimpleString("OK"), false
+}
+
+// bgsaveCmd implements BGSAVE [SCHEDULE], a scheduled save starts once the
+// one in progress is over
+func (rs *RedisService) bgsaveCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	schedule := false
+	if len(cmdInfo.Args) > 0 {
+		if len(cmdInfo.Args) > 1 || !strings.EqualFold(cmdInfo.Args[0], "SCHEDULE") {
+			return respencoding.EncodeSimpleError("ERR syntax error"), false
+		}
+		schedule = true
+	}
+	if err := rs.dbs.RDB().BackgroundSave(); err != nil {
+		if schedule {
+			rs.dbs.RDB().scheduled.Store(true)
+			return respencoding.EncodeSimpleString("Background saving scheduled"), false
+		}
+		return respencoding.EncodeSimpleError(err.Error() + ". Use BGSAVE SCHEDULE in order to schedule a BGSAVE whenever possible."), false
+	}
+	return respencoding.EncodeSimpleString("Background saving started"), false
+}
+
+func (rs *RedisService) lastsaveCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return respencoding.EncodeInteger(int(rs.dbs.RDB().LastSave())), false
+}
+
+// shutdownCmd implements SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT], the
+// process exits unless the final snapshot fails without FORCE. NOW is
+// accepted, no replica is waited for anyway
+func (rs *RedisService) shutdownCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	mode := ""
+	force, abort := false, false
+	for _, arg := range cmdInfo.Args {
+		switch strings.ToLower(arg) {
+		case "save", "nosave":
+			if mode != "" {
+				return respencoding.EncodeSimpleError("ERR syntax error"), false
+			}
+			mode = arg
+		case "force":
+			force = true
+		case "abort":
+			abort = true
+		case "now":
+		default:
+			return respencoding.EncodeSimpleError("ERR syntax error"), false
+		}
+	}
+	if abort {
+		// ABORT takes no other flag
+		if len(cmdInfo.Args) > 1 {
+			return respencoding.EncodeSimpleError("ERR syntax error"), false
+		}
+		if !rs.dbs.RDB().AbortShutdown() {
+			return respencoding.EncodeSimpleError("ERR No shutdown in progress."), false
+		}
+		return respencoding.EncodeSimpleString("OK"), false
+	}
+	if err := rs.dbs.RDB().Shutdown(mode, force); err != nil {
+		return respencoding.EncodeSimpleError("ERR Errors trying to SHUTDOWN. Check logs."), false
+	}
+	log.Println("Redis is now ready to exit, bye bye...")
+	os.Exit(0)
+	return nil, false
+}
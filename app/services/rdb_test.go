@@ -0,0 +1,238 @@
+package services
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
+	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SaveParams(t *testing.T) {
+
+	tests := []struct {
+		value    string
+		expected string
+		params   []saveParam
+		err      bool
+	}{
+		{value: "3600 1  300 100", expected: "3600 1 300 100", params: []saveParam{{3600, 1}, {300, 100}}},
+		{value: "", expected: "", params: []saveParam{}},
+		{value: "3600", err: true},
+		{value: "0 1", err: true},
+		{value: "60 x", err: true},
+	}
+
+	for _, tc := range tests {
+		r := NewRDBService(nil)
+		got, err := r.SetSaveParams(tc.value)
+		if tc.err {
+			assert.Error(t, err, tc.value)
+			continue
+		}
+		assert.NoError(t, err, tc.value)
+		assert.Equal(t, tc.expected, got)
+		assert.Equal(t, tc.params, *r.saveParams.Load())
+	}
+
+	_, err := NewRDBService(nil).SetFilename("dir/dump.rdb")
+	assert.Error(t, err)
+}
+
+func Test_SnapshotValues(t *testing.T) {
+	rs, ctx := newObjectTestService()
+	for _, command := range [][]string{
+		{"SET", "s", "value"}, {"SET", "n", "100"}, {"SET", "e", "v", "px", "100000"},
+		{"HSET", "h", "f", "v"}, {"SADD", "set", "2", "1"}, {"ZADD", "z", "1.5", "m"},
+		{"XADD", "x", "1-1", "f", "v"},
+	} {
+		rs.executeCmd(&parser.CmdInfo{CmdName: strings.ToLower(command[0]), Args: command[1:]}, ctx)
+	}
+
+	snapshot := rs.dbs.Snapshot()
+	values := map[string]redisdb.RDBValue{}
+	for _, entry := range snapshot[0] {
+		values[entry.Key] = entry.Value
+		assert.Equal(t, entry.Key == "e", entry.Expires != 0, entry.Key)
+	}
+	assert.Equal(t, map[string]redisdb.RDBValue{
+		"s":   redisdb.RDBString("value"),
+		"n":   redisdb.RDBString("100"),
+		"e":   redisdb.RDBString("v"),
+		"h":   redisdb.RDBHash{{Field: "f", Value: "v"}},
+		"set": redisdb.RDBSet{"1", "2"},
+		"z":   redisdb.RDBSortedSet{{Member: "m", Score: 1.5}},
+		"x": &redisdb.RDBStream{
+			Entries: []redisdb.RDBStreamEntry{{ID: redisdb.RDBStreamID{Ms: 1, Seq: 1}, Fields: []string{"f", "v"}}},
+			LastID:  redisdb.RDBStreamID{Ms: 1, Seq: 1},
+		},
+	}, values)
+}
+
+func Test_SaveCommands(t *testing.T) {
+	rs, ctx := newObjectTestService()
+	rdb := rs.dbs.RDB()
+	dir := t.TempDir()
+	_, err := rdb.SetDir(dir)
+	assert.NoError(t, err)
+	path := filepath.Join(dir, DEFAULT_DB_FILENAME)
+
+	rs.executeCmd(&parser.CmdInfo{CmdName: SET, Args: []string{"k", "v"}}, ctx)
+	assert.Contains(t, string(rs.persistenceInfo()), "rdb_changes_since_last_save:1")
+
+	rdb.lastSave.Store(0)
+	got, _ := rs.executeCmd(&parser.CmdInfo{CmdName: SAVE}, ctx)
+	assert.Equal(t, "+OK\r\n", string(got))
+	assert.Contains(t, string(rs.persistenceInfo()), "rdb_changes_since_last_save:0")
+	got, _ = rs.executeCmd(&parser.CmdInfo{CmdName: LASTSAVE}, ctx)
+	assert.NotEqual(t, ":0\r\n", string(got))
+
+	file, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(file), redisdb.MAGIC_NUMBER+redisdb.VERSION))
+	assert.Contains(t, string(file), "\x01k\x01v")
+
+	// the keys written after the snapshot are not in the file
+	rs.executeCmd(&parser.CmdInfo{CmdName: SET, Args: []string{"later", "v"}}, ctx)
+	assert.NoError(t, rdb.BackgroundSave())
+	rs.executeCmd(&parser.CmdInfo{CmdName: SET, Args: []string{"during", "v"}}, ctx)
+	got, _ = rs.executeCmd(&parser.CmdInfo{CmdName: BGSAVE}, ctx)
+	if rdb.InProgress() {
+		assert.True(t, strings.HasPrefix(string(got), "-ERR Background save already in progress"), string(got))
+	}
+	for rdb.InProgress() {
+		time.Sleep(time.Millisecond)
+	}
+	file, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(file), "later")
+
+	// the temporary files are renamed or removed
+	files, _ := os.ReadDir(dir)
+	assert.Len(t, files, 1)
+}
+
+func Test_Shutdown(t *testing.T) {
+	rs, ctx := newObjectTestService()
+	rdb := rs.dbs.RDB()
+	shutdown := func(args ...string) string {
+		got, _ := rs.executeCmd(&parser.CmdInfo{CmdName: SHUTDOWN, Args: args}, ctx)
+		return string(got)
+	}
+
+	assert.Equal(t, "-ERR No shutdown in progress.\r\n", shutdown("ABORT"))
+	assert.Equal(t, "-ERR syntax error\r\n", shutdown("ABORT", "NOW"))
+	assert.Equal(t, "-ERR syntax error\r\n", shutdown("SAVE", "NOSAVE"))
+
+	// the shutdown waits for the background save and can be aborted
+	rdb.saving.Store(true)
+	done := make(chan error)
+	go func() { done <- rdb.Shutdown("SAVE", false) }()
+	assert.Eventually(t, func() bool { return shutdown("ABORT") == "+OK\r\n" }, time.Second, time.Millisecond)
+	assert.EqualError(t, <-done, "shutdown aborted")
+	rdb.saving.Store(false)
+	assert.Equal(t, "-ERR No shutdown in progress.\r\n", shutdown("ABORT"))
+
+	// FORCE exits even when the final snapshot fails
+	missing := filepath.Join(t.TempDir(), "missing")
+	rdb.dir.Store(&missing)
+	assert.Error(t, rdb.Shutdown("SAVE", false))
+	assert.Equal(t, "-ERR Errors trying to SHUTDOWN. Check logs.\r\n", shutdown("SAVE"))
+	assert.NoError(t, rdb.Shutdown("SAVE", true))
+}
+
+func Test_LoadRDBFile(t *testing.T) {
+	rs, ctx := newObjectTestService()
+	stream := &redisdb.RDBStream{
+		Entries: []redisdb.RDBStreamEntry{{ID: redisdb.RDBStreamID{Ms: 1, Seq: 1}, Fields: []string{"f", "v"}}},
+		LastID:  redisdb.RDBStreamID{Ms: 1, Seq: 1},
+		Groups:  []redisdb.RDBStreamGroup{{Name: "g", EntriesRead: -1}},
+	}
+	path := filepath.Join(t.TempDir(), DEFAULT_DB_FILENAME)
+	writeRDB(t, path, map[int][]redisdb.RDBEntry{0: {
+		{Key: "s", Value: redisdb.RDBString("10")},
+		{Key: "expired", Value: redisdb.RDBString("v"), Expires: 1},
+		{Key: "volatile", Value: redisdb.RDBString("v"), Expires: time.Now().Add(time.Hour).UnixMilli()},
+		{Key: "l", Value: redisdb.RDBList{"a", "b"}},
+		{Key: "h", Value: redisdb.RDBHash{{Field: "f", Value: "v"}}, Expires: time.Now().Add(time.Hour).UnixMilli()},
+		{Key: "expiredset", Value: redisdb.RDBSet{"1"}, Expires: 1},
+		{Key: "set", Value: redisdb.RDBSet{"1", "2"}},
+		{Key: "z", Value: redisdb.RDBSortedSet{{Member: "m", Score: 2}}},
+		{Key: "x", Value: stream},
+		{Key: "empty", Value: redisdb.RDBSet{}},
+	}})
+	rdb := rs.dbs.RDB()
+	_, err := rdb.SetDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.NoError(t, rdb.Load())
+	assert.False(t, rdb.Loading())
+	// the loaded keys are not changes
+	assert.Contains(t, string(rs.persistenceInfo()), "rdb_changes_since_last_save:0")
+	assert.Equal(t, int64(0), rs.dbs.Dirty())
+
+	for key, expect := range map[string]string{
+		"s": "int", "volatile": "embstr", "l": "listpack", "h": "listpack", "set": "intset", "z": "listpack", "x": "stream",
+	} {
+		got, _ := rs.executeCmd(&parser.CmdInfo{CmdName: OBJECT, Args: []string{"encoding", key}}, ctx)
+		assert.Equal(t, string(respencoding.EncodeBulkString([]byte(expect))), string(got), key)
+	}
+	got, _ := rs.executeCmd(&parser.CmdInfo{CmdName: TYPE, Args: []string{"l"}}, ctx)
+	assert.Equal(t, "+list\r\n", string(got))
+	assert.Equal(t, 7, rs.dbs.Get(0).Size())
+
+	// lists and stream groups are saved as they were loaded
+	values := map[string]redisdb.RDBValue{}
+	for _, entry := range rs.dbs.Snapshot()[0] {
+		values[entry.Key] = entry.Value
+	}
+	assert.Equal(t, redisdb.RDBList{"a", "b"}, values["l"])
+	assert.Equal(t, stream, values["x"])
+	// every type keeps its expiration
+	entry, _ := rs.db(ctx).DumpKey("h")
+	assert.InDelta(t, time.Now().Add(time.Hour).UnixMilli(), entry.Expires, 1000)
+
+	writeRDB(t, path, map[int][]redisdb.RDBEntry{1: {{Key: "k", Value: redisdb.RDBString("v")}}})
+	assert.ErrorContains(t, rdb.Load(), "more than 1 databases")
+
+	// a truncated file is an error, a missing one an empty dataset
+	file, _ := os.ReadFile(path)
+	assert.NoError(t, os.WriteFile(path, file[:len(file)-10], 0644))
+	assert.ErrorContains(t, rdb.Load(), "unexpected end of file")
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, rdb.Load())
+}
+
+func Test_LoadingInfo(t *testing.T) {
+	rs, _ := newObjectTestService()
+	rdb := rs.dbs.RDB()
+	rdb.loadingStart.Store(time.Now().Unix())
+	rdb.loadingTotal.Store(400)
+	rdb.loadingLoaded.Store(100)
+	rdb.loading.Store(true)
+
+	persistence := string(rs.persistenceInfo())
+	assert.Contains(t, persistence, "loading:1\r\n")
+	assert.Contains(t, persistence, "loading_total_bytes:400\r\n")
+	assert.Contains(t, persistence, "loading_loaded_perc:25.00\r\n")
+
+	rdb.loading.Store(false)
+	persistence = string(rs.persistenceInfo())
+	assert.Contains(t, persistence, "loading:0\r\n")
+	assert.NotContains(t, persistence, "loading_loaded_perc")
+}
+
+func writeRDB(t *testing.T, path string, dbs map[int][]redisdb.RDBEntry) {
+	var buf bytes.Buffer
+	w := redisdb.NewRDBWriter(&buf)
+	for db, entries := range dbs {
+		assert.NoError(t, w.WriteDB(db, entries))
+	}
+	assert.NoError(t, w.Close())
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+}
@@ -2,19 +2,22 @@ package services
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"net"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/codecrafters-io/redis-starter-go/app/glob"
 	"github.com/codecrafters-io/redis-starter-go/app/info"
 	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
-	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
 	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
 )
 
@@ -38,36 +41,108 @@ const (
 	MULTI    = "multi"
 	EXEC     = "exec"
 	DISCARD  = "discard"
+	DEL      = "del"
+	CLIENT   = "client"
+	HELLO    = "hello"
+	AUTH     = "auth"
+	COMMAND  = "command"
+	SELECT   = "select"
+	MOVE     = "move"
+	SWAPDB   = "swapdb"
+	FLUSHDB  = "flushdb"
+	FLUSHALL = "flushall"
+	DBSIZE   = "dbsize"
+	SCAN     = "scan"
+	HSCAN    = "hscan"
+	SSCAN    = "sscan"
+	ZSCAN    = "zscan"
+	HSET     = "hset"
+	SADD     = "sadd"
+	ZADD     = "zadd"
+	OBJECT   = "object"
+	MEMORY   = "memory"
+	SAVE     = "save"
+	BGSAVE   = "bgsave"
+	LASTSAVE = "lastsave"
+	SHUTDOWN = "shutdown"
+
+	BGREWRITEAOF = "bgrewriteaof"
+
+	DUMP    = "dump"
+	RESTORE = "restore"
+	MIGRATE = "migrate"
+
+	//Pub/Sub CMD names
+	SUBSCRIBE    = "subscribe"
+	UNSUBSCRIBE  = "unsubscribe"
+	PSUBSCRIBE   = "psubscribe"
+	PUNSUBSCRIBE = "punsubscribe"
+	PUBLISH      = "publish"
+	QUIT         = "quit"
+	RESET        = "reset"
 
-	//RESP3 reply
-	NULLS     = "_\r\n"
 	NULL_BULK = "$-1\r\n"
 
 	//SET OPTIONS
-	PX = "px"
+	PX   = "px"
+	EX   = "ex"
+	PXAT = "pxat"
+	EXAT = "exat"
 
 	//info cmd
 	REPLICATION = "#Replication"
 )
 
 type RedisService struct {
-	multiQueue map[string][]*parser.CmdInfo
-	kvs        Kvs
+	dbs      *KvsDatabases
+	pubsub   PubSubService
+	clients  *ClientRegistry
+	tracking *TrackingService
+
+	// heap bytes allocated before serving, and the most seen since
+	startupAllocated uint64
+	peakAllocated    atomic.Uint64
 }
 
-func NewRedisService(kvs Kvs, streamSetEven chan string) *RedisService {
-	return &RedisService{kvs: kvs, multiQueue: make(map[string][]*parser.CmdInfo, 10)}
+func NewRedisService(dbs *KvsDatabases, pubsub PubSubService, clients *ClientRegistry, tracking *TrackingService, streamSetEven chan string) *RedisService {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	rs := &RedisService{
+		dbs:              dbs,
+		pubsub:           pubsub,
+		clients:          clients,
+		tracking:         tracking,
+		startupAllocated: ms.HeapAlloc,
+	}
+	rs.peakAllocated.Store(ms.HeapAlloc)
+	return rs
 }
 
 func (rs *RedisService) HandleConn(conn net.Conn, ctx context.Context) {
 
+	requirePass := ""
+	if config, ok := ctx.Value(info.CTX_CONFIG).(*info.Config); ok {
+		requirePass, _ = config.Get(info.CONFIG_REQUIREPASS)
+	}
+	client := rs.clients.Register(conn, requirePass == "")
+	ctx = context.WithValue(ctx, info.CTX_CLIENT, client)
 	shouldclose := true
+
+	// one reader for the whole connection so pipelined commands are not
+	// lost, their replies are queued in the client and flushed together
+	// once every buffered command has been handled
+	reader := bufio.NewReader(conn)
+	p := parser.NewParser(reader)
 OuterLoop:
 	for {
-		reader := bufio.NewReader(conn)
-		p := parser.NewParser(reader)
+		if reader.Buffered() == 0 {
+			if err := client.Flush(); err != nil {
+				log.Println("Error writing replies: ", err)
+				break
+			}
+		}
 
-		incoming, err := p.ParseIncomingData()
+		cmd, err := p.ReadRequest()
 		if err != nil {
 			if err == io.EOF {
 				log.Println("done with client", conn.RemoteAddr())
@@ -77,321 +152,855 @@ OuterLoop:
 			break
 		}
 
-		switch incoming.(type) {
-		case parser.CmdInfo:
-			cmd := incoming.(parser.CmdInfo)
-			if cmd.CmdName == WAIT {
+		command, err := lookupCommand(cmd.CmdName, cmd.Args)
+		inMulti := client.InMulti()
+		if err != nil {
+			client.MarkMultiDirty()
+			client.Write(respencoding.EncodeSimpleError(err.Error()))
+			client.EndCommand()
+			continue
+		}
+		client.Touch(command.FullName())
 
-				minReplicationReplies, _ := strconv.Atoi(cmd.Args[0])
-				waitTime, _ := strconv.Atoi(cmd.Args[1])
+		queuing := inMulti && cmd.CmdName != EXEC && cmd.CmdName != DISCARD && cmd.CmdName != MULTI &&
+			cmd.CmdName != QUIT && cmd.CmdName != RESET
+		// CLIENT UNPAUSE has to go through for a pause to be lifted early
+		pausable := !queuing && command.FullName() != "client|unpause"
+		if write := rs.isPausedWrite(client, command); pausable && rs.clients.IsPaused(write) {
+			// replies queued before the pause are not held back by it
+			if err := client.Flush(); err != nil {
+				log.Println("Error writing replies: ", err)
+				break OuterLoop
+			}
+			rs.clients.WaitIfPaused(write)
+		}
 
-				ackEventChan := ctx.Value(info.CTX_ACK_EVENT).(chan NotifyReplicationAck)
-				log.Println("sending ack to replication", ackEventChan)
-				ackEventChan <- NotifyReplicationAck{
-					timeout:         waitTime,
-					minimumNotifs:   minReplicationReplies,
-					redisClientConn: conn,
-				}
-				shouldclose = false
+		if cmd.CmdName == WAIT || isBlockingCmd(&cmd) {
+			// replies queued before a blocking command must not wait for it
+			if err := client.Flush(); err != nil {
+				log.Println("Error writing replies: ", err)
 				break OuterLoop
-			} else if rs.multiQueue[conn.RemoteAddr().String()] != nil && cmd.CmdName != EXEC && cmd.CmdName != DISCARD {
-				log.Printf("Adding cmd %s to queue %s\n", cmd.CmdName, rs.multiQueue[conn.RemoteAddr().String()])
-				rs.multiQueue[conn.RemoteAddr().String()] = append(rs.multiQueue[conn.RemoteAddr().String()], &cmd)
-				conn.Write(respencoding.EncodeSimpleString("QUEUED"))
+			}
+		}
+
+		if !client.IsAuthenticated() && command.Flags&CMD_NO_AUTH == 0 {
+			client.Write(respencoding.EncodeSimpleError("NOAUTH Authentication required."))
+		} else if rs.dbs.RDB().Loading() && command.Flags&CMD_LOADING == 0 {
+			client.Write(respencoding.EncodeSimpleError("LOADING Redis is loading the dataset in memory"))
+		} else if client.Protocol() == respencoding.RESP2 &&
+			rs.pubsub.SubscriptionCount(client) > 0 && !isAllowedInSubscribedMode(cmd.CmdName) {
+			client.Write(respencoding.EncodeSimpleError(fmt.Sprintf(
+				"ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context",
+				cmd.CmdName,
+			)))
+		} else if !rs.dbs.Evictor().PerformEvictions() && rs.isDenyOOM(client, command, queuing) {
+			client.MarkMultiDirty()
+			client.Write(respencoding.EncodeSimpleError("OOM command not allowed when used memory > 'maxmemory'."))
+		} else if queuing {
+			if command.Flags&CMD_NO_MULTI != 0 {
+				client.MarkMultiDirty()
+				client.Write(respencoding.EncodeSimpleError("ERR Command not allowed inside a transaction"))
 			} else {
+				log.Printf("Adding cmd %s to queue\n", cmd.CmdName)
+				client.QueueMulti(&cmd)
+				client.Write(respencoding.EncodeSimpleString("QUEUED"))
+			}
+		} else if cmd.CmdName == WAIT {
 
-				if cmd.CmdName == EXEC || cmd.CmdName == MULTI || cmd.CmdName == DISCARD {
-					cmd.Args = append(cmd.Args, conn.RemoteAddr().String())
-				}
+			minReplicationReplies, _ := strconv.Atoi(cmd.Args[0])
+			waitTime, _ := strconv.Atoi(cmd.Args[1])
+
+			ackEventChan := ctx.Value(info.CTX_ACK_EVENT).(chan NotifyReplicationAck)
+			log.Println("sending ack to replication", ackEventChan)
+			done := make(chan struct{})
+			ackEventChan <- NotifyReplicationAck{
+				timeout:       waitTime,
+				minimumNotifs: minReplicationReplies,
+				client:        client,
+				done:          done,
+			}
+			<-done
+		} else {
 
-				shouldRegister := rs.writeResponse(conn, &cmd, ctx)
-				if shouldRegister {
-					registrationChan := ctx.Value(info.CTX_REPLACATION_REGISTRATION).(chan net.Conn)
-					registrationChan <- conn
-					shouldclose = false
-					log.Println("register replica", conn.RemoteAddr())
+			shouldRegister := rs.writeResponse(client, &cmd, ctx)
+			if !isClientCachingCmd(&cmd) {
+				rs.tracking.ResetCaching(client)
+			}
+			if shouldRegister {
+				if err := client.Flush(); err != nil {
+					log.Println("Error writing replies: ", err)
 					break OuterLoop
 				}
+				client.SetReplica()
+				shouldclose = false
+				log.Println("register replica", conn.RemoteAddr())
+				break OuterLoop
 			}
-		case parser.SimpleString:
-			log.Println("got simple string\n", incoming)
-		case parser.UnknownData:
-			log.Printf("got unknown data,\ndata type: '%c' \ndata: '%s'",
-				incoming.(parser.UnknownData).Dt, string(incoming.(parser.UnknownData).Data))
+		}
+		client.EndCommand()
+		if client.closeAfterReply.Load() {
+			break OuterLoop
 		}
 	}
 
+	client.EndMulti()
+	rs.tracking.Disable(client)
 	if shouldclose {
+		rs.clients.Unregister(client)
 		log.Println("clossing ", conn.RemoteAddr())
-		conn.Close()
+		rs.pubsub.UnsubscribeAll(client)
+		client.Close()
 	} else {
 		log.Println("releaseing replication conn", conn.RemoteAddr())
 	}
 
 }
 
-func (rs *RedisService) writeResponse(conn net.Conn, cmd *parser.CmdInfo, ctx context.Context) bool {
-	resp, shouldRegister := rs.getCmdResponse(cmd, ctx)
+// isPausedWrite tells whether CLIENT PAUSE WRITE holds the command back,
+// EXEC is held when its transaction has a write
+func (rs *RedisService) isPausedWrite(client *Client, command *Command) bool {
+	if command.Name != EXEC {
+		return command.Flags&(CMD_WRITE|CMD_MAY_REPLICATE) != 0
+	}
+	return queuedFlags(client)&(CMD_WRITE|CMD_MAY_REPLICATE) != 0
+}
+
+// isDenyOOM tells whether the command is rejected when over maxmemory,
+// every command is while queuing since the queue takes memory too
+func (rs *RedisService) isDenyOOM(client *Client, command *Command, queuing bool) bool {
+	switch {
+	case queuing:
+		return true
+	case command.Name == EXEC:
+		return queuedFlags(client)&CMD_DENYOOM != 0
+	}
+	return command.Flags&CMD_DENYOOM != 0
+}
+
+// queuedFlags merges the flags of the commands in the MULTI queue
+func queuedFlags(client *Client) int {
+	client.mx.RLock()
+	defer client.mx.RUnlock()
+	flags := 0
+	for _, queued := range client.multi {
+		if cmd, err := lookupCommand(queued.CmdName, queued.Args); err == nil {
+			flags |= cmd.Flags
+		}
+	}
+	return flags
+}
+
+func (rs *RedisService) writeResponse(out io.Writer, cmd *parser.CmdInfo, ctx context.Context) bool {
+	resp, shouldRegister := rs.executeCmd(cmd, ctx)
 	if resp != nil {
 		log.Printf("response to %+v:\n%s\n", cmd, resp)
-		conn.Write(resp)
+		out.Write(resp)
 	}
 	return shouldRegister
 }
 
+// executeCmd runs the command, updates the client side caching state
+// with the keys it read or modified and logs the writes to the aof
+func (rs *RedisService) executeCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	cmd, err := lookupCommand(cmdInfo.CmdName, cmdInfo.Args)
+	if _, inExec := ctx.Value(info.CTX_TRANSACTION).(*transaction); err == nil && cmd.Flags&CMD_WRITE != 0 && !inExec {
+		// writes run one at a time and are propagated in that order
+		rs.dbs.LockWrites()
+		defer rs.dbs.UnlockWrites()
+	}
+	resp, shouldRegister := rs.getCmdResponse(cmdInfo, ctx)
+	if client, ok := ctx.Value(info.CTX_CLIENT).(*Client); ok && err == nil {
+		// read only commands access the keys a tracking client could cache
+		if cmd.Flags&CMD_READONLY != 0 {
+			rs.tracking.RememberKeys(client, cmd.Keys(cmdInfo.Args))
+		}
+		if cmd.Flags&CMD_WRITE != 0 {
+			rs.tracking.InvalidateKeys(client, cmd.Keys(cmdInfo.Args))
+		}
+	}
+	if err == nil && cmd.Flags&CMD_WRITE != 0 {
+		rs.propagate(cmdInfo, resp, ctx)
+	}
+	return resp, shouldRegister
+}
+
 func (rs *RedisService) getCmdResponse(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	cmd, err := lookupCommand(cmdInfo.CmdName, cmdInfo.Args)
+	if err != nil {
+		return respencoding.EncodeSimpleError(err.Error()), false
+	}
+	return cmd.getHandler()(rs, cmdInfo, ctx)
+}
 
-	serverInfo := ctx.Value(info.CTX_SERVER_INFO).(info.ServerInfo)
-	switch cmdInfo.CmdName {
-	case PING:
-		return respencoding.EncodeSimpleString("PONG"), false
-	case ECHO:
-		return respencoding.EncodeSimpleString(cmdInfo.Args[0]), false
-	case SET:
-		if serverInfo[info.SERVER_ROLE] == info.ROLE_MASTER {
-			cmdEvent := ctx.Value(info.CTX_REPLICATION_EVENTS).(chan parser.CmdInfo)
-			cmdEvent <- *cmdInfo
+func (rs *RedisService) pingCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	if client, ok := ctx.Value(info.CTX_CLIENT).(*Client); ok &&
+		clientProtocol(ctx) == respencoding.RESP2 && rs.pubsub.SubscriptionCount(client) > 0 {
+		return respencoding.EncodeArray([][]byte{[]byte("pong"), {}}), false
+	}
+	return respencoding.EncodeSimpleString("PONG"), false
+}
+
+func (rs *RedisService) echoCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return respencoding.EncodeSimpleString(cmdInfo.Args[0]), false
+}
+
+func (rs *RedisService) setCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	ok := false
+
+	key := cmdInfo.Args[0]
+	val := cmdInfo.Args[1]
+	if len(cmdInfo.Args) > 2 {
+
+		ops, err := buildKvsOptions(cmdInfo.Args[2:])
+		if err != nil {
+			return respencoding.EncodeSimpleError(err.Error()), false
+		}
+		ok = rs.db(ctx).SetWithOptions(key, []byte(val), ops)
+	} else {
+		ok = rs.db(ctx).Set(key, []byte(val))
+	}
+	if ok {
+
+		return respencoding.EncodeSimpleString("OK"), false
+	} else {
+		return respencoding.EncodeSimpleError("ERR: could not store k/v"), false
+	}
+}
+
+func (rs *RedisService) getCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	value, ok := rs.db(ctx).Get(cmdInfo.Args[0])
+	if ok {
+		return respencoding.EncodeBulkStringArray([][]byte{value}), false
+	} else {
+		return respencoding.EncodeNull(clientProtocol(ctx)), false
+	}
+}
+
+func (rs *RedisService) infoCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	proto := clientProtocol(ctx)
+	if len(cmdInfo.Args) > 0 && strings.EqualFold(cmdInfo.Args[0], INFO_PERSISTENCE) {
+		return respencoding.EncodeVerbatimString(proto, "txt", rs.persistenceInfo()), false
+	}
+	if len(cmdInfo.Args) < 1 {
+		return respencoding.EncodeVerbatimString(proto, "txt", info.BuildInfo("", ctx)), false
+	} else {
+		return respencoding.EncodeVerbatimString(proto, "txt", info.BuildInfo(cmdInfo.Args[0], ctx)), false
+	}
+}
+
+func (rs *RedisService) replconfCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	if len(cmdInfo.Args) > 0 && cmdInfo.Args[0] == "getack" {
+		ackRply := [][]byte{
+			[]byte(REPLCONF),
+			[]byte("ack"),
+			{'0'},
 		}
+		return respencoding.EncodeArray(ackRply), false
+	}
+
+	log.Println("Replication config received", cmdInfo)
+	return respencoding.EncodeSimpleString("OK"), false
+}
 
-		if len(cmdInfo.Args) < 2 {
-			return respencoding.EncodeSimpleError("Not enough args for SET: " + strings.Join(cmdInfo.Args, ",")), false
+// psyncCmd always performs a full resync, the replica gets a snapshot of
+// the databases followed by the writes propagated since it was taken. The
+// replica is registered before the snapshot with its stream held back
+// until the snapshot is sent
+func (rs *RedisService) psyncCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	serverInfo := ctx.Value(info.CTX_SERVER_INFO).(info.ServerInfo)
+	log.Println("Psync received", cmdInfo)
+	client := ctx.Value(info.CTX_CLIENT).(*Client)
+	register, isMaster := ctx.Value(info.CTX_REPLACATION_REGISTRATION).(func(*Client))
+	var snapshot bytes.Buffer
+	// the stream of the replica starts with the writes after the snapshot
+	err := rs.dbs.RDB().Dump(&snapshot, func() error {
+		if isMaster {
+			client.HoldReplication()
+			register(client)
 		}
-		ok := false
+		return nil
+	})
+	if err != nil {
+		// the registered replica is dropped with its connection
+		client.CloseAfterReply()
+		return respencoding.EncodeSimpleError("ERR " + err.Error()), false
+	}
+	resync := respencoding.EncodeSimpleString("FULLRESYNC " + serverInfo[info.SERVER_MASTER_REPLID] + " 0")
+	rdbFile := snapshot.Bytes()
+	rdbFileSize := strconv.Itoa(len(rdbFile))
+	reply := make([]byte, 0, len(resync)+len(rdbFile)+10)
+	reply = append(reply, resync...)
+	reply = append(reply, '$')
+	reply = append(reply, rdbFileSize...)
+	reply = append(reply, []byte(parser.CRNL)...)
+	reply = append(reply, rdbFile...)
 
-		key := cmdInfo.Args[0]
-		val := cmdInfo.Args[1]
-		if len(cmdInfo.Args) > 2 {
+	return reply, true
+}
 
-			ops, err := buildKvsOptions(cmdInfo.Args[2:])
-			if err != nil {
-				return respencoding.EncodeSimpleError(err.Error()), false
+// waitCmd has no reply of its own, the connection loop hands WAIT to the
+// master service
+func (rs *RedisService) waitCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return nil, false
+}
+
+func (rs *RedisService) configGetCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	serverInfo := ctx.Value(info.CTX_SERVER_INFO).(info.ServerInfo)
+	config, hasConfig := ctx.Value(info.CTX_CONFIG).(*info.Config)
+	names := []string{}
+	if hasConfig {
+		names = config.Names()
+	}
+
+	resp := [][]byte{}
+	seen := make(map[string]bool)
+	for _, pattern := range cmdInfo.Args[1:] {
+		for _, name := range names {
+			if seen[name] || !glob.MatchNoCase(pattern, name) {
+				continue
 			}
-			ok = rs.kvs.SetWithOptions(key, []byte(val), ops)
-		} else {
-			ok = rs.kvs.Set(key, []byte(val))
+			val, ok := "", false
+			if hasConfig {
+				val, ok = config.Get(name)
+			}
+			if !ok {
+				val = serverInfo[name]
+				ok = val != ""
+			}
+			if !ok {
+				continue
+			}
+			seen[name] = true
+			resp = append(resp,
+				respencoding.EncodeBulkString([]byte(name)),
+				respencoding.EncodeBulkString([]byte(val)),
+			)
 		}
-		if ok {
+	}
+	return respencoding.BuildMap(clientProtocol(ctx), resp), false
+}
 
-			return respencoding.EncodeSimpleString("OK"), false
-		} else {
-			return respencoding.EncodeSimpleError("ERR: could not store k/v"), false
-		}
-	case GET:
-		value, ok := rs.kvs.Get(cmdInfo.Args[0])
-		if ok {
-			return respencoding.EncodeBulkStringArray([][]byte{value}), false
-		} else {
-			return []byte(NULL_BULK), false
+func (rs *RedisService) configSetCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	config, hasConfig := ctx.Value(info.CTX_CONFIG).(*info.Config)
+	if !hasConfig || len(cmdInfo.Args)%2 == 0 {
+		return respencoding.EncodeSimpleError("ERR wrong number of arguments for 'config|set' command"), false
+	}
+	for i := 1; i < len(cmdInfo.Args); i += 2 {
+		name := cmdInfo.Args[i]
+		if _, ok := config.Get(name); !ok {
+			return respencoding.EncodeSimpleError("ERR Unknown option or number of arguments for CONFIG SET - '" + name + "'"), false
 		}
-	case INFO:
-		if len(cmdInfo.Args) < 1 {
-			return respencoding.EncodeBulkString(info.BuildInfo("", ctx)), false
-		} else {
-			return respencoding.EncodeBulkString(info.BuildInfo(cmdInfo.Args[0], ctx)), false
+		if config.IsImmutable(name) {
+			return respencoding.EncodeSimpleError(fmt.Sprintf(
+				"ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", name,
+			)), false
 		}
-	case REPLCONF:
-		if cmdInfo.Args[0] == "getack" {
-			ackRply := [][]byte{
-				[]byte(REPLCONF),
-				[]byte("ack"),
-				{'0'},
-			}
-			return respencoding.EncodeArray(ackRply), false
+		if err := config.Set(name, cmdInfo.Args[i+1]); err != nil {
+			return respencoding.EncodeSimpleError(fmt.Sprintf(
+				"ERR CONFIG SET failed (possibly related to argument '%s') - %s", name, err,
+			)), false
 		}
+	}
+	return respencoding.EncodeSimpleString("OK"), false
+}
 
-		log.Println("Replication config received", cmdInfo)
-		return respencoding.EncodeSimpleString("OK"), false
-	case PSYNC:
-		log.Println("Psync received", cmdInfo)
-		resync := respencoding.EncodeSimpleString("FULLRESYNC " + serverInfo[info.SERVER_MASTER_REPLID] + " 0")
-		rdbFile := redisdb.BuildRDBFromMemory()
-		rdbFileSize := strconv.Itoa(len(rdbFile))
-		reply := make([]byte, 0, len(resync)+len(rdbFile)+10)
-		reply = append(reply, resync...)
-		reply = append(reply, '$')
-		reply = append(reply, rdbFileSize...)
-		reply = append(reply, []byte(parser.CRNL)...)
-		reply = append(reply, rdbFile...)
-
-		return reply, true
-	case WAIT:
-		return nil, false
-	case CONFIG:
-
-		if cmdInfo.Args[0] == "GET" {
-			val := serverInfo[cmdInfo.Args[1]]
-			if val != "" {
-				resp := [][]byte{
-					[]byte(cmdInfo.Args[1]),
-					[]byte(val),
-				}
-				return respencoding.EncodeArray(resp), false
-			}
+func (rs *RedisService) keysCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	pattern := cmdInfo.Args[0]
+	kvsKeys := rs.db(ctx).Keys()
+	if pattern == "*" {
+		return respencoding.EncodeArray(kvsKeys), false
+	}
+	keys := make([][]byte, 0, len(kvsKeys))
+	for _, key := range kvsKeys {
+		if glob.Match(pattern, string(key)) {
+			keys = append(keys, key)
 		}
-		return []byte(NULLS), false
+	}
+	return respencoding.EncodeArray(keys), false
+}
 
-	case KEYS:
-		if cmdInfo.Args[0] == "*" {
-			kvsKeys := rs.kvs.Keys()
-			return respencoding.EncodeArray(kvsKeys), false
+func (rs *RedisService) typeCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	key := cmdInfo.Args[0]
+	valueType := rs.db(ctx).GetType(key)
+	return respencoding.EncodeSimpleString(valueType), false
+}
+
+func (rs *RedisService) scanCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	cursor, options, err := parseScanArgs(cmdInfo.Args, true)
+	if err != nil {
+		return respencoding.EncodeSimpleError(err.Error()), false
+	}
+	db := rs.db(ctx)
+	keys, cursor := db.Scan(cursor, options.count)
+
+	elements := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if !options.matches(key) {
+			continue
 		}
-	case TYPE:
-		key := cmdInfo.Args[0]
-		valueType := rs.kvs.GetType(key)
-		return respencoding.EncodeSimpleString(valueType), false
-	case XADD:
-		if len(cmdInfo.Args) < 3 {
-			return respencoding.EncodeSimpleError("Not enough data"), false
+		// GetType also drops the keys that expired
+		keyType := db.GetType(key)
+		if keyType == "none" || (options.keyType != "" && keyType != options.keyType) {
+			continue
 		}
-		data := make(map[string]any, 1)
-		for i := 2; i < len(cmdInfo.Args); i += 2 {
-			data[cmdInfo.Args[i]] = cmdInfo.Args[i+1]
+		elements = append(elements, []byte(key))
+	}
+	return encodeScanReply(cursor, elements), false
+}
+
+// scanElementsCmd serves HSCAN, SSCAN and ZSCAN
+func (rs *RedisService) scanElementsCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	cursor, options, err := parseScanArgs(cmdInfo.Args[1:], false)
+	if err != nil {
+		return respencoding.EncodeSimpleError(err.Error()), false
+	}
+	kind, stride := "set", 1
+	switch cmdInfo.CmdName {
+	case HSCAN:
+		kind, stride = "hash", 2
+	case ZSCAN:
+		kind, stride = "zset", 2
+	}
+	found, cursor, err := rs.db(ctx).ScanElements(cmdInfo.Args[0], kind, cursor, options.count)
+	if err != nil {
+		return respencoding.EncodeSimpleError(err.Error()), false
+	}
+
+	elements := make([][]byte, 0, len(found))
+	for i := 0; i < len(found); i += stride {
+		if !options.matches(found[i]) {
+			continue
 		}
-		stored, error := rs.kvs.SetStream(cmdInfo.Args[0], cmdInfo.Args[1], data)
-		if error != nil {
-			return respencoding.EncodeSimpleError(error.Error()), false
+		for _, element := range found[i : i+stride] {
+			elements = append(elements, []byte(element))
 		}
-		if stored != "" {
-			return respencoding.EncodeBulkString([]byte(stored)), false
-		} else {
-			return respencoding.EncodeSimpleError("ERR the stream as not saved"), false
-		}
-	case XRANGE:
-		stream := rs.kvs.GetStream(cmdInfo.Args[0])
-		var lowerRange, upperRange KvsStreamId
-		if len(cmdInfo.Args) == 3 {
-			lowerString := cmdInfo.Args[1]
-			upperString := cmdInfo.Args[2]
-			lowerMilli, err := strconv.ParseInt(lowerString, 10, 64)
+	}
+	return encodeScanReply(cursor, elements), false
+}
+
+type scanOptions struct {
+	match   string
+	count   int
+	keyType string
+}
+
+func (options scanOptions) matches(s string) bool {
+	if options.match == "" || options.match == "*" {
+		return true
+	}
+	return glob.Match(options.match, s)
+}
+
+// parseScanArgs parses cursor [MATCH pattern] [COUNT count] [TYPE type],
+// TYPE is only accepted by SCAN
+func parseScanArgs(args []string, withType bool) (uint64, scanOptions, error) {
+	options := scanOptions{count: 10}
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, options, fmt.Errorf("ERR invalid cursor")
+	}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return 0, options, fmt.Errorf("ERR syntax error")
+		}
+		switch value := args[i+1]; strings.ToUpper(args[i]) {
+		case "MATCH":
+			options.match = value
+		case "COUNT":
+			count, err := strconv.Atoi(value)
 			if err != nil {
+				return 0, options, fmt.Errorf("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return 0, options, fmt.Errorf("ERR syntax error")
+			}
+			options.count = count
+		case "TYPE":
+			if !withType {
+				return 0, options, fmt.Errorf("ERR syntax error")
+			}
+			options.keyType = strings.ToLower(value)
+		default:
+			return 0, options, fmt.Errorf("ERR syntax error")
+		}
+	}
+	return cursor, options, nil
+}
 
-				if cmdInfo.Args[1] == "-" {
-					lowerRange = KvsStreamId{}
-				} else {
-					lowerRange, err = newStreamId(lowerString)
-					if err != nil {
-						return respencoding.EncodeSimpleError("ERR invalid lower range id " + err.Error()), false
-					}
-				}
+func encodeScanReply(cursor uint64, elements [][]byte) []byte {
+	return respencoding.BuildArray([][]byte{
+		respencoding.EncodeBulkString([]byte(strconv.FormatUint(cursor, 10))),
+		respencoding.EncodeArray(elements),
+	})
+}
+
+func (rs *RedisService) hsetCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	if len(cmdInfo.Args)%2 == 0 {
+		return respencoding.EncodeSimpleError("ERR wrong number of arguments for 'hset' command"), false
+	}
+	added, err := rs.db(ctx).HSet(cmdInfo.Args[0], cmdInfo.Args[1:])
+	if err != nil {
+		return respencoding.EncodeSimpleError(err.Error()), false
+	}
+	return respencoding.EncodeInteger(added), false
+}
+
+func (rs *RedisService) saddCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	added, err := rs.db(ctx).SAdd(cmdInfo.Args[0], cmdInfo.Args[1:])
+	if err != nil {
+		return respencoding.EncodeSimpleError(err.Error()), false
+	}
+	return respencoding.EncodeInteger(added), false
+}
+
+func (rs *RedisService) zaddCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	pairs := cmdInfo.Args[1:]
+	if len(pairs)%2 != 0 {
+		return respencoding.EncodeSimpleError("ERR syntax error"), false
+	}
+	scores := make([]float64, 0, len(pairs)/2)
+	members := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(pairs[i], 64)
+		if err != nil || math.IsNaN(score) {
+			return respencoding.EncodeSimpleError("ERR value is not a valid float"), false
+		}
+		scores = append(scores, score)
+		members = append(members, pairs[i+1])
+	}
+	added, err := rs.db(ctx).ZAdd(cmdInfo.Args[0], scores, members)
+	if err != nil {
+		return respencoding.EncodeSimpleError(err.Error()), false
+	}
+	return respencoding.EncodeInteger(added), false
+}
 
+func (rs *RedisService) xaddCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	if len(cmdInfo.Args)%2 != 0 {
+		return respencoding.EncodeSimpleError("ERR wrong number of arguments for 'xadd' command"), false
+	}
+	data := make(map[string]any, 1)
+	for i := 2; i < len(cmdInfo.Args); i += 2 {
+		data[cmdInfo.Args[i]] = cmdInfo.Args[i+1]
+	}
+	stored, error := rs.db(ctx).SetStream(cmdInfo.Args[0], cmdInfo.Args[1], data)
+	if error != nil {
+		return respencoding.EncodeSimpleError(error.Error()), false
+	}
+	if stored != "" {
+		return respencoding.EncodeBulkString([]byte(stored)), false
+	} else {
+		return respencoding.EncodeSimpleError("ERR the stream as not saved"), false
+	}
+}
+
+func (rs *RedisService) xrangeCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	stream := rs.db(ctx).GetStream(cmdInfo.Args[0])
+	var lowerRange, upperRange KvsStreamId
+	if len(cmdInfo.Args) == 3 {
+		lowerString := cmdInfo.Args[1]
+		upperString := cmdInfo.Args[2]
+		lowerMilli, err := strconv.ParseInt(lowerString, 10, 64)
+		if err != nil {
+
+			if cmdInfo.Args[1] == "-" {
+				lowerRange = KvsStreamId{}
 			} else {
-				lowerRange = KvsStreamId{milli: lowerMilli}
+				lowerRange, err = newStreamId(lowerString)
+				if err != nil {
+					return respencoding.EncodeSimpleError("ERR invalid lower range id " + err.Error()), false
+				}
 			}
-			upperMilli, err := strconv.ParseInt(upperString, 10, 64)
-			if err != nil {
 
-				if cmdInfo.Args[2] == "+" {
-					upperRange = KvsStreamId{milli: math.MaxInt64, sequence: math.MaxInt}
-				} else {
-					upperRange, err = newStreamId(upperString)
-					if err != nil {
-						return respencoding.EncodeSimpleError("ERR invalid upper range arg " + err.Error()), false
-					}
-				}
+		} else {
+			lowerRange = KvsStreamId{milli: lowerMilli}
+		}
+		upperMilli, err := strconv.ParseInt(upperString, 10, 64)
+		if err != nil {
 
+			if cmdInfo.Args[2] == "+" {
+				upperRange = KvsStreamId{milli: math.MaxInt64, sequence: math.MaxInt}
 			} else {
-				upperRange = KvsStreamId{milli: upperMilli}
-			}
-		}
-		return stream.GetXRange(&lowerRange, &upperRange), false
-
-	case XREAD:
-		streamCmd := false
-		streamsIndex := 0
-		var blockMilis int64
-		blockMilis = -1
-		for i, cmdOp := range cmdInfo.Args {
-			switch cmdOp {
-			case "streams":
-				streamCmd = true
-				streamsIndex = i + 1
-			case "block":
+				upperRange, err = newStreamId(upperString)
+				if err != nil {
+					return respencoding.EncodeSimpleError("ERR invalid upper range arg " + err.Error()), false
+				}
+			}
+
+		} else {
+			upperRange = KvsStreamId{milli: upperMilli}
+		}
+	}
+	return stream.GetXRange(&lowerRange, &upperRange), false
+}
+
+func (rs *RedisService) xreadCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	streamCmd := false
+	streamsIndex := 0
+	var blockMilis int64
+	blockMilis = -1
+	for i, cmdOp := range cmdInfo.Args {
+		switch strings.ToLower(cmdOp) {
+		case "streams":
+			streamCmd = true
+			streamsIndex = i + 1
+		case "block":
+			if i+1 < len(cmdInfo.Args) {
 				blockMilis, _ = strconv.ParseInt(cmdInfo.Args[i+1], 10, 64)
 			}
 		}
+	}
 
-		if streamCmd {
-			if blockMilis >= 0 {
-				listener := make(chan string)
-				k := cmdInfo.Args[streamsIndex]
-				rs.kvs.SubscriveStreamEventListener(k, listener)
+	db := rs.db(ctx)
+	if streamCmd {
+		if blockMilis >= 0 {
+			listener := make(chan string)
+			k := cmdInfo.Args[streamsIndex]
+			db.SubscriveStreamEventListener(k, listener)
 
-				if blockMilis > 0 {
+			var timeout <-chan time.Time
+			if blockMilis > 0 {
+				timeout = time.After(time.Duration(blockMilis) * time.Millisecond)
+			}
+			var unblocked <-chan int
+			if client, ok := ctx.Value(info.CTX_CLIENT).(*Client); ok {
+				unblocked = client.Block()
+				defer client.Unblock()
+			}
 
-					go func(millis int64) {
-						time.Sleep(time.Duration(millis) * time.Millisecond)
-						listener <- "none"
-					}(blockMilis)
-				}
-				event := <-listener
-				if event == "none" {
-					rs.kvs.UnsubscriveStreamEventListener(k)
-					return []byte(NULL_BULK), false
-				} else {
-					eventData := strings.Split(event, ",")
-					return rs.xRead(cmdInfo.Args[streamsIndex:], eventData[1]), false
+			select {
+			case event := <-listener:
+				eventData := strings.Split(event, ",")
+				return rs.xRead(db, cmdInfo.Args[streamsIndex:], eventData[1]), false
+			case <-timeout:
+			case reason := <-unblocked:
+				if reason == UNBLOCK_ERROR {
+					db.UnsubscriveStreamEventListener(k)
+					return respencoding.EncodeSimpleError("UNBLOCKED client unblocked via CLIENT UNBLOCK"), false
 				}
-			} else {
-				return rs.xRead(cmdInfo.Args[streamsIndex:], ""), false
 			}
+			db.UnsubscriveStreamEventListener(k)
+			return respencoding.EncodeNull(clientProtocol(ctx)), false
+		} else {
+			return rs.xRead(db, cmdInfo.Args[streamsIndex:], ""), false
 		}
-		return respencoding.EncodeSimpleError("invalid read cmd"), false
+	}
+	return respencoding.EncodeSimpleError("invalid read cmd"), false
+}
 
-	case INCR:
-		key := cmdInfo.Args[0]
-		val, exist := rs.kvs.Get(key)
-		if !exist {
-			Kvs.Set(rs.kvs, key, []byte("1"))
-			val = []byte("0")
-		}
-		num, err := strconv.Atoi(string(val))
+func (rs *RedisService) incrCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	num, err := rs.db(ctx).IncrBy(cmdInfo.Args[0], 1)
+	if err != nil {
+		return respencoding.EncodeSimpleError(err.Error()), false
+	}
 
-		if err != nil {
-			return respencoding.EncodeSimpleError("ERR value is not an integer or out of range"), false
+	return respencoding.EncodeInteger(num), false
+}
+
+func (rs *RedisService) delCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	deleted := 0
+	for _, key := range cmdInfo.Args {
+		if rs.db(ctx).Del(key) {
+			deleted++
 		}
+	}
+	return respencoding.EncodeInteger(deleted), false
+}
 
-		num++
-		numBytes := []byte(strconv.Itoa(num))
-		rs.kvs.Set(key, numBytes)
+func (rs *RedisService) subscribeCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return rs.subscribe(cmdInfo, ctx.Value(info.CTX_CLIENT).(*Client)), false
+}
 
-		return respencoding.EncodeInteger(num), false
+func (rs *RedisService) unsubscribeCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return rs.unsubscribe(cmdInfo, ctx.Value(info.CTX_CLIENT).(*Client)), false
+}
 
-	case MULTI:
-		remote := cmdInfo.Args[0]
-		rs.multiQueue[remote] = make([]*parser.CmdInfo, 0, 10)
-		return respencoding.EncodeSimpleString("OK"), false
+func (rs *RedisService) helloCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return rs.hello(cmdInfo.Args, ctx), false
+}
 
-	case EXEC:
-		remote := cmdInfo.Args[0]
-		if rs.multiQueue[remote] == nil {
-			return respencoding.EncodeSimpleError("ERR EXEC without MULTI"), false
-		}
-		responses := make([][]byte, 0, len(rs.multiQueue[remote]))
-		for _, cmd := range rs.multiQueue[remote] {
-			resp, _ := rs.getCmdResponse(cmd, ctx)
-			responses = append(responses, resp)
+// quitCmd closes the connection once the reply is sent
+func (rs *RedisService) quitCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	ctx.Value(info.CTX_CLIENT).(*Client).CloseAfterReply()
+	return respencoding.EncodeSimpleString("OK"), false
+}
 
-		}
+// resetCmd brings the connection back to the state it had when it was
+// opened: out of MULTI, pub/sub and tracking, on database 0 with RESP2,
+// no name and authenticated only when there is no password
+func (rs *RedisService) resetCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	client := ctx.Value(info.CTX_CLIENT).(*Client)
+	client.EndMulti()
+	rs.pubsub.UnsubscribeAll(client)
+	rs.tracking.Disable(client)
+	client.SetReplyMode(CLIENT_REPLY_ON)
+	client.SetNoEvict(false)
+	client.SelectDB(0)
+	client.SetProtocol(respencoding.RESP2)
+	client.SetName("")
+	requirePass := ""
+	if config, ok := ctx.Value(info.CTX_CONFIG).(*info.Config); ok {
+		requirePass, _ = config.Get(info.CONFIG_REQUIREPASS)
+	}
+	client.ResetAuth(requirePass == "")
+	return respencoding.EncodeSimpleString("RESET"), false
+}
+
+func (rs *RedisService) authCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	if len(cmdInfo.Args) > 2 {
+		return respencoding.EncodeSimpleError("ERR wrong number of arguments for 'auth' command"), false
+	}
+	username, password := DEFAULT_USER, cmdInfo.Args[0]
+	if len(cmdInfo.Args) == 2 {
+		username, password = cmdInfo.Args[0], cmdInfo.Args[1]
+	} else if requirePass, _ := ctx.Value(info.CTX_CONFIG).(*info.Config).Get(info.CONFIG_REQUIREPASS); requirePass == "" {
+		return respencoding.EncodeSimpleError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"), false
+	}
+	if err := authenticate(ctx, username, password); err != nil {
+		return respencoding.EncodeSimpleError(err.Error()), false
+	}
+	return respencoding.EncodeSimpleString("OK"), false
+}
+
+func (rs *RedisService) publishCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	receivers := rs.pubsub.Publish(cmdInfo.Args[0], []byte(cmdInfo.Args[1]))
+	return respencoding.EncodeInteger(receivers), false
+}
+
+func (rs *RedisService) selectCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	db, err := rs.parseDB(cmdInfo.Args[0])
+	if err != nil {
+		return respencoding.EncodeSimpleError(err.Error()), false
+	}
+	ctx.Value(info.CTX_CLIENT).(*Client).SelectDB(db)
+	return respencoding.EncodeSimpleString("OK"), false
+}
+
+func (rs *RedisService) moveCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	dst, err := rs.parseDB(cmdInfo.Args[1])
+	if err != nil {
+		return respencoding.EncodeSimpleError(err.Error()), false
+	}
+	src := clientDB(ctx)
+	if src == dst {
+		return respencoding.EncodeSimpleError("ERR source and destination objects are the same"), false
+	}
+	if rs.dbs.Get(src).Move(cmdInfo.Args[0], rs.dbs.Get(dst)) {
+		return respencoding.EncodeInteger(1), false
+	}
+	return respencoding.EncodeInteger(0), false
+}
 
-		rs.multiQueue[remote] = nil
-		return respencoding.BuildArray(responses), false
+func (rs *RedisService) swapdbCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	a, err := strconv.Atoi(cmdInfo.Args[0])
+	if err != nil {
+		return respencoding.EncodeSimpleError("ERR invalid first DB index"), false
+	}
+	b, err := strconv.Atoi(cmdInfo.Args[1])
+	if err != nil {
+		return respencoding.EncodeSimpleError("ERR invalid second DB index"), false
+	}
+	if a < 0 || a >= rs.dbs.Len() || b < 0 || b >= rs.dbs.Len() {
+		return respencoding.EncodeSimpleError("ERR DB index is out of range"), false
+	}
+	rs.dbs.Swap(a, b)
+	return respencoding.EncodeSimpleString("OK"), false
+}
 
-	case DISCARD:
-		remote := cmdInfo.Args[0]
-		_, exits := rs.multiQueue[remote]
-		if exits {
-			delete(rs.multiQueue, remote)
-			return respencoding.EncodeSimpleString("OK"), false
+// flushCmd implements FLUSHDB and FLUSHALL, both accept the ASYNC and SYNC
+// modifiers
+func (rs *RedisService) flushCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	if len(cmdInfo.Args) > 1 || (len(cmdInfo.Args) == 1 &&
+		!strings.EqualFold(cmdInfo.Args[0], "ASYNC") && !strings.EqualFold(cmdInfo.Args[0], "SYNC")) {
+		return respencoding.EncodeSimpleError("ERR syntax error"), false
+	}
+	if cmdInfo.CmdName == FLUSHALL {
+		rs.dbs.FlushAll()
+	} else {
+		rs.dbs.Flush(clientDB(ctx))
+	}
+	rs.tracking.InvalidateAll()
+	return respencoding.EncodeSimpleString("OK"), false
+}
+
+func (rs *RedisService) dbsizeCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return respencoding.EncodeInteger(rs.db(ctx).Size()), false
+}
+
+// parseDB validates a database index argument
+func (rs *RedisService) parseDB(arg string) (int, error) {
+	db, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	if db < 0 || db >= rs.dbs.Len() {
+		return 0, fmt.Errorf("ERR DB index is out of range")
+	}
+	return db, nil
+}
+
+// db returns the database selected by the client running the command
+func (rs *RedisService) db(ctx context.Context) Kvs {
+	return rs.dbs.Get(clientDB(ctx))
+}
+
+func (rs *RedisService) multiCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	client := ctx.Value(info.CTX_CLIENT).(*Client)
+	if client.InMulti() {
+		return respencoding.EncodeSimpleError("ERR MULTI calls can not be nested"), false
+	}
+	client.StartMulti()
+	return respencoding.EncodeSimpleString("OK"), false
+}
+
+// transaction is the state of an EXEC running the commands it queued,
+// its writes are propagated between a MULTI and an EXEC
+type transaction struct {
+	multi bool
+}
+
+func (rs *RedisService) execCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	client := ctx.Value(info.CTX_CLIENT).(*Client)
+	queue, dirty := client.EndMulti()
+	if queue == nil {
+		return respencoding.EncodeSimpleError("ERR EXEC without MULTI"), false
+	}
+	if dirty {
+		return respencoding.EncodeSimpleError("EXECABORT Transaction discarded because of previous errors."), false
+	}
+
+	// the writes of the transaction run and propagate as one step
+	rs.dbs.LockWrites()
+	defer rs.dbs.UnlockWrites()
+	tx := &transaction{}
+	ctx = context.WithValue(ctx, info.CTX_TRANSACTION, tx)
+
+	responses := make([][]byte, 0, len(queue))
+	for _, cmd := range queue {
+		resp, _ := rs.executeCmd(cmd, ctx)
+		if resp == nil {
+			// CLIENT REPLY OFF|SKIP answer nothing, the array still needs an element
+			resp = respencoding.EncodeSimpleString("OK")
 		}
+		responses = append(responses, resp)
+	}
+	if tx.multi {
+		rs.dbs.Propagate(clientDB(ctx), []string{strings.ToUpper(EXEC)})
+	}
 
-		return respencoding.EncodeSimpleError("ERR DISCARD without MULTI"), false
+	return respencoding.BuildArray(responses), false
+}
 
+func (rs *RedisService) discardCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	client := ctx.Value(info.CTX_CLIENT).(*Client)
+	if queue, _ := client.EndMulti(); queue != nil {
+		return respencoding.EncodeSimpleString("OK"), false
 	}
 
-	return respencoding.EncodeSimpleString("UNKNOWN CMD"), false
+	return respencoding.EncodeSimpleError("ERR DISCARD without MULTI"), false
 }
 
-func (rs *RedisService) xRead(streams []string, prevId string) []byte {
+func (rs *RedisService) xRead(db Kvs, streams []string, prevId string) []byte {
 	totalStreams := len(streams) / 2
 	xreadResp := make([][]byte, 0, totalStreams)
 	res := make([][]byte, 0, totalStreams)
@@ -408,7 +1017,7 @@ func (rs *RedisService) xRead(streams []string, prevId string) []byte {
 		if err != nil {
 			return respencoding.EncodeSimpleError(err.Error())
 		}
-		stream := rs.kvs.GetStream(key)
+		stream := db.GetStream(key)
 		streamData = append(streamData, respencoding.EncodeBulkString([]byte(key)))
 		streamData = append(streamData, respencoding.BuildArray([][]byte{stream.GetXRead(&from)}))
 		res = append(res, respencoding.BuildArray(streamData))
@@ -420,22 +1029,560 @@ func (rs *RedisService) xRead(streams []string, prevId string) []byte {
 	return respencoding.BuildArray(xreadResp)
 }
 
+func (rs *RedisService) subscribe(cmdInfo *parser.CmdInfo, client *Client) []byte {
+	res := make([]byte, 0, 64*len(cmdInfo.Args))
+	for _, name := range cmdInfo.Args {
+		var count int
+		if cmdInfo.CmdName == PSUBSCRIBE {
+			count = rs.pubsub.PSubscribe(client, name)
+		} else {
+			count = rs.pubsub.Subscribe(client, name)
+		}
+		res = append(res, encodeSubscriptionReply(client.Protocol(), cmdInfo.CmdName, []byte(name), count)...)
+	}
+	return res
+}
+
+// unsubscribe without arguments removes every channel (or pattern) of the
+// connection, one reply is sent for each of them
+func (rs *RedisService) unsubscribe(cmdInfo *parser.CmdInfo, client *Client) []byte {
+	names := cmdInfo.Args
+	if len(names) == 0 {
+		channels, patterns := rs.pubsub.Subscriptions(client)
+		if cmdInfo.CmdName == PUNSUBSCRIBE {
+			names = patterns
+		} else {
+			names = channels
+		}
+	}
+
+	if len(names) == 0 {
+		return encodeSubscriptionReply(client.Protocol(), cmdInfo.CmdName, nil, rs.pubsub.SubscriptionCount(client))
+	}
+
+	res := make([]byte, 0, 64*len(names))
+	for _, name := range names {
+		var count int
+		if cmdInfo.CmdName == PUNSUBSCRIBE {
+			count = rs.pubsub.PUnsubscribe(client, name)
+		} else {
+			count = rs.pubsub.Unsubscribe(client, name)
+		}
+		res = append(res, encodeSubscriptionReply(client.Protocol(), cmdInfo.CmdName, []byte(name), count)...)
+	}
+	return res
+}
+
+func (rs *RedisService) clientCmd(cmdInfo *parser.CmdInfo, ctx context.Context) ([]byte, bool) {
+	return rs.client(cmdInfo.Args, ctx.Value(info.CTX_CLIENT).(*Client)), false
+}
+
+func (rs *RedisService) client(args []string, client *Client) []byte {
+	switch strings.ToUpper(args[0]) {
+	case "ID":
+		return respencoding.EncodeInteger(int(client.id))
+	case "INFO":
+		return respencoding.EncodeVerbatimString(client.Protocol(), "txt", []byte(rs.clientInfo(client)+"\n"))
+	case "LIST":
+		return rs.clientList(args[1:], client)
+	case "SETNAME":
+		if err := validateClientName(args[1]); err != nil {
+			return respencoding.EncodeSimpleError(err.Error())
+		}
+		client.SetName(args[1])
+		return respencoding.EncodeSimpleString("OK")
+	case "GETNAME":
+		if client.Name() == "" {
+			return respencoding.EncodeNull(client.Protocol())
+		}
+		return respencoding.EncodeBulkString([]byte(client.Name()))
+	case "KILL":
+		return rs.clientKill(args[1:], client)
+	case "PAUSE":
+		timeout, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return respencoding.EncodeSimpleError("ERR timeout is not an integer or out of range")
+		}
+		if timeout < 0 {
+			return respencoding.EncodeSimpleError("ERR timeout is negative")
+		}
+		all := true
+		if len(args) == 3 && strings.EqualFold(args[2], "WRITE") {
+			all = false
+		} else if len(args) > 3 || (len(args) == 3 && !strings.EqualFold(args[2], "ALL")) {
+			return respencoding.EncodeSimpleError("ERR syntax error")
+		}
+		rs.clients.Pause(time.Duration(timeout)*time.Millisecond, all)
+		return respencoding.EncodeSimpleString("OK")
+	case "UNPAUSE":
+		rs.clients.Unpause()
+		return respencoding.EncodeSimpleString("OK")
+	case "REPLY":
+		switch strings.ToUpper(args[1]) {
+		case "ON":
+			client.SetReplyMode(CLIENT_REPLY_ON)
+			return respencoding.EncodeSimpleString("OK")
+		case "OFF":
+			client.SetReplyMode(CLIENT_REPLY_OFF)
+		case "SKIP":
+			client.SetReplyMode(CLIENT_REPLY_SKIP)
+		default:
+			return respencoding.EncodeSimpleError("ERR syntax error")
+		}
+		return nil
+	case "NO-EVICT":
+		switch strings.ToUpper(args[1]) {
+		case "ON":
+			client.SetNoEvict(true)
+		case "OFF":
+			client.SetNoEvict(false)
+		default:
+			return respencoding.EncodeSimpleError("ERR syntax error")
+		}
+		return respencoding.EncodeSimpleString("OK")
+	case "UNBLOCK":
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return respencoding.EncodeSimpleError("ERR value is not an integer or out of range")
+		}
+		reason := UNBLOCK_TIMEOUT
+		if len(args) == 3 && strings.EqualFold(args[2], "ERROR") {
+			reason = UNBLOCK_ERROR
+		} else if len(args) > 3 || (len(args) == 3 && !strings.EqualFold(args[2], "TIMEOUT")) {
+			return respencoding.EncodeSimpleError("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+		}
+		if target, ok := rs.clients.Get(id); ok && target.Wake(reason) {
+			return respencoding.EncodeInteger(1)
+		}
+		return respencoding.EncodeInteger(0)
+	case "TRACKING":
+		if len(args) < 2 {
+			return respencoding.EncodeSimpleError("ERR wrong number of arguments for 'client|tracking' command")
+		}
+		switch strings.ToUpper(args[1]) {
+		case "ON":
+			ops, err := buildTrackingOptions(args[2:])
+			if err != nil {
+				return respencoding.EncodeSimpleError(err.Error())
+			}
+			if err := rs.tracking.Enable(client, ops); err != nil {
+				return respencoding.EncodeSimpleError(err.Error())
+			}
+		case "OFF":
+			rs.tracking.Disable(client)
+		default:
+			return respencoding.EncodeSimpleError("ERR syntax error")
+		}
+		return respencoding.EncodeSimpleString("OK")
+	case "CACHING":
+		if len(args) != 2 {
+			return respencoding.EncodeSimpleError("ERR wrong number of arguments for 'client|caching' command")
+		}
+		var err error
+		switch strings.ToUpper(args[1]) {
+		case "YES":
+			err = rs.tracking.SetCaching(client, true)
+		case "NO":
+			err = rs.tracking.SetCaching(client, false)
+		default:
+			return respencoding.EncodeSimpleError("ERR syntax error")
+		}
+		if err != nil {
+			return respencoding.EncodeSimpleError(err.Error())
+		}
+		return respencoding.EncodeSimpleString("OK")
+	case "GETREDIR":
+		return respencoding.EncodeInteger(int(rs.tracking.GetRedirect(client)))
+	case "TRACKINGINFO":
+		flags, redirect, prefixes := rs.tracking.Info(client)
+		return respencoding.BuildMap(client.Protocol(), [][]byte{
+			respencoding.EncodeBulkString([]byte("flags")),
+			respencoding.BuildSet(client.Protocol(), encodeBulkStrings(flags)),
+			respencoding.EncodeBulkString([]byte("redirect")),
+			respencoding.EncodeInteger(int(redirect)),
+			respencoding.EncodeBulkString([]byte("prefixes")),
+			respencoding.BuildArray(encodeBulkStrings(prefixes)),
+		})
+	}
+	return respencoding.EncodeSimpleError("ERR unknown subcommand '" + args[0] + "'. Try CLIENT HELP.")
+}
+
+// clientInfo builds the CLIENT LIST line of a client
+func (rs *RedisService) clientInfo(c *Client) string {
+	channels, patterns := rs.pubsub.Subscriptions(c)
+	redirect := rs.tracking.GetRedirect(c)
+
+	flags := ""
+	if c.replica.Load() {
+		flags += "S"
+	}
+	if len(channels)+len(patterns) > 0 {
+		flags += "P"
+	}
+	if c.InMulti() {
+		flags += "x"
+	}
+	if c.IsBlocked() {
+		flags += "b"
+	}
+	if redirect != -1 {
+		flags += "t"
+	}
+	if c.closeAfterReply.Load() {
+		flags += "c"
+	}
+	if c.noEvict.Load() {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	addr, laddr := "", ""
+	if c.conn != nil {
+		addr, laddr = c.conn.RemoteAddr().String(), c.conn.LocalAddr().String()
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d ssub=0 multi=%d watch=0 omem=%d cmd=%s user=%s redir=%d resp=%d",
+		c.id, addr, laddr, c.Name(), int64(c.Age().Seconds()), int64(c.Idle().Seconds()), flags, c.DB(),
+		len(channels), len(patterns), c.MultiLen(), c.OutputBufferSize(), c.LastCmd(), c.User(), redirect, c.Protocol())
+}
+
+// clientList implements CLIENT LIST [TYPE type] [ID id [id ...]]
+func (rs *RedisService) clientList(args []string, client *Client) []byte {
+	class := -1
+	var ids map[int64]bool
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "TYPE") && i+1 < len(args):
+			if class = getClientClass(args[i+1]); class == -1 {
+				return respencoding.EncodeSimpleError("ERR Unknown client type '" + args[i+1] + "'")
+			}
+			i++
+		case strings.EqualFold(args[i], "ID") && i+1 < len(args):
+			ids = make(map[int64]bool)
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(args[i], 10, 64)
+				if err != nil || id <= 0 {
+					return respencoding.EncodeSimpleError("ERR Invalid client ID")
+				}
+				ids[id] = true
+			}
+		default:
+			return respencoding.EncodeSimpleError("ERR syntax error")
+		}
+	}
+
+	var list strings.Builder
+	for _, c := range rs.clients.All() {
+		if (class != -1 && c.Class() != class) || (ids != nil && !ids[c.id]) {
+			continue
+		}
+		list.WriteString(rs.clientInfo(c))
+		list.WriteByte('\n')
+	}
+	return respencoding.EncodeVerbatimString(client.Protocol(), "txt", []byte(list.String()))
+}
+
+// clientKill implements both the old CLIENT KILL addr:port form and the
+// filters one, killing the calling client only once its reply is sent
+func (rs *RedisService) clientKill(args []string, client *Client) []byte {
+	if len(args) == 1 {
+		for _, c := range rs.clients.All() {
+			if c.conn != nil && c.conn.RemoteAddr().String() == args[0] {
+				rs.killClient(c, client)
+				return respencoding.EncodeSimpleString("OK")
+			}
+		}
+		return respencoding.EncodeSimpleError("ERR No such client")
+	}
+	if len(args)%2 != 0 {
+		return respencoding.EncodeSimpleError("ERR syntax error")
+	}
+
+	var filters []func(c *Client) bool
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return respencoding.EncodeSimpleError("ERR client-id should be greater than 0")
+			}
+			filters = append(filters, func(c *Client) bool { return c.id == id })
+		case "TYPE":
+			class := getClientClass(value)
+			if class == -1 {
+				return respencoding.EncodeSimpleError("ERR Unknown client type '" + value + "'")
+			}
+			filters = append(filters, func(c *Client) bool { return c.Class() == class })
+		case "ADDR":
+			filters = append(filters, func(c *Client) bool { return c.conn != nil && c.conn.RemoteAddr().String() == value })
+		case "LADDR":
+			filters = append(filters, func(c *Client) bool { return c.conn != nil && c.conn.LocalAddr().String() == value })
+		case "USER":
+			filters = append(filters, func(c *Client) bool { return c.User() == value })
+		case "MAXAGE":
+			maxAge, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || maxAge <= 0 {
+				return respencoding.EncodeSimpleError("ERR syntax error")
+			}
+			filters = append(filters, func(c *Client) bool { return c.Age() >= time.Duration(maxAge)*time.Second })
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return respencoding.EncodeSimpleError("ERR syntax error")
+			}
+		default:
+			return respencoding.EncodeSimpleError("ERR syntax error")
+		}
+	}
+
+	killed := 0
+OuterLoop:
+	for _, c := range rs.clients.All() {
+		if skipMe && c == client {
+			continue
+		}
+		for _, filter := range filters {
+			if !filter(c) {
+				continue OuterLoop
+			}
+		}
+		rs.killClient(c, client)
+		killed++
+	}
+	return respencoding.EncodeInteger(killed)
+}
+
+func (rs *RedisService) killClient(c, caller *Client) {
+	if c == caller {
+		c.CloseAfterReply()
+		return
+	}
+	c.Kill()
+}
+
+// hello switches the protocol of the connection, optionally authenticating
+// and naming it, and replies with the server info in the new protocol
+func (rs *RedisService) hello(args []string, ctx context.Context) []byte {
+	client := ctx.Value(info.CTX_CLIENT).(*Client)
+	serverInfo := ctx.Value(info.CTX_SERVER_INFO).(info.ServerInfo)
+
+	proto := client.Protocol()
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0])
+		if err != nil {
+			return respencoding.EncodeSimpleError("ERR Protocol version is not an integer or out of range")
+		}
+		if ver < respencoding.RESP2 || ver > respencoding.RESP3 {
+			return respencoding.EncodeSimpleError("NOPROTO unsupported protocol version")
+		}
+		proto = ver
+	}
+
+	name, setName := "", false
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				return respencoding.EncodeSimpleError("ERR Syntax error in HELLO option '" + args[i] + "'")
+			}
+			if err := authenticate(ctx, args[i+1], args[i+2]); err != nil {
+				return respencoding.EncodeSimpleError(err.Error())
+			}
+			i += 2
+		case "SETNAME":
+			if i+1 >= len(args) {
+				return respencoding.EncodeSimpleError("ERR Syntax error in HELLO option '" + args[i] + "'")
+			}
+			if err := validateClientName(args[i+1]); err != nil {
+				return respencoding.EncodeSimpleError(err.Error())
+			}
+			name, setName = args[i+1], true
+			i++
+		default:
+			return respencoding.EncodeSimpleError("ERR Syntax error in HELLO option '" + args[i] + "'")
+		}
+	}
+
+	if !client.IsAuthenticated() {
+		return respencoding.EncodeSimpleError("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	if setName {
+		client.SetName(name)
+	}
+	client.SetProtocol(proto)
+
+	role := serverInfo[info.SERVER_ROLE]
+	if role == info.ROLE_SLAVE {
+		role = "replica"
+	}
+	return respencoding.BuildMap(proto, [][]byte{
+		respencoding.EncodeBulkString([]byte("server")),
+		respencoding.EncodeBulkString([]byte("redis")),
+		respencoding.EncodeBulkString([]byte("version")),
+		respencoding.EncodeBulkString([]byte(info.REDIS_VERSION)),
+		respencoding.EncodeBulkString([]byte("proto")),
+		respencoding.EncodeInteger(proto),
+		respencoding.EncodeBulkString([]byte("id")),
+		respencoding.EncodeInteger(int(client.id)),
+		respencoding.EncodeBulkString([]byte("mode")),
+		respencoding.EncodeBulkString([]byte("standalone")),
+		respencoding.EncodeBulkString([]byte("role")),
+		respencoding.EncodeBulkString([]byte(role)),
+		respencoding.EncodeBulkString([]byte("modules")),
+		respencoding.BuildArray([][]byte{}),
+	})
+}
+
+// authenticate checks the credentials against requirepass, the only user is
+// the default one
+func authenticate(ctx context.Context, username, password string) error {
+	requirePass, _ := ctx.Value(info.CTX_CONFIG).(*info.Config).Get(info.CONFIG_REQUIREPASS)
+	if username != DEFAULT_USER || (requirePass != "" && password != requirePass) {
+		return fmt.Errorf("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	ctx.Value(info.CTX_CLIENT).(*Client).Authenticate(username)
+	return nil
+}
+
+func validateClientName(name string) error {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return fmt.Errorf("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+	}
+	return nil
+}
+
+func clientProtocol(ctx context.Context) int {
+	if client, ok := ctx.Value(info.CTX_CLIENT).(*Client); ok {
+		return client.Protocol()
+	}
+	return respencoding.RESP2
+}
+
+func clientDB(ctx context.Context) int {
+	if client, ok := ctx.Value(info.CTX_CLIENT).(*Client); ok {
+		return client.DB()
+	}
+	return 0
+}
+
+func buildTrackingOptions(args []string) (TrackingOptions, error) {
+	ops := TrackingOptions{}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REDIRECT":
+			if i+1 >= len(args) {
+				return TrackingOptions{}, fmt.Errorf("ERR syntax error")
+			}
+			i++
+			redirect, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return TrackingOptions{}, fmt.Errorf("ERR value is not an integer or out of range")
+			}
+			ops.Redirect = redirect
+		case "PREFIX":
+			if i+1 >= len(args) {
+				return TrackingOptions{}, fmt.Errorf("ERR syntax error")
+			}
+			i++
+			ops.Prefixes = append(ops.Prefixes, args[i])
+		case "BCAST":
+			ops.Flags |= TRACKING_BCAST
+		case "OPTIN":
+			ops.Flags |= TRACKING_OPTIN
+		case "OPTOUT":
+			ops.Flags |= TRACKING_OPTOUT
+		case "NOLOOP":
+			ops.Flags |= TRACKING_NOLOOP
+		default:
+			return TrackingOptions{}, fmt.Errorf("ERR syntax error")
+		}
+	}
+	return ops, nil
+}
+
+func isClientCachingCmd(cmdInfo *parser.CmdInfo) bool {
+	return cmdInfo.CmdName == CLIENT && len(cmdInfo.Args) > 0 && strings.EqualFold(cmdInfo.Args[0], "caching")
+}
+
+func encodeBulkStrings(s []string) [][]byte {
+	res := make([][]byte, 0, len(s))
+	for _, str := range s {
+		res = append(res, respencoding.EncodeBulkString([]byte(str)))
+	}
+	return res
+}
+
+func encodeSubscriptionReply(proto int, kind string, name []byte, count int) []byte {
+	encodedName := respencoding.EncodeNull(proto)
+	if name != nil {
+		encodedName = respencoding.EncodeBulkString(name)
+	}
+	return respencoding.BuildPush(proto, [][]byte{
+		respencoding.EncodeBulkString([]byte(kind)),
+		encodedName,
+		respencoding.EncodeInteger(count),
+	})
+}
+
+// isBlockingCmd reports commands that can wait for other clients
+func isBlockingCmd(cmdInfo *parser.CmdInfo) bool {
+	if cmdInfo.CmdName != XREAD {
+		return false
+	}
+	for _, arg := range cmdInfo.Args {
+		if strings.EqualFold(arg, "block") {
+			return true
+		}
+	}
+	return false
+}
+
+func isAllowedInSubscribedMode(cmdName string) bool {
+	switch cmdName {
+	case SUBSCRIBE, UNSUBSCRIBE, PSUBSCRIBE, PUNSUBSCRIBE, PING, QUIT, RESET:
+		return true
+	}
+	return false
+}
+
 func buildKvsOptions(args []string) (KvsOptions, error) {
 	ops := KvsOptions{}
 	processedLines := 0
 	for processedLines < len(args) {
-		switch args[processedLines] {
-		case PX:
+		option := strings.ToLower(args[processedLines])
+		switch option {
+		case PX, EX, PXAT, EXAT:
 			if processedLines+1 >= len(args) {
-				return KvsOptions{}, fmt.Errorf("Missing PX value")
+				return KvsOptions{}, fmt.Errorf("Missing %s value", strings.ToUpper(option))
 			}
 			processedLines++
-			pxArg := args[processedLines]
-			pxNumericVal, err := strconv.Atoi(pxArg)
+			arg := args[processedLines]
+			numericVal, err := strconv.Atoi(arg)
 			if err != nil {
-				return KvsOptions{}, fmt.Errorf("PX value is not a number: %s", pxArg)
+				return KvsOptions{}, fmt.Errorf("%s value is not a number: %s", strings.ToUpper(option), arg)
+			}
+			// the relative expirations are milliseconds, the absolute
+			// ones unix milliseconds
+			switch option {
+			case PX:
+				ops.expires = time.Duration(numericVal)
+			case EX:
+				ops.expires = time.Duration(numericVal) * 1000
+			case PXAT:
+				ops.timestamp = uint64(numericVal)
+			case EXAT:
+				ops.timestamp = uint64(numericVal) * 1000
 			}
-			ops.expires = time.Duration(pxNumericVal)
 
 			processedLines++
 		default:
@@ -2,10 +2,17 @@ package services
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/codecrafters-io/redis-starter-go/app/info"
 	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	redisdb "github.com/codecrafters-io/redis-starter-go/app/protocol/redis_db"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -68,10 +75,24 @@ func Test_getCmdResponse(t *testing.T) {
 			store:    map[string]KvsStringObject{},
 			expected: []byte("-Missing PX value\r\n"),
 		},
+		{
+			input: parser.CmdInfo{
+				CmdName: GET,
+			},
+			expected: []byte("-ERR wrong number of arguments for 'get' command\r\n"),
+		},
+		{
+			input: parser.CmdInfo{
+				CmdName: "foo",
+				Args:    []string{"bar"},
+			},
+			expected: []byte("-ERR unknown command 'foo', with args beginning with: 'bar' \r\n"),
+		},
 	}
 
 	for _, tc := range tests {
-		rs := RedisService{&KvSMock{tc.store}}
+		store := tc.store
+		rs := RedisService{dbs: NewKvsDatabases(1, func(int) Kvs { return &KvSMock{store} })}
 		testCtx := tc.ctx
 		if tc.ctx == nil {
 			testCtx = ctx
@@ -84,10 +105,235 @@ func Test_getCmdResponse(t *testing.T) {
 
 }
 
+func Test_BuildKvsOptions(t *testing.T) {
+	tests := []struct {
+		args   []string
+		expect KvsOptions
+		err    string
+	}{
+		{args: []string{"px", "100"}, expect: KvsOptions{expires: 100}},
+		{args: []string{"EX", "2"}, expect: KvsOptions{expires: 2000}},
+		{args: []string{"PXAT", "2000000000001"}, expect: KvsOptions{timestamp: 2000000000001}},
+		{args: []string{"NX", "exat", "2000000000"}, expect: KvsOptions{timestamp: 2000000000000}},
+		{args: []string{"ex"}, err: "Missing EX value"},
+		{args: []string{"pxat", "soon"}, err: "PXAT value is not a number: soon"},
+	}
+
+	for _, tc := range tests {
+		got, err := buildKvsOptions(tc.args)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.args)
+			continue
+		}
+		assert.NoError(t, err, tc.args)
+		assert.Equal(t, tc.expect, got, tc.args)
+	}
+}
+
+func Test_Databases(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		commands [][]string
+		expected []string
+	}{
+		{
+			name:     "select",
+			commands: [][]string{{"SET", "k", "0"}, {"SELECT", "1"}, {"GET", "k"}, {"SET", "k", "1"}, {"SELECT", "0"}, {"GET", "k"}},
+			expected: []string{"+OK\r\n", "+OK\r\n", "$-1\r\n", "+OK\r\n", "+OK\r\n", "$1\r\n0\r\n"},
+		},
+		{
+			name:     "select out of range",
+			commands: [][]string{{"SELECT", "2"}, {"SELECT", "x"}},
+			expected: []string{"-ERR DB index is out of range\r\n", "-ERR value is not an integer or out of range\r\n"},
+		},
+		{
+			name:     "move",
+			commands: [][]string{{"SET", "k", "v"}, {"MOVE", "k", "1"}, {"MOVE", "k", "1"}, {"SELECT", "1"}, {"GET", "k"}, {"MOVE", "k", "1"}},
+			expected: []string{"+OK\r\n", ":1\r\n", ":0\r\n", "+OK\r\n", "$1\r\nv\r\n", "-ERR source and destination objects are the same\r\n"},
+		},
+		{
+			name:     "move to an existing key",
+			commands: [][]string{{"SELECT", "1"}, {"SET", "k", "1"}, {"SELECT", "0"}, {"SET", "k", "0"}, {"MOVE", "k", "1"}, {"GET", "k"}},
+			expected: []string{"+OK\r\n", "+OK\r\n", "+OK\r\n", "+OK\r\n", ":0\r\n", "$1\r\n0\r\n"},
+		},
+		{
+			name:     "swapdb",
+			commands: [][]string{{"SET", "k", "0"}, {"SWAPDB", "0", "1"}, {"GET", "k"}, {"SELECT", "1"}, {"GET", "k"}, {"SWAPDB", "0", "5"}},
+			expected: []string{"+OK\r\n", "+OK\r\n", "$-1\r\n", "+OK\r\n", "$1\r\n0\r\n", "-ERR DB index is out of range\r\n"},
+		},
+		{
+			name:     "flushdb",
+			commands: [][]string{{"SET", "a", "0"}, {"SELECT", "1"}, {"SET", "b", "1"}, {"FLUSHDB", "ASYNC"}, {"DBSIZE"}, {"SELECT", "0"}, {"DBSIZE"}},
+			expected: []string{"+OK\r\n", "+OK\r\n", "+OK\r\n", "+OK\r\n", ":0\r\n", "+OK\r\n", ":1\r\n"},
+		},
+		{
+			name:     "flushall",
+			commands: [][]string{{"SET", "a", "0"}, {"SELECT", "1"}, {"SET", "b", "1"}, {"FLUSHALL"}, {"DBSIZE"}, {"SELECT", "0"}, {"DBSIZE"}, {"FLUSHALL", "LATER"}},
+			expected: []string{"+OK\r\n", "+OK\r\n", "+OK\r\n", "+OK\r\n", ":0\r\n", "+OK\r\n", ":0\r\n", "-ERR syntax error\r\n"},
+		},
+	}
+
+	for _, tc := range tests {
+		clients := NewClientRegistry()
+		pubsub := NewPubSubService()
+		tracking := NewTrackingService(clients, pubsub)
+		notifier := NewKeyspaceNotifier(pubsub)
+		dbs := NewKvsDatabases(2, func(db int) Kvs { return NewKvSService(notifier, tracking, db) })
+		rs := NewRedisService(dbs, pubsub, clients, tracking, nil)
+		ctx := context.WithValue(context.Background(), info.CTX_SERVER_INFO, make(info.ServerInfo))
+		ctx = context.WithValue(ctx, info.CTX_CLIENT, clients.Register(nil, true))
+
+		for i, command := range tc.commands {
+			got, _ := rs.executeCmd(&parser.CmdInfo{CmdName: strings.ToLower(command[0]), Args: command[1:]}, ctx)
+			assert.Equal(t, tc.expected[i], string(got), tc.name)
+		}
+	}
+}
+
+func Test_Scan(t *testing.T) {
+
+	tests := []struct {
+		command  []string
+		expected string
+	}{
+		{command: []string{"SCAN", "0", "TYPE", "hash", "COUNT", "100"}, expected: "*2\r\n$1\r\n0\r\n*1\r\n$1\r\nh\r\n"},
+		{command: []string{"SCAN", "0", "MATCH", "st*", "COUNT", "100"}, expected: "*2\r\n$1\r\n0\r\n*1\r\n$3\r\nstr\r\n"},
+		{command: []string{"SCAN", "0", "TYPE", "list"}, expected: "*2\r\n$1\r\n0\r\n*0\r\n"},
+		{command: []string{"HSCAN", "h", "0"}, expected: "*2\r\n$1\r\n0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{command: []string{"SSCAN", "s", "0", "MATCH", "m"}, expected: "*2\r\n$1\r\n0\r\n*1\r\n$1\r\nm\r\n"},
+		{command: []string{"ZSCAN", "z", "0"}, expected: "*2\r\n$1\r\n0\r\n*2\r\n$1\r\nm\r\n$3\r\n1.5\r\n"},
+		{command: []string{"HSCAN", "missing", "0"}, expected: "*2\r\n$1\r\n0\r\n*0\r\n"},
+		{command: []string{"SSCAN", "h", "0"}, expected: "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{command: []string{"SCAN", "-1"}, expected: "-ERR invalid cursor\r\n"},
+		{command: []string{"SCAN", "0", "COUNT", "0"}, expected: "-ERR syntax error\r\n"},
+		{command: []string{"SCAN", "0", "COUNT", "x"}, expected: "-ERR value is not an integer or out of range\r\n"},
+		{command: []string{"SCAN", "0", "MATCH"}, expected: "-ERR syntax error\r\n"},
+		{command: []string{"HSCAN", "h", "0", "TYPE", "hash"}, expected: "-ERR syntax error\r\n"},
+	}
+
+	clients := NewClientRegistry()
+	pubsub := NewPubSubService()
+	tracking := NewTrackingService(clients, pubsub)
+	notifier := NewKeyspaceNotifier(pubsub)
+	dbs := NewKvsDatabases(1, func(db int) Kvs { return NewKvSService(notifier, tracking, db) })
+	rs := NewRedisService(dbs, pubsub, clients, tracking, nil)
+	ctx := context.WithValue(context.Background(), info.CTX_SERVER_INFO, make(info.ServerInfo))
+	ctx = context.WithValue(ctx, info.CTX_CLIENT, clients.Register(nil, true))
+	for _, command := range [][]string{{"SET", "str", "v"}, {"HSET", "h", "f", "v"}, {"SADD", "s", "m", "n"}, {"ZADD", "z", "1.5", "m"}} {
+		rs.executeCmd(&parser.CmdInfo{CmdName: strings.ToLower(command[0]), Args: command[1:]}, ctx)
+	}
+
+	for _, tc := range tests {
+		got, _ := rs.executeCmd(&parser.CmdInfo{CmdName: strings.ToLower(tc.command[0]), Args: tc.command[1:]}, ctx)
+		assert.Equal(t, tc.expected, string(got), strings.Join(tc.command, " "))
+	}
+}
+
 type KvSMock struct {
 	store map[string]KvsStringObject
 }
 
+func Test_HandleConnPipelining(t *testing.T) {
+
+	ctx := context.WithValue(context.Background(), info.CTX_SERVER_INFO, info.ServerInfo{info.SERVER_ROLE: info.ROLE_SLAVE})
+
+	tests := []struct {
+		name     string
+		commands int
+		build    func(i int) (request, reply string)
+	}{
+		{
+			name:     "resp",
+			commands: 5000,
+			build: func(i int) (string, string) {
+				key, val := fmt.Sprintf("key:%d", i), fmt.Sprintf("val:%d", i)
+				return fmt.Sprintf("*3\r\n$3\r\nSET\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(key), key, len(val), val) +
+						fmt.Sprintf("*2\r\n$3\r\nGET\r\n$%d\r\n%s\r\n", len(key), key),
+					fmt.Sprintf("+OK\r\n$%d\r\n%s\r\n", len(val), val)
+			},
+		},
+		{
+			name:     "binary values",
+			commands: 3000,
+			build: func(i int) (string, string) {
+				val := fmt.Sprintf("a\r\n%d\x00", i)
+				return fmt.Sprintf("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$%d\r\n%s\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", len(val), val),
+					fmt.Sprintf("+OK\r\n$%d\r\n%s\r\n", len(val), val)
+			},
+		},
+		{
+			name:     "inline",
+			commands: 5000,
+			build: func(i int) (string, string) {
+				return fmt.Sprintf("INCR counter\r\nECHO \"%d\"\n", i),
+					fmt.Sprintf(":%d\r\n+%d\r\n", i+1, i)
+			},
+		},
+		{
+			// only '*' starts a multibulk request, the rest is inline
+			name:     "resp3 type bytes",
+			commands: 1000,
+			build: func(i int) (string, string) {
+				return "%1\r\n*0\r\nPING\r\n",
+					"-ERR unknown command '%1', with args beginning with: \r\n+PONG\r\n"
+			},
+		},
+		{
+			// WAIT is refused in MULTI and EXEC replies an element per command,
+			// a CLIENT REPLY SKIP it runs skips the command after it
+			name:     "multi",
+			commands: 1000,
+			build: func(i int) (string, string) {
+				return "MULTI\r\nSET a 1\r\nWAIT 0 0\r\nEXEC\r\nMULTI\r\nCLIENT REPLY SKIP\r\nPING\r\nEXEC\r\nPING\r\n",
+					"+OK\r\n+QUEUED\r\n-ERR Command not allowed inside a transaction\r\n" +
+						"-EXECABORT Transaction discarded because of previous errors.\r\n" +
+						"+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n+PONG\r\n"
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		clients := NewClientRegistry()
+		pubsub := NewPubSubService()
+		tracking := NewTrackingService(clients, pubsub)
+		dbs := NewKvsDatabases(1, func(db int) Kvs { return NewKvSService(NewKeyspaceNotifier(pubsub), tracking, db) })
+		rs := NewRedisService(dbs, pubsub, clients, tracking, nil)
+
+		server, client := net.Pipe()
+		conn := &writeCounterConn{Conn: server}
+		go rs.HandleConn(conn, ctx)
+
+		var request, expected strings.Builder
+		for i := range tc.commands {
+			req, rep := tc.build(i)
+			request.WriteString(req)
+			expected.WriteString(rep)
+		}
+
+		go client.Write([]byte(request.String()))
+
+		got := make([]byte, expected.Len())
+		_, err := io.ReadFull(client, got)
+		client.Close()
+
+		assert.Nil(t, err, tc.name)
+		assert.Equal(t, expected.String(), string(got), tc.name)
+		// replies are batched, not written one by one
+		assert.Less(t, conn.writes.Load(), int64(tc.commands/10), tc.name)
+	}
+}
+
+type writeCounterConn struct {
+	net.Conn
+	writes atomic.Int64
+}
+
+func (c *writeCounterConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
 func (kvs *KvSMock) Get(k string) ([]byte, bool) {
 
 	v, ok := kvs.store[k]
@@ -123,3 +369,97 @@ func (kvs *KvSMock) GetStream(k string) *KvsStream {
 func (kvs *KvSMock) SubscriveStreamEventListener(k string, listener chan string) {}
 
 func (kvs *KvSMock) UnsubscriveStreamEventListener(k string) {}
+
+func (kvs *KvSMock) Del(k string) bool {
+	_, ok := kvs.store[k]
+	delete(kvs.store, k)
+	return ok
+}
+
+func (kvs *KvSMock) IncrBy(k string, delta int) (int, error) {
+	return 0, nil
+}
+
+func (kvs *KvSMock) Size() int {
+	return len(kvs.store)
+}
+
+func (kvs *KvSMock) Move(k string, dst Kvs) bool {
+	return false
+}
+
+func (kvs *KvSMock) AddObject(k string, obj KvsObject, expires int64) bool {
+	return false
+}
+
+func (kvs *KvSMock) LoadObject(k string, obj KvsObject, expires int64) bool {
+	return false
+}
+
+func (kvs *KvSMock) DumpKey(k string) (redisdb.RDBEntry, bool) {
+	return redisdb.RDBEntry{}, false
+}
+
+func (kvs *KvSMock) Restore(k string, obj KvsObject, expires int64, replace bool, idle time.Duration, freq int) bool {
+	return false
+}
+
+func (kvs *KvSMock) SetDB(db int) {}
+
+func (kvs *KvSMock) SetPropagate(propagate func(db int, args []string)) {}
+
+func (kvs *KvSMock) ActiveExpireCycle() (int, int) {
+	return 0, 0
+}
+
+func (kvs *KvSMock) Scan(cursor uint64, count int) ([]string, uint64) {
+	return nil, 0
+}
+
+func (kvs *KvSMock) ScanElements(k, kind string, cursor uint64, count int) ([]string, uint64, error) {
+	return nil, 0, nil
+}
+
+func (kvs *KvSMock) HSet(k string, pairs []string) (int, error) {
+	return 0, nil
+}
+
+func (kvs *KvSMock) SAdd(k string, members []string) (int, error) {
+	return 0, nil
+}
+
+func (kvs *KvSMock) ZAdd(k string, scores []float64, members []string) (int, error) {
+	return 0, nil
+}
+
+func (kvs *KvSMock) UsedMemory() int64 {
+	return 0
+}
+
+func (kvs *KvSMock) EvictionSample(count int, volatile bool) []evictionCandidate {
+	return nil
+}
+
+func (kvs *KvSMock) Evict(k string) bool {
+	return false
+}
+
+func (kvs *KvSMock) Inspect(k string) (objectInfo, bool) {
+	return objectInfo{}, false
+}
+
+func (kvs *KvSMock) MemoryUsage(k string, samples int) (int64, bool) {
+	return 0, false
+}
+
+func (kvs *KvSMock) Lock() {}
+
+func (kvs *KvSMock) Unlock() {}
+
+func (kvs *KvSMock) Dump() []redisdb.RDBEntry {
+	return nil
+}
+
+func (kvs *KvSMock) Dirty() int64 {
+	return 0
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -20,12 +21,12 @@ type ReplicationService interface {
 }
 
 type replicationServiceImp struct {
-	kvnService Kvs
-	metrics    *info.Metrics
+	rs      *RedisService
+	metrics *info.Metrics
 }
 
-func NewReplicationService(kvs Kvs, metrics *info.Metrics) ReplicationService {
-	return &replicationServiceImp{kvnService: kvs, metrics: metrics}
+func NewReplicationService(rs *RedisService, metrics *info.Metrics) ReplicationService {
+	return &replicationServiceImp{rs: rs, metrics: metrics}
 }
 
 func (r *replicationServiceImp) HandleMasterConn(conn net.Conn, ctx context.Context) {
@@ -41,12 +42,14 @@ func (r *replicationServiceImp) HandleMasterConn(conn net.Conn, ctx context.Cont
 	if ok {
 		log.Println("Handshake sucessful")
 
-		go r.listenToMaster(conn, parser)
+		// the stream of the master runs as a client without a connection
+		client := r.rs.clients.Register(nil, true)
+		go r.listenToMaster(conn, parser, context.WithValue(ctx, info.CTX_CLIENT, client))
 	}
 
 }
 
-func (r *replicationServiceImp) listenToMaster(conn net.Conn, p *parser.Parser) {
+func (r *replicationServiceImp) listenToMaster(conn net.Conn, p *parser.Parser, ctx context.Context) {
 
 	log.Println("listen to master coms")
 	for {
@@ -62,24 +65,38 @@ func (r *replicationServiceImp) listenToMaster(conn net.Conn, p *parser.Parser)
 		switch incoming.(type) {
 		case parser.CmdInfo:
 			cmd := incoming.(parser.CmdInfo)
-			r.handleMasterCmd(cmd, conn)
+			r.handleMasterCmd(cmd, conn, ctx)
 		case parser.RDBFile:
-			log.Println("received rbd file")
-		case parser.UnknownData:
-			ud := incoming.(parser.UnknownData)
-			log.Println("some incoming data\n", ud.Dt, string(ud.Data))
+			if err := r.loadMasterRDB(incoming.(parser.RDBFile).Data, ctx); err != nil {
+				log.Println("Failed trying to load the MASTER synchronization DB:", err)
+				conn.Close()
+				return
+			}
 		}
 
 	}
 }
 
-func (r *replicationServiceImp) handleMasterCmd(cmd parser.CmdInfo, conn net.Conn) {
+// loadMasterRDB replaces the databases with the snapshot of the master,
+// the stream that follows starts on database 0
+func (r *replicationServiceImp) loadMasterRDB(data []byte, ctx context.Context) error {
+	log.Printf("MASTER <-> REPLICA sync: receiving %d bytes from master", len(data))
+	r.rs.dbs.FlushAll()
+	r.rs.tracking.InvalidateAll()
+	ctx.Value(info.CTX_CLIENT).(*Client).SelectDB(0)
+	if err := r.rs.dbs.RDB().LoadFrom(bytes.NewReader(data), int64(len(data))); err != nil {
+		return err
+	}
+	log.Println("MASTER <-> REPLICA sync: Finished with success")
+	return nil
+}
+
+// handleMasterCmd applies the stream of the master like the commands of a
+// client, its replies are dropped
+func (r *replicationServiceImp) handleMasterCmd(cmd parser.CmdInfo, conn net.Conn, ctx context.Context) {
 
 	log.Println("handling replication from master", cmd)
 	switch cmd.CmdName {
-	case SET:
-		r.kvnService.Set(cmd.Args[0], []byte(cmd.Args[1]))
-		r.metrics.AddToOffset(int64(cmd.Size))
 	case REPLCONF:
 		if cmd.Args[0] == "GETACK" {
 			r.metrics.AddToOffset(int64(cmd.Size))
@@ -94,11 +111,10 @@ func (r *replicationServiceImp) handleMasterCmd(cmd parser.CmdInfo, conn net.Con
 			log.Println("metrics:", r.metrics)
 			conn.Write(rep)
 		}
-	case PING:
-		r.metrics.AddToOffset(int64(cmd.Size))
 
 	default:
-		log.Println("could not handle cmd", cmd)
+		r.rs.replayCmd(&cmd, ctx)
+		r.metrics.AddToOffset(int64(cmd.Size))
 	}
 
 }
@@ -114,7 +130,7 @@ func (r *replicationServiceImp) performMasterHandshake(conn net.Conn, p *parser.
 		return false, fmt.Errorf("Handshake 1/3 failed: %s", err)
 	}
 
-	if pingResp.Data != "PONG\r\n" {
+	if pingResp.Data != "PONG" {
 		return false, fmt.Errorf("Handshake 1/3 failed, invalid ping reply: '%s'", pingResp.Data)
 	}
 
@@ -128,7 +144,7 @@ func (r *replicationServiceImp) performMasterHandshake(conn net.Conn, p *parser.
 		return false, fmt.Errorf("Handshake 2/3 failed: %s", err)
 	}
 
-	if okRep.Data != "OK\r\n" {
+	if okRep.Data != "OK" {
 		return false, fmt.Errorf("Handshake 2/3 failed, invalid REPLCONF reply: '%s'", pingResp.Data)
 	}
 
@@ -142,7 +158,7 @@ func (r *replicationServiceImp) performMasterHandshake(conn net.Conn, p *parser.
 		return false, fmt.Errorf("Handshake 2/3 failed: %s", err)
 	}
 
-	if okRep.Data != "OK\r\n" {
+	if okRep.Data != "OK" {
 		return false, fmt.Errorf("Handshake 2/3 failed, invalid REPLCONF reply: '%s'", pingResp.Data)
 	}
 
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+	"github.com/codecrafters-io/redis-starter-go/app/protocol/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FullResync(t *testing.T) {
+	master, ctx := newObjectTestService()
+	execCommands(master, ctx,
+		[]string{"SET", "s", "v"},
+		[]string{"SET", "ttl", "v", "PX", "100000"},
+		[]string{"HSET", "h", "f", "v"},
+		[]string{"SADD", "set", "a", "b"},
+	)
+	reply, _ := master.psyncCmd(&parser.CmdInfo{CmdName: PSYNC, Args: []string{"?", "-1"}}, ctx)
+	assert.True(t, strings.HasPrefix(string(reply), "+FULLRESYNC "))
+
+	replica, replicaCtx := newObjectTestService()
+	execCommands(replica, replicaCtx, []string{"SET", "stale", "v"}, []string{"SET", "s", "old"})
+	r := NewReplicationService(replica, info.NewMetrics()).(*replicationServiceImp)
+	// the stream after the snapshot starts on database 0
+	replicaCtx.Value(info.CTX_CLIENT).(*Client).SelectDB(3)
+	// the clients caching the replaced keys are told to drop them
+	tracker := replica.clients.Register(nil, true)
+	assert.Nil(t, replica.tracking.Enable(tracker, TrackingOptions{}))
+	replica.tracking.RememberKeys(tracker, []string{"s"})
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go r.listenToMaster(server, parser.NewParser(bufio.NewReader(server)), replicaCtx)
+	// the snapshot is followed by the stream of writes, any command and
+	// the transactions
+	stream := "*4\r\n$4\r\nHSET\r\n$1\r\nh\r\n$1\r\ng\r\n$1\r\nw\r\n" +
+		"*3\r\n$3\r\nSET\r\n$5\r\nafter\r\n$1\r\n1\r\n" +
+		"*1\r\n$5\r\nMULTI\r\n*2\r\n$4\r\nINCR\r\n$5\r\nafter\r\n*1\r\n$4\r\nEXEC\r\n"
+	go client.Write(append(reply, stream...))
+
+	// the flush replaces the databases
+	assert.Eventually(t, func() bool {
+		value, _ := replica.dbs.Get(0).Get("after")
+		return string(value) == "2"
+	}, time.Second, 10*time.Millisecond)
+	replica.tracking.mx.Lock()
+	assert.Empty(t, replica.tracking.keys)
+	replica.tracking.mx.Unlock()
+
+	replica.dbs.Get(0).Del("after")
+	execCommands(master, ctx, []string{"HSET", "h", "g", "w"})
+	assertSameData(t, master, replica)
+}
+
+func Test_FullResyncConcurrentWrites(t *testing.T) {
+	master, ctx := newObjectTestService()
+	var mx sync.Mutex
+	registered := false
+	stream := []*parser.CmdInfo{}
+	master.dbs.SetReplication(func(db int, args []string) {
+		mx.Lock()
+		defer mx.Unlock()
+		if registered {
+			stream = append(stream, &parser.CmdInfo{CmdName: strings.ToLower(args[0]), Args: args[1:]})
+		}
+	})
+	ctx = context.WithValue(ctx, info.CTX_REPLACATION_REGISTRATION, func(*Client) {
+		mx.Lock()
+		defer mx.Unlock()
+		registered = true
+	})
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clientCtx := context.WithValue(ctx, info.CTX_CLIENT, master.clients.Register(nil, true))
+			for range 500 {
+				execCommands(master, clientCtx, []string{"INCR", "n"})
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	reply, _ := master.psyncCmd(&parser.CmdInfo{CmdName: PSYNC, Args: []string{"?", "-1"}}, ctx)
+	wg.Wait()
+
+	// a write is either in the snapshot or in the stream after it
+	_, rdbFile, _ := strings.Cut(string(reply), "\r\n$")
+	_, rdbFile, _ = strings.Cut(rdbFile, "\r\n")
+	replica, replicaCtx := newObjectTestService()
+	r := NewReplicationService(replica, info.NewMetrics()).(*replicationServiceImp)
+	assert.NoError(t, r.loadMasterRDB([]byte(rdbFile), replicaCtx))
+	for _, cmd := range stream {
+		replica.executeCmd(cmd, replicaCtx)
+	}
+	value, _ := replica.dbs.Get(0).Get("n")
+	assert.Equal(t, "4000", string(value))
+}
+
+func Test_ReplicationFeed(t *testing.T) {
+	rs, ctx := newObjectTestService()
+	fed := [][]string{}
+	rs.dbs.SetReplication(func(db int, args []string) {
+		fed = append(fed, append([]string{strconv.Itoa(db)}, args...))
+	})
+	execCommands(rs, ctx,
+		[]string{"SET", "k", "v", "EX", "100"},
+		[]string{"HSET", "h", "f", "v"},
+		[]string{"GET", "k"},
+		// failed commands are not sent
+		[]string{"INCR", "h"},
+		[]string{"SET", "gone", "v", "PX", "1"},
+	)
+	time.Sleep(5 * time.Millisecond)
+	execCommands(rs, ctx, []string{"GET", "gone"})
+
+	// the writes are sent like they are logged to the aof
+	assert.Len(t, fed, 4)
+	assert.Equal(t, []string{"0", "SET", "k", "v", "PXAT"}, fed[0][:5])
+	assert.Equal(t, []string{"0", "HSET", "h", "f", "v"}, fed[1])
+	assert.Equal(t, []string{"0", "DEL", "gone"}, fed[3])
+}
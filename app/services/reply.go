@@ -0,0 +1,371 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/info"
+)
+
+const (
+	// client classes for the output buffer limits
+	CLIENT_CLASS_NORMAL = iota
+	CLIENT_CLASS_REPLICA
+	CLIENT_CLASS_PUBSUB
+
+	DEFAULT_OUTPUT_BUFFER_LIMIT = "normal 0 0 0 slave 268435456 67108864 60 pubsub 33554432 8388608 60"
+
+	// how long closing a client waits for its pending replies to be sent
+	REPLY_DRAIN_TIMEOUT = 5 * time.Second
+)
+
+// CLIENT REPLY modes
+const (
+	CLIENT_REPLY_ON = iota
+	CLIENT_REPLY_OFF
+	CLIENT_REPLY_SKIP
+)
+
+var clientClassNames = []string{"normal", "slave", "pubsub"}
+
+var errOutputBufferLimit = errors.New("output buffer limit reached")
+
+// OutputBufferLimit disconnects a client when its pending replies reach
+// Hard bytes, or stay over Soft bytes for more than SoftSeconds, zero
+// disables a limit
+type OutputBufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds int64
+}
+
+type outputBufferLimits struct {
+	mx     sync.RWMutex
+	limits [CLIENT_CLASS_PUBSUB + 1]OutputBufferLimit
+}
+
+func (l *outputBufferLimits) get(class int) OutputBufferLimit {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+	return l.limits[class]
+}
+
+// set parses a client-output-buffer-limit value, made of
+// <class> <hard> <soft> <soft seconds> groups, classes not in the value
+// keep their limits
+func (l *outputBufferLimits) set(value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return "", fmt.Errorf("Wrong number of arguments in buffer limit configuration.")
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	limits := l.limits
+	for i := 0; i < len(fields); i += 4 {
+		class := getClientClass(fields[i])
+		if class == -1 {
+			return "", fmt.Errorf("Invalid client class specified in buffer limit configuration.")
+		}
+		hard, hardErr := info.ParseMemory(fields[i+1])
+		soft, softErr := info.ParseMemory(fields[i+2])
+		seconds, secondsErr := strconv.ParseInt(fields[i+3], 10, 64)
+		if hardErr != nil || softErr != nil || secondsErr != nil || seconds < 0 {
+			return "", fmt.Errorf("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		limits[class] = OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}
+	}
+	l.limits = limits
+
+	canonical := make([]string, 0, len(clientClassNames))
+	for class, name := range clientClassNames {
+		limit := l.limits[class]
+		canonical = append(canonical, fmt.Sprintf("%s %d %d %d", name, limit.Hard, limit.Soft, limit.SoftSeconds))
+	}
+	return strings.Join(canonical, " "), nil
+}
+
+func getClientClass(name string) int {
+	switch strings.ToLower(name) {
+	case "normal":
+		return CLIENT_CLASS_NORMAL
+	case "replica", "slave":
+		return CLIENT_CLASS_REPLICA
+	case "pubsub":
+		return CLIENT_CLASS_PUBSUB
+	}
+	return -1
+}
+
+// replyBuffer holds the replies of a client until its writer goroutine
+// sends them, so a slow connection never blocks whoever produces the reply
+type replyBuffer struct {
+	mx   sync.Mutex
+	cond *sync.Cond
+	// replies not handed to the connection yet
+	pending []byte
+	// bytes being written right now, still part of the buffer size
+	inflight       int
+	flush          bool
+	closing        bool
+	closed         bool
+	softLimitSince time.Time
+	done           chan struct{}
+
+	// CLIENT REPLY state, skip drops the replies of the running command
+	replyOff bool
+	skipNext bool
+	skip     bool
+
+	// replication stream held back while a replica waits for its snapshot
+	syncing bool
+	held    []byte
+}
+
+// Write queues a reply, it is sent on the next Flush
+func (c *Client) Write(b []byte) (int, error) {
+	if c.conn == nil {
+		return len(b), nil
+	}
+
+	r := &c.reply
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.closed {
+		return 0, net.ErrClosed
+	}
+	if r.replyOff || r.skip {
+		return len(b), nil
+	}
+	r.pending = append(r.pending, b...)
+
+	if err := c.checkOutputLimit(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// checkOutputLimit kills the client once its buffer, with the replication
+// stream held back, is over the limits. It is called with the reply lock
+// held
+func (c *Client) checkOutputLimit() error {
+	r := &c.reply
+	if !c.outputLimitReached(int64(len(r.pending)+r.inflight+len(r.held)), time.Now()) {
+		return nil
+	}
+	log.Printf("Client id=%d addr=%s closed for overcoming of output buffer limits.", c.id, c.conn.RemoteAddr())
+	c.killLocked()
+	return errOutputBufferLimit
+}
+
+// SetReplyMode implements CLIENT REPLY, SKIP drops the replies of the
+// next command
+func (c *Client) SetReplyMode(mode int) {
+	r := &c.reply
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	switch mode {
+	case CLIENT_REPLY_ON:
+		r.replyOff, r.skipNext, r.skip = false, false, false
+	case CLIENT_REPLY_OFF:
+		r.replyOff = true
+	case CLIENT_REPLY_SKIP:
+		if !r.replyOff {
+			r.skipNext = true
+		}
+	}
+}
+
+// EndCommand moves a CLIENT REPLY SKIP to the next command once the
+// current one is done
+func (c *Client) EndCommand() {
+	r := &c.reply
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.skip = false
+	if r.skipNext {
+		r.skip, r.skipNext = true, false
+	}
+}
+
+// Kill drops the pending replies and closes the connection right away
+func (c *Client) Kill() {
+	if c.conn == nil {
+		return
+	}
+	c.reply.mx.Lock()
+	defer c.reply.mx.Unlock()
+	c.killLocked()
+}
+
+func (c *Client) killLocked() {
+	r := &c.reply
+	r.pending, r.held = nil, nil
+	r.closed = true
+	r.cond.Signal()
+	c.conn.Close()
+}
+
+// Flush asks the writer to send the queued replies
+func (c *Client) Flush() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	r := &c.reply
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.closed {
+		return net.ErrClosed
+	}
+	r.flush = true
+	r.cond.Signal()
+	return nil
+}
+
+// Send queues and flushes a message that does not come from the client
+// own commands, like pub/sub messages and invalidations
+func (c *Client) Send(b []byte) error {
+	if _, err := c.Write(b); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// HoldReplication holds back the replication stream sent to the client
+// until it is a replica, so the stream follows the snapshot it loads
+func (c *Client) HoldReplication() {
+	c.reply.mx.Lock()
+	defer c.reply.mx.Unlock()
+	c.reply.syncing = true
+}
+
+// SendReplication sends a part of the replication stream to a replica,
+// it is held back while the replica waits for its snapshot
+func (c *Client) SendReplication(b []byte) error {
+	r := &c.reply
+	r.mx.Lock()
+	if r.syncing && !r.closed {
+		r.held = append(r.held, b...)
+		var err error
+		if c.conn != nil {
+			err = c.checkOutputLimit()
+		}
+		r.mx.Unlock()
+		return err
+	}
+	r.mx.Unlock()
+	return c.Send(b)
+}
+
+// releaseReplication queues the stream held back after the replies sent
+// so far, like the snapshot
+func (c *Client) releaseReplication() {
+	r := &c.reply
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	held := r.held
+	r.syncing, r.held = false, nil
+	if c.conn == nil || r.closed || len(held) == 0 {
+		return
+	}
+	r.pending = append(r.pending, held...)
+	r.flush = true
+	r.cond.Signal()
+}
+
+// OutputBufferSize returns the bytes waiting to be written to the client
+func (c *Client) OutputBufferSize() int {
+	c.reply.mx.Lock()
+	defer c.reply.mx.Unlock()
+	return len(c.reply.pending) + c.reply.inflight
+}
+
+// Close sends the pending replies and closes the connection
+func (c *Client) Close() {
+	if c.conn == nil {
+		return
+	}
+
+	r := &c.reply
+	r.mx.Lock()
+	r.closing = true
+	r.cond.Signal()
+	r.mx.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(REPLY_DRAIN_TIMEOUT))
+	<-r.done
+	c.conn.Close()
+}
+
+func (c *Client) writeLoop() {
+	r := &c.reply
+	defer close(r.done)
+
+	for {
+		r.mx.Lock()
+		for !r.closed && !r.closing && !(r.flush && len(r.pending) > 0) {
+			r.cond.Wait()
+		}
+		if r.closed || len(r.pending) == 0 {
+			r.closed = true
+			r.mx.Unlock()
+			return
+		}
+		data := r.pending
+		r.pending = nil
+		r.flush = false
+		r.inflight = len(data)
+		r.mx.Unlock()
+
+		_, err := c.conn.Write(data)
+
+		r.mx.Lock()
+		r.inflight = 0
+		if err != nil {
+			if !r.closed {
+				log.Printf("Error writing to client id=%d: %s", c.id, err)
+			}
+			r.pending = nil
+			r.closed = true
+			r.mx.Unlock()
+			c.conn.Close()
+			return
+		}
+		r.mx.Unlock()
+	}
+}
+
+// outputLimitReached checks the limits of the client class, it is called
+// with the reply lock held
+func (c *Client) outputLimitReached(size int64, now time.Time) bool {
+	if c.limits == nil {
+		return false
+	}
+
+	r := &c.reply
+	class := c.Class()
+	if r.syncing {
+		// a replica waiting for its snapshot has the limits of the replicas
+		class = CLIENT_CLASS_REPLICA
+	}
+	limit := c.limits.get(class)
+	if limit.Hard > 0 && size >= limit.Hard {
+		return true
+	}
+	if limit.Soft > 0 && size >= limit.Soft {
+		if r.softLimitSince.IsZero() {
+			r.softLimitSince = now
+			return false
+		}
+		return now.Sub(r.softLimitSince) > time.Duration(limit.SoftSeconds)*time.Second
+	}
+	r.softLimitSince = time.Time{}
+	return false
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SetOutputBufferLimits(t *testing.T) {
+
+	tests := []struct {
+		input         string
+		expected      string
+		expectedError bool
+	}{
+		{
+			input:    DEFAULT_OUTPUT_BUFFER_LIMIT,
+			expected: DEFAULT_OUTPUT_BUFFER_LIMIT,
+		},
+		{
+			input:    "pubsub 64mb 16mb 30",
+			expected: "normal 0 0 0 slave 268435456 67108864 60 pubsub 67108864 16777216 30",
+		},
+		{
+			input:    "normal 1kb 1k 10 replica 1gb 0 0",
+			expected: "normal 1024 1000 10 slave 1073741824 0 0 pubsub 33554432 8388608 60",
+		},
+		{input: "pubsub 64mb 16mb", expectedError: true},
+		{input: "master 0 0 0", expectedError: true},
+		{input: "pubsub 64xb 16mb 30", expectedError: true},
+		{input: "pubsub 64mb 16mb -1", expectedError: true},
+	}
+
+	for _, tc := range tests {
+		clients := NewClientRegistry()
+		got, err := clients.SetOutputBufferLimits(tc.input)
+
+		if tc.expectedError {
+			assert.NotNil(t, err, tc.input)
+			continue
+		}
+		assert.Nil(t, err, tc.input)
+		assert.Equal(t, tc.expected, got)
+	}
+}
+
+func Test_OutputBufferLimit(t *testing.T) {
+
+	tests := []struct {
+		limits       string
+		subscribed   bool
+		writes       int
+		disconnected bool
+	}{
+		{limits: "pubsub 1kb 0 0", subscribed: true, writes: 10, disconnected: true},
+		{limits: "pubsub 0 100 0", subscribed: true, writes: 10, disconnected: true},
+		{limits: "pubsub 1kb 0 0", writes: 10},
+		{limits: "normal 1kb 0 0", writes: 10, disconnected: true},
+		{limits: "normal 1mb 0 0", writes: 10},
+	}
+
+	for _, tc := range tests {
+		clients := NewClientRegistry()
+		_, err := clients.SetOutputBufferLimits(tc.limits)
+		assert.Nil(t, err)
+
+		// nobody reads the other end so every reply stays in the buffer
+		server, _ := net.Pipe()
+		c := clients.Register(server, true)
+		c.subscribed.Store(tc.subscribed)
+
+		var sendErr error
+		for range tc.writes {
+			if sendErr = c.Send([]byte(strings.Repeat("x", 200))); sendErr != nil {
+				break
+			}
+		}
+
+		if tc.disconnected {
+			assert.Equal(t, errOutputBufferLimit, sendErr, tc.limits)
+		} else {
+			assert.Nil(t, sendErr, tc.limits)
+		}
+		server.Close()
+	}
+}
+
+func Test_HoldReplication(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewClientRegistry().Register(server, true)
+
+	// the stream propagated while the snapshot is taken follows it
+	c.HoldReplication()
+	assert.Nil(t, c.SendReplication([]byte("write1;")))
+	c.Write([]byte("snapshot;"))
+	assert.Nil(t, c.Flush())
+	assert.Nil(t, c.SendReplication([]byte("write2;")))
+	c.SetReplica()
+	assert.Nil(t, c.SendReplication([]byte("write3;")))
+
+	expect := "snapshot;write1;write2;write3;"
+	got := make([]byte, len(expect))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := io.ReadFull(client, got)
+	assert.Nil(t, err)
+	assert.Equal(t, expect, string(got))
+}
+
+func Test_HoldReplicationLimit(t *testing.T) {
+	clients := NewClientRegistry()
+	_, err := clients.SetOutputBufferLimits("slave 1kb 0 0")
+	assert.Nil(t, err)
+	server, client := net.Pipe()
+	defer client.Close()
+	c := clients.Register(server, true)
+
+	// the stream held back counts in the buffer of the replica
+	c.HoldReplication()
+	var sendErr error
+	for range 10 {
+		if sendErr = c.SendReplication([]byte(strings.Repeat("x", 200))); sendErr != nil {
+			break
+		}
+	}
+	assert.Equal(t, errOutputBufferLimit, sendErr)
+	_, err = c.Write([]byte("snapshot;"))
+	assert.Equal(t, net.ErrClosed, err)
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	respencoding "github.com/codecrafters-io/redis-starter-go/app/protocol/resp_encoding"
+)
+
+const TRACKING_CHANNEL = "__redis__:invalidate"
+
+const (
+	// CLIENT TRACKING options
+	TRACKING_BCAST = 1 << iota
+	TRACKING_OPTIN
+	TRACKING_OPTOUT
+	TRACKING_NOLOOP
+)
+
+type clientTracking struct {
+	enabled  bool
+	flags    int
+	redirect int64
+	prefixes []string
+	// set by CLIENT CACHING, only valid for the next command. It means
+	// "yes" in OPTIN mode and "no" in OPTOUT mode
+	caching bool
+}
+
+type TrackingOptions struct {
+	Flags    int
+	Redirect int64
+	Prefixes []string
+}
+
+// TrackingService implements server assisted client side caching, it keeps
+// the keys read by each tracking client (or the prefixes for BCAST clients)
+// and sends invalidation messages when those keys are modified
+type TrackingService struct {
+	mx      sync.Mutex
+	clients *ClientRegistry
+	pubsub  PubSubService
+	// key -> ids of the clients that may have it cached
+	keys map[string]map[int64]struct{}
+	// BCAST prefix -> clients
+	prefixes map[string]map[int64]*Client
+}
+
+func NewTrackingService(clients *ClientRegistry, pubsub PubSubService) *TrackingService {
+	return &TrackingService{
+		clients:  clients,
+		pubsub:   pubsub,
+		keys:     make(map[string]map[int64]struct{}),
+		prefixes: make(map[string]map[int64]*Client),
+	}
+}
+
+func (ts *TrackingService) Enable(c *Client, ops TrackingOptions) error {
+	if ops.Flags&TRACKING_OPTIN != 0 && ops.Flags&TRACKING_OPTOUT != 0 {
+		return fmt.Errorf("ERR You can't use both OPTIN and OPTOUT")
+	}
+	if ops.Flags&TRACKING_BCAST == 0 && len(ops.Prefixes) > 0 {
+		return fmt.Errorf("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	if ops.Flags&TRACKING_BCAST != 0 && ops.Flags&(TRACKING_OPTIN|TRACKING_OPTOUT) != 0 {
+		return fmt.Errorf("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if ops.Redirect != 0 {
+		if _, ok := ts.clients.Get(ops.Redirect); !ok {
+			return fmt.Errorf("ERR The client ID you want redirect to does not exist")
+		}
+	}
+
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	tracking := &c.tracking
+	if tracking.enabled {
+		if tracking.flags&TRACKING_BCAST != ops.Flags&TRACKING_BCAST {
+			return fmt.Errorf("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+		if (ops.Flags&TRACKING_OPTIN != 0 && tracking.flags&TRACKING_OPTOUT != 0) ||
+			(ops.Flags&TRACKING_OPTOUT != 0 && tracking.flags&TRACKING_OPTIN != 0) {
+			return fmt.Errorf("ERR You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+	}
+
+	prefixes := ops.Prefixes
+	if ops.Flags&TRACKING_BCAST != 0 && len(prefixes) == 0 && len(tracking.prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	if err := checkPrefixCollisions(tracking.prefixes, prefixes); err != nil {
+		return err
+	}
+
+	tracking.enabled = true
+	tracking.flags = ops.Flags
+	tracking.redirect = ops.Redirect
+	tracking.caching = false
+	for _, prefix := range prefixes {
+		clients, ok := ts.prefixes[prefix]
+		if !ok {
+			clients = make(map[int64]*Client)
+			ts.prefixes[prefix] = clients
+		}
+		clients[c.id] = c
+		tracking.prefixes = append(tracking.prefixes, prefix)
+	}
+	return nil
+}
+
+// Disable turns tracking off, the keys read by the client are removed
+// lazily from the table when they are invalidated
+func (ts *TrackingService) Disable(c *Client) {
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	for _, prefix := range c.tracking.prefixes {
+		clients := ts.prefixes[prefix]
+		delete(clients, c.id)
+		if len(clients) == 0 {
+			delete(ts.prefixes, prefix)
+		}
+	}
+	c.tracking = clientTracking{}
+}
+
+func (ts *TrackingService) SetCaching(c *Client, yes bool) error {
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	tracking := &c.tracking
+	if !tracking.enabled || tracking.flags&(TRACKING_OPTIN|TRACKING_OPTOUT) == 0 {
+		return fmt.Errorf("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+	if yes && tracking.flags&TRACKING_OPTIN == 0 {
+		return fmt.Errorf("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+	}
+	if !yes && tracking.flags&TRACKING_OPTOUT == 0 {
+		return fmt.Errorf("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+	}
+	tracking.caching = true
+	return nil
+}
+
+// ResetCaching is called after every command but CLIENT CACHING itself
+func (ts *TrackingService) ResetCaching(c *Client) {
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+	c.tracking.caching = false
+}
+
+func (ts *TrackingService) GetRedirect(c *Client) int64 {
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+	if !c.tracking.enabled {
+		return -1
+	}
+	return c.tracking.redirect
+}
+
+func (ts *TrackingService) Info(c *Client) ([]string, int64, []string) {
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	tracking := c.tracking
+	if !tracking.enabled {
+		return []string{"off"}, -1, []string{}
+	}
+	flags := []string{"on"}
+	if tracking.flags&TRACKING_BCAST != 0 {
+		flags = append(flags, "bcast")
+	}
+	if tracking.flags&TRACKING_OPTIN != 0 {
+		flags = append(flags, "optin")
+		if tracking.caching {
+			flags = append(flags, "caching-yes")
+		}
+	}
+	if tracking.flags&TRACKING_OPTOUT != 0 {
+		flags = append(flags, "optout")
+		if tracking.caching {
+			flags = append(flags, "caching-no")
+		}
+	}
+	if tracking.flags&TRACKING_NOLOOP != 0 {
+		flags = append(flags, "noloop")
+	}
+	if tracking.redirect != 0 {
+		if _, ok := ts.clients.Get(tracking.redirect); !ok {
+			flags = append(flags, "broken_redirect")
+		}
+	}
+	prefixes := make([]string, len(tracking.prefixes))
+	copy(prefixes, tracking.prefixes)
+	return flags, tracking.redirect, prefixes
+}
+
+// RememberKeys records the keys read by a command so the client gets
+// invalidations for them, BCAST clients do not need it
+func (ts *TrackingService) RememberKeys(c *Client, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	tracking := c.tracking
+	if !tracking.enabled || tracking.flags&TRACKING_BCAST != 0 {
+		return
+	}
+	if tracking.flags&TRACKING_OPTIN != 0 && !tracking.caching {
+		return
+	}
+	if tracking.flags&TRACKING_OPTOUT != 0 && tracking.caching {
+		return
+	}
+
+	for _, key := range keys {
+		ids, ok := ts.keys[key]
+		if !ok {
+			ids = make(map[int64]struct{})
+			ts.keys[key] = ids
+		}
+		ids[c.id] = struct{}{}
+	}
+}
+
+// InvalidateKeys notifies the clients that may have cached the keys,
+// modifier is the client that changed them, nil when the server did it
+// (e.g. expiration)
+func (ts *TrackingService) InvalidateKeys(modifier *Client, keys []string) {
+	if ts == nil || len(keys) == 0 {
+		return
+	}
+
+	type invalidation struct {
+		client   *Client
+		redirect int64
+		keys     [][]byte
+	}
+	pending := make(map[int64]*invalidation)
+	add := func(c *Client, key string) {
+		if c.tracking.flags&TRACKING_NOLOOP != 0 && c == modifier {
+			return
+		}
+		inv, ok := pending[c.id]
+		if !ok {
+			inv = &invalidation{client: c, redirect: c.tracking.redirect}
+			pending[c.id] = inv
+		}
+		inv.keys = append(inv.keys, []byte(key))
+	}
+
+	ts.mx.Lock()
+	for _, key := range keys {
+		for id := range ts.keys[key] {
+			c, ok := ts.clients.Get(id)
+			if !ok || !c.tracking.enabled || c.tracking.flags&TRACKING_BCAST != 0 {
+				continue
+			}
+			add(c, key)
+		}
+		delete(ts.keys, key)
+
+		for prefix, clients := range ts.prefixes {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			for _, c := range clients {
+				add(c, key)
+			}
+		}
+	}
+	ts.mx.Unlock()
+
+	for _, inv := range pending {
+		ts.sendInvalidation(inv.client, inv.redirect, inv.keys)
+	}
+}
+
+// InvalidateAll tells every tracking client to drop its whole cache, it is
+// sent when the keys are flushed
+func (ts *TrackingService) InvalidateAll() {
+	if ts == nil {
+		return
+	}
+
+	type invalidation struct {
+		client   *Client
+		redirect int64
+	}
+	pending := make([]invalidation, 0)
+	ts.mx.Lock()
+	ts.keys = make(map[string]map[int64]struct{})
+	for _, c := range ts.clients.All() {
+		if c.tracking.enabled {
+			pending = append(pending, invalidation{client: c, redirect: c.tracking.redirect})
+		}
+	}
+	ts.mx.Unlock()
+
+	for _, inv := range pending {
+		ts.sendInvalidation(inv.client, inv.redirect, nil)
+	}
+}
+
+// sendInvalidation writes the message as a push to RESP3 clients, RESP2
+// clients can only get it through a redirect client that is subscribed
+func (ts *TrackingService) sendInvalidation(c *Client, redirect int64, keys [][]byte) {
+	target := c
+	if redirect != 0 {
+		redirectClient, ok := ts.clients.Get(redirect)
+		if !ok {
+			if c.Protocol() >= respencoding.RESP3 {
+				c.Send(respencoding.BuildPush(respencoding.RESP3, [][]byte{
+					respencoding.EncodeBulkString([]byte("tracking-redir-broken")),
+					respencoding.EncodeInteger(int(redirect)),
+				}))
+			}
+			return
+		}
+		target = redirectClient
+	}
+
+	// nil keys invalidate everything
+	invalidated := respencoding.EncodeArray(keys)
+	if keys == nil {
+		invalidated = respencoding.EncodeNull(target.Protocol())
+	}
+
+	var payload []byte
+	if target.Protocol() >= respencoding.RESP3 {
+		payload = respencoding.BuildPush(respencoding.RESP3, [][]byte{
+			respencoding.EncodeBulkString([]byte("invalidate")),
+			invalidated,
+		})
+	} else if redirect != 0 && ts.pubsub.SubscriptionCount(target) > 0 {
+		payload = respencoding.BuildArray([][]byte{
+			respencoding.EncodeBulkString([]byte("message")),
+			respencoding.EncodeBulkString([]byte(TRACKING_CHANNEL)),
+			invalidated,
+		})
+	} else {
+		return
+	}
+	target.Send(payload)
+}
+
+func checkPrefixCollisions(current, prefixes []string) error {
+	for i, prefix := range prefixes {
+		for _, other := range current {
+			if strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix) {
+				return fmt.Errorf("ERR Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", prefix, other)
+			}
+		}
+		for j, other := range prefixes {
+			if i != j && (strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix)) {
+				return fmt.Errorf("ERR Prefix '%s' overlaps with another provided prefix '%s'. Prefixes for a single client must not overlap.", prefix, other)
+			}
+		}
+	}
+	return nil
+}
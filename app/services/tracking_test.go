@@ -0,0 +1,112 @@
+package services
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TrackingEnable(t *testing.T) {
+
+	tests := []struct {
+		ops           TrackingOptions
+		expectedError bool
+	}{
+		{ops: TrackingOptions{}},
+		{ops: TrackingOptions{Flags: TRACKING_BCAST, Prefixes: []string{"user:", "session:"}}},
+		{ops: TrackingOptions{Flags: TRACKING_OPTIN | TRACKING_NOLOOP}},
+		{ops: TrackingOptions{Prefixes: []string{"user:"}}, expectedError: true},
+		{ops: TrackingOptions{Flags: TRACKING_OPTIN | TRACKING_OPTOUT}, expectedError: true},
+		{ops: TrackingOptions{Flags: TRACKING_BCAST | TRACKING_OPTIN}, expectedError: true},
+		{ops: TrackingOptions{Flags: TRACKING_BCAST, Prefixes: []string{"user", "user:"}}, expectedError: true},
+		{ops: TrackingOptions{Redirect: 1000}, expectedError: true},
+	}
+
+	for _, tc := range tests {
+		clients := NewClientRegistry()
+		ts := NewTrackingService(clients, NewPubSubService())
+		client := clients.Register(nil, true)
+
+		err := ts.Enable(client, tc.ops)
+
+		if tc.expectedError {
+			assert.NotNil(t, err)
+			assert.Equal(t, int64(-1), ts.GetRedirect(client))
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, tc.ops.Redirect, ts.GetRedirect(client))
+		}
+	}
+}
+
+func Test_InvalidateKeys(t *testing.T) {
+
+	tests := []struct {
+		ops       TrackingOptions
+		read      []string
+		modified  []string
+		byTracker bool
+		expected  string
+	}{
+		{
+			read:     []string{"foo"},
+			modified: []string{"foo"},
+			expected: "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$3\r\nfoo\r\n",
+		},
+		{
+			read:     []string{"foo"},
+			modified: []string{"bar"},
+			expected: "",
+		},
+		{
+			ops:      TrackingOptions{Flags: TRACKING_BCAST, Prefixes: []string{"user:"}},
+			modified: []string{"user:1", "session:1"},
+			expected: "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$6\r\nuser:1\r\n",
+		},
+		{
+			ops:       TrackingOptions{Flags: TRACKING_NOLOOP},
+			read:      []string{"foo"},
+			modified:  []string{"foo"},
+			byTracker: true,
+			expected:  "",
+		},
+		{
+			ops:      TrackingOptions{Flags: TRACKING_OPTIN},
+			read:     []string{"foo"},
+			modified: []string{"foo"},
+			expected: "",
+		},
+	}
+
+	for _, tc := range tests {
+		clients := NewClientRegistry()
+		pubsub := NewPubSubService()
+		ts := NewTrackingService(clients, pubsub)
+
+		server, client := net.Pipe()
+		redirect := clients.Register(server, true)
+		pubsub.Subscribe(redirect, TRACKING_CHANNEL)
+
+		tracker := clients.Register(nil, true)
+		tc.ops.Redirect = redirect.Id()
+		assert.Nil(t, ts.Enable(tracker, tc.ops))
+		ts.RememberKeys(tracker, tc.read)
+
+		received := make(chan string, 1)
+		go func() {
+			data, _ := io.ReadAll(client)
+			received <- string(data)
+		}()
+
+		var modifier *Client
+		if tc.byTracker {
+			modifier = tracker
+		}
+		ts.InvalidateKeys(modifier, tc.modified)
+		redirect.Close()
+
+		assert.Equal(t, tc.expected, <-received)
+	}
+}
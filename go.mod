@@ -10,10 +10,7 @@ module github.com/codecrafters-io/redis-starter-go
 
 go 1.22
 
-require (
-	github.com/google/uuid v1.6.0
-	github.com/stretchr/testify v1.9.0
-)
+require github.com/stretchr/testify v1.9.0
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect